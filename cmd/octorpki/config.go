@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"net/url"
+	"os"
+)
+
+// Resolved values of the flags. The Sentry DSN falls back to the SENTRY_DSN
+// environment variable like at startup and its key is redacted. Files such
+// as the signing key are only referred to by their path.
+func effectiveConfig(fs *flag.FlagSet) map[string]string {
+	config := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		config[f.Name] = f.Value.String()
+	})
+
+	if dsn, ok := config["sentry.dsn"]; ok {
+		if dsn == "" {
+			dsn = os.Getenv("SENTRY_DSN")
+		}
+		config["sentry.dsn"] = redactURL(dsn)
+	}
+	return config
+}
+
+func redactURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || u.User == nil {
+		return value
+	}
+	u.User = url.User("REDACTED")
+	return u.String()
+}
+
+func printConfig(w io.Writer, fs *flag.FlagSet) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(effectiveConfig(fs))
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrintConfig(t *testing.T) {
+	t.Setenv("SENTRY_DSN", "https://secret@sentry.example.com/1")
+
+	var buf bytes.Buffer
+	assert.Nil(t, printConfig(&buf, flag.CommandLine))
+
+	var config map[string]string
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &config))
+	assert.Equal(t, *Basepath, config["cache"])
+	assert.Equal(t, *SignKey, config["output.sign.key"])
+	assert.Equal(t, "1h0m0s", config["output.sign.validity"])
+	assert.Equal(t, "false", config["print-config"])
+	assert.Equal(t, "https://REDACTED@sentry.example.com/1", config["sentry.dsn"])
+	assert.NotContains(t, buf.String(), "secret")
+}
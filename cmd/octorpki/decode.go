@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+
+	librpki "github.com/cloudflare/cfrpki/validator/lib"
+)
+
+// Object decoded by "octorpki decode".
+type DecodeResult struct {
+	File    string         `json:"file"`
+	Type    string         `json:"type"`
+	Size    int            `json:"size"`
+	Hash    string         `json:"sha256"`
+	Summary *ObjectSummary `json:"summary"`
+}
+
+// Types of the objects summarizeObject decodes, by extension.
+var decodeTypes = map[string]int{
+	".cer": pki.TYPE_CER,
+	".mft": pki.TYPE_MFT,
+	".roa": pki.TYPE_ROA,
+	".crl": pki.TYPE_CRL,
+}
+
+// Extension of an object read without a file name: signed objects are told
+// apart by their content type, the other ones by trying the parsers.
+func detectObjectExtension(data []byte) (string, error) {
+	der, err := librpki.BER2DER(data)
+	if err != nil {
+		return "", err
+	}
+
+	if cms, err := librpki.DecodeCMS(der); err == nil {
+		var content librpki.Manifest
+		_, err = asn1.Unmarshal(cms.SignedData.EncapContentInfo.FullBytes, &content)
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case content.OID.Equal(librpki.RoaOID):
+			return ".roa", nil
+		case content.OID.Equal(librpki.ManifestOID):
+			return ".mft", nil
+		}
+		return "", fmt.Errorf("signed object with content type %v is not supported", content.OID)
+	}
+	if _, err := x509.ParseCertificate(der); err == nil {
+		return ".cer", nil
+	}
+	if _, err := x509.ParseDERCRL(der); err == nil {
+		return ".crl", nil
+	}
+	return "", errors.New("not a certificate, CRL, manifest or ROA")
+}
+
+// Parses a single object with librpki, without validating it.
+func decodeObject(name string, data []byte, objectType string) (*DecodeResult, error) {
+	ext := path.Ext(name)
+	if objectType != "" {
+		ext = "." + objectType
+	}
+	if _, ok := decodeTypes[ext]; !ok {
+		var err error
+		ext, err = detectObjectExtension(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	typeName := pki.TypeToName[decodeTypes[ext]]
+	summary, err := summarizeObject(ext, data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode %s as %s: %v", name, typeName, err)
+	}
+	hash := sha256.Sum256(data)
+	return &DecodeResult{
+		File:    name,
+		Type:    typeName,
+		Size:    len(data),
+		Hash:    hex.EncodeToString(hash[:]),
+		Summary: summary,
+	}, nil
+}
+
+// octorpki decode [-type cer|mft|roa|crl] [file]: prints an RPKI object as
+// JSON. The object is read from stdin without file or with "-". CMS objects
+// are decoded with the -strict.cms setting.
+func runDecode(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("decode", flag.ContinueOnError)
+	objectType := fs.String("type", "", "Type of the object (cer/mft/roa/crl), guessed from the extension or the content when empty")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if _, ok := decodeTypes["."+*objectType]; *objectType != "" && !ok {
+		return fmt.Errorf("type %q is not supported. Choose either cer, mft, roa or crl", *objectType)
+	}
+	if fs.NArg() > 1 {
+		return errors.New("decode takes a single file")
+	}
+
+	name := fs.Arg(0)
+	var data []byte
+	if name == "" || name == "-" {
+		name = "-"
+		data, err = io.ReadAll(stdin)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return err
+	}
+
+	// The validator sets the default configuration the same way
+	librpki.DefaultDecoderConfig.ValidateStrict = *StrictCms
+	result, err := decodeObject(name, data, *objectType)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(result)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecode(t *testing.T) {
+	basepath := t.TempDir()
+	createTestRepository(t, basepath, "rpki.example.com", []testROA{
+		{ASN: 65001, Prefix: "192.0.2.0/24", MaxLength: 24},
+	})
+	repo := filepath.Join(basepath, "rpki.example.com", "repo")
+
+	decode := func(args []string, stdin []byte) (*DecodeResult, error) {
+		var out bytes.Buffer
+		err := runDecode(args, bytes.NewReader(stdin), &out)
+		if err != nil {
+			return nil, err
+		}
+		var result DecodeResult
+		assert.Nil(t, json.Unmarshal(out.Bytes(), &result))
+		return &result, nil
+	}
+
+	tests := []struct {
+		file  string
+		ftype string
+		check func(*DecodeResult)
+	}{
+		{"root.cer", "certificate", func(r *DecodeResult) {
+			assert.Contains(t, r.Summary.Certificate.Resources, "0.0.0.0/0")
+			assert.Less(t, r.Summary.Certificate.NotBefore, r.Summary.Certificate.NotAfter)
+		}},
+		{"root.mft", "manifest", func(r *DecodeResult) {
+			assert.Equal(t, "1", r.Summary.Manifest.ManifestNumber)
+			assert.Equal(t, 2, r.Summary.Manifest.Files)
+			assert.NotNil(t, r.Summary.Certificate)
+		}},
+		{"root.crl", "crl", func(r *DecodeResult) {
+			assert.Equal(t, 0, r.Summary.CRL.Revoked)
+			assert.Less(t, r.Summary.CRL.ThisUpdate, r.Summary.CRL.NextUpdate)
+		}},
+		{"0.roa", "roa", func(r *DecodeResult) {
+			assert.Equal(t, 65001, r.Summary.ROA.ASN)
+			assert.Equal(t, []string{"192.0.2.0/24-24"}, r.Summary.ROA.Prefixes)
+			assert.NotNil(t, r.Summary.Certificate)
+		}},
+	}
+	for _, test := range tests {
+		file := filepath.Join(repo, test.file)
+		result, err := decode([]string{file}, nil)
+		if assert.Nil(t, err, test.file) {
+			assert.Equal(t, test.ftype, result.Type, test.file)
+			assert.Equal(t, file, result.File)
+			test.check(result)
+		}
+
+		// From stdin, the type is guessed from the content
+		data, err := os.ReadFile(file)
+		assert.Nil(t, err)
+		result, err = decode(nil, data)
+		if assert.Nil(t, err, test.file) {
+			assert.Equal(t, test.ftype, result.Type, test.file)
+			assert.Equal(t, "-", result.File)
+			test.check(result)
+		}
+	}
+
+	// The type given overrides the extension
+	renamed := filepath.Join(t.TempDir(), "object.bin")
+	data, err := os.ReadFile(filepath.Join(repo, "0.roa"))
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(renamed, data, 0644))
+	result, err := decode([]string{"-type", "roa", renamed}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "roa", result.Type)
+	_, err = decode([]string{"-type", "mft", renamed}, nil)
+	assert.NotNil(t, err)
+
+	_, err = decode([]string{"-"}, []byte("not an RPKI object"))
+	assert.NotNil(t, err)
+	_, err = decode([]string{"-type", "gbr"}, data)
+	assert.True(t, err != nil && strings.Contains(err.Error(), "not supported"))
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Minimal DNS server answering the A queries with 127.0.0.1 and the other
+// queries with an empty answer.
+type fakeResolver struct {
+	conn net.PacketConn
+
+	mu      sync.Mutex
+	queries []string
+}
+
+func newFakeResolver(t *testing.T) *fakeResolver {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	r := &fakeResolver{conn: conn}
+	go r.serve()
+	t.Cleanup(func() { conn.Close() })
+	return r
+}
+
+func (r *fakeResolver) serve() {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := r.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if response := r.answer(buf[:n]); response != nil {
+			r.conn.WriteTo(response, addr)
+		}
+	}
+}
+
+func (r *fakeResolver) answer(query []byte) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+	labels := make([]string, 0)
+	offset := 12
+	for offset < len(query) && query[offset] != 0 {
+		length := int(query[offset])
+		if offset+1+length > len(query) {
+			return nil
+		}
+		labels = append(labels, string(query[offset+1:offset+1+length]))
+		offset += 1 + length
+	}
+	offset++
+	if offset+4 > len(query) {
+		return nil
+	}
+	qtype := binary.BigEndian.Uint16(query[offset:])
+	question := query[12 : offset+4]
+
+	r.mu.Lock()
+	r.queries = append(r.queries, strings.Join(labels, "."))
+	r.mu.Unlock()
+
+	response := make([]byte, 12, 64)
+	copy(response, query[:2])
+	binary.BigEndian.PutUint16(response[2:], 0x8180) // response, recursion available
+	binary.BigEndian.PutUint16(response[4:], 1)
+	response = append(response, question...)
+	if qtype == 1 {
+		binary.BigEndian.PutUint16(response[6:], 1)
+		response = append(response,
+			0xc0, 0x0c, // name of the question
+			0, 1, 0, 1, // A, IN
+			0, 0, 0, 60, // TTL
+			0, 4, 127, 0, 0, 1)
+	}
+	return response
+}
+
+func (r *fakeResolver) getQueries() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string{}, r.queries...)
+}
+
+func TestResolverTransport(t *testing.T) {
+	resolver := newFakeResolver(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("resolved"))
+	}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	assert.Nil(t, err)
+
+	client := &http.Client{
+		Transport: newResolverTransport(newResolver(resolver.conn.LocalAddr().String())),
+	}
+	resp, err := client.Get("http://rpki.octorpki.test:" + u.Port() + "/")
+	assert.Nil(t, err)
+	if err == nil {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, "resolved", string(body))
+	}
+	assert.Contains(t, resolver.getQueries(), "rpki.octorpki.test")
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/cloudflare/gortr/prefixfile"
+)
+
+// Local policy removing VRPs from the output: the VRPs of an excluded ASN and
+// the VRPs of prefixes covered by an excluded prefix (eg: bogons).
+type OutputExclusions struct {
+	ASNs     map[uint32]bool
+	Prefixes []*net.IPNet
+}
+
+// Reads the exclusions from a file listing one ASN (AS64500 or 64500) or
+// prefix per line. Empty lines and comments starting with # are ignored.
+func loadOutputExclusions(file string) (*OutputExclusions, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	exclusions := &OutputExclusions{
+		ASNs:     make(map[uint32]bool),
+		Prefixes: make([]*net.IPNet, 0),
+	}
+	scanner := bufio.NewScanner(f)
+	var line int
+	for scanner.Scan() {
+		line++
+		entry := strings.TrimSpace(strings.SplitN(scanner.Text(), "#", 2)[0])
+		if entry == "" {
+			continue
+		}
+
+		if strings.Contains(entry, "/") {
+			_, prefix, err := net.ParseCIDR(entry)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid prefix %q", file, line, entry)
+			}
+			exclusions.Prefixes = append(exclusions.Prefixes, prefix)
+			continue
+		}
+
+		asn, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(entry), "AS"), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid ASN or prefix %q", file, line, entry)
+		}
+		exclusions.ASNs[uint32(asn)] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return exclusions, nil
+}
+
+func (e *OutputExclusions) Excludes(roa prefixfile.ROAJson) bool {
+	if e.ASNs[roa.GetASN()] {
+		return true
+	}
+
+	prefix := roa.GetPrefix()
+	if prefix == nil {
+		return false
+	}
+	prefixLen, prefixBits := prefix.Mask.Size()
+	for _, excluded := range e.Prefixes {
+		excludedLen, excludedBits := excluded.Mask.Size()
+		if excludedBits == prefixBits && excludedLen <= prefixLen && excluded.Contains(prefix.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// Removes the excluded VRPs and returns how many were dropped.
+func FilterExcluded(roalist []prefixfile.ROAJson, exclusions *OutputExclusions) ([]prefixfile.ROAJson, int) {
+	if exclusions == nil {
+		return roalist, 0
+	}
+
+	kept := make([]prefixfile.ROAJson, 0, len(roalist))
+	for _, roa := range roalist {
+		if !exclusions.Excludes(roa) {
+			kept = append(kept, roa)
+		}
+	}
+	return kept, len(roalist) - len(kept)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudflare/gortr/prefixfile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadOutputExclusions(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "exclude.txt")
+	assert.Nil(t, os.WriteFile(file, []byte("# bogons\n10.0.0.0/8\n\nAS64500\n64501 # documentation\n2001:db8::/32\n"), 0644))
+
+	exclusions, err := loadOutputExclusions(file)
+	assert.Nil(t, err)
+	assert.Equal(t, map[uint32]bool{64500: true, 64501: true}, exclusions.ASNs)
+	if assert.Len(t, exclusions.Prefixes, 2) {
+		assert.Equal(t, "10.0.0.0/8", exclusions.Prefixes[0].String())
+		assert.Equal(t, "2001:db8::/32", exclusions.Prefixes[1].String())
+	}
+
+	assert.Nil(t, os.WriteFile(file, []byte("ASfoo\n"), 0644))
+	_, err = loadOutputExclusions(file)
+	assert.NotNil(t, err)
+
+	_, err = loadOutputExclusions(filepath.Join(dir, "missing.txt"))
+	assert.NotNil(t, err)
+}
+
+func TestFilterExcludedASN(t *testing.T) {
+	exclusions := &OutputExclusions{ASNs: map[uint32]bool{64500: true}}
+	roas := []prefixfile.ROAJson{
+		{Prefix: "192.0.2.0/24", Length: 24, ASN: "AS64500"},
+		{Prefix: "198.51.100.0/24", Length: 24, ASN: "AS64501"},
+		{Prefix: "203.0.113.0/24", Length: 24, ASN: float64(64500)},
+	}
+
+	kept, excluded := FilterExcluded(roas, exclusions)
+	assert.Equal(t, 2, excluded)
+	assert.Equal(t, []prefixfile.ROAJson{{Prefix: "198.51.100.0/24", Length: 24, ASN: "AS64501"}}, kept)
+
+	kept, excluded = FilterExcluded(roas, nil)
+	assert.Equal(t, 0, excluded)
+	assert.Equal(t, roas, kept)
+}
+
+func TestFilterExcludedPrefix(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "exclude.txt")
+	assert.Nil(t, os.WriteFile(file, []byte("10.0.0.0/8\n2001:db8::/32\n"), 0644))
+	exclusions, err := loadOutputExclusions(file)
+	assert.Nil(t, err)
+
+	roas := []prefixfile.ROAJson{
+		{Prefix: "10.1.0.0/16", Length: 24, ASN: "AS64500"},
+		{Prefix: "10.0.0.0/8", Length: 8, ASN: "AS64500"},
+		{Prefix: "8.0.0.0/6", Length: 8, ASN: "AS64500"},
+		{Prefix: "2001:db8:1::/48", Length: 48, ASN: "AS64500"},
+		{Prefix: "2001:db9::/32", Length: 32, ASN: "AS64500"},
+	}
+
+	// Only the prefixes covered by an exclusion are removed
+	kept, excluded := FilterExcluded(roas, exclusions)
+	assert.Equal(t, 3, excluded)
+	assert.Equal(t, []prefixfile.ROAJson{
+		{Prefix: "8.0.0.0/6", Length: 8, ASN: "AS64500"},
+		{Prefix: "2001:db9::/32", Length: 32, ASN: "AS64500"},
+	}, kept)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/uber/jaeger-client-go"
+)
+
+// Returns the trace ID of a sampled Jaeger span, or an empty string.
+func spanTraceID(span opentracing.Span) string {
+	if span == nil {
+		return ""
+	}
+	sc, ok := span.Context().(jaeger.SpanContext)
+	if !ok || !sc.TraceID().IsValid() || !sc.IsSampled() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
+
+// Records the duration of an operation. When exemplars are enabled, the
+// observation is linked to the trace of span.
+func observeOperation(opType string, duration time.Duration, span opentracing.Span) {
+	MetricOperationTime.With(prometheus.Labels{"type": opType}).Observe(duration.Seconds())
+	if statsd != nil {
+		statsd.timing(opType, duration)
+	}
+
+	observer := MetricOperationDuration.With(prometheus.Labels{"type": opType})
+	if traceID := spanTraceID(span); *MetricsExemplars && traceID != "" {
+		observer.(prometheus.ExemplarObserver).ObserveWithExemplar(duration.Seconds(), prometheus.Labels{"trace_id": traceID})
+		return
+	}
+	observer.Observe(duration.Seconds())
+}
+
+// Observes the time from the start of a cycle of iterations, traced by span,
+// until the validation is stable.
+func observeConvergence(duration time.Duration, span opentracing.Span) {
+	if traceID := spanTraceID(span); *MetricsExemplars && traceID != "" {
+		MetricConvergenceDuration.(prometheus.ExemplarObserver).ObserveWithExemplar(duration.Seconds(), prometheus.Labels{"trace_id": traceID})
+		return
+	}
+	MetricConvergenceDuration.Observe(duration.Seconds())
+}
+
+// Exemplars are only exposed using the OpenMetrics format, which is
+// negotiated with the scraper when enabled.
+func metricsHandler(exemplars bool) http.Handler {
+	return promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{
+			EnableOpenMetrics: exemplars,
+		}),
+	)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber/jaeger-client-go"
+)
+
+func scrapeMetrics(t *testing.T, exemplars bool, accept string) string {
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Accept", accept)
+	rec := httptest.NewRecorder()
+	metricsHandler(exemplars).ServeHTTP(rec, req)
+	assert.Equal(t, 200, rec.Code)
+	return rec.Body.String()
+}
+
+func TestOperationExemplars(t *testing.T) {
+	defer func(v bool) { *MetricsExemplars = v }(*MetricsExemplars)
+
+	tracer, closer := jaeger.NewTracer("test", jaeger.NewConstSampler(true), jaeger.NewNullReporter())
+	defer closer.Close()
+	span := tracer.StartSpan("test")
+	defer span.Finish()
+
+	traceID := spanTraceID(span)
+	assert.NotEmpty(t, traceID)
+	assert.Empty(t, spanTraceID(opentracing.NoopTracer{}.StartSpan("test")))
+
+	*MetricsExemplars = true
+	observeOperation("exemplar-test", 20*time.Millisecond, span)
+	*MetricsExemplars = false
+	observeOperation("exemplar-disabled-test", 20*time.Millisecond, span)
+
+	openMetrics := "application/openmetrics-text; version=0.0.1"
+	body := scrapeMetrics(t, true, openMetrics)
+	assert.Regexp(t, fmt.Sprintf(`operation_duration_seconds_bucket\{type="exemplar-test",le="0.04"\} 1 # \{trace_id="%s"\} 0.02 \S+\n`, traceID), body)
+	assert.Regexp(t, `operation_duration_seconds_bucket\{type="exemplar-disabled-test",le="0.04"\} 1\n`, body)
+	assert.Contains(t, body, "# EOF")
+
+	// Exemplars are not part of the Prometheus text format
+	assert.NotContains(t, scrapeMetrics(t, false, openMetrics), "trace_id")
+	assert.NotContains(t, scrapeMetrics(t, true, "text/plain"), "trace_id")
+}
+
+func TestConvergenceDuration(t *testing.T) {
+	histogram := func() *dto.Histogram {
+		m := &dto.Metric{}
+		assert.Nil(t, MetricConvergenceDuration.Write(m))
+		return m.GetHistogram()
+	}
+	before := histogram()
+
+	observeConvergence(90*time.Second, opentracing.NoopTracer{}.StartSpan("multoperation"))
+	after := histogram()
+	assert.Equal(t, before.GetSampleCount()+1, after.GetSampleCount())
+	assert.InDelta(t, before.GetSampleSum()+90, after.GetSampleSum(), 1e-9)
+	for i, bucket := range after.GetBucket() {
+		expected := before.GetBucket()[i].GetCumulativeCount()
+		if bucket.GetUpperBound() >= 90 {
+			expected++
+		}
+		assert.Equal(t, expected, bucket.GetCumulativeCount(), "le=%v", bucket.GetUpperBound())
+	}
+}
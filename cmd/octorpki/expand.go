@@ -0,0 +1,80 @@
+package main
+
+import (
+	"math/big"
+	"net"
+
+	"github.com/cloudflare/gortr/prefixfile"
+)
+
+// Number of VRPs replacing a VRP once expanded: one per sub-prefix of each
+// length between the prefix length and maxLength.
+func expandedCount(prefixLen int, maxLength int) int {
+	if maxLength <= prefixLen {
+		return 1
+	}
+	if maxLength-prefixLen >= 62 {
+		return int(^uint(0) >> 1)
+	}
+	return 1<<(maxLength-prefixLen+1) - 1
+}
+
+// Sub-prefixes of the given length.
+func subPrefixes(prefix *net.IPNet, length int) []*net.IPNet {
+	prefixLen, bits := prefix.Mask.Size()
+	size := len(prefix.IP)
+
+	base := new(big.Int).SetBytes(prefix.IP)
+	step := new(big.Int).Lsh(big.NewInt(1), uint(bits-length))
+	count := 1 << (length - prefixLen)
+
+	subs := make([]*net.IPNet, count)
+	for i := 0; i < count; i++ {
+		ip := make(net.IP, size)
+		base.FillBytes(ip)
+		subs[i] = &net.IPNet{
+			IP:   ip,
+			Mask: net.CIDRMask(length, bits),
+		}
+		base.Add(base, step)
+	}
+	return subs
+}
+
+// Replaces the VRPs whose maxLength is greater than the prefix length by one
+// VRP per covered prefix, each with a maxLength equal to its length. VRPs
+// which would expand to more than max VRPs are kept as is and counted.
+func ExpandROAs(roalist []prefixfile.ROAJson, max int) ([]prefixfile.ROAJson, int) {
+	expanded := make([]prefixfile.ROAJson, 0, len(roalist))
+	var skipped int
+	for _, roa := range roalist {
+		prefix := roa.GetPrefix()
+		if prefix == nil {
+			expanded = append(expanded, roa)
+			continue
+		}
+		prefixLen, _ := prefix.Mask.Size()
+		maxLength := int(roa.Length)
+		if maxLength <= prefixLen {
+			expanded = append(expanded, roa)
+			continue
+		}
+		if max > 0 && expandedCount(prefixLen, maxLength) > max {
+			expanded = append(expanded, roa)
+			skipped++
+			continue
+		}
+
+		for length := prefixLen; length <= maxLength; length++ {
+			for _, sub := range subPrefixes(prefix, length) {
+				expanded = append(expanded, prefixfile.ROAJson{
+					Prefix: sub.String(),
+					Length: uint8(length),
+					ASN:    roa.ASN,
+					TA:     roa.TA,
+				})
+			}
+		}
+	}
+	return expanded, skipped
+}
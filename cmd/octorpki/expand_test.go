@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/cloudflare/gortr/prefixfile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandROAs(t *testing.T) {
+	roas := []prefixfile.ROAJson{
+		{Prefix: "192.0.2.0/24", Length: 24, ASN: "AS65001", TA: "A"},
+		{Prefix: "198.51.100.0/22", Length: 24, ASN: "AS65002", TA: "A"},
+		{Prefix: "2001:db8::/32", Length: 34, ASN: "AS65003", TA: "B"},
+		{Prefix: "10.0.0.0/8", Length: 24, ASN: "AS65004", TA: "B"},
+	}
+
+	expanded, skipped := ExpandROAs(roas, 1000)
+	assert.Equal(t, 1, skipped)
+	// 1 + (1 + 2 + 4) + (1 + 2 + 4) + 1 not expanded
+	assert.Len(t, expanded, 16)
+	assert.Equal(t, roas[0], expanded[0])
+	assert.Equal(t, []prefixfile.ROAJson{
+		{Prefix: "198.51.100.0/22", Length: 22, ASN: "AS65002", TA: "A"},
+		{Prefix: "198.51.100.0/23", Length: 23, ASN: "AS65002", TA: "A"},
+		{Prefix: "198.51.102.0/23", Length: 23, ASN: "AS65002", TA: "A"},
+		{Prefix: "198.51.100.0/24", Length: 24, ASN: "AS65002", TA: "A"},
+		{Prefix: "198.51.101.0/24", Length: 24, ASN: "AS65002", TA: "A"},
+		{Prefix: "198.51.102.0/24", Length: 24, ASN: "AS65002", TA: "A"},
+		{Prefix: "198.51.103.0/24", Length: 24, ASN: "AS65002", TA: "A"},
+	}, expanded[1:8])
+	assert.Equal(t, "2001:db8:c000::/34", expanded[14].Prefix)
+	assert.Equal(t, roas[3], expanded[15])
+
+	expanded, skipped = ExpandROAs(roas, 0)
+	assert.Equal(t, 0, skipped)
+	assert.Len(t, expanded, 1+7+7+(1<<17-1))
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+	log "github.com/sirupsen/logrus"
+
+	syncpki "github.com/cloudflare/cfrpki/sync/lib"
+)
+
+// Returns the files of the valid certificates, manifests and ROAs.
+func validObjectFiles(validator *pki.Validator) []*pki.PKIFile {
+	files := make([]*pki.PKIFile, 0, len(validator.ValidObjects)+len(validator.ValidManifest)+len(validator.ValidROA))
+	for _, objects := range []map[string]*pki.Resource{validator.ValidObjects, validator.ValidManifest, validator.ValidROA} {
+		for _, res := range objects {
+			if res.File != nil {
+				files = append(files, res.File)
+			}
+		}
+	}
+	return files
+}
+
+// Name of the file in the archive, keeping the repository path.
+func tarEntryName(file *pki.PKIFile) string {
+	name := strings.TrimPrefix(file.ComputePath(), syncpki.RsyncProtoPrefix)
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// Streams the files from the local cache into a tar archive. Without files on
+// disk, the repository files are read from storage.
+func writeObjectsTar(w io.Writer, files []*pki.PKIFile, replace map[string]string, storage syncpki.Storage) error {
+	tw := tar.NewWriter(w)
+
+	written := make(map[string]struct{})
+	for _, file := range files {
+		name := tarEntryName(file)
+		if _, ok := written[name]; ok {
+			continue
+		}
+		written[name] = struct{}{}
+
+		var err error
+		if uri := file.ComputePath(); storage != nil && strings.HasPrefix(uri, syncpki.RsyncProtoPrefix) {
+			err = writeTarStorage(tw, name, storage, uri)
+		} else {
+			err = writeTarFile(tw, name, syncpki.ReplacePath(file, replace))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+func writeTarStorage(tw *tar.Writer, name string, storage syncpki.Storage, uri string) error {
+	data, err := storage.Get(syncpki.StoragePath(uri))
+	if err != nil {
+		return fmt.Errorf("could not read %s: %v", uri, err)
+	}
+
+	err = tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("could not write header of %s: %v", name, err)
+	}
+
+	_, err = tw.Write(data)
+	return err
+}
+
+func writeTarFile(tw *tar.Writer, name string, localPath string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	hdr, err := tar.FileInfoHeader(fi, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+
+	err = tw.WriteHeader(hdr)
+	if err != nil {
+		return fmt.Errorf("could not write header of %s: %v", name, err)
+	}
+
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+func (s *OctoRPKI) exportTar(file string) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	err = tmpFile.Chmod(0644)
+	if err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	var storage syncpki.Storage
+	if _, ok := s.getStorage().(*syncpki.FileStorage); !ok {
+		storage = s.getStorage()
+	}
+	err = writeObjectsTar(tmpFile, s.validFiles, s.Fetcher.MapDirectory, storage)
+	if err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	err = tmpFile.Close()
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Exported %d objects to %s", len(s.validFiles), file)
+	return os.Rename(tmpFile.Name(), file)
+}
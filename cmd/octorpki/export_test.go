@@ -0,0 +1,121 @@
+package main
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+	"github.com/stretchr/testify/assert"
+
+	syncpki "github.com/cloudflare/cfrpki/sync/lib"
+)
+
+func TestExportTar(t *testing.T) {
+	basepath := t.TempDir()
+	objects := map[string]string{
+		"rpki.example.com/repo/ca.cer":        "certificate",
+		"rpki.example.com/repo/ca/ca.mft":     "manifest",
+		"rpki.example.com/repo/ca/prefix.roa": "roa",
+	}
+	for name, content := range objects {
+		localPath := filepath.Join(basepath, name)
+		assert.Nil(t, os.MkdirAll(filepath.Dir(localPath), os.ModePerm))
+		assert.Nil(t, os.WriteFile(localPath, []byte(content), 0644))
+	}
+
+	mft := &pki.PKIFile{
+		Path: "rsync://rpki.example.com/repo/ca/ca.mft",
+		Repo: "rsync://rpki.example.com/repo/ca/",
+		Type: pki.TYPE_MFT,
+	}
+
+	validator := pki.NewValidator()
+	validator.ValidObjects["ca"] = &pki.Resource{File: &pki.PKIFile{Path: "rsync://rpki.example.com/repo/ca.cer", Type: pki.TYPE_CER}}
+	validator.ValidManifest["ca"] = &pki.Resource{File: mft}
+	validator.ValidROA["prefix"] = &pki.Resource{File: &pki.PKIFile{Parent: mft, Path: "prefix.roa", Type: pki.TYPE_ROA}}
+	// Same file reached twice is only archived once
+	validator.ValidROA["prefix-dup"] = &pki.Resource{File: &pki.PKIFile{Parent: mft, Path: "prefix.roa", Type: pki.TYPE_ROA}}
+
+	s := NewOctoRPKI(nil, nil)
+	s.Fetcher = syncpki.NewLocalFetch(basepath)
+	s.validFiles = validObjectFiles(validator)
+	assert.Len(t, s.validFiles, 4)
+
+	archive := filepath.Join(t.TempDir(), "objects.tar")
+	err := s.exportTar(archive)
+	assert.Nil(t, err)
+
+	f, err := os.Open(archive)
+	assert.Nil(t, err)
+	defer f.Close()
+
+	found := make(map[string]string)
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		content, err := io.ReadAll(tr)
+		assert.Nil(t, err)
+		found[hdr.Name] = string(content)
+	}
+	assert.Equal(t, objects, found)
+
+	// Missing objects in the cache fail the export
+	s.validFiles = append(s.validFiles, &pki.PKIFile{Path: "rsync://rpki.example.com/repo/missing.cer"})
+	err = s.exportTar(archive)
+	assert.NotNil(t, err)
+}
+
+func TestExportTarMemoryStorage(t *testing.T) {
+	storage := syncpki.NewMemoryStorage()
+	assert.Nil(t, storage.Put("rpki.example.com/repo/ca.cer", []byte("certificate")))
+	basepath := t.TempDir()
+	tal := filepath.Join(basepath, "example.tal")
+	assert.Nil(t, os.WriteFile(tal, []byte("tal"), 0644))
+
+	s := NewOctoRPKI(nil, nil)
+	s.storage = storage
+	s.Fetcher = syncpki.NewLocalFetch(basepath)
+	s.Fetcher.SetStorage(storage)
+	// The TALs stay on disk
+	s.validFiles = []*pki.PKIFile{
+		{Path: "rsync://rpki.example.com/repo/ca.cer", Type: pki.TYPE_CER},
+		{Path: tal, Type: pki.TYPE_TAL},
+	}
+
+	archive := filepath.Join(t.TempDir(), "objects.tar")
+	assert.Nil(t, s.exportTar(archive))
+
+	f, err := os.Open(archive)
+	assert.Nil(t, err)
+	defer f.Close()
+
+	found := make(map[string]string)
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		content, err := io.ReadAll(tr)
+		assert.Nil(t, err)
+		found[hdr.Name] = string(content)
+	}
+	assert.Equal(t, map[string]string{
+		"rpki.example.com/repo/ca.cer":        "certificate",
+		tarEntryName(&pki.PKIFile{Path: tal}): "tal",
+	}, found)
+}
+
+func TestTarEntryName(t *testing.T) {
+	assert.Equal(t, "rpki.example.com/repo/ca.cer", tarEntryName(&pki.PKIFile{Path: "rsync://rpki.example.com/repo/ca.cer"}))
+	assert.Equal(t, "ca.cer", tarEntryName(&pki.PKIFile{Path: "rsync://rpki.example.com/repo/../../../ca.cer"}))
+	assert.Equal(t, "tals/example.tal", tarEntryName(&pki.PKIFile{Path: "tals/example.tal"}))
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Compiles the pattern of -fetch.filter. A pattern prefixed by "re:" is a
+// regular expression searched in the rsync URI, otherwise it is a glob
+// matching the whole URI where * matches any sequence of characters,
+// for instance rsync://rpki.ripe.net/*.
+func parseFetchFilter(pattern string) (*regexp.Regexp, error) {
+	if strings.HasPrefix(pattern, "re:") {
+		re, err := regexp.Compile(strings.TrimPrefix(pattern, "re:"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid fetch filter %q: %v", pattern, err)
+		}
+		return re, nil
+	}
+
+	var expr strings.Builder
+	expr.WriteString("^")
+	for _, c := range pattern {
+		switch c {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
+
+// Returns true when the repository is fetched: all of them without -fetch.filter.
+func (s *OctoRPKI) fetchAllowed(rsyncURI string) bool {
+	return s.fetchFilter == nil || s.fetchFilter.MatchString(rsyncURI)
+}
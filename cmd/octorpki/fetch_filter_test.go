@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFetchFilter(t *testing.T) {
+	glob, err := parseFetchFilter("rsync://rpki.ripe.net/*")
+	assert.Nil(t, err)
+	assert.True(t, glob.MatchString("rsync://rpki.ripe.net/repository"))
+	assert.False(t, glob.MatchString("rsync://rpki.ripe.net.example.com/repository"))
+	assert.False(t, glob.MatchString("rsync://rpki.arin.net/repository"))
+
+	re, err := parseFetchFilter("re:ripe|apnic")
+	assert.Nil(t, err)
+	assert.True(t, re.MatchString("rsync://rpki.apnic.net/member_repository"))
+	assert.False(t, re.MatchString("rsync://rpki.arin.net/repository"))
+
+	_, err = parseFetchFilter("re:(")
+	assert.NotNil(t, err)
+}
+
+func TestFetchFilter(t *testing.T) {
+	defer func(v bool) { *Sign = v }(*Sign)
+	*Sign = false
+
+	basepath := t.TempDir()
+	tals := []*pki.PKIFile{
+		createTestRepository(t, basepath, "rpki-a.example.com", []testROA{
+			{ASN: 65001, Prefix: "192.0.2.0/24", MaxLength: 24},
+		}),
+		createTestRepository(t, basepath, "rpki-b.example.com", []testROA{
+			{ASN: 65002, Prefix: "198.51.100.0/24", MaxLength: 24},
+		}),
+	}
+
+	result := runValidation(basepath, tals, []string{"A", "B"}, 1)
+	assert.Len(t, result.RsyncFetch, 2)
+
+	result = runValidation(basepath, tals, []string{"A", "B"}, 1, func(s *OctoRPKI) {
+		s.fetchFilter, _ = parseFetchFilter("rsync://rpki-b.example.com/*")
+	})
+	assert.Len(t, result.RsyncFetch, 1)
+	for rsync := range result.RsyncFetch {
+		assert.Equal(t, "rsync://rpki-b.example.com/repo", rsync)
+	}
+	assert.Len(t, result.RepositoryTALs, 1)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const (
+	FetchProtocolRRDP  = "rrdp"
+	FetchProtocolRsync = "rsync"
+)
+
+// Loads a JSON object mapping repository domains to the protocol used to fetch
+// them, for instance {"rpki.example.com": "rsync"}.
+func loadFetchProtocols(file string) (map[string]string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var protocols map[string]string
+	err = json.Unmarshal(data, &protocols)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode %s: %v", file, err)
+	}
+
+	ret := make(map[string]string, len(protocols))
+	for domain, protocol := range protocols {
+		protocol = strings.ToLower(protocol)
+		if protocol != FetchProtocolRRDP && protocol != FetchProtocolRsync {
+			return nil, fmt.Errorf("invalid protocol %q for %s. Choose either %v or %v", protocol, domain, FetchProtocolRRDP, FetchProtocolRsync)
+		}
+		ret[strings.ToLower(domain)] = protocol
+	}
+	return ret, nil
+}
+
+// Returns the protocol configured for the domain of the rsync or RRDP URI.
+// Repositories without an entry are fetched using RRDP first.
+func (s *OctoRPKI) preferredProtocol(uris ...string) string {
+	for _, uri := range uris {
+		u, err := url.Parse(uri)
+		if err != nil {
+			continue
+		}
+		if protocol, ok := s.fetchProtocols[strings.ToLower(u.Hostname())]; ok {
+			return protocol
+		}
+	}
+	return FetchProtocolRRDP
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadFetchProtocols(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "protocols.json")
+
+	assert.Nil(t, os.WriteFile(file, []byte(`{"RPKI.example.com": "RSYNC", "rrdp.example.net": "rrdp"}`), 0644))
+	protocols, err := loadFetchProtocols(file)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{
+		"rpki.example.com": FetchProtocolRsync,
+		"rrdp.example.net": FetchProtocolRRDP,
+	}, protocols)
+
+	assert.Nil(t, os.WriteFile(file, []byte(`{"rpki.example.com": "ftp"}`), 0644))
+	_, err = loadFetchProtocols(file)
+	assert.NotNil(t, err)
+
+	assert.Nil(t, os.WriteFile(file, []byte(`["rpki.example.com"]`), 0644))
+	_, err = loadFetchProtocols(file)
+	assert.NotNil(t, err)
+
+	_, err = loadFetchProtocols(filepath.Join(dir, "missing.json"))
+	assert.NotNil(t, err)
+}
+
+func TestFetchProtocolOverride(t *testing.T) {
+	defer func(v bool) { *Sign = v }(*Sign)
+	*Sign = false
+
+	basepath := t.TempDir()
+	tals := []*pki.PKIFile{
+		createTestRepository(t, basepath, "rpki-a.example.com", []testROA{
+			{ASN: 65001, Prefix: "192.0.2.0/24", MaxLength: 24},
+		}),
+		createTestRepository(t, basepath, "rpki-b.example.com", []testROA{
+			{ASN: 65002, Prefix: "198.51.100.0/24", MaxLength: 24},
+		}),
+	}
+	talNames := []string{"A", "B"}
+
+	res := runValidation(basepath, tals, talNames, 1)
+	assert.Equal(t, map[string]string{
+		"https://rpki-a.example.com/notification.xml": "rsync://rpki-a.example.com/repo",
+		"https://rpki-b.example.com/notification.xml": "rsync://rpki-b.example.com/repo",
+	}, res.RRDPFetch)
+
+	res = runValidation(basepath, tals, talNames, 1, func(s *OctoRPKI) {
+		s.fetchProtocols = map[string]string{"rpki-a.example.com": FetchProtocolRsync}
+	})
+	// Only fetched using rsync
+	assert.Equal(t, map[string]string{
+		"https://rpki-b.example.com/notification.xml": "rsync://rpki-b.example.com/repo",
+	}, res.RRDPFetch)
+	assert.Contains(t, res.RsyncFetch, "rsync://rpki-a.example.com/repo")
+	assert.Len(t, res.ROAs, 2)
+
+	s := NewOctoRPKI(nil, nil)
+	s.fetchProtocols = map[string]string{"rpki-a.example.com": FetchProtocolRsync}
+	assert.Equal(t, FetchProtocolRsync, s.preferredProtocol("rsync://rpki-a.example.com/repo"))
+	assert.Equal(t, FetchProtocolRsync, s.preferredProtocol("rsync://rpki-b.example.com/repo", "https://rpki-a.example.com/notification.xml"))
+	assert.Equal(t, FetchProtocolRRDP, s.preferredProtocol("rsync://rpki-b.example.com/repo"))
+}
@@ -1,6 +1,15 @@
 package main
 
-import "github.com/cloudflare/gortr/prefixfile"
+import (
+	"net"
+	"sort"
+	"time"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+	"github.com/cloudflare/gortr/prefixfile"
+
+	librpki "github.com/cloudflare/cfrpki/validator/lib"
+)
 
 func FilterInvalidPrefixLen(roalist []prefixfile.ROAJson) []prefixfile.ROAJson {
 	validROAs := make([]prefixfile.ROAJson, 0)
@@ -23,7 +32,8 @@ func FilterInvalidPrefixLen(roalist []prefixfile.ROAJson) []prefixfile.ROAJson {
 	return validROAs
 }
 
-func FilterDuplicates(roalist []prefixfile.ROAJson) []prefixfile.ROAJson {
+// Removes duplicate ROAs and returns how many were dropped.
+func FilterDuplicates(roalist []prefixfile.ROAJson) ([]prefixfile.ROAJson, int) {
 	roalistNodup := make([]prefixfile.ROAJson, 0)
 	existingsROAs := make(map[string]struct{})
 	for _, roa := range roalist {
@@ -35,5 +45,97 @@ func FilterDuplicates(roalist []prefixfile.ROAJson) []prefixfile.ROAJson {
 		}
 	}
 
-	return roalistNodup
+	return roalistNodup, len(roalist) - len(roalistNodup)
+}
+
+// Counts the distinct origin ASNs and prefixes of a ROA list.
+func CountDistinct(roalist []prefixfile.ROAJson) (int, int) {
+	asns := make(map[uint32]struct{})
+	prefixes := make(map[string]struct{})
+	for _, roa := range roalist {
+		asns[roa.GetASN()] = struct{}{}
+		prefixes[roa.Prefix] = struct{}{}
+	}
+	return len(asns), len(prefixes)
+}
+
+type roaSorter struct {
+	roas []prefixfile.ROAJson
+	asns []uint32 // decoded once, the ASN of a ROA can be a string
+}
+
+func (s roaSorter) Len() int {
+	return len(s.roas)
+}
+
+func (s roaSorter) Swap(i, j int) {
+	s.roas[i], s.roas[j] = s.roas[j], s.roas[i]
+	s.asns[i], s.asns[j] = s.asns[j], s.asns[i]
+}
+
+func (s roaSorter) Less(i, j int) bool {
+	a, b := s.roas[i], s.roas[j]
+	if a.Prefix != b.Prefix {
+		return a.Prefix < b.Prefix
+	}
+	if a.Length != b.Length {
+		return a.Length < b.Length
+	}
+	if s.asns[i] != s.asns[j] {
+		return s.asns[i] < s.asns[j]
+	}
+	return a.TA < b.TA
+}
+
+// Sorts ROAs by prefix, max length, ASN and TA for a deterministic output.
+func SortROAs(roalist []prefixfile.ROAJson) {
+	asns := make([]uint32, len(roalist))
+	for i := range roalist {
+		asns[i] = roalist[i].GetASN()
+	}
+	sort.Stable(roaSorter{roas: roalist, asns: asns})
+}
+
+func IsNotYetValid(cert *librpki.RPKICertificate, t time.Time) bool {
+	return cert != nil && cert.Certificate != nil && cert.Certificate.NotBefore.After(t)
+}
+
+// Checks the EE certificate of an object and the CA certificates up to the
+// trust anchor: the object cannot be used before all of them are valid.
+func IsChainNotYetValid(res *pki.Resource, t time.Time) bool {
+	for ; res != nil; res = res.Parent {
+		var cert *librpki.RPKICertificate
+		switch obj := res.Resource.(type) {
+		case *librpki.RPKICertificate:
+			cert = obj
+		case *librpki.RPKIROA:
+			cert = obj.Certificate
+		case *librpki.RPKIManifest:
+			cert = obj.Certificate
+		}
+		if IsNotYetValid(cert, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// A point ROA only authorizes its own prefix: the maximum length equals the
+// prefix length.
+func IsPointROA(entry *librpki.ROAEntry) bool {
+	prefixLen, _ := entry.IPNet.Mask.Size()
+	return entry.MaxLength == prefixLen
+}
+
+// A short prefix covers a large part of the address space, which is almost
+// always a mistake or an attack. The default route is always short.
+func IsShortPrefix(prefix *net.IPNet, minIPv4 int, minIPv6 int) bool {
+	prefixLen, _ := prefix.Mask.Size()
+	if prefixLen == 0 {
+		return true
+	}
+	if prefix.IP.To4() != nil {
+		return prefixLen < minIPv4
+	}
+	return prefixLen < minIPv6
 }
@@ -1,10 +1,16 @@
 package main
 
 import (
+	"crypto/x509"
+	"net"
 	"testing"
+	"time"
 
+	"github.com/cloudflare/cfrpki/validator/pki"
 	"github.com/cloudflare/gortr/prefixfile"
 	"github.com/stretchr/testify/assert"
+
+	librpki "github.com/cloudflare/cfrpki/validator/lib"
 )
 
 func TestFilter(t *testing.T) {
@@ -69,3 +75,125 @@ func TestFilter(t *testing.T) {
 		assert.Equal(t, test.expected, res, test.name)
 	}
 }
+
+func TestIsNotYetValid(t *testing.T) {
+	now := time.Now().UTC()
+	future := &librpki.RPKICertificate{
+		Certificate: &x509.Certificate{
+			NotBefore: now.Add(time.Hour),
+			NotAfter:  now.Add(time.Hour * 24),
+		},
+	}
+	current := &librpki.RPKICertificate{
+		Certificate: &x509.Certificate{
+			NotBefore: now.Add(-time.Hour),
+			NotAfter:  now.Add(time.Hour * 24),
+		},
+	}
+
+	assert.True(t, IsNotYetValid(future, now))
+	assert.False(t, IsNotYetValid(current, now))
+	assert.False(t, IsNotYetValid(nil, now))
+}
+
+func TestIsChainNotYetValid(t *testing.T) {
+	now := time.Now().UTC()
+	newCert := func(notBefore time.Time) *librpki.RPKICertificate {
+		return &librpki.RPKICertificate{
+			Certificate: &x509.Certificate{NotBefore: notBefore, NotAfter: now.Add(time.Hour * 24)},
+		}
+	}
+	ta := &pki.Resource{Type: pki.TYPE_CER, Resource: newCert(now.Add(-time.Hour))}
+	futureCA := &pki.Resource{Type: pki.TYPE_CER, Parent: ta, Resource: newCert(now.Add(time.Hour))}
+	currentCA := &pki.Resource{Type: pki.TYPE_CER, Parent: ta, Resource: newCert(now.Add(-time.Hour))}
+	newROA := func(parent *pki.Resource, notBefore time.Time) *pki.Resource {
+		return &pki.Resource{Type: pki.TYPE_ROA, Parent: parent, Resource: &librpki.RPKIROA{Certificate: newCert(notBefore)}}
+	}
+
+	assert.False(t, IsChainNotYetValid(newROA(currentCA, now.Add(-time.Hour)), now))
+	assert.True(t, IsChainNotYetValid(newROA(currentCA, now.Add(time.Hour)), now))
+	// Current EE certificate under a CA certificate valid in the future
+	assert.True(t, IsChainNotYetValid(newROA(futureCA, now.Add(-time.Hour)), now))
+}
+
+func TestFilterDuplicates(t *testing.T) {
+	input := []prefixfile.ROAJson{
+		{Prefix: "1.1.1.0/24", ASN: 13335, Length: 24},
+		{Prefix: "1.1.1.0/24", ASN: 13335, Length: 24},
+		{Prefix: "1.1.1.0/24", ASN: 13335, Length: 32},
+		{Prefix: "2001:db8::/32", ASN: 13335, Length: 48},
+		{Prefix: "2001:db8::/32", ASN: 13335, Length: 48},
+		{Prefix: "2001:db8::/32", ASN: 13335, Length: 48},
+	}
+
+	got, removed := FilterDuplicates(input)
+	assert.Equal(t, 3, removed)
+	assert.Equal(t, []prefixfile.ROAJson{
+		{Prefix: "1.1.1.0/24", ASN: 13335, Length: 24},
+		{Prefix: "1.1.1.0/24", ASN: 13335, Length: 32},
+		{Prefix: "2001:db8::/32", ASN: 13335, Length: 48},
+	}, got)
+
+	got, removed = FilterDuplicates(got)
+	assert.Equal(t, 0, removed)
+	assert.Len(t, got, 3)
+}
+
+func TestSortROAs(t *testing.T) {
+	roas := []prefixfile.ROAJson{
+		{Prefix: "192.0.2.0/24", ASN: "AS64500", Length: 24, TA: "B"},
+		{Prefix: "192.0.2.0/24", ASN: "AS10", Length: 24},
+		{Prefix: "192.0.2.0/24", ASN: "AS9", Length: 24},
+		{Prefix: "192.0.2.0/24", ASN: "AS64500", Length: 24, TA: "A"},
+		{Prefix: "192.0.2.0/24", ASN: "AS9", Length: 23},
+		{Prefix: "10.0.0.0/8", ASN: "AS64500", Length: 8},
+	}
+	SortROAs(roas)
+	assert.Equal(t, []prefixfile.ROAJson{
+		{Prefix: "10.0.0.0/8", ASN: "AS64500", Length: 8},
+		{Prefix: "192.0.2.0/24", ASN: "AS9", Length: 23},
+		{Prefix: "192.0.2.0/24", ASN: "AS9", Length: 24},
+		{Prefix: "192.0.2.0/24", ASN: "AS10", Length: 24},
+		{Prefix: "192.0.2.0/24", ASN: "AS64500", Length: 24, TA: "A"},
+		{Prefix: "192.0.2.0/24", ASN: "AS64500", Length: 24, TA: "B"},
+	}, roas)
+}
+
+func TestCountDistinct(t *testing.T) {
+	asns, prefixes := CountDistinct([]prefixfile.ROAJson{
+		{Prefix: "1.1.1.0/24", ASN: 13335, Length: 24},
+		{Prefix: "1.1.1.0/24", ASN: 13335, Length: 32},
+		{Prefix: "1.1.1.0/24", ASN: "AS64500", Length: 24},
+		{Prefix: "2001:db8::/32", ASN: "AS13335", Length: 48},
+		{Prefix: "198.51.100.0/24", ASN: uint32(64501), Length: 24},
+	})
+	assert.Equal(t, 3, asns)
+	assert.Equal(t, 3, prefixes)
+
+	asns, prefixes = CountDistinct(nil)
+	assert.Equal(t, 0, asns)
+	assert.Equal(t, 0, prefixes)
+}
+
+func TestIsShortPrefix(t *testing.T) {
+	tests := []struct {
+		prefix string
+		short  bool
+	}{
+		{"0.0.0.0/0", true},
+		{"::/0", true},
+		{"10.0.0.0/7", true},
+		{"10.0.0.0/8", false},
+		{"2000::/3", true},
+		{"2001:db8::/32", false},
+	}
+	for _, test := range tests {
+		_, prefix, err := net.ParseCIDR(test.prefix)
+		assert.Nil(t, err)
+		assert.Equal(t, test.short, IsShortPrefix(prefix, 8, 16), test.prefix)
+	}
+
+	// The default route is reported even without a minimum length
+	_, prefix, _ := net.ParseCIDR("0.0.0.0/0")
+	assert.True(t, IsShortPrefix(prefix, 0, 0))
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+	"github.com/stretchr/testify/assert"
+
+	librpki "github.com/cloudflare/cfrpki/validator/lib"
+)
+
+type testROA struct {
+	ASN       int
+	Prefix    string
+	MaxLength int
+}
+
+// Writes a TAL with a root certificate, its manifest, CRL and ROAs into basepath,
+// using the same layout as the rsync cache. Returns the TAL file.
+func createTestRepository(t *testing.T, basepath string, host string, roas []testROA) *pki.PKIFile {
+	repo := fmt.Sprintf("rsync://%s/repo/", host)
+	writeFile := func(uri string, data []byte) {
+		localPath := filepath.Join(basepath, uri[len("rsync://"):])
+		assert.Nil(t, os.MkdirAll(filepath.Dir(localPath), os.ModePerm))
+		assert.Nil(t, os.WriteFile(localPath, data, 0644))
+	}
+	newKey := func() (*rsa.PrivateKey, []byte) {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		assert.Nil(t, err)
+		ski, err := librpki.HashPublicKey(key.Public())
+		assert.Nil(t, err)
+		return key, ski
+	}
+	fileHash := func(data []byte) asn1.BitString {
+		hash := sha256.Sum256(data)
+		return asn1.BitString{Bytes: hash[:], BitLength: 256}
+	}
+
+	genTime := time.Now().UTC().Add(-time.Hour)
+	validity := time.Hour * 24 * 365
+
+	policy, err := librpki.EncodePolicyInformation("http://example.com/cps.html")
+	assert.Nil(t, err)
+	parentPath, err := librpki.EncodeInfoAccess(true, repo+"root.cer")
+	assert.Nil(t, err)
+
+	_, net4, _ := net.ParseCIDR("0.0.0.0/0")
+	_, net6, _ := net.ParseCIDR("::/0")
+	ipBlocks, err := librpki.EncodeIPAddressBlock([]librpki.IPCertificateInformation{
+		&librpki.IPNet{IPNet: net4},
+		&librpki.IPNet{IPNet: net6},
+	})
+	assert.Nil(t, err)
+	ipBlocksInherit, err := librpki.EncodeIPAddressBlock([]librpki.IPCertificateInformation{
+		&librpki.IPAddressNull{Family: 1},
+	})
+	assert.Nil(t, err)
+	asnBlocks, err := librpki.EncodeASN([]librpki.ASNCertificateInformation{
+		&librpki.ASNRange{Min: 0, Max: 1<<31 - 1},
+	}, nil)
+	assert.Nil(t, err)
+	asnBlocksInherit, err := librpki.EncodeASN([]librpki.ASNCertificateInformation{
+		&librpki.ASNull{},
+	}, nil)
+	assert.Nil(t, err)
+
+	sias, err := librpki.EncodeSIA([]*librpki.SIA{
+		{AccessMethod: librpki.CertRepository, GeneralName: []byte(repo)},
+		{AccessMethod: librpki.SIAManifest, GeneralName: []byte(repo + "root.mft")},
+		{AccessMethod: CertRRDP, GeneralName: []byte(fmt.Sprintf("https://%s/notification.xml", host))},
+	})
+	assert.Nil(t, err)
+
+	// Root certificate and TAL
+	rootKey, rootSKI := newKey()
+	rootCert := &x509.Certificate{
+		Version:               3,
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: host},
+		ExtraExtensions:       []pkix.Extension{*sias, *ipBlocks, *asnBlocks, *policy},
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		SubjectKeyId:          rootSKI,
+		NotBefore:             genTime,
+		NotAfter:              genTime.Add(validity),
+	}
+	rootBytes, err := x509.CreateCertificate(rand.Reader, rootCert, rootCert, rootKey.Public(), rootKey)
+	assert.Nil(t, err)
+	writeFile(repo+"root.cer", rootBytes)
+
+	tal, err := librpki.CreateTAL([]string{repo + "root.cer"}, rootKey.Public())
+	assert.Nil(t, err)
+	talBytes, err := librpki.EncodeTAL(tal)
+	assert.Nil(t, err)
+	talPath := filepath.Join(basepath, host+".tal")
+	assert.Nil(t, os.WriteFile(talPath, talBytes, 0644))
+
+	// CRL
+	crlBytes, err := librpki.CreateCRL(rootCert, rand.Reader, rootKey, []pkix.RevokedCertificate{}, genTime, genTime.Add(validity), big.NewInt(1))
+	assert.Nil(t, err)
+	writeFile(repo+"root.crl", crlBytes)
+
+	eeCert := func(serial int64, ski []byte, object string, ipExt *pkix.Extension, asnExt *pkix.Extension) *x509.Certificate {
+		objectPath, err := librpki.EncodeInfoAccess(false, repo+object)
+		assert.Nil(t, err)
+		extensions := []pkix.Extension{*policy, *ipExt, *parentPath, *objectPath}
+		if asnExt != nil {
+			extensions = append(extensions, *asnExt)
+		}
+		return &x509.Certificate{
+			Version:               3,
+			SerialNumber:          big.NewInt(serial),
+			Subject:               pkix.Name{CommonName: object},
+			ExtraExtensions:       extensions,
+			NotBefore:             genTime,
+			NotAfter:              genTime.Add(validity),
+			SubjectKeyId:          ski,
+			AuthorityKeyId:        rootSKI,
+			KeyUsage:              x509.KeyUsageDigitalSignature,
+			CRLDistributionPoints: []string{repo + "root.crl"},
+		}
+	}
+
+	// ROAs
+	files := []librpki.File{{Name: "root.crl", Hash: fileHash(crlBytes)}}
+	for i, roa := range roas {
+		_, prefix, err := net.ParseCIDR(roa.Prefix)
+		assert.Nil(t, err)
+		content, err := librpki.EncodeROAEntries(roa.ASN, []*librpki.ROAEntry{
+			{IPNet: prefix, MaxLength: roa.MaxLength},
+		})
+		assert.Nil(t, err)
+		roaCms, err := librpki.EncodeCMS(nil, content, genTime)
+		assert.Nil(t, err)
+
+		name := fmt.Sprintf("%d.roa", i)
+		roaKey, roaSKI := newKey()
+		certBytes, err := x509.CreateCertificate(rand.Reader, eeCert(int64(100+i), roaSKI, name, ipBlocks, nil), rootCert, roaKey.Public(), rootKey)
+		assert.Nil(t, err)
+
+		encap, err := librpki.ROAToEncap(content)
+		assert.Nil(t, err)
+		assert.Nil(t, roaCms.Sign(rand.Reader, roaSKI, encap, roaKey, certBytes))
+		roaBytes, err := asn1.Marshal(*roaCms)
+		assert.Nil(t, err)
+
+		writeFile(repo+name, roaBytes)
+		files = append(files, librpki.File{Name: name, Hash: fileHash(roaBytes)})
+	}
+
+	// Manifest
+	mftContent, err := librpki.EncodeManifestContent(librpki.ManifestContent{
+		ManifestNumber: big.NewInt(1),
+		ThisUpdate:     genTime,
+		NextUpdate:     genTime.Add(time.Hour * 24),
+		FileHashAlg:    librpki.SHA256OID,
+		FileList:       files,
+	})
+	assert.Nil(t, err)
+	mftCms, err := librpki.EncodeCMS(nil, mftContent, genTime)
+	assert.Nil(t, err)
+
+	mftKey, mftSKI := newKey()
+	certBytes, err := x509.CreateCertificate(rand.Reader, eeCert(2, mftSKI, "root.mft", ipBlocksInherit, asnBlocksInherit), rootCert, mftKey.Public(), rootKey)
+	assert.Nil(t, err)
+
+	encap, err := librpki.ManifestToEncap(mftContent)
+	assert.Nil(t, err)
+	assert.Nil(t, mftCms.Sign(rand.Reader, mftSKI, encap, mftKey, certBytes))
+	mftBytes, err := asn1.Marshal(*mftCms)
+	assert.Nil(t, err)
+	writeFile(repo+"root.mft", mftBytes)
+
+	return &pki.PKIFile{
+		Path: talPath,
+		Type: pki.TYPE_TAL,
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/gortr/prefixfile"
+)
+
+const (
+	OutputFormatGoRTR      = "gortr"
+	OutputFormatRoutinator = "routinator"
+)
+
+type RoutinatorMetadata struct {
+	Generated     int      `json:"generated"`
+	GeneratedTime string   `json:"generatedTime"`
+	TAFilter      []string `json:"taFilter,omitempty"`
+}
+
+type RoutinatorVRP struct {
+	ASN       string `json:"asn"`
+	Prefix    string `json:"prefix"`
+	MaxLength uint8  `json:"maxLength"`
+	TA        string `json:"ta"`
+}
+
+// Same shape as the json output of Routinator.
+type RoutinatorOutput struct {
+	Metadata RoutinatorMetadata `json:"metadata"`
+	ROAs     []RoutinatorVRP    `json:"roas"`
+}
+
+func ToRoutinatorOutput(roaList *prefixfile.ROAList) *RoutinatorOutput {
+	output := &RoutinatorOutput{
+		Metadata: RoutinatorMetadata{
+			Generated:     roaList.Metadata.Generated,
+			GeneratedTime: time.Unix(int64(roaList.Metadata.Generated), 0).UTC().Format(time.RFC3339),
+		},
+		ROAs: make([]RoutinatorVRP, len(roaList.Data)),
+	}
+
+	for i, roa := range roaList.Data {
+		output.ROAs[i] = RoutinatorVRP{
+			ASN:       fmt.Sprintf("AS%d", roa.GetASN()),
+			Prefix:    roa.Prefix,
+			MaxLength: roa.Length,
+			TA:        roa.TA,
+		}
+	}
+
+	return output
+}
+
+// Metadata of a ROA list restricted to some trust anchors. The signature
+// covers the complete list, so a filtered list is never signed.
+type FilteredMetaData struct {
+	Counts    int      `json:"counts"`
+	Generated int      `json:"generated"`
+	Valid     int      `json:"valid,omitempty"`
+	Unsigned  bool     `json:"unsigned"`
+	TAFilter  []string `json:"ta-filter"`
+}
+
+type FilteredROAList struct {
+	Metadata FilteredMetaData     `json:"metadata"`
+	Data     []prefixfile.ROAJson `json:"roas"`
+}
+
+// Keeps the VRPs of the given trust anchors (case insensitive).
+func filterROAsTA(roas []prefixfile.ROAJson, tas []string) []prefixfile.ROAJson {
+	filtered := make([]prefixfile.ROAJson, 0)
+	for _, roa := range roas {
+		for _, ta := range tas {
+			if strings.EqualFold(roa.TA, ta) {
+				filtered = append(filtered, roa)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// Returns the ROA list in the selected output format, restricted to the
+// trust anchors in taFilter when it is not empty.
+func formatROAList(roaList *prefixfile.ROAList, format string, taFilter []string) (interface{}, error) {
+	if len(taFilter) > 0 {
+		roaList = &prefixfile.ROAList{
+			Metadata: prefixfile.MetaData{
+				Generated: roaList.Metadata.Generated,
+				Valid:     roaList.Metadata.Valid,
+			},
+			Data: filterROAsTA(roaList.Data, taFilter),
+		}
+		roaList.Metadata.Counts = len(roaList.Data)
+	}
+
+	switch format {
+	case OutputFormatGoRTR:
+		if len(taFilter) > 0 {
+			return &FilteredROAList{
+				Metadata: FilteredMetaData{
+					Counts:    roaList.Metadata.Counts,
+					Generated: roaList.Metadata.Generated,
+					Valid:     roaList.Metadata.Valid,
+					Unsigned:  true,
+					TAFilter:  taFilter,
+				},
+				Data: roaList.Data,
+			}, nil
+		}
+		return roaList, nil
+	case OutputFormatRoutinator:
+		output := ToRoutinatorOutput(roaList)
+		output.Metadata.TAFilter = taFilter
+		return output, nil
+	case OutputFormatProtobuf:
+		return &ProtobufROAList{ROAList: roaList, TAFilter: taFilter}, nil
+	}
+	return nil, fmt.Errorf("output format %v is not supported. Choose either %v, %v or %v", format, OutputFormatGoRTR, OutputFormatRoutinator, OutputFormatProtobuf)
+}
+
+// Encodes a ROA list returned by formatROAList.
+func marshalROAList(output interface{}) ([]byte, error) {
+	if l, ok := output.(*ProtobufROAList); ok {
+		return l.Marshal()
+	}
+	return json.Marshal(output)
+}
+
+func outputContentType(format string) string {
+	if format == OutputFormatProtobuf {
+		return "application/x-protobuf"
+	}
+	return "application/json"
+}
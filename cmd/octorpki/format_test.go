@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/cloudflare/gortr/prefixfile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoutinatorFormat(t *testing.T) {
+	roaList := &prefixfile.ROAList{
+		Metadata: prefixfile.MetaData{
+			Counts:    3,
+			Generated: 1626853335,
+			Valid:     1626856935,
+		},
+		Data: []prefixfile.ROAJson{
+			{Prefix: "1.0.0.0/24", Length: 24, ASN: "AS13335", TA: "APNIC"},
+			{Prefix: "2606:4700::/32", Length: 48, ASN: 13335, TA: "ARIN"},
+			{Prefix: "192.0.2.0/24", Length: 24, ASN: "AS0", TA: "RIPE"},
+		},
+	}
+
+	output, err := formatROAList(roaList, OutputFormatRoutinator, nil)
+	assert.Nil(t, err)
+	data, err := json.MarshalIndent(output, "", "  ")
+	assert.Nil(t, err)
+
+	golden, err := os.ReadFile("testdata/routinator.json")
+	assert.Nil(t, err)
+	assert.JSONEq(t, string(golden), string(data))
+
+	output, err = formatROAList(roaList, OutputFormatGoRTR, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, roaList, output)
+
+	_, err = formatROAList(roaList, "unknown", nil)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/cloudflare/gortr/prefixfile"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Updates queued for a client of the VRPStream service before it is
+// disconnected for being too slow.
+const vrpStreamBuffer = 8
+
+type grpcMessage interface {
+	Marshal() ([]byte, error)
+	Unmarshal([]byte) error
+}
+
+// Codec of the VRPStream service (vrps.proto), encoding the messages with the
+// protobuf helpers instead of generated code. The wire format is the same.
+type grpcCodec struct{}
+
+func (grpcCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(grpcMessage)
+	if !ok {
+		return nil, fmt.Errorf("unexpected message type %T", v)
+	}
+	return m.Marshal()
+}
+
+func (grpcCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(grpcMessage)
+	if !ok {
+		return fmt.Errorf("unexpected message type %T", v)
+	}
+	return m.Unmarshal(data)
+}
+
+func (grpcCodec) Name() string {
+	return "proto"
+}
+
+type StreamRequest struct{}
+
+func (r *StreamRequest) Marshal() ([]byte, error) {
+	return nil, nil
+}
+
+func (r *StreamRequest) Unmarshal(b []byte) error {
+	return consumeProtobufFields(b, func(num protowire.Number, typ protowire.Type, value []byte, varint uint64) error {
+		return nil
+	})
+}
+
+// VRPUpdate message of vrps.proto.
+type VRPUpdate struct {
+	Metadata  prefixfile.MetaData
+	Reset     bool
+	Announced []prefixfile.ROAJson
+	Withdrawn []prefixfile.ROAJson
+}
+
+func (u *VRPUpdate) Marshal() ([]byte, error) {
+	b := appendProtobufMessage(nil, 1, encodeProtobufMetadata(u.Metadata, nil))
+	if u.Reset {
+		b = appendProtobufVarint(b, 2, 1)
+	}
+	for _, roa := range u.Announced {
+		vrp, err := encodeProtobufVRP(roa)
+		if err != nil {
+			return nil, err
+		}
+		b = appendProtobufMessage(b, 3, vrp)
+	}
+	for _, roa := range u.Withdrawn {
+		vrp, err := encodeProtobufVRP(roa)
+		if err != nil {
+			return nil, err
+		}
+		b = appendProtobufMessage(b, 4, vrp)
+	}
+	return b, nil
+}
+
+func (u *VRPUpdate) Unmarshal(b []byte) error {
+	*u = VRPUpdate{}
+	return consumeProtobufFields(b, func(num protowire.Number, typ protowire.Type, value []byte, varint uint64) error {
+		switch num {
+		case 1:
+			_, err := decodeProtobufMetadata(value, &u.Metadata)
+			return err
+		case 2:
+			u.Reset = varint != 0
+		case 3, 4:
+			roa, err := decodeProtobufVRP(value)
+			if err != nil {
+				return err
+			}
+			if num == 3 {
+				u.Announced = append(u.Announced, roa)
+			} else {
+				u.Withdrawn = append(u.Withdrawn, roa)
+			}
+		}
+		return nil
+	})
+}
+
+// VRPStream service: keeps the last published ROA list to send it to new
+// clients, and the changes to the connected ones.
+type vrpStream struct {
+	mu       sync.Mutex
+	metadata prefixfile.MetaData
+	roas     map[string]prefixfile.ROAJson // by ROAJson.String(), nil until the first list
+	clients  map[chan *VRPUpdate]struct{}
+}
+
+func newVRPStream() *vrpStream {
+	return &vrpStream{
+		clients: make(map[chan *VRPUpdate]struct{}),
+	}
+}
+
+// Complete list, sent to the new clients.
+func (v *vrpStream) snapshot() *VRPUpdate {
+	update := &VRPUpdate{
+		Metadata:  v.metadata,
+		Reset:     true,
+		Announced: make([]prefixfile.ROAJson, 0, len(v.roas)),
+	}
+	for _, roa := range v.roas {
+		update.Announced = append(update.Announced, roa)
+	}
+	SortROAs(update.Announced)
+	return update
+}
+
+// Sends the VRPs which changed since the previous list to the clients, meant
+// to be called on stable validations. The first list is sent complete.
+func (v *vrpStream) publish(roaList *prefixfile.ROAList) {
+	roas := make(map[string]prefixfile.ROAJson, len(roaList.Data))
+	for _, roa := range roaList.Data {
+		roas[roa.String()] = roa
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	update := &VRPUpdate{
+		Metadata: roaList.Metadata,
+		Reset:    v.roas == nil,
+	}
+	for k, roa := range roas {
+		if _, ok := v.roas[k]; !ok {
+			update.Announced = append(update.Announced, roa)
+		}
+	}
+	for k, roa := range v.roas {
+		if _, ok := roas[k]; !ok {
+			update.Withdrawn = append(update.Withdrawn, roa)
+		}
+	}
+	v.metadata = roaList.Metadata
+	v.roas = roas
+	if !update.Reset && len(update.Announced) == 0 && len(update.Withdrawn) == 0 {
+		return
+	}
+	SortROAs(update.Announced)
+	SortROAs(update.Withdrawn)
+
+	for c := range v.clients {
+		select {
+		case c <- update:
+		default:
+			delete(v.clients, c)
+			close(c)
+		}
+	}
+	MetricGRPCClients.Set(float64(len(v.clients)))
+}
+
+// Registers a client, which first receives the complete list if one was
+// published. The channel is closed when the client falls behind.
+func (v *vrpStream) subscribe() chan *VRPUpdate {
+	c := make(chan *VRPUpdate, vrpStreamBuffer)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.roas != nil {
+		c <- v.snapshot()
+	}
+	v.clients[c] = struct{}{}
+	MetricGRPCClients.Set(float64(len(v.clients)))
+	return c
+}
+
+func (v *vrpStream) unsubscribe(c chan *VRPUpdate) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	delete(v.clients, c)
+	MetricGRPCClients.Set(float64(len(v.clients)))
+}
+
+func (v *vrpStream) stream(stream grpc.ServerStream) error {
+	var req StreamRequest
+	err := stream.RecvMsg(&req)
+	if err != nil {
+		return err
+	}
+
+	c := v.subscribe()
+	defer v.unsubscribe(c)
+	for {
+		select {
+		case update, ok := <-c:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "too slow to receive the VRP updates")
+			}
+			err = stream.SendMsg(update)
+			if err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+var vrpStreamServiceDesc = grpc.ServiceDesc{
+	ServiceName: "VRPStream",
+	HandlerType: (*interface{})(nil),
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName: "Stream",
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				return srv.(*vrpStream).stream(stream)
+			},
+			ServerStreams: true,
+		},
+	},
+	Metadata: "vrps.proto",
+}
+
+func (v *vrpStream) newServer() *grpc.Server {
+	server := grpc.NewServer(grpc.ForceServerCodec(grpcCodec{}))
+	server.RegisterService(&vrpStreamServiceDesc, v)
+	return server
+}
+
+func (v *vrpStream) serve(addr string) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Fatal(v.newServer().Serve(l))
+}
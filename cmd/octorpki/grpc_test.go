@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/gortr/prefixfile"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestVRPUpdateProtobuf(t *testing.T) {
+	update := &VRPUpdate{
+		Metadata:  prefixfile.MetaData{Generated: 1000, Valid: 4600, Counts: 1, Serial: 3},
+		Announced: []prefixfile.ROAJson{{Prefix: "192.0.2.0/24", Length: 24, ASN: "AS65001", TA: "Example"}},
+		Withdrawn: []prefixfile.ROAJson{{Prefix: "2001:db8::/32", Length: 48, ASN: "AS65002", TA: "Example"}},
+	}
+	b, err := update.Marshal()
+	assert.Nil(t, err)
+
+	var decoded VRPUpdate
+	assert.Nil(t, decoded.Unmarshal(b))
+	assert.Equal(t, update.Metadata, decoded.Metadata)
+	assert.False(t, decoded.Reset)
+	assert.Equal(t, []string{"192.0.2.0/24/24/AS65001"}, roaStrings(decoded.Announced))
+	assert.Equal(t, []string{"2001:db8::/32/48/AS65002"}, roaStrings(decoded.Withdrawn))
+}
+
+func roaStrings(roas []prefixfile.ROAJson) []string {
+	strs := make([]string, len(roas))
+	for i, roa := range roas {
+		strs[i] = roa.String()
+	}
+	return strs
+}
+
+func TestVRPStream(t *testing.T) {
+	v := newVRPStream()
+	v.publish(&prefixfile.ROAList{
+		Metadata: prefixfile.MetaData{Generated: 1000, Counts: 2},
+		Data: []prefixfile.ROAJson{
+			{Prefix: "192.0.2.0/24", Length: 24, ASN: "AS65001", TA: "Example"},
+			{Prefix: "198.51.100.0/24", Length: 24, ASN: "AS65002", TA: "Example"},
+		},
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	server := v.newServer()
+	go server.Serve(l)
+	defer server.Stop()
+
+	conn, err := grpc.Dial(l.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(grpcCodec{})))
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stream, err := conn.NewStream(ctx, &vrpStreamServiceDesc.Streams[0], "/VRPStream/Stream")
+	assert.Nil(t, err)
+	assert.Nil(t, stream.SendMsg(&StreamRequest{}))
+	assert.Nil(t, stream.CloseSend())
+
+	// Initial snapshot
+	var update VRPUpdate
+	assert.Nil(t, stream.RecvMsg(&update))
+	assert.True(t, update.Reset)
+	assert.Equal(t, 1000, update.Metadata.Generated)
+	assert.Equal(t, []string{"192.0.2.0/24/24/AS65001", "198.51.100.0/24/24/AS65002"}, roaStrings(update.Announced))
+	assert.Empty(t, update.Withdrawn)
+
+	// An unchanged list is not sent
+	v.publish(&prefixfile.ROAList{
+		Metadata: prefixfile.MetaData{Generated: 1100, Counts: 2},
+		Data: []prefixfile.ROAJson{
+			{Prefix: "192.0.2.0/24", Length: 24, ASN: "AS65001", TA: "Example"},
+			{Prefix: "198.51.100.0/24", Length: 24, ASN: "AS65002", TA: "Example"},
+		},
+	})
+	v.publish(&prefixfile.ROAList{
+		Metadata: prefixfile.MetaData{Generated: 1200, Counts: 2},
+		Data: []prefixfile.ROAJson{
+			{Prefix: "192.0.2.0/24", Length: 24, ASN: "AS65001", TA: "Example"},
+			{Prefix: "203.0.113.0/24", Length: 24, ASN: "AS65003", TA: "Example"},
+		},
+	})
+	assert.Nil(t, stream.RecvMsg(&update))
+	assert.False(t, update.Reset)
+	assert.Equal(t, 1200, update.Metadata.Generated)
+	assert.Equal(t, []string{"203.0.113.0/24/24/AS65003"}, roaStrings(update.Announced))
+	assert.Equal(t, []string{"198.51.100.0/24/24/AS65002"}, roaStrings(update.Withdrawn))
+	assert.Equal(t, float64(1), getGaugeValue(t, MetricGRPCClients))
+}
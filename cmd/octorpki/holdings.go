@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+
+	librpki "github.com/cloudflare/cfrpki/validator/lib"
+)
+
+// Resources (RFC 3779) held by a valid CA certificate.
+type CAHoldings struct {
+	ID       string   `json:"id"`               // URI of the certificate, or its SKI when the file is unknown
+	Parent   string   `json:"parent,omitempty"` // issuing CA, empty for the root certificates
+	SKI      string   `json:"ski"`
+	TA       string   `json:"ta"`
+	Prefixes []string `json:"prefixes"` // prefixes, or ranges as first-last
+	ASNs     []string `json:"asns"`     // ASNs, or ranges as first-last
+	Inherit  []string `json:"inherit,omitempty"`
+}
+
+type HoldingsResult struct {
+	CAs []CAHoldings `json:"cas"`
+}
+
+func ipHolding(ip librpki.IPCertificateInformation) (string, bool) {
+	switch ip := ip.(type) {
+	case *librpki.IPNet:
+		return ip.IPNet.String(), true
+	case *librpki.IPAddressRange:
+		return fmt.Sprintf("%v-%v", ip.Min, ip.Max), true
+	}
+	return "", false
+}
+
+func asnHolding(asn librpki.ASNCertificateInformation) (string, bool) {
+	switch asn := asn.(type) {
+	case *librpki.ASN:
+		return fmt.Sprintf("AS%d", asn.ASN), true
+	case *librpki.ASNRange:
+		return fmt.Sprintf("AS%d-AS%d", asn.Min, asn.Max), true
+	}
+	return "", false
+}
+
+// Lists the resources of the valid CA certificates of a TAL, sorted by ID.
+// Inherited resources are only listed by the ancestor holding them.
+func caHoldings(ta string, validator *pki.Validator) []CAHoldings {
+	holdings := make([]CAHoldings, 0)
+	for _, res := range validator.ValidObjects {
+		if res.Type != pki.TYPE_CER {
+			continue
+		}
+		cer, ok := res.Resource.(*librpki.RPKICertificate)
+		if !ok || cer.Certificate == nil || !cer.Certificate.IsCA {
+			continue
+		}
+
+		h := CAHoldings{
+			ID:       topologyCAID(res),
+			SKI:      hex.EncodeToString(cer.Certificate.SubjectKeyId),
+			TA:       ta,
+			Prefixes: make([]string, 0, len(cer.IPAddresses)),
+			ASNs:     make([]string, 0, len(cer.ASNums)),
+		}
+		if res.Parent != nil {
+			h.Parent = topologyCAID(res.Parent)
+		}
+		for _, ip := range cer.IPAddresses {
+			if prefix, ok := ipHolding(ip); ok {
+				h.Prefixes = append(h.Prefixes, prefix)
+			} else if ip.GetAfi() == 1 {
+				h.Inherit = append(h.Inherit, "ipv4")
+			} else {
+				h.Inherit = append(h.Inherit, "ipv6")
+			}
+		}
+		for _, asn := range cer.ASNums {
+			if holding, ok := asnHolding(asn); ok {
+				h.ASNs = append(h.ASNs, holding)
+			} else {
+				h.Inherit = append(h.Inherit, "asn")
+			}
+		}
+		holdings = append(holdings, h)
+	}
+	sort.Slice(holdings, func(i, j int) bool {
+		return holdings[i].ID < holdings[j].ID
+	})
+	return holdings
+}
+
+func (s *OctoRPKI) ServeHoldings(w http.ResponseWriter, r *http.Request) {
+	if !s.Stable.Load() && *WaitStable && !s.HasPreviousStable.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("File not ready yet"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.Encode(HoldingsResult{CAs: s.getSnapshot().Holdings})
+}
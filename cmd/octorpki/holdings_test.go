@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+	"github.com/stretchr/testify/assert"
+
+	librpki "github.com/cloudflare/cfrpki/validator/lib"
+)
+
+func TestCAHoldings(t *testing.T) {
+	parent := &pki.Resource{File: &pki.PKIFile{Path: "rsync://rpki.example.com/repo/root.cer"}}
+	validator := pki.NewValidator()
+	validator.ValidObjects["child"] = &pki.Resource{
+		Type:   pki.TYPE_CER,
+		File:   &pki.PKIFile{Path: "rsync://rpki.example.com/repo/child.cer"},
+		Parent: parent,
+		Resource: &librpki.RPKICertificate{
+			Certificate: &x509.Certificate{IsCA: true, SubjectKeyId: []byte{0x01, 0x02}},
+			IPAddresses: []librpki.IPCertificateInformation{
+				&librpki.IPAddressRange{Min: net.ParseIP("192.0.2.10").To4(), Max: net.ParseIP("192.0.2.20").To4()},
+				&librpki.IPAddressNull{Family: 2},
+			},
+			ASNums: []librpki.ASNCertificateInformation{
+				&librpki.ASNull{},
+			},
+		},
+	}
+	validator.ValidObjects["router"] = &pki.Resource{
+		Type: pki.TYPE_CER,
+		Resource: &librpki.RPKICertificate{
+			Certificate: &x509.Certificate{},
+			ASNums:      []librpki.ASNCertificateInformation{&librpki.ASN{ASN: 65001}},
+		},
+	}
+
+	assert.Equal(t, []CAHoldings{{
+		ID:       "rsync://rpki.example.com/repo/child.cer",
+		Parent:   "rsync://rpki.example.com/repo/root.cer",
+		SKI:      "0102",
+		TA:       "Example",
+		Prefixes: []string{"192.0.2.10-192.0.2.20"},
+		ASNs:     []string{},
+		Inherit:  []string{"ipv6", "asn"},
+	}}, caHoldings("Example", validator))
+}
+
+func TestValidationHoldings(t *testing.T) {
+	defer func(v bool) { *Sign = v }(*Sign)
+	*Sign = false
+	defer func(v string) { *HoldingsPath = v }(*HoldingsPath)
+
+	basepath := t.TempDir()
+	tals := []*pki.PKIFile{createTestRepository(t, basepath, "rpki.example.com", []testROA{
+		{ASN: 65001, Prefix: "192.0.2.0/24", MaxLength: 24},
+	})}
+
+	// Not collected by default
+	var s *OctoRPKI
+	runValidation(basepath, tals, []string{"Example"}, 1, func(o *OctoRPKI) { s = o })
+	assert.Empty(t, s.getSnapshot().Holdings)
+
+	*HoldingsPath = "/holdings"
+	runValidation(basepath, tals, []string{"Example"}, 1, func(o *OctoRPKI) { s = o })
+	s.Stable.Store(true)
+
+	rec := httptest.NewRecorder()
+	s.ServeHoldings(rec, httptest.NewRequest("GET", "/holdings", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var result HoldingsResult
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &result))
+
+	// Only the root certificate is a CA, the EE certificates are not listed
+	assert.Len(t, result.CAs, 1)
+	root := result.CAs[0]
+	assert.Equal(t, "rsync://rpki.example.com/repo/root.cer", root.ID)
+	assert.Empty(t, root.Parent)
+	assert.Equal(t, "Example", root.TA)
+	assert.NotEmpty(t, root.SKI)
+	assert.Equal(t, []string{"0.0.0.0/0", "::/0"}, root.Prefixes)
+	assert.Equal(t, []string{"AS0-AS2147483647"}, root.ASNs)
+	assert.Empty(t, root.Inherit)
+}
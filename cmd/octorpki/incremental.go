@@ -0,0 +1,148 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+	log "github.com/sirupsen/logrus"
+
+	syncpki "github.com/cloudflare/cfrpki/sync/lib"
+)
+
+// Files of the repositories which may have changed since the last validation.
+// RRDP deltas and rsync tell which files changed: their directories are
+// marked. A snapshot, a failed or an interrupted fetch may change the whole
+// repository (rsync module).
+type repositoryChanges struct {
+	mu          sync.Mutex
+	modules     map[string]bool
+	directories map[string]bool
+}
+
+func newRepositoryChanges() *repositoryChanges {
+	return &repositoryChanges{
+		modules:     make(map[string]bool),
+		directories: make(map[string]bool),
+	}
+}
+
+func repositoryModule(uri string) string {
+	module, _, err := syncpki.ExtractRsyncDomainModule(uri)
+	if err != nil {
+		return uri
+	}
+	return module
+}
+
+// Directory of a file URI, or the URI itself when it ends with a slash
+func uriDirectory(uri string) string {
+	return uri[:strings.LastIndex(uri, "/")+1]
+}
+
+// Marks the whole repository of a URI as changed
+func (c *repositoryChanges) mark(uri string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.modules[repositoryModule(uri)] = true
+}
+
+func (c *repositoryChanges) fileChanged(uri string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.directories[uriDirectory(uri)] = true
+}
+
+// Records a successful RRDP fetch: a snapshot changes the repository, the
+// deltas change the directories of their files.
+func (c *repositoryChanges) rrdpFetched(rsyncURL string, snapshot bool, changes *rrdpObjectChanges) {
+	if snapshot {
+		c.mark(rsyncURL)
+		return
+	}
+	for _, uri := range changes.published {
+		c.fileChanged(uri)
+	}
+	for _, uri := range changes.withdrawn {
+		c.fileChanged(uri)
+	}
+}
+
+// Records a successful rsync fetch. The files are only located when the
+// repository was not fetched from a mirror or replayed.
+func (c *repositoryChanges) rsyncFetched(uri string, source string, files []*syncpki.FileStat) {
+	for _, file := range files {
+		if source != uri || !strings.HasPrefix(file.Path, uri) {
+			c.mark(uri)
+			return
+		}
+		c.fileChanged(file.Path)
+	}
+}
+
+// Tells whether the files under the directory of a URI may have changed
+func (c *repositoryChanges) changed(uri string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.modules[repositoryModule(uri)] || c.directories[uriDirectory(uri)]
+}
+
+func (c *repositoryChanges) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.modules = make(map[string]bool)
+	c.directories = make(map[string]bool)
+}
+
+// Validation of a TAL whose unchanged publication points are reused by the
+// next iteration.
+type talValidation struct {
+	manager *pki.SimpleManager
+	full    time.Time // time of the last validation without reuse
+}
+
+// Returns the validation of a TAL from the previous iteration, unless every
+// publication point must be validated again (-validation.incremental.full).
+func (s *OctoRPKI) previousValidation(tal *pki.PKIFile, now time.Time) *pki.SimpleManager {
+	previous, ok := s.talValidations[tal.Path]
+	if !ok || s.repositoryChanges == nil {
+		return nil
+	}
+	if *IncrementalFull > 0 && now.Sub(previous.full) >= *IncrementalFull {
+		return nil
+	}
+	return previous.manager
+}
+
+// Keeps the validations of the TALs for the next iteration and clears the
+// changes they include.
+func (s *OctoRPKI) keepValidations(pkiManagers []*pki.SimpleManager, now time.Time) {
+	validations := make(map[string]*talValidation, len(s.Tals))
+	var reused, total int
+	for i, tal := range s.Tals {
+		full := now
+		if previous, ok := s.talValidations[tal.Path]; ok && pkiManagers[i].Previous == previous.manager {
+			full = previous.full
+		}
+		pkiManagers[i].Previous = nil
+
+		validations[tal.Path] = &talValidation{
+			manager: pkiManagers[i],
+			full:    full,
+		}
+		reused += pkiManagers[i].Reused
+		total += len(pkiManagers[i].Validator.ValidManifest)
+	}
+	s.talValidations = validations
+	s.repositoryChanges.reset()
+
+	if reused > 0 {
+		log.Infof("Reused the validation of %d publication points out of %d (-validation.incremental)", reused, total)
+	}
+	MetricPublicationPointsReused.Set(float64(reused))
+}
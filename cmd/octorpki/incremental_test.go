@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/asn1"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+	"github.com/cloudflare/gortr/prefixfile"
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+
+	syncpki "github.com/cloudflare/cfrpki/sync/lib"
+)
+
+func TestIncrementalValidation(t *testing.T) {
+	defer func(sign, incremental, strictManifests, messages bool, full time.Duration) {
+		*Sign, *Incremental, *StrictManifests, *OutputMessages, *IncrementalFull = sign, incremental, strictManifests, messages, full
+	}(*Sign, *Incremental, *StrictManifests, *OutputMessages, *IncrementalFull)
+	*Sign = false
+	*Incremental = true
+	*IncrementalFull = time.Hour
+	*OutputMessages = true
+	*StrictManifests = false
+
+	basepath := t.TempDir()
+	tals := []*pki.PKIFile{
+		createTestRepository(t, basepath, "rpki-a.example.com", []testROA{
+			{ASN: 65001, Prefix: "192.0.2.0/24", MaxLength: 24},
+		}),
+		createTestRepository(t, basepath, "rpki-b.example.com", []testROA{
+			{ASN: 65002, Prefix: "198.51.100.0/24", MaxLength: 24},
+		}),
+	}
+	// Does not match the hash listed on the manifest: reported at every validation
+	crl, err := asn1.Marshal([]int{1})
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(filepath.Join(basepath, "rpki-b.example.com/repo/root.crl"), crl, 0644))
+
+	s := NewOctoRPKI(tals, []string{"A", "B"})
+	s.Fetcher = syncpki.NewLocalFetch(basepath)
+	validate := func() ([]prefixfile.ROAJson, []ValidationMessage) {
+		s.mainValidation(context.Background(), opentracing.NoopTracer{}.StartSpan("test"))
+		snapshot := s.getSnapshot()
+		return snapshot.ROAList.Data, snapshot.ValidationMessages
+	}
+	reused := func() []int {
+		return []int{s.talValidations[tals[0].Path].manager.Reused, s.talValidations[tals[1].Path].manager.Reused}
+	}
+
+	all, messages := validate()
+	assert.Len(t, all, 2)
+	assert.NotEmpty(t, messages)
+	assert.Equal(t, []int{0, 0}, reused())
+	assert.Equal(t, float64(0), getGaugeValue(t, MetricPublicationPointsReused))
+
+	// Nothing changed: the publication points are reused with their messages
+	roas, reusedMessages := validate()
+	assert.Equal(t, all, roas)
+	assert.Equal(t, messages, reusedMessages)
+	assert.Equal(t, []int{1, 1}, reused())
+	assert.Equal(t, float64(2), getGaugeValue(t, MetricPublicationPointsReused))
+
+	// RRDP without deltas and rsync without new files do not change the repositories
+	s.repositoryChanges.rrdpFetched("rsync://rpki-a.example.com/repo/", false, &rrdpObjectChanges{})
+	s.repositoryChanges.rsyncFetched("rsync://rpki-b.example.com/repo/", "rsync://rpki-b.example.com/repo/", nil)
+	validate()
+	assert.Equal(t, []int{1, 1}, reused())
+
+	// A delta in the repository of A: only its publication point is validated again
+	assert.Nil(t, os.Remove(filepath.Join(basepath, "rpki-a.example.com/repo/0.roa")))
+	s.repositoryChanges.rrdpFetched("rsync://rpki-a.example.com/repo/", false, &rrdpObjectChanges{
+		withdrawn: []string{"rsync://rpki-a.example.com/repo/0.roa"},
+	})
+	roas, _ = validate()
+	assert.Equal(t, all[1:], roas)
+	assert.Equal(t, []int{0, 1}, reused())
+
+	// A file changed in another directory of the repository
+	s.repositoryChanges.rsyncFetched("rsync://rpki-b.example.com/repo/", "rsync://rpki-b.example.com/repo/", []*syncpki.FileStat{
+		{Path: "rsync://rpki-b.example.com/repo/child/0.roa", Change: syncpki.FileNew},
+	})
+	assert.True(t, s.repositoryChanges.changed("rsync://rpki-b.example.com/repo/child/"))
+	assert.False(t, s.repositoryChanges.changed("rsync://rpki-b.example.com/repo/"))
+	validate()
+	assert.Equal(t, []int{1, 1}, reused())
+
+	// Full validation when the previous one is too old
+	*IncrementalFull = time.Nanosecond
+	validate()
+	assert.Equal(t, []int{0, 0}, reused())
+	assert.Equal(t, float64(0), getGaugeValue(t, MetricPublicationPointsReused))
+}
+
+func TestRepositoryChanges(t *testing.T) {
+	c := newRepositoryChanges()
+	assert.False(t, c.changed("rsync://rpki.example.com/repo/ca/"))
+
+	// Files fetched from a mirror are not located: the module changed
+	c.rsyncFetched("rsync://rpki.example.com/repo/", "rsync://mirror.example.com/repo/", []*syncpki.FileStat{
+		{Path: "rsync://mirror.example.com/repo/ca/0.roa", Change: syncpki.FileNew},
+	})
+	assert.True(t, c.changed("rsync://rpki.example.com/repo/ca/"))
+	assert.True(t, c.changed("rsync://rpki.example.com/repo/other/ca.mft"))
+	assert.False(t, c.changed("rsync://rpki.example.com/other/"))
+
+	c.reset()
+	c.rrdpFetched("rsync://rpki.example.com/repo/", false, &rrdpObjectChanges{
+		published: []string{"rsync://rpki.example.com/repo/ca/0.roa"},
+	})
+	assert.True(t, c.changed("rsync://rpki.example.com/repo/ca/"))
+	assert.True(t, c.changed("rsync://rpki.example.com/repo/ca/ca.mft"))
+	assert.False(t, c.changed("rsync://rpki.example.com/repo/other/"))
+
+	// A snapshot may change any file
+	c.rrdpFetched("rsync://rpki.example.com/repo/", true, &rrdpObjectChanges{})
+	assert.True(t, c.changed("rsync://rpki.example.com/repo/other/"))
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"time"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+)
+
+// Delays the next iteration when nothing validated, for instance during a
+// network outage, instead of revalidating immediately. The delay doubles
+// after each failed iteration up to max and is reset by the first success.
+type loopBackoff struct {
+	min     time.Duration
+	max     time.Duration
+	current time.Duration
+}
+
+func newLoopBackoff(min time.Duration, max time.Duration) *loopBackoff {
+	return &loopBackoff{
+		min: min,
+		max: max,
+	}
+}
+
+// Returns the delay before the next iteration after a failed one.
+func (b *loopBackoff) next() time.Duration {
+	if b == nil || b.min <= 0 {
+		return 0
+	}
+	if b.current == 0 {
+		b.current = b.min
+	} else {
+		b.current *= 2
+	}
+	if b.max > 0 && b.current > b.max {
+		b.current = b.max
+	}
+	MetricLoopBackoff.Set(b.current.Seconds())
+	return b.current
+}
+
+func (b *loopBackoff) reset() {
+	if b == nil {
+		return
+	}
+	b.current = 0
+	MetricLoopBackoff.Set(0)
+}
+
+// An iteration failed when no TAL has a valid root certificate.
+func allTALsFailed(pkiManagers []*pki.SimpleManager) bool {
+	for _, sm := range pkiManagers {
+		if len(sm.Validator.ValidObjects) > 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+
+	syncpki "github.com/cloudflare/cfrpki/sync/lib"
+)
+
+func TestLoopBackoff(t *testing.T) {
+	b := newLoopBackoff(time.Second, 5*time.Second)
+	assert.Equal(t, time.Second, b.next())
+	assert.Equal(t, 2*time.Second, b.next())
+	assert.Equal(t, 4*time.Second, b.next())
+	assert.Equal(t, 5*time.Second, b.next())
+	assert.Equal(t, 5*time.Second, b.next())
+	assert.Equal(t, float64(5), getGaugeValue(t, MetricLoopBackoff))
+
+	b.reset()
+	assert.Equal(t, float64(0), getGaugeValue(t, MetricLoopBackoff))
+	assert.Equal(t, time.Second, b.next())
+
+	// Disabled
+	assert.Equal(t, time.Duration(0), newLoopBackoff(0, time.Minute).next())
+	var nilBackoff *loopBackoff
+	assert.Equal(t, time.Duration(0), nilBackoff.next())
+}
+
+func TestAllTALsFailed(t *testing.T) {
+	defer func(v bool) { *Sign = v }(*Sign)
+	*Sign = false
+
+	basepath := t.TempDir()
+	tals := []*pki.PKIFile{
+		createTestRepository(t, basepath, "rpki-a.example.com", []testROA{
+			{ASN: 65001, Prefix: "192.0.2.0/24", MaxLength: 24},
+		}),
+		createTestRepository(t, basepath, "rpki-b.example.com", []testROA{
+			{ASN: 65002, Prefix: "198.51.100.0/24", MaxLength: 24},
+		}),
+	}
+
+	s := NewOctoRPKI(tals, []string{"A", "B"})
+	s.Fetcher = syncpki.NewLocalFetch(basepath)
+	validate := func() {
+		s.mainValidation(context.Background(), opentracing.NoopTracer{}.StartSpan("test"))
+	}
+
+	validate()
+	assert.False(t, s.allTALsFailed)
+
+	// A single TAL failing is not a total failure
+	assert.Nil(t, os.Remove(filepath.Join(basepath, "rpki-b.example.com/repo/root.cer")))
+	validate()
+	assert.False(t, s.allTALsFailed)
+
+	// No root certificate can be fetched anymore
+	assert.Nil(t, os.Remove(filepath.Join(basepath, "rpki-a.example.com/repo/root.cer")))
+	validate()
+	assert.True(t, s.allTALsFailed)
+}
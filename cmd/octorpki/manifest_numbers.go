@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	librpki "github.com/cloudflare/cfrpki/validator/lib"
+)
+
+type ManifestNumber struct {
+	Number *big.Int `json:"number"`
+	Seen   int64    `json:"seen"` // Unix time at which the number was first seen
+}
+
+// Highest manifest number seen for each manifest URI, so one per CA. The
+// number must increase with each manifest issued (RFC 9286 section 4.2.1):
+// a lower number can be a replay of an older manifest.
+type manifestNumbers struct {
+	mu      sync.Mutex
+	numbers map[string]ManifestNumber
+	changed bool // since the last save
+}
+
+func newManifestNumbers() *manifestNumbers {
+	return &manifestNumbers{
+		numbers: make(map[string]ManifestNumber),
+	}
+}
+
+func (m *manifestNumbers) load(file string) error {
+	fc, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	numbers := make(map[string]ManifestNumber)
+	err = json.Unmarshal(fc, &numbers)
+	if err != nil {
+		return fmt.Errorf("unable to decode %s: %v", file, err)
+	}
+	m.numbers = numbers
+	m.changed = false
+	return nil
+}
+
+// Writes the numbers when they changed since the last save.
+func (m *manifestNumbers) save(file string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.changed {
+		return nil
+	}
+	fc, err := json.Marshal(m.numbers)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(file, fc, 0600)
+	if err != nil {
+		return err
+	}
+	m.changed = false
+	return nil
+}
+
+// Records the number of a manifest. Returns the highest number seen before
+// when the new one is lower, which is then not recorded.
+func (m *manifestNumbers) check(uri string, number *big.Int, now time.Time) (*big.Int, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	previous, ok := m.numbers[uri]
+	if ok && number.Cmp(previous.Number) < 0 {
+		return previous.Number, true
+	}
+	if !ok || number.Cmp(previous.Number) > 0 {
+		m.numbers[uri] = ManifestNumber{Number: new(big.Int).Set(number), Seen: now.Unix()}
+		m.changed = true
+	}
+	return nil, false
+}
+
+// Reports the valid manifests of a TAL whose number decreased.
+func (s *OctoRPKI) checkManifestNumbers(ta string, validator *pki.Validator, now time.Time) {
+	var regressions int
+	for _, res := range validator.ValidManifest {
+		mft, ok := res.Resource.(*librpki.RPKIManifest)
+		if !ok || res.File == nil || mft.Content.ManifestNumber == nil {
+			continue
+		}
+		uri := res.File.ComputePath()
+		if previous, regressed := s.manifestNumbers.check(uri, mft.Content.ManifestNumber, now); regressed {
+			log.Warnf("Manifest %s has number %v, lower than the number %v seen before: possible replay of an older manifest", uri, mft.Content.ManifestNumber, previous)
+			regressions++
+		}
+	}
+	MetricManifestNumberRegressions.With(prometheus.Labels{"ta": ta}).Add(float64(regressions))
+}
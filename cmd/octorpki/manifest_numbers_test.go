@@ -0,0 +1,72 @@
+package main
+
+import (
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManifestNumbers(t *testing.T) {
+	now := time.Now()
+	m := newManifestNumbers()
+	uri := "rsync://rpki.example.com/repo/root.mft"
+
+	_, regressed := m.check(uri, big.NewInt(4), now)
+	assert.False(t, regressed)
+	_, regressed = m.check(uri, big.NewInt(4), now.Add(time.Hour))
+	assert.False(t, regressed)
+	assert.Equal(t, now.Unix(), m.numbers[uri].Seen)
+	_, regressed = m.check(uri, big.NewInt(5), now)
+	assert.False(t, regressed)
+
+	previous, regressed := m.check(uri, big.NewInt(3), now)
+	assert.True(t, regressed)
+	assert.Equal(t, big.NewInt(5), previous)
+	// The highest number is kept
+	previous, regressed = m.check(uri, big.NewInt(4), now)
+	assert.True(t, regressed)
+	assert.Equal(t, big.NewInt(5), previous)
+
+	file := filepath.Join(t.TempDir(), "manifests.json")
+	assert.Nil(t, m.save(file))
+	loaded := newManifestNumbers()
+	assert.Nil(t, loaded.load(file))
+	assert.Equal(t, m.numbers, loaded.numbers)
+}
+
+func TestManifestNumberRegression(t *testing.T) {
+	defer func(v bool) { *Sign = v }(*Sign)
+	*Sign = false
+
+	basepath := t.TempDir()
+	tals := []*pki.PKIFile{
+		createTestRepository(t, basepath, "rpki.example.com", []testROA{
+			{ASN: 65001, Prefix: "192.0.2.0/24", MaxLength: 24},
+		}),
+	}
+	regressions := MetricManifestNumberRegressions.With(prometheus.Labels{"ta": "example"})
+	before := getCounterValue(t, regressions)
+
+	// The fixture manifest has number 1
+	uri := "rsync://rpki.example.com/repo/root.mft"
+	result := runValidation(basepath, tals, []string{"example"}, 1, func(s *OctoRPKI) {
+		s.manifestNumbers.check(uri, big.NewInt(1), time.Now())
+	})
+	assert.Len(t, result.ROAs, 1)
+	assert.Equal(t, before, getCounterValue(t, regressions))
+
+	var s *OctoRPKI
+	result = runValidation(basepath, tals, []string{"example"}, 1, func(o *OctoRPKI) {
+		s = o
+		s.manifestNumbers.check(uri, big.NewInt(5), time.Now())
+	})
+	// Only reported, the manifest is still used
+	assert.Len(t, result.ROAs, 1)
+	assert.Equal(t, before+1, getCounterValue(t, regressions))
+	assert.Equal(t, big.NewInt(5), s.manifestNumbers.numbers[uri].Number)
+}
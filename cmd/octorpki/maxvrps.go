@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/cloudflare/gortr/prefixfile"
+	"github.com/getsentry/sentry-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// Checks the size of a new ROA list against -max.vrps. A list above the limit
+// is reported and, with -max.vrps.refuse, replaced by the list currently
+// served to protect the routers from a sudden flood of bogus VRPs. The
+// iteration is then not considered stable.
+func (s *OctoRPKI) checkMaxVRPs(roaList *prefixfile.ROAList) *prefixfile.ROAList {
+	s.maxVRPsRefused = false
+	if *MaxVRPs <= 0 || len(roaList.Data) <= *MaxVRPs {
+		return roaList
+	}
+
+	err := fmt.Errorf("the validation produced %d VRPs, above the limit of %d (-max.vrps)", len(roaList.Data), *MaxVRPs)
+	if *MaxVRPsRefuse {
+		err = fmt.Errorf("%v: keeping the previous %d VRPs", err, len(s.getROAList().Data))
+	}
+	log.Error(err)
+	sentry.WithScope(func(scope *sentry.Scope) {
+		scope.SetLevel(sentry.LevelFatal)
+		scope.SetTag("vrps", fmt.Sprint(len(roaList.Data)))
+		sentry.CaptureException(err)
+	})
+
+	if *MaxVRPsRefuse {
+		s.maxVRPsRefused = true
+		return s.getROAList()
+	}
+	return roaList
+}
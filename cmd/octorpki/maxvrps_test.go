@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+	"github.com/cloudflare/gortr/prefixfile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckMaxVRPs(t *testing.T) {
+	defer func(max int, refuse bool) { *MaxVRPs, *MaxVRPsRefuse = max, refuse }(*MaxVRPs, *MaxVRPsRefuse)
+
+	previous := &prefixfile.ROAList{
+		Data: []prefixfile.ROAJson{
+			{Prefix: "192.0.2.0/24", Length: 24, ASN: "AS64500", TA: "Example"},
+		},
+	}
+	flood := &prefixfile.ROAList{
+		Data: []prefixfile.ROAJson{
+			{Prefix: "192.0.2.0/24", Length: 24, ASN: "AS64500", TA: "Example"},
+			{Prefix: "198.51.100.0/24", Length: 24, ASN: "AS64501", TA: "Example"},
+			{Prefix: "203.0.113.0/24", Length: 24, ASN: "AS64502", TA: "Example"},
+		},
+	}
+
+	s := NewOctoRPKI(nil, nil)
+	s.setROAList(previous)
+
+	*MaxVRPs = 0
+	*MaxVRPsRefuse = true
+	assert.Equal(t, flood, s.checkMaxVRPs(flood))
+
+	// Under the threshold
+	*MaxVRPs = 3
+	assert.Equal(t, flood, s.checkMaxVRPs(flood))
+
+	// Crossing the threshold: reported, published unless refused
+	*MaxVRPs = 2
+	*MaxVRPsRefuse = false
+	assert.Equal(t, flood, s.checkMaxVRPs(flood))
+
+	assert.False(t, s.maxVRPsRefused)
+
+	*MaxVRPsRefuse = true
+	assert.Equal(t, previous, s.checkMaxVRPs(flood))
+	assert.True(t, s.maxVRPsRefused)
+
+	*MaxVRPs = 3
+	assert.Equal(t, flood, s.checkMaxVRPs(flood))
+	assert.False(t, s.maxVRPsRefused)
+}
+
+func TestMaxVRPsRefuseInitial(t *testing.T) {
+	defer func(max int, refuse bool) { *MaxVRPs, *MaxVRPsRefuse = max, refuse }(*MaxVRPs, *MaxVRPsRefuse)
+	defer func(v bool) { *Sign = v }(*Sign)
+	*Sign = false
+
+	basepath := t.TempDir()
+	tals := []*pki.PKIFile{createTestRepository(t, basepath, "rpki.example.com", []testROA{
+		{ASN: 65001, Prefix: "192.0.2.0/24", MaxLength: 24},
+		{ASN: 65002, Prefix: "198.51.100.0/24", MaxLength: 24},
+	})}
+
+	// The first list is refused: the initial empty list is kept and blocks
+	// the stabilization
+	*MaxVRPs = 1
+	*MaxVRPsRefuse = true
+	var s *OctoRPKI
+	result := runValidation(basepath, tals, []string{"Example"}, 1, func(o *OctoRPKI) { s = o })
+	assert.Empty(t, result.ROAs)
+	assert.True(t, s.maxVRPsRefused)
+}
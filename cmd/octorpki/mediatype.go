@@ -0,0 +1,144 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/gortr/prefixfile"
+)
+
+// Versioned media types of the ROA list. v1 is the gortr schema, v2 extends
+// it with per-TA counts, the generation time and numeric ASNs.
+const (
+	MediaTypeROAListV1 = "application/vnd.octorpki.v1+json"
+	MediaTypeROAListV2 = "application/vnd.octorpki.v2+json"
+
+	mediaTypeVendorPrefix = "application/vnd.octorpki."
+)
+
+type ROAListV2MetaData struct {
+	Version       int            `json:"version"`
+	Counts        int            `json:"counts"`
+	Generated     int            `json:"generated"`
+	GeneratedTime string         `json:"generatedTime"`
+	Valid         int            `json:"valid,omitempty"`
+	Signature     string         `json:"signature,omitempty"`
+	SignatureDate string         `json:"signatureDate,omitempty"`
+	TACounts      map[string]int `json:"taCounts"`
+	TAFilter      []string       `json:"taFilter,omitempty"`
+}
+
+type VRPV2 struct {
+	Prefix    string `json:"prefix"`
+	MaxLength uint8  `json:"maxLength"`
+	ASN       uint32 `json:"asn"`
+	TA        string `json:"ta,omitempty"`
+}
+
+type ROAListV2 struct {
+	Metadata ROAListV2MetaData `json:"metadata"`
+	ROAs     []VRPV2           `json:"roas"`
+}
+
+// Converts a ROA list to the v2 schema. Like the other filtered outputs, the
+// signature is dropped when the list is restricted to some trust anchors.
+func ToROAListV2(roaList *prefixfile.ROAList, taFilter []string) *ROAListV2 {
+	roas := roaList.Data
+	if len(taFilter) > 0 {
+		roas = filterROAsTA(roas, taFilter)
+	}
+
+	output := &ROAListV2{
+		Metadata: ROAListV2MetaData{
+			Version:       2,
+			Counts:        len(roas),
+			Generated:     roaList.Metadata.Generated,
+			GeneratedTime: time.Unix(int64(roaList.Metadata.Generated), 0).UTC().Format(time.RFC3339),
+			Valid:         roaList.Metadata.Valid,
+			TACounts:      make(map[string]int),
+			TAFilter:      taFilter,
+		},
+		ROAs: make([]VRPV2, len(roas)),
+	}
+	if len(taFilter) == 0 {
+		output.Metadata.Signature = roaList.Metadata.Signature
+		output.Metadata.SignatureDate = roaList.Metadata.SignatureDate
+	}
+
+	for i, roa := range roas {
+		output.ROAs[i] = VRPV2{
+			Prefix:    roa.Prefix,
+			MaxLength: roa.Length,
+			ASN:       roa.GetASN(),
+			TA:        roa.TA,
+		}
+		output.Metadata.TACounts[roa.TA]++
+	}
+	return output
+}
+
+type acceptedMediaType struct {
+	mediaType string
+	quality   float64
+}
+
+// Parses an Accept header, the preferred media types first.
+func parseAccept(accept string) []acceptedMediaType {
+	accepted := make([]acceptedMediaType, 0)
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+		if mediaType == "" {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 && strings.TrimSpace(kv[0]) == "q" {
+				if q, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		if quality > 0 {
+			accepted = append(accepted, acceptedMediaType{mediaType: mediaType, quality: quality})
+		}
+	}
+
+	sort.SliceStable(accepted, func(i, j int) bool {
+		return accepted[i].quality > accepted[j].quality
+	})
+	return accepted
+}
+
+// Selects the versioned media type of the ROA list from the Accept header.
+// An empty media type means the format of -output.format. This is also the
+// case without Accept header: the default gortr format is the v1 schema.
+// Returns false when only unsupported versions are accepted.
+func negotiateROAListMediaType(accept string) (string, bool) {
+	accepted := parseAccept(accept)
+	if len(accepted) == 0 {
+		return "", true
+	}
+
+	for _, a := range accepted {
+		switch {
+		case a.mediaType == MediaTypeROAListV1 || a.mediaType == MediaTypeROAListV2:
+			return a.mediaType, true
+		case !strings.HasPrefix(a.mediaType, mediaTypeVendorPrefix):
+			return "", true
+		}
+	}
+	return "", false
+}
+
+// Returns the ROA list in the schema of a versioned media type.
+func formatROAListMediaType(roaList *prefixfile.ROAList, mediaType string, taFilter []string) (interface{}, error) {
+	if mediaType == MediaTypeROAListV2 {
+		return ToROAListV2(roaList, taFilter), nil
+	}
+	return formatROAList(roaList, OutputFormatGoRTR, taFilter)
+}
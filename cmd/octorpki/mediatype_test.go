@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudflare/gortr/prefixfile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateROAListMediaType(t *testing.T) {
+	tests := []struct {
+		accept    string
+		mediaType string
+		ok        bool
+	}{
+		{"", "", true},
+		{"*/*", "", true},
+		{"application/json", "", true},
+		{MediaTypeROAListV1, MediaTypeROAListV1, true},
+		{MediaTypeROAListV2, MediaTypeROAListV2, true},
+		{"application/vnd.octorpki.v9+json, application/vnd.octorpki.v2+json", MediaTypeROAListV2, true},
+		{"application/vnd.octorpki.v1+json;q=0.5, application/vnd.octorpki.v2+json", MediaTypeROAListV2, true},
+		{"application/vnd.octorpki.v2+json;q=0, application/vnd.octorpki.v1+json", MediaTypeROAListV1, true},
+		{"application/vnd.octorpki.v9+json, */*;q=0.1", "", true},
+		{"application/vnd.octorpki.v9+json", "", false},
+	}
+	for _, test := range tests {
+		mediaType, ok := negotiateROAListMediaType(test.accept)
+		assert.Equal(t, test.mediaType, mediaType, test.accept)
+		assert.Equal(t, test.ok, ok, test.accept)
+	}
+}
+
+func TestServeROAsMediaType(t *testing.T) {
+	defer func(format string) { *OutputFormat = format }(*OutputFormat)
+	*OutputFormat = OutputFormatRoutinator
+
+	s := NewOctoRPKI(nil, nil)
+	s.Stable.Store(true)
+	s.setROAList(&prefixfile.ROAList{
+		Metadata: prefixfile.MetaData{
+			Counts:    3,
+			Generated: 1626853335,
+			Valid:     1626856935,
+			Signature: "signature",
+		},
+		Data: []prefixfile.ROAJson{
+			{Prefix: "1.0.0.0/24", Length: 24, ASN: "AS13335", TA: "APNIC"},
+			{Prefix: "192.0.2.0/24", Length: 24, ASN: "AS64500", TA: "RIPE"},
+			{Prefix: "198.51.100.0/24", Length: 24, ASN: "AS64501", TA: "RIPE"},
+		},
+	})
+
+	serve := func(accept string, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/output.json"+query, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rec := httptest.NewRecorder()
+		s.ServeROAs(rec, req)
+		return rec
+	}
+
+	// Without versioned media type, -output.format applies
+	rec := serve("", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "Accept, Accept-Encoding", rec.Header().Get("Vary"))
+	var routinator RoutinatorOutput
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &routinator))
+	assert.Len(t, routinator.ROAs, 3)
+
+	rec = serve(MediaTypeROAListV1, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, MediaTypeROAListV1, rec.Header().Get("Content-Type"))
+	var v1 prefixfile.ROAList
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &v1))
+	assert.Len(t, v1.Data, 3)
+	assert.Equal(t, "signature", v1.Metadata.Signature)
+	v1Etag := rec.Header().Get("Etag")
+
+	rec = serve(MediaTypeROAListV2, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, MediaTypeROAListV2, rec.Header().Get("Content-Type"))
+	assert.NotEqual(t, v1Etag, rec.Header().Get("Etag"))
+	var v2 ROAListV2
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &v2))
+	assert.Equal(t, ROAListV2MetaData{
+		Version:       2,
+		Counts:        3,
+		Generated:     1626853335,
+		GeneratedTime: "2021-07-21T07:42:15Z",
+		Valid:         1626856935,
+		Signature:     "signature",
+		TACounts:      map[string]int{"APNIC": 1, "RIPE": 2},
+	}, v2.Metadata)
+	assert.Equal(t, VRPV2{Prefix: "1.0.0.0/24", MaxLength: 24, ASN: 13335, TA: "APNIC"}, v2.ROAs[0])
+
+	// Filtered lists are not signed
+	rec = serve(MediaTypeROAListV2, "?ta=ripe")
+	v2 = ROAListV2{}
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &v2))
+	assert.Equal(t, 2, v2.Metadata.Counts)
+	assert.Equal(t, "", v2.Metadata.Signature)
+	assert.Equal(t, []string{"ripe"}, v2.Metadata.TAFilter)
+
+	rec = serve(MediaTypeROAListV1, "?ta=ripe")
+	var filtered FilteredROAList
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &filtered))
+	assert.True(t, filtered.Metadata.Unsigned)
+	assert.Len(t, filtered.Data, 2)
+
+	assert.Equal(t, http.StatusNotAcceptable, serve("application/vnd.octorpki.v9+json", "").Code)
+}
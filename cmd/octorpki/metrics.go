@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// Writes every metric of the gatherer using the Prometheus text format
+func writeMetrics(w io.Writer, gatherer prometheus.Gatherer) error {
+	families, err := gatherer.Gather()
+	if err != nil {
+		return err
+	}
+	for _, family := range families {
+		_, err = expfmt.MetricFamilyToText(w, family)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Dumps the metrics to file, replacing it atomically
+func writeMetricsFile(file string, gatherer prometheus.Gatherer) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	err = tmpFile.Chmod(0644)
+	if err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	err = writeMetrics(tmpFile, gatherer)
+	if err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	err = tmpFile.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), file)
+}
+
+// Sets the runtime gauges. ReadMemStats stops the world, so it is only done
+// once per iteration.
+func sampleRuntimeStats() {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	MetricHeapAlloc.Set(float64(stats.HeapAlloc))
+	MetricNumGC.Set(float64(stats.NumGC))
+	MetricGoroutines.Set(float64(runtime.NumGoroutine()))
+}
+
+// Address label of the repositories above -metrics.max-addresses
+const MetricAddressOther = "other"
+
+// Bounds the distinct values of the address label so a repository changing
+// its URI cannot grow the number of series without limit.
+type addressLabels struct {
+	mu        sync.Mutex
+	max       int // 0 for no limit
+	addresses map[string]bool
+}
+
+func newAddressLabels(max int) *addressLabels {
+	return &addressLabels{
+		max:       max,
+		addresses: make(map[string]bool),
+	}
+}
+
+// Returns the address, or "other" when it is new and the limit is reached.
+func (a *addressLabels) label(address string) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.addresses[address] {
+		return address
+	}
+	if a.max > 0 && len(a.addresses) >= a.max {
+		return MetricAddressOther
+	}
+	a.addresses[address] = true
+	return address
+}
+
+var metricAddresses = newAddressLabels(0)
+
+func metricAddress(address string) string {
+	return metricAddresses.label(address)
+}
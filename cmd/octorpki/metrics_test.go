@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteMetricsFile(t *testing.T) {
+	MetricSIACounts.With(prometheus.Labels{"address": "rsync://rpki.example.com/repo/", "type": "valid"}).Set(3)
+
+	file := filepath.Join(t.TempDir(), "metrics.prom")
+	err := writeMetricsFile(file, prometheus.DefaultGatherer)
+	assert.Nil(t, err)
+
+	content, err := os.ReadFile(file)
+	assert.Nil(t, err)
+	for _, name := range []string{
+		"# TYPE file_count_sia gauge",
+		`file_count_sia{address="rsync://rpki.example.com/repo/",type="valid"} 3`,
+		"# TYPE state gauge",
+		"# TYPE last_stable_validation gauge",
+		"# TYPE go_goroutines gauge",
+	} {
+		assert.Contains(t, string(content), name)
+	}
+
+	err = writeMetricsFile(filepath.Join(t.TempDir(), "missing", "metrics.prom"), prometheus.DefaultGatherer)
+	assert.NotNil(t, err)
+}
+
+func TestMetricAddressLimit(t *testing.T) {
+	defer func(v *addressLabels) { metricAddresses = v }(metricAddresses)
+	metricAddresses = newAddressLabels(2)
+
+	assert.Equal(t, "rsync://a.example.com/repo/", metricAddress("rsync://a.example.com/repo/"))
+	assert.Equal(t, "https://b.example.com/notification.xml", metricAddress("https://b.example.com/notification.xml"))
+	assert.Equal(t, MetricAddressOther, metricAddress("rsync://c.example.com/repo/"))
+	assert.Equal(t, MetricAddressOther, metricAddress("rsync://d.example.com/repo/"))
+	// Known addresses keep their label
+	assert.Equal(t, "rsync://a.example.com/repo/", metricAddress("rsync://a.example.com/repo/"))
+
+	s := &OctoRPKI{}
+	s.rsyncError("rsync://e.example.com/repo/", "cache/e.example.com/repo", errors.New("failure"), opentracing.NoopTracer{}.StartSpan("test"))
+	assert.GreaterOrEqual(t, getGaugeValue(t, MetricRsyncErrors.With(prometheus.Labels{"address": MetricAddressOther})), float64(1))
+
+	metricAddresses = newAddressLabels(0)
+	assert.Equal(t, "rsync://c.example.com/repo/", metricAddress("rsync://c.example.com/repo/"))
+}
+
+func TestSampleRuntimeStats(t *testing.T) {
+	runtime.GC()
+	sampleRuntimeStats()
+
+	assert.Greater(t, getGaugeValue(t, MetricHeapAlloc), float64(0))
+	assert.GreaterOrEqual(t, getGaugeValue(t, MetricNumGC), float64(1))
+	assert.GreaterOrEqual(t, getGaugeValue(t, MetricGoroutines), float64(1))
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	assert.Nil(t, err)
+	names := make(map[string]bool)
+	for _, family := range families {
+		names[family.GetName()] = true
+	}
+	assert.True(t, names["iteration_heap_alloc_bytes"])
+	assert.True(t, names["iteration_gc_count"])
+	assert.True(t, names["iteration_goroutines"])
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	syncpki "github.com/cloudflare/cfrpki/sync/lib"
+	librpki "github.com/cloudflare/cfrpki/validator/lib"
+)
+
+type ObjectCertificate struct {
+	Subject   string   `json:"subject"`
+	Serial    string   `json:"serial"`
+	SKI       string   `json:"ski"`
+	AKI       string   `json:"aki"`
+	NotBefore int      `json:"not-before"`
+	NotAfter  int      `json:"not-after"`
+	Resources []string `json:"resources,omitempty"`
+}
+
+type ObjectManifest struct {
+	ManifestNumber string `json:"manifest-number"`
+	ThisUpdate     int    `json:"this-update"`
+	NextUpdate     int    `json:"next-update"`
+	Files          int    `json:"files"`
+}
+
+type ObjectROA struct {
+	ASN      int      `json:"asn"`
+	Prefixes []string `json:"prefixes"`
+}
+
+type ObjectCRL struct {
+	ThisUpdate int `json:"this-update"`
+	NextUpdate int `json:"next-update"`
+	Revoked    int `json:"revoked"`
+}
+
+// Parsed content of a validated object, only the field of its type is set.
+type ObjectSummary struct {
+	Certificate *ObjectCertificate `json:"certificate,omitempty"` // certificate, or EE certificate of signed objects
+	Manifest    *ObjectManifest    `json:"manifest,omitempty"`
+	ROA         *ObjectROA         `json:"roa,omitempty"`
+	CRL         *ObjectCRL         `json:"crl,omitempty"`
+}
+
+type ObjectResult struct {
+	URI     string         `json:"uri"`
+	TA      string         `json:"ta"`
+	Size    int            `json:"size"`
+	Hash    string         `json:"sha256"`
+	Data    []byte         `json:"data"` // base64 in JSON
+	Summary *ObjectSummary `json:"summary,omitempty"`
+	Error   string         `json:"error,omitempty"` // the object could not be parsed again
+}
+
+func summarizeCertificate(cert *librpki.RPKICertificate) *ObjectCertificate {
+	if cert == nil || cert.Certificate == nil {
+		return nil
+	}
+	return &ObjectCertificate{
+		Subject:   cert.Certificate.Subject.String(),
+		Serial:    cert.Certificate.SerialNumber.Text(16),
+		SKI:       hex.EncodeToString(cert.Certificate.SubjectKeyId),
+		AKI:       hex.EncodeToString(cert.Certificate.AuthorityKeyId),
+		NotBefore: int(cert.Certificate.NotBefore.Unix()),
+		NotAfter:  int(cert.Certificate.NotAfter.Unix()),
+		Resources: formatResources(cert.ASNums, cert.IPAddresses),
+	}
+}
+
+// Decodes an object the same way as the validator, based on its extension.
+func summarizeObject(uri string, data []byte) (*ObjectSummary, error) {
+	der, err := librpki.BER2DER(data)
+	if err != nil {
+		return nil, err
+	}
+
+	switch path.Ext(uri) {
+	case ".cer":
+		cert, err := librpki.DecodeCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		return &ObjectSummary{Certificate: summarizeCertificate(cert)}, nil
+	case ".mft":
+		mft, err := librpki.DecodeManifest(der)
+		if err != nil {
+			return nil, err
+		}
+		return &ObjectSummary{
+			Certificate: summarizeCertificate(mft.Certificate),
+			Manifest: &ObjectManifest{
+				ManifestNumber: mft.Content.ManifestNumber.String(),
+				ThisUpdate:     int(mft.Content.ThisUpdate.Unix()),
+				NextUpdate:     int(mft.Content.NextUpdate.Unix()),
+				Files:          len(mft.Content.FileList),
+			},
+		}, nil
+	case ".roa":
+		roa, err := librpki.DecodeROA(der)
+		if err != nil {
+			return nil, err
+		}
+		prefixes := make([]string, len(roa.Entries))
+		for i, entry := range roa.Entries {
+			prefixes[i] = fmt.Sprintf("%s-%d", entry.IPNet, entry.MaxLength)
+		}
+		return &ObjectSummary{
+			Certificate: summarizeCertificate(roa.Certificate),
+			ROA: &ObjectROA{
+				ASN:      roa.ASN,
+				Prefixes: prefixes,
+			},
+		}, nil
+	case ".crl":
+		crl, err := x509.ParseDERCRL(der)
+		if err != nil {
+			return nil, err
+		}
+		return &ObjectSummary{
+			CRL: &ObjectCRL{
+				ThisUpdate: int(crl.TBSCertList.ThisUpdate.Unix()),
+				NextUpdate: int(crl.TBSCertList.NextUpdate.Unix()),
+				Revoked:    len(crl.TBSCertList.RevokedCertificates),
+			},
+		}, nil
+	}
+	return nil, nil
+}
+
+// Checks the bearer token of -http.object.token or -http.reload.token when set.
+func tokenAuthorized(r *http.Request, token string) bool {
+	if token == "" {
+		return true
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) == 1
+}
+
+// Returns a validated object from the cache, as JSON with its parsed summary
+// or as the raw file with ?format=raw.
+func (s *OctoRPKI) ServeObject(w http.ResponseWriter, r *http.Request) {
+	if !tokenAuthorized(r, *ObjectToken) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	uri := r.URL.Query().Get("uri")
+	if !strings.HasPrefix(uri, syncpki.RsyncProtoPrefix) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("uri must be an rsync URI"))
+		return
+	}
+
+	ta, ok := s.getSnapshot().ValidObjects[uri]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Object not validated"))
+		return
+	}
+
+	data, err := s.getStorage().Get(syncpki.StoragePath(uri))
+	if errors.Is(err, syncpki.ErrIllegalPath) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	} else if errors.Is(err, fs.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Object not in the cache"))
+		return
+	} else if err != nil {
+		log.Errorf("Unable to read object %s: %v", uri, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "raw":
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(data)
+	case "", "json":
+		hash := sha256.Sum256(data)
+		result := ObjectResult{
+			URI:  uri,
+			TA:   ta,
+			Size: len(data),
+			Hash: hex.EncodeToString(hash[:]),
+			Data: data,
+		}
+		result.Summary, err = summarizeObject(uri, data)
+		if err != nil {
+			result.Error = err.Error()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.Encode(result)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("format %q is not supported, use json or raw", format)))
+	}
+}
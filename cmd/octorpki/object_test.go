@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+
+	syncpki "github.com/cloudflare/cfrpki/sync/lib"
+)
+
+func TestServeObject(t *testing.T) {
+	defer func(sign bool, token string) { *Sign, *ObjectToken = sign, token }(*Sign, *ObjectToken)
+	*Sign = false
+
+	basepath := t.TempDir()
+	tals := []*pki.PKIFile{
+		createTestRepository(t, basepath, "rpki.example.com", []testROA{
+			{ASN: 65001, Prefix: "192.0.2.0/24", MaxLength: 24},
+		}),
+	}
+	s := NewOctoRPKI(tals, []string{"Example"})
+	s.Fetcher = syncpki.NewLocalFetch(basepath)
+	s.storage = syncpki.NewFileStorage(basepath)
+	s.mainValidation(context.Background(), opentracing.NoopTracer{}.StartSpan("test"))
+
+	get := func(uri string, query string, token string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/object?uri="+url.QueryEscape(uri)+query, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		s.ServeObject(rec, req)
+		return rec
+	}
+
+	data, err := os.ReadFile(filepath.Join(basepath, "rpki.example.com/repo/0.roa"))
+	assert.Nil(t, err)
+	hash := sha256.Sum256(data)
+
+	rec := get("rsync://rpki.example.com/repo/0.roa", "", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var result ObjectResult
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	assert.Equal(t, "Example", result.TA)
+	assert.Equal(t, data, result.Data)
+	assert.Equal(t, hex.EncodeToString(hash[:]), result.Hash)
+	assert.Empty(t, result.Error)
+	assert.Equal(t, &ObjectROA{ASN: 65001, Prefixes: []string{"192.0.2.0/24-24"}}, result.Summary.ROA)
+	assert.NotNil(t, result.Summary.Certificate)
+
+	rec = get("rsync://rpki.example.com/repo/root.cer", "", "")
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	assert.Equal(t, "CN=rpki.example.com", result.Summary.Certificate.Subject)
+	assert.Equal(t, "1", result.Summary.Certificate.Serial)
+
+	rec = get("rsync://rpki.example.com/repo/0.roa", "&format=raw", "")
+	assert.Equal(t, "application/octet-stream", rec.Header().Get("Content-Type"))
+	assert.Equal(t, data, rec.Body.Bytes())
+
+	assert.Equal(t, http.StatusNotFound, get("rsync://rpki.example.com/repo/missing.roa", "", "").Code)
+	assert.Equal(t, http.StatusNotFound, get("rsync://rpki.example.com/repo/../../../etc/passwd", "", "").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/etc/passwd", "", "").Code)
+	assert.Equal(t, http.StatusBadRequest, get("rsync://rpki.example.com/repo/0.roa", "&format=xml", "").Code)
+
+	*ObjectToken = "secret"
+	assert.Equal(t, http.StatusUnauthorized, get("rsync://rpki.example.com/repo/0.roa", "", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, get("rsync://rpki.example.com/repo/0.roa", "", "wrong").Code)
+	assert.Equal(t, http.StatusOK, get("rsync://rpki.example.com/repo/0.roa", "", "secret").Code)
+}
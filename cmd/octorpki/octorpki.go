@@ -1,6 +1,7 @@
 package main
 
 import (
+	"compress/gzip"
 	"context"
 	"crypto/ecdsa"
 	"crypto/sha256"
@@ -9,27 +10,31 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/pprof"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/cloudflare/cfrpki/api/schemas"
+	"github.com/cloudflare/cfrpki/ov"
 	"github.com/cloudflare/cfrpki/validator/pki"
 	"github.com/cloudflare/gortr/prefixfile"
 	"github.com/getsentry/sentry-go"
 	"github.com/opentracing/opentracing-go"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/cors"
 
 	syncpki "github.com/cloudflare/cfrpki/sync/lib"
@@ -45,56 +50,164 @@ var (
 	AllowRoot  = flag.Bool("allow.root", false, "Allow starting as root")
 
 	// Validator Options
-	RootTAL       = flag.String("tal.root", "tals/afrinic.tal,tals/apnic.tal,tals/arin.tal,tals/lacnic.tal,tals/ripe.tal", "List of TAL separated by comma")
-	TALNames      = flag.String("tal.name", "AFRINIC,APNIC,ARIN,LACNIC,RIPE", "Name of the TALs")
-	UseManifest   = flag.Bool("manifest.use", true, "Use manifests file to explore instead of going into the repository")
-	Basepath      = flag.String("cache", "cache/", "Base directory to store certificates")
-	LogLevel      = flag.String("loglevel", "info", "Log level")
-	Refresh       = flag.Duration("refresh", time.Minute*20, "Revalidation interval")
-	MaxIterations = flag.Int("max.iterations", 32, "Specify the max number of iterations octorpki will make before failing to generate output.json")
-	Filter        = flag.Bool("filter", true, "Filter out non accessible prefixes and duplicates")
+	RootTAL        = flag.String("tal.root", "tals/afrinic.tal,tals/apnic.tal,tals/arin.tal,tals/lacnic.tal,tals/ripe.tal", "List of TAL separated by comma")
+	TALNames       = flag.String("tal.name", "AFRINIC,APNIC,ARIN,LACNIC,RIPE", "Name of the TALs, one for each TAL of -tal.root")
+	TALSkipInvalid = flag.Bool("tal.skip-invalid", false, "Skip TALs that cannot be loaded at startup")
+	TALHashes      = flag.String("tal.expected-hashes", "", "Expected SHA-256 of the public key of TALs, as name=hash separated by comma: refuses to start when a TAL does not match")
+	RequireTALs    = flag.String("require.tals", "", "TALs (names or paths separated by comma) which must validate for the output to be published, the previous output is kept otherwise")
+	TALMaxSize     = flag.Int64("tal.maxsize", 1000000, "Maximum size in bytes of a certificate downloaded from a TAL")
+	TALRsync       = flag.Bool("tal.rsync-failover", true, "Download the root certificate with rsync when HTTPS fails (requires -rrdp.failover)")
+	UseManifest    = flag.Bool("manifest.use", true, "Use manifests file to explore instead of going into the repository")
+	MftNumbersFile = flag.String("manifest.numbers.file", "cache/manifests.json", "Save the highest manifest number seen for each CA to report the manifests whose number decreases across restarts (empty to only keep them in memory)")
+	ExploreOrder   = flag.String("explore.order", "bfs", "Order in which the certificate tree is explored (bfs/dfs)")
+	Basepath       = flag.String("cache", "cache/", "Base directory to store certificates")
+	CacheStorage   = flag.String("cache.storage", syncpki.StorageFilesystem, "Storage of the repository files (filesystem/memory). The memory storage is lost on restart, only supports RRDP (-rrdp.failover=false, TALs with an HTTPS URI) and does not write the RRDP state and manifest numbers files")
+	CacheMemMax    = flag.Int64("cache.memory.max", 0, "Maximum size in bytes of the repository files kept by the memory storage (0 for no limit)")
+	LogLevel       = flag.String("loglevel", "info", "Log level")
+	ValidationLog  = flag.String("log.validation.dir", "", "Directory where the messages of each validation iteration are written as JSON (empty to disable)")
+	ValidationKeep = flag.Int("log.validation.keep", 72, "Number of validation logs kept in -log.validation.dir (0 keeps all)")
+	Refresh        = flag.Duration("refresh", time.Minute*20, "Revalidation interval")
+	RefreshMode    = flag.String("refresh.mode", RefreshModeFixed, "Select how the revalidation is scheduled (fixed/manifest)")
+	RefreshMin     = flag.Duration("refresh.min", time.Minute, "Minimum revalidation interval in manifest refresh mode")
+	RefreshMax     = flag.Duration("refresh.max", time.Hour, "Maximum revalidation interval in manifest refresh mode")
+	TALRefresh     = flag.String("tal.refresh", "", "Revalidation interval of each TAL separated by comma, in the order of -tal.root (empty uses -refresh)")
+	MaxIterations  = flag.Int("max.iterations", 32, "Specify the max number of iterations octorpki will make before failing to generate output.json")
+	BackoffMin     = flag.Duration("backoff.min", 10*time.Second, "Initial delay before revalidating when no TAL validated, doubled after each failed iteration (0 to disable)")
+	BackoffMax     = flag.Duration("backoff.max", 10*time.Minute, "Maximum delay before revalidating when no TAL validated")
+	Watchdog       = flag.Duration("watchdog", 0, "Exit when a validation iteration does not complete within this duration (0 to disable)")
+	MaxDepth       = flag.Int("max.depth", 32, "Report CA certificates deeper than this in the hierarchy (0 to disable)")
+	InvalidateDeep = flag.Bool("max.depth.invalidate", false, "Invalidate CA certificates deeper than -max.depth")
+	MaxVRPs        = flag.Int("max.vrps", 0, "Report an error when the validation produces more VRPs than this (0 to disable)")
+	MaxVRPsRefuse  = flag.Bool("max.vrps.refuse", false, "Keep serving the previous VRPs when -max.vrps is exceeded")
+	Filter         = flag.Bool("filter", true, "Filter out non accessible prefixes and duplicates")
+	ShortPrefixV4  = flag.Int("vrp.short-prefix.ipv4", 8, "Report VRPs of IPv4 prefixes shorter than this length (0.0.0.0/0 is always reported)")
+	ShortPrefixV6  = flag.Int("vrp.short-prefix.ipv6", 16, "Report VRPs of IPv6 prefixes shorter than this length (::/0 is always reported)")
 
 	StrictManifests = flag.Bool("strict.manifests", true, "Manifests must be complete or invalidate CA")
-	StrictHash      = flag.Bool("strict.hash", true, "Check the hash of files")
+	AllowUnknown    = flag.Bool("manifest.allow-unknown", true, "Files of an unknown type listed on a manifest are reported without invalidating the CA (with -strict.manifests)")
+	StrictHash      = flag.Bool("strict.hash", true, "Check the hash of files and that manifests use SHA-256")
 	StrictCms       = flag.Bool("strict.cms", false, "Decode CMS with strict settings")
+	StrictCrypto    = flag.Bool("strict.crypto", false, "Invalidate certificates signed with SHA-1 or holding a short RSA key")
+	MinRSAKeySize   = flag.Int("strict.crypto.rsa-min", 2048, "Minimum RSA key size in bits with -strict.crypto")
+	StrictEKU       = flag.Bool("strict.eku", false, "Invalidate signed objects whose EE certificate has an extended key usage")
+
+	ValidationWorkers     = flag.Int("validation.workers", 1, "Number of TALs validated concurrently")
+	ClockSkew             = flag.Duration("validation.clock-skew", 0, "Tolerance applied to the validity periods of certificates and manifests")
+	ValidationNow         = flag.String("validation.now", "", "Validate the objects as if the current time was this time (RFC 3339), to reproduce expiry issues. The metrics and the output keep the real time")
+	ValidationNotYetValid = flag.Bool("validation.notyetvalid", false, "Validate objects whose validity has not started yet but exclude their ROAs from the output")
+	Incremental           = flag.Bool("validation.incremental", false, "Reuse the validation of the publication points whose CA certificate and files did not change (as told by the RRDP deltas and rsync) and whose objects did not change validity")
+	IncrementalFull       = flag.Duration("validation.incremental.full", time.Hour, "Validate every publication point at least at this interval with -validation.incremental (0 to disable)")
+
+	// Phase Timeouts
+	TimeoutRRDP       = flag.Duration("timeout.rrdp", 0, "Maximum duration of the RRDP fetches of an iteration, the iteration is then unstable (0 for no limit)")
+	TimeoutTAL        = flag.Duration("timeout.tal", 0, "Maximum duration of the root certificate downloads of an iteration, the iteration is then unstable (0 for no limit)")
+	TimeoutRsync      = flag.Duration("timeout.rsync", 0, "Maximum duration of the rsync fetches of an iteration, the iteration is then unstable (0 for no limit)")
+	TimeoutValidation = flag.Duration("timeout.validation", 0, "Maximum duration of the validation of an iteration, the previous results are then kept and the iteration is unstable (0 for no limit)")
 
 	// Rsync Options
 	RsyncTimeout = flag.Duration("rsync.timeout", time.Minute*20, "Rsync command timeout")
 	RsyncBin     = flag.String("rsync.bin", DefaultBin(), "The rsync binary to use")
+	RsyncMirrors = flag.String("rsync.mirrors", "", "JSON file mapping rsync URI prefixes to the mirror they are fetched from")
+	RsyncProxy   = flag.String("rsync.proxy", "", "Proxy used by rsync: host:port or http://host:port (RSYNC_PROXY), socks5://host:port (requires nc)")
+	RsyncHoldoff = flag.Duration("rsync.restricted.holdoff", time.Hour*6, "Delay before fetching again a repository whose rsync server refused access or restricted the path (0 to retry at each iteration)")
 
 	// RRDP Options
-	RRDP         = flag.Bool("rrdp", true, "Enable RRDP fetching")
-	RRDPFile     = flag.String("rrdp.file", "cache/rrdp.json", "Save RRDP state")
-	RRDPFailover = flag.Bool("rrdp.failover", true, "Failover to rsync when RRDP fails")
-	UserAgent    = flag.String("useragent", fmt.Sprintf("Cloudflare-RRDP-%v (+https://github.com/cloudflare/cfrpki)", AppVersion), "User-Agent header")
-
-	Mode       = flag.String("mode", "server", "Select output mode (server/oneoff)")
-	WaitStable = flag.Bool("output.wait", true, "Wait until stable state to create the file (returns 503 when unstable on HTTP)")
+	RRDP           = flag.Bool("rrdp", true, "Enable RRDP fetching")
+	RRDPFile       = flag.String("rrdp.file", "cache/rrdp.json", "Save RRDP state")
+	RRDPFailover   = flag.Bool("rrdp.failover", true, "Failover to rsync when RRDP fails")
+	FetchProtocols = flag.String("fetch.protocols", "", "JSON file mapping repository domains to the protocol to fetch them with (rrdp/rsync)")
+	UserAgent      = flag.String("useragent", fmt.Sprintf("Cloudflare-RRDP-%v (+https://github.com/cloudflare/cfrpki)", AppVersion), "User-Agent header ({type} is replaced by the request type: tal, rrdp or output)")
+	RRDPMaxDeltas  = flag.Int("rrdp.max-deltas", 0, "Fetch the snapshot instead of the deltas when a repository is more than this number of serials behind (0 for no limit)")
+	RRDPAudit      = flag.Bool("audit.rrdp-manifest", false, "Report the objects published over RRDP but missing from their manifest and the files of a manifest not published")
+	RRDPSerialGap  = flag.Int64("rrdp.serial-gap", 100, "Warn when the serial of a notification jumps by more than this amount between two fetches (0 disables)")
+	ReplayDir      = flag.String("replay.dir", "", "Fetch from a recorded session instead of the network: HTTP files from <dir>/http/<host>/<path> and rsync trees from <dir>/rsync/<host>/<module>")
+	FetchFilter    = flag.String("fetch.filter", "", "Only fetch the repositories whose rsync URI matches this glob (eg: rsync://rpki.ripe.net/*) or regular expression prefixed by re:")
+	DNSResolver    = flag.String("dns.resolver", "", "DNS server (host or host:port) resolving the hostnames of the HTTP fetches (TAL and RRDP), uses the system resolver when empty")
+	RRDPIdleConns  = flag.Int("rrdp.max-idle-conns-per-host", http.DefaultMaxIdleConnsPerHost, "Idle connections kept open to each server for the next HTTP fetches (TAL and RRDP)")
+	RRDPMaxConns   = flag.Int("rrdp.max-conns-per-host", 0, "Maximum connections opened to each server by the HTTP fetches (TAL and RRDP), the next fetches wait (0 for no limit)")
+
+	Mode             = flag.String("mode", "server", "Select output mode (server/oneoff/serve-static)")
+	WaitStable       = flag.Bool("output.wait", true, "Wait until stable state to create the file (returns 503 when unstable on HTTP)")
+	MetricsFile      = flag.String("metrics.file", "", "Write the Prometheus metrics to this file before exiting (oneoff mode)")
+	MetricsAddresses = flag.Int("metrics.max-addresses", 5000, "Maximum number of repository addresses used as metric label, the others are reported as \"other\" (0 for no limit)")
+	MetricsRuntime   = flag.Bool("metrics.runtime", false, "Sample the heap, GC and goroutine statistics at the end of each iteration")
+	StatsdAddr       = flag.String("statsd.addr", "", "Also push the main metrics to this StatsD server (host:port, UDP) at the end of each iteration")
+	StatsdPrefix     = flag.String("statsd.prefix", "octorpki", "Prefix of the StatsD metric names")
+	MetricsExemplars = flag.Bool("metrics.exemplars", false, "Attach trace IDs to operation durations (exposed with the OpenMetrics format)")
 
 	// Serving Options
-	Addr        = flag.String("http.addr", ":8081", "Listening address")
-	CacheHeader = flag.Bool("http.cache", true, "Enable cache header")
-	MetricsPath = flag.String("http.metrics", "/metrics", "Prometheus metrics endpoint")
-	InfoPath    = flag.String("http.info", "/infos", "Information URL")
-	HealthPath  = flag.String("http.health", "/health", "Health URL")
+	Addr                 = flag.String("http.addr", ":8081", "Listening address")
+	CacheHeader          = flag.Bool("http.cache", true, "Enable cache header")
+	HTTPDelayStart       = flag.Bool("http.delay-start", false, "Start listening after the first validation iteration instead of at startup")
+	CacheStaleRevalidate = flag.Duration("http.cache.stale-while-revalidate", 0, "Allow caches to serve a stale ROA list while revalidating it for this duration (0 to omit the directive)")
+	CacheStaleError      = flag.Duration("http.cache.stale-if-error", 0, "Allow caches to serve a stale ROA list when OctoRPKI returns an error for this duration (0 to omit the directive)")
+	MetricsPath          = flag.String("http.metrics", "/metrics", "Prometheus metrics endpoint")
+	InfoPath             = flag.String("http.info", "/infos", "Information URL")
+	HealthPath           = flag.String("http.health", "/health", "Health URL")
+	ValidatePath         = flag.String("http.validate", "/validate", "Origin validation URL")
+	OpenAPIPath          = flag.String("http.openapi", "/openapi.json", "OpenAPI document URL")
+	ReloadPath           = flag.String("http.reload", "/reload", "URL reloading the TAL files on POST, served with -http.reload.token")
+	ReloadToken          = flag.String("http.reload.token", "", "Bearer token required by the reload URL (empty to disable reloading over HTTP)")
+	TopologyPath         = flag.String("http.topology", "/topology", "Topology of the CAs and publication points URL (JSON, or DOT with ?format=dot)")
+	PublicKeyPath        = flag.String("http.publickey", "/publickey", "Public keys verifying the output signature URL (PEM)")
+	ObjectPath           = flag.String("http.object", "/object", "Validated object at ?uri= URL (JSON summary, or the raw file with ?format=raw)")
+	ObjectToken          = flag.String("http.object.token", "", "Bearer token required by the object URL (empty for no authentication)")
+	HoldingsPath         = flag.String("http.holdings", "", "Resources held by each valid CA certificate URL (JSON, empty to disable as it is large)")
+	DiffPath             = flag.String("http.diff", "/diff", "Last changes of the VRPs between stable validations, with the ROAs causing them URL (JSON)")
 
 	CorsOrigins = flag.String("cors.origins", "*", "Cors origins separated by comma")
 	CorsCreds   = flag.Bool("cors.creds", false, "Cors enable credentials")
 
+	GRPCAddr = flag.String("grpc.addr", "", "Listening address of the gRPC service streaming the VRPs and their changes after each stable validation (vrps.proto, empty to disable)")
+
 	// File option
-	Output           = flag.String("output.roa", "output.json", "Output ROA file or URL")
+	Output           = flag.String("output.roa", "output.json", "Output ROA files, http(s) URLs (PUT) or s3://bucket/key objects separated by comma (the first file is the serving path in server mode)")
+	OutputS3Endpoint = flag.String("output.s3.endpoint", "", "Endpoint of the s3:// outputs with the bucket in the path, for S3 compatible storages (empty for AWS)")
+	OutputS3Region   = flag.String("output.s3.region", "us-east-1", "Region of the s3:// outputs. The credentials are read from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN")
+	OutputWebhook    = flag.String("output.webhook", "", "URL notified with a JSON POST request after the ROA list was written to -output.roa")
+	OutputFormat     = flag.String("output.format", OutputFormatGoRTR, "Format of the ROA list (gortr/routinator/protobuf)")
+	OutputGzip       = flag.Bool("output.gzip", false, "Compress the written ROA lists with gzip (always done for outputs ending in .gz)")
+	OutputPerTAL     = flag.String("output.per-tal", "", "Also write the ROA list of each TAL to its own file in this directory after each stable validation")
 	Sign             = flag.Bool("output.sign", true, "Sign output (GoRTR compatible)")
 	SignKey          = flag.String("output.sign.key", "private.pem", "ECDSA signing key")
+	SignKeyNext      = flag.String("output.sign.key.next", "", "ECDSA key replacing -output.sign.key: signs the output while both public keys are advertised until -output.sign.key.rotation-end")
+	SignKeyRotation  = flag.String("output.sign.key.rotation-end", "", "End of the key rotation window (RFC 3339), after which only the next key is advertised")
+	SignKeyMissing   = flag.String("output.sign.key.missing", SignKeyMissingFail, "Behavior when the signing keys cannot be read at startup: fail, retry until -output.sign.key.wait, or ephemeral (sign with a generated key lost on restart)")
+	SignKeyWait      = flag.Duration("output.sign.key.wait", time.Minute, "Time to wait for the signing keys with -output.sign.key.missing=retry")
 	ValidityDuration = flag.Duration("output.sign.validity", time.Hour, "Validity")
+	OutputExclude    = flag.String("output.exclude", "", "File listing ASNs and prefixes (one per line) whose VRPs are removed from the output")
+	OutputExpand     = flag.Bool("output.expand", false, "Expand each VRP into one VRP per covered prefix whose maxLength is its length (greatly increases the size of the output)")
+	OutputExpandMax  = flag.Int("output.expand.max", 65536, "VRPs which would expand to more VRPs than this are kept compact (0 for no limit)")
+	OutputMessages   = flag.Bool("output.validation-messages", false, "Add the messages of the validation to the metadata of the ROA list file as validation-messages (gortr format, not covered by the signature)")
+	StaleAfter       = flag.Duration("output.staleafter", 0, "Return 503 when the last stable output is older than this duration (0 to disable)")
+
+	// Export options
+	ExportTar = flag.String("export.tar", "", "Write a tar archive of the validated objects after each stable validation")
+
+	// Publication server diagnostics
+	PublicationURL  = flag.String("publication.url", "", "Publication server (RFC 8181) whose list of objects is compared with the validated objects after each stable validation")
+	PublicationCert = flag.String("publication.cert", "", "BPKI certificate of the publication client")
+	PublicationKey  = flag.String("publication.key", "", "RSA private key of the publication client")
+	PublicationCRL  = flag.String("publication.crl", "", "CRL of the BPKI issuer of the publication client, added to the queries")
+
+	// Static serving options (-mode=serve-static)
+	StaticFile      = flag.String("static.file", "", "ROA list (GoRTR format, optionally gzipped) served without validating, the first -output.roa when empty")
+	StaticPublicKey = flag.String("static.publickey", "", "PEM public keys (as served on -http.publickey) verifying the signature of the static ROA list, required unless -output.sign=false")
+	StaticInterval  = flag.Duration("static.interval", 10*time.Second, "Interval at which the static ROA list is checked for changes (0 to never reload it)")
 
 	// Debugging options
-	Pprof     = flag.Bool("pprof", false, "Enable pprof endpoint")
-	Tracer    = flag.Bool("tracer", false, "Enable tracer")
-	SentryDSN = flag.String("sentry.dsn", "", "Send errors to Sentry")
+	Pprof                  = flag.Bool("pprof", false, "Enable pprof endpoint")
+	Tracer                 = flag.Bool("tracer", false, "Enable tracer")
+	SentryDSN              = flag.String("sentry.dsn", "", "Send errors to Sentry")
+	SentryEnvironment      = flag.String("sentry.environment", "", "Sentry environment (eg: staging, production)")
+	SentryRelease          = flag.String("sentry.release", AppVersion, "Sentry release")
+	SentryTracesSampleRate = flag.Float64("sentry.traces-sample-rate", 0, "Ratio of the transactions sent to Sentry (0 to disable tracing)")
 
 	MaxConcurrentRetrievals = flag.Uint("max_concurrent_retrievals", 100, "Maximum amount of concurrent retrievals (rsync + RRDP)")
+	MaxOpenFiles            = flag.Int("max.openfiles", 0, "Maximum amount of repository files read concurrently during validation (0 for no limit)")
 
-	Version = flag.Bool("version", false, "Print version")
+	Version     = flag.Bool("version", false, "Print version")
+	PrintConfig = flag.Bool("print-config", false, "Print the effective configuration as JSON and exit")
 
 	CertRepository = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 5}
 	CertRRDP       = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 13}
@@ -108,6 +221,13 @@ var (
 		},
 		[]string{"address", "type"},
 	)
+	MetricRsyncFilesChanged = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rsync_files_changed",
+			Help: "Files added, modified or deleted by the last rsync of a repository.",
+		},
+		[]string{"address", "type"},
+	)
 	MetricRsyncErrors = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "rsync_errors",
@@ -115,6 +235,60 @@ var (
 		},
 		[]string{"address"},
 	)
+	MetricPublicationPoints = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "publication_points",
+			Help: "Publication points discovered in each TAL, reachable over RRDP or only over rsync.",
+		},
+		[]string{"ta", "protocol"},
+	)
+	MetricRRDPCoverage = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rrdp_coverage_ratio",
+			Help: "Fraction of the publication points discovered in each TAL which are reachable over RRDP.",
+		},
+		[]string{"ta"},
+	)
+	MetricCacheMemory = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "cache_memory_bytes",
+			Help: "Size of the repository files kept by the memory storage.",
+		},
+	)
+	MetricGRPCClients = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "grpc_stream_clients",
+			Help: "Clients receiving the VRP updates over gRPC.",
+		},
+	)
+	MetricManifestNumberRegressions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "manifest_number_regressions_total",
+			Help: "Valid manifests whose number is lower than the number seen before for the same CA.",
+		},
+		[]string{"ta"},
+	)
+	MetricPhaseTimeout = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "phase_timeout",
+			Help: "Phase of the last iteration cancelled by its timeout (1 = timed out).",
+		},
+		[]string{"phase"},
+	)
+	MetricRsyncExitErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rsync_exit_errors_total",
+			Help: "Rsync processes exiting with an error, by category of the exit code (usage/restricted/network/partial/local/timeout/unknown).",
+		},
+		[]string{"address", "category"},
+	)
+	MetricRRDPConflicts = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rrdp_mapping_conflicts",
+			Help: "Conflicting RRDP to rsync mappings found during the last validation.",
+		},
+		[]string{"type"},
+	)
 	MetricRRDPErrors = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "rrdp_errors",
@@ -122,6 +296,34 @@ var (
 		},
 		[]string{"address"},
 	)
+	MetricRRDPFailover = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rrdp_failover",
+			Help: "RRDP failed over to rsync (1 = rsync, 0 = RRDP).",
+		},
+		[]string{"address"},
+	)
+	MetricRRDPSnapshots = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rrdp_snapshots_total",
+			Help: "RRDP snapshots applied.",
+		},
+		[]string{"address"},
+	)
+	MetricRRDPTooManyDeltas = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rrdp_max_deltas_snapshots_total",
+			Help: "RRDP snapshots applied instead of more deltas than -rrdp.max-deltas.",
+		},
+		[]string{"address"},
+	)
+	MetricRRDPDeltas = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rrdp_deltas_total",
+			Help: "RRDP deltas applied.",
+		},
+		[]string{"address"},
+	)
 	MetricRRDPSerial = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "rrdp_serial",
@@ -129,6 +331,13 @@ var (
 		},
 		[]string{"address"},
 	)
+	MetricRRDPSerialGap = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rrdp_serial_gap",
+			Help: "Serial increase of the RRDP notification since the previous fetch.",
+		},
+		[]string{"address"},
+	)
 	MetricROAsCount = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "roas",
@@ -136,6 +345,141 @@ var (
 		},
 		[]string{"ta"},
 	)
+	MetricROAPointCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "roa_point_count",
+			Help: "VRPs with a maximum length equal to the prefix length.",
+		},
+		[]string{"ta"},
+	)
+	MetricROARangeCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "roa_range_count",
+			Help: "VRPs with a maximum length longer than the prefix length.",
+		},
+		[]string{"ta"},
+	)
+	MetricShortPrefixes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "short_prefix_vrps",
+			Help: "VRPs of the default route or of a prefix shorter than -vrp.short-prefix.ipv4/ipv6.",
+		},
+		[]string{"ta"},
+	)
+	MetricNotYetValid = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "not_yet_valid_count",
+			Help: "ROAs excluded because their certificate is not valid yet.",
+		},
+		[]string{"ta"},
+	)
+	MetricDepthExceeded = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ca_depth_exceeded",
+			Help: "CA certificates deeper than the maximum depth.",
+		},
+		[]string{"ta"},
+	)
+	MetricDuplicateSKI = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "duplicate_ski",
+			Help: "Certificates rejected because another certificate has the same SubjectKeyIdentifier.",
+		},
+		[]string{"ta"},
+	)
+	MetricWeakCrypto = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "weak_crypto",
+			Help: "Certificates rejected because of a weak signature algorithm or key size (-strict.crypto).",
+		},
+		[]string{"ta"},
+	)
+	MetricEKUMismatches = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "eku_mismatches",
+			Help: "Signed objects whose EE certificate has an extended key usage (invalid with -strict.eku).",
+		},
+		[]string{"ta"},
+	)
+	MetricUnsafePaths = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "unsafe_paths_rejected",
+			Help: "Files not written because their path escapes the repository (tal, rrdp).",
+		},
+		[]string{"source"},
+	)
+	MetricRRDPManifestMismatch = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rrdp_manifest_mismatches",
+			Help: "Objects published over RRDP but not on their manifest (rrdp-only) and the reverse (manifest-only) (-audit.rrdp-manifest).",
+		},
+		[]string{"ta", "type"},
+	)
+	MetricManifestHashAlgorithm = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "manifest_hash_algorithm_errors",
+			Help: "Manifests rejected because their file hashes do not use SHA-256 (-strict.hash).",
+		},
+		[]string{"ta"},
+	)
+	MetricMultipleManifests = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "manifest_multiple",
+			Help: "CAs publishing several manifests, of which the valid one with the highest manifest number is used.",
+		},
+		[]string{"ta"},
+	)
+	MetricCMSDecodeFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cms_decode_failures",
+			Help: "Signed objects failing to decode with the strict CMS settings (strict=true) and without them (strict=false).",
+		},
+		[]string{"strict"},
+	)
+	MetricOriginChanges = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "origin_changes_total",
+			Help: "Origins authorized for a prefix which appeared or disappeared between stable validations.",
+		},
+		[]string{"type"},
+	)
+	MetricOriginChangedPrefixes = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "origin_changed_prefixes",
+			Help: "Prefixes whose authorized origins changed at the last stable validation.",
+		},
+	)
+	MetricPublicationDiscrepancies = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "publication_discrepancies",
+			Help: "Differences between the publication server (-publication.url) and the last stable validation.",
+		},
+		[]string{"type"},
+	)
+	MetricExcluded = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "excluded_vrps",
+			Help: "VRPs removed by the local exclusions (-output.exclude) during the last validation.",
+		},
+	)
+	MetricDuplicatesRemoved = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "duplicate_vrps_removed",
+			Help: "Duplicate VRPs removed during the last validation.",
+		},
+	)
+	MetricDistinctASNs = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "distinct_asns",
+			Help: "Distinct origin ASNs of the VRPs of the last validation.",
+		},
+	)
+	MetricDistinctPrefixes = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "distinct_prefixes",
+			Help: "Distinct prefixes of the VRPs of the last validation.",
+		},
+	)
 	MetricState = prometheus.NewGauge(
 		prometheus.GaugeOpts{
 			Name: "state",
@@ -154,6 +498,53 @@ var (
 			Help: "Timestamp of last validation.",
 		},
 	)
+	MetricPublicationPointsReused = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "validation_publication_points_reused",
+			Help: "Publication points whose validation was reused by the last iteration as their CA certificate and files did not change.",
+		},
+	)
+	MetricLoopBackoff = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "loop_backoff_seconds",
+			Help: "Delay before the next iteration after iterations where no TAL validated.",
+		},
+	)
+	MetricOldestRepository = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "repository_oldest_age_seconds",
+			Help: "Time since the least recently refreshed repository was last seen during a validation.",
+		},
+	)
+	// Age of the output of the running validator, computed when scraped
+	outputAgeFunc   = func() time.Duration { return 0 }
+	MetricOutputAge = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "output_age_seconds",
+			Help: "Age in seconds of the last stable output.",
+		},
+		func() float64 {
+			return outputAgeFunc().Seconds()
+		},
+	)
+	MetricHeapAlloc = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "iteration_heap_alloc_bytes",
+			Help: "Bytes of allocated heap objects at the end of the last iteration (-metrics.runtime).",
+		},
+	)
+	MetricNumGC = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "iteration_gc_count",
+			Help: "Completed GC cycles at the end of the last iteration (-metrics.runtime).",
+		},
+	)
+	MetricGoroutines = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "iteration_goroutines",
+			Help: "Goroutines at the end of the last iteration (-metrics.runtime).",
+		},
+	)
 	MetricOperationTime = prometheus.NewSummaryVec(
 		prometheus.SummaryOpts{
 			Name:       "operation_time",
@@ -162,6 +553,36 @@ var (
 		},
 		[]string{"type"},
 	)
+	MetricOperationDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "operation_duration_seconds",
+			Help:    "Time to run an operation.",
+			Buckets: prometheus.ExponentialBuckets(0.01, 4, 10),
+		},
+		[]string{"type"},
+	)
+	MetricTALValidationTime = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "tal_validation_time",
+			Help: "Time to explore and extract a TAL during the last validation.",
+		},
+		[]string{"ta", "type"},
+	)
+	MetricConvergenceDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "convergence_duration_seconds",
+			Help:    "Time from the first iteration of a cycle until the validation is stable.",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 14),
+		},
+	)
+	MetricObjectParseTime = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "object_parse_seconds",
+			Help:    "Time to decode and validate an object.",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 4, 8),
+		},
+		[]string{"type"},
+	)
 	MetricLastFetch = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "last_fetch",
@@ -183,6 +604,15 @@ type RRDPInfo struct {
 	Serial    int64  `json:"serial"`
 }
 
+// Serial increase since the previous fetch. A new session restarts the
+// numbering and is not a gap.
+func rrdpSerialGap(previous RRDPInfo, sessionID string, serial int64) int64 {
+	if previous.SessionID != sessionID || serial < previous.Serial {
+		return 0
+	}
+	return serial - previous.Serial
+}
+
 var errKeyNotParsed = fmt.Errorf("Failed to PEM decode key")
 
 func ReadKey(key []byte, isPem bool) (*ecdsa.PrivateKey, error) {
@@ -208,29 +638,74 @@ type OctoRPKI struct {
 	LastComputed time.Time
 	Key          *ecdsa.PrivateKey
 
+	// Public key advertised until PreviousKeyUntil after a key rotation
+	PreviousKey      *ecdsa.PublicKey
+	PreviousKeyUntil time.Time
+
 	Stable            atomic.Bool // Indicates something has been added to the fetch list (rsync or rrdp)
 	HasPreviousStable atomic.Bool
+	LastStable        atomic.Int64 // Unix timestamp of the last stable validation
+	started           time.Time
 	Fetcher           *syncpki.LocalFetch
 	HTTPFetcher       *syncpki.HTTPFetcher
 
 	PrevRepos    map[string]time.Time
 	CurrentRepos map[string]time.Time
 
+	validFiles []*pki.PKIFile // files of the validated objects, kept for exporting
+
+	previousOrigins      map[string]map[uint32]bool // origins authorized for each prefix at the last stable validation
+	previousVRPs         *prefixfile.ROAList        // ROA list of the last stable validation
+	previousSources      vrpSources
+	vrpDiff              atomic.Pointer[VRPDiff]
+	publication          *publicationClient
+	publicationValidated map[string]bool // URIs of the valid objects, compared with the publication server
+	vrpStream            *vrpStream      // clients of -grpc.addr
+
+	talValidations    map[string]*talValidation // by TAL path, reused with -validation.incremental
+	repositoryChanges *repositoryChanges
+	manifestNumbers   *manifestNumbers
+
+	talScheduler       *talScheduler
+	validationTime     time.Time // -validation.now, zero to validate at the current time
+	nextManifestUpdate time.Time
+	repositoryTALs     map[string][]int // maps from rsync URL to the TALs using it
+	fetchProtocols     map[string]string
+	fetchFilter        *regexp.Regexp
+	storage            syncpki.Storage
+	outputExclusions   *OutputExclusions
+	rsyncMirrors       map[string]string
+	rrdpObjects        *rrdpObjects
+	requiredTALsFailed bool     // a TAL of -require.tals did not validate in the last iteration
+	maxVRPsRefused     bool     // the ROA list of the last iteration was above -max.vrps and refused
+	allTALsFailed      bool     // no TAL validated in the last iteration
+	rsyncEnv           []string // added to the environment of rsync
+	exploreOrder       int      // pki.EXPLORE_BFS or pki.EXPLORE_DFS
+	watchdog           *watchdog
+	backoff            *loopBackoff
+	onFirstIteration   func() // called once, when the first iteration completes
+	pendingReload      atomic.Pointer[talReload]
+
 	rrdpFetch         map[string]string // maps from RRDP Url to rsync URL
 	rrdpFetchMu       sync.RWMutex
 	rrdpFetchDomain   map[string]string
 	rrdpFetchDomainMu sync.RWMutex
 
 	rsyncFetchJobManager *rsyncFetchJobManager
+	rsyncRestrictions    *rsyncRestrictions
 
 	RRDPInfo   map[string]RRDPInfo
 	RRDPInfoMu sync.RWMutex
 
-	ROAList   *prefixfile.ROAList
-	ROAListMu sync.RWMutex
+	rrdpFailover   map[string]bool // maps from rsync URL to failover state
+	rrdpFailoverMu sync.RWMutex
 
-	InfoAuthorities     [][]SIA
-	InfoAuthoritiesLock sync.RWMutex
+	snapshot   atomic.Pointer[validationSnapshot]
+	snapshotMu sync.Mutex // serializes updates of the snapshot
+
+	originValidator     *ov.OriginValidator
+	originValidatorList *prefixfile.ROAList // ROA list used to build the origin validator
+	originValidatorMu   sync.Mutex
 
 	stats  *octoRPKIStats
 	tracer opentracing.Tracer
@@ -281,11 +756,16 @@ type octoRPKIStats struct {
 	ValidationDuration time.Duration
 	iterations         atomic.Uint64
 	ROAsTALsCount      []ROAsTAL
+	DuplicatesRemoved  int
+	TALTimings         []TALTiming
+	exploreDurations   []time.Duration
+	vrpSources         vrpSources
 }
 
 func newOctoRPKIStats() *octoRPKIStats {
 	return &octoRPKIStats{
 		ROAsTALsCount: make([]ROAsTAL, 0),
+		TALTimings:    make([]TALTiming, 0),
 	}
 }
 
@@ -293,7 +773,7 @@ func (s *OctoRPKI) MainReduce() bool {
 	t1 := time.Now()
 	defer func() {
 		t2 := time.Now()
-		MetricOperationTime.With(prometheus.Labels{"type": "reduce"}).Observe(float64(t2.Sub(t1).Seconds()))
+		observeOperation("reduce", t2.Sub(t1), nil)
 	}()
 
 	var hasChanged bool
@@ -305,6 +785,8 @@ func (s *OctoRPKI) MainReduce() bool {
 		}
 	}
 
+	MetricOldestRepository.Set(oldestRepositoryAge(s.CurrentRepos, time.Now()).Seconds())
+
 	// Init deletion of folder if missing from current
 	s.Fetcher.SetRepositories(s.CurrentRepos)
 
@@ -315,6 +797,21 @@ func (s *OctoRPKI) MainReduce() bool {
 	return hasChanged
 }
 
+// Age of the repository refreshed the longest time ago. A repository stuck
+// since a given iteration keeps its timestamp while the others move forward.
+func oldestRepositoryAge(repos map[string]time.Time, now time.Time) time.Duration {
+	var oldest time.Time
+	for _, ts := range repos {
+		if oldest.IsZero() || ts.Before(oldest) {
+			oldest = ts
+		}
+	}
+	if oldest.IsZero() {
+		return 0
+	}
+	return now.Sub(oldest)
+}
+
 func ExtractRsyncDomain(rsyncURL string) (string, error) {
 	if !strings.HasPrefix(rsyncURL, syncpki.RsyncProtoPrefix) {
 		return "", fmt.Errorf("%q is not an rsync URL", rsyncURL)
@@ -323,30 +820,53 @@ func ExtractRsyncDomain(rsyncURL string) (string, error) {
 	return strings.Split(strings.TrimPrefix(rsyncURL, syncpki.RsyncProtoPrefix), "/")[0], nil
 }
 
-func (s *OctoRPKI) WriteRsyncFileOnDisk(rsyncURL string, data []byte) error {
-	fPath := mustExtractFoldersPathFromRsyncURL(rsyncURL)
-	mustMkdirAll(fPath)
-	filePath := mustExtractFilePathFromRsyncURL(rsyncURL)
-
-	// GHSA-8459-6rc9-8vf8: Prevent parent directory writes outside of Basepath
-	if strings.Contains(filePath, "../") || strings.Contains(filePath, "..\\") {
-		return fmt.Errorf("Path %q contains illegal path element", filePath)
+// Storage of the repository files, the filesystem under -cache by default.
+func (s *OctoRPKI) getStorage() syncpki.Storage {
+	if s.storage == nil {
+		return syncpki.NewFileStorage(*Basepath)
 	}
+	return s.storage
+}
 
-	fp := filepath.Join(*Basepath, filePath)
-	err := ioutil.WriteFile(fp, data, 0600)
-	if err != nil {
-		return fmt.Errorf("Unable to write file %q: %v", fp, err)
+// The memory storage only receives RRDP files: refuses the configurations
+// which need rsync, where a part of the repositories would never be fetched.
+func checkMemoryStorage(tals []*pki.PKIFile, fetchProtocols map[string]string) error {
+	if !*RRDP {
+		return fmt.Errorf("-cache.storage=%v requires -rrdp", syncpki.StorageMemory)
+	}
+	if *RRDPFailover {
+		return fmt.Errorf("-cache.storage=%v cannot fail over to rsync: set -rrdp.failover=false", syncpki.StorageMemory)
+	}
+	for domain, protocol := range fetchProtocols {
+		if protocol == FetchProtocolRsync {
+			return fmt.Errorf("-cache.storage=%v cannot fetch %s with rsync (-fetch.protocols)", syncpki.StorageMemory, domain)
+		}
+	}
+	for _, tal := range tals {
+		data, err := os.ReadFile(tal.Path)
+		if err != nil {
+			continue // reported by the validation
+		}
+		decoded, err := librpki.DecodeTAL(data)
+		if err != nil {
+			continue
+		}
+		var https bool
+		for _, uri := range decoded.URI {
+			https = https || strings.HasPrefix(uri, "https://")
+		}
+		if !https {
+			return fmt.Errorf("-cache.storage=%v cannot fetch the root certificate of %s without an HTTPS URI", syncpki.StorageMemory, tal.Path)
+		}
 	}
-
 	return nil
 }
 
-func mustMkdirAll(fPath string) {
-	err := os.MkdirAll(filepath.Join(*Basepath, fPath), os.ModePerm)
-	if err != nil {
-		log.Fatalf("Failed to create directories: %v", err)
-	}
+// Writes a file to the storage. The name is kept from when the cache was
+// always on disk.
+func (s *OctoRPKI) WriteRsyncFileOnDisk(rsyncURL string, data []byte) error {
+	filePath := mustExtractFilePathFromRsyncURL(rsyncURL)
+	return s.getStorage().Put(filePath, data)
 }
 
 func (s *OctoRPKI) ReceiveRRDPFileCallback(main string, url string, path string, data []byte, withdraw bool, snapshot bool, serial int64, args ...interface{}) error {
@@ -354,16 +874,23 @@ func (s *OctoRPKI) ReceiveRRDPFileCallback(main string, url string, path string,
 		rsync, ok := args[0].(string)
 		if ok && !strings.Contains(path, rsync) {
 			log.Errorf("rrdp: %s is outside directory %s", path, rsync)
+			MetricUnsafePaths.With(prometheus.Labels{"source": "rrdp"}).Inc()
 			return nil
 		}
 	}
 
 	err := s.WriteRsyncFileOnDisk(path, data)
 	if err != nil {
+		if errors.Is(err, syncpki.ErrIllegalPath) {
+			MetricUnsafePaths.With(prometheus.Labels{"source": "rrdp"}).Inc()
+		}
+		if errors.Is(err, syncpki.ErrStorageFull) {
+			return fmt.Errorf("%w, increase -cache.memory.max", err)
+		}
 		return fmt.Errorf("Unable to write sync file %q on disk: %v", path, err)
 	}
 
-	MetricSIACounts.With(prometheus.Labels{"address": main, "type": "rrdp"}).Inc()
+	MetricSIACounts.With(prometheus.Labels{"address": metricAddress(main), "type": "rrdp"}).Inc()
 	return nil
 }
 
@@ -411,12 +938,18 @@ func (s *OctoRPKI) getRRDPInfo() map[string]RRDPInfo {
 	return ret
 }
 
-func (s *OctoRPKI) mainRRDP(pSpan opentracing.Span) {
+func (s *OctoRPKI) mainRRDP(ctx context.Context, pSpan opentracing.Span) {
 	span := s.tracer.StartSpan("rrdp", opentracing.ChildOf(pSpan.Context()))
 	defer span.Finish()
 
-	fetcher := newRRDPFetcher(s, int(*MaxConcurrentRetrievals), span)
+	fetcher := newRRDPFetcher(ctx, s, int(*MaxConcurrentRetrievals), span)
 	for path, rsync := range s.getRRDPFetch() {
+		if ctx.Err() != nil {
+			break
+		}
+		if !s.isRepositoryDue(rsync) {
+			continue
+		}
 		fetcher.fetch(path, rsync)
 	}
 
@@ -424,7 +957,7 @@ func (s *OctoRPKI) mainRRDP(pSpan opentracing.Span) {
 	fetcher.wait()
 }
 
-func (s *OctoRPKI) fetchRRDP(path string, rsyncURL string, span opentracing.Span) {
+func (s *OctoRPKI) fetchRRDP(ctx context.Context, path string, rsyncURL string, span opentracing.Span) {
 	rSpan := s.tracer.StartSpan("sync", opentracing.ChildOf(span.Context()))
 	defer rSpan.Finish()
 
@@ -433,19 +966,40 @@ func (s *OctoRPKI) fetchRRDP(path string, rsyncURL string, span opentracing.Span
 	rSpan.SetTag("type", "rrdp")
 	log.Infof("RRDP sync %v", path)
 
-	MetricSIACounts.With(prometheus.Labels{"address": path, "type": "rrdp"}).Set(0)
+	MetricSIACounts.With(prometheus.Labels{"address": metricAddress(path), "type": "rrdp"}).Set(0)
 
 	rrdpSystem := s.newRRDPSystem(path, rsyncURL)
+	rrdpSystem.Context = ctx
+	changes := &rrdpObjectChanges{}
+	if *RRDPAudit || *Incremental {
+		callback := rrdpSystem.Callback
+		rrdpSystem.Callback = func(main string, url string, file string, data []byte, withdraw bool, snapshot bool, serial int64, args ...interface{}) error {
+			changes.record(file, withdraw)
+			return callback(main, url, file, data, withdraw, snapshot, serial, args...)
+		}
+	}
 
 	domain, _ := s.getRRDPDomain(path)
 	err := rrdpSystem.FetchRRDP(domain)
 	if err != nil {
+		s.repositoryChanges.mark(rsyncURL) // deltas may have been partially applied
+		if ctx.Err() != nil {
+			// The phase timed out, the repository is fetched again at the next iteration
+			log.Warnf("RRDP fetch of %v interrupted: %v", path, err)
+			rSpan.SetTag("interrupted", true)
+			return
+		}
 		s.rrdpError(rsyncURL, path, err, rSpan, rrdpSystem)
 		return
 	}
+	s.repositoryChanges.rrdpFetched(rsyncURL, rrdpSystem.SnapshotApplied, changes)
+	if *RRDPAudit {
+		s.rrdpObjects.apply(rsyncURL, rrdpSystem.SnapshotApplied, changes)
+	}
 
 	log.Debugf("Success fetching %s, removing rsync %s", path, rsyncURL)
 	s.rsyncFetchJobManager.delete(rsyncURL)
+	s.setRRDPFailover(rsyncURL, path, false)
 
 	rSpan.LogKV("event", "rrdp", "type", "success", "message", "rrdp successfully fetched")
 	sentry.WithScope(func(scope *sentry.Scope) {
@@ -456,12 +1010,27 @@ func (s *OctoRPKI) fetchRRDP(path string, rsyncURL string, span opentracing.Span
 		sentry.CaptureMessage("fetched rrdp successfully")
 	})
 
-	MetricRRDPSerial.With(prometheus.Labels{"address": path}).Set(float64(rrdpSystem.Serial))
-	MetricLastFetch.With(prometheus.Labels{"address": path, "type": "rrdp"}).Set(float64(time.Now().Unix()))
+	MetricRRDPSerial.With(prometheus.Labels{"address": metricAddress(path)}).Set(float64(rrdpSystem.Serial))
+	if rrdpSystem.SnapshotApplied {
+		MetricRRDPSnapshots.With(prometheus.Labels{"address": metricAddress(path)}).Inc()
+	}
+	if rrdpSystem.TooManyDeltas {
+		MetricRRDPTooManyDeltas.With(prometheus.Labels{"address": metricAddress(path)}).Inc()
+	}
+	MetricRRDPDeltas.With(prometheus.Labels{"address": metricAddress(path)}).Add(float64(rrdpSystem.DeltasApplied))
+	MetricLastFetch.With(prometheus.Labels{"address": metricAddress(path), "type": "rrdp"}).Set(float64(time.Now().Unix()))
 
 	s.RRDPInfoMu.Lock()
 	defer s.RRDPInfoMu.Unlock()
 
+	if previous, ok := s.RRDPInfo[rsyncURL]; ok {
+		gap := rrdpSerialGap(previous, rrdpSystem.SessionID, rrdpSystem.Serial)
+		MetricRRDPSerialGap.With(prometheus.Labels{"address": metricAddress(path)}).Set(float64(gap))
+		if *RRDPSerialGap > 0 && gap > *RRDPSerialGap {
+			log.Warnf("rrdp: serial of %s jumped from %d to %d since the previous fetch", path, previous.Serial, rrdpSystem.Serial)
+		}
+	}
+
 	s.RRDPInfo[rsyncURL] = RRDPInfo{
 		RsyncURL:  rsyncURL,
 		Path:      path,
@@ -470,6 +1039,26 @@ func (s *OctoRPKI) fetchRRDP(path string, rsyncURL string, span opentracing.Span
 	}
 }
 
+func (s *OctoRPKI) setRRDPFailover(rsyncURL string, path string, failover bool) {
+	s.rrdpFailoverMu.Lock()
+	defer s.rrdpFailoverMu.Unlock()
+
+	s.rrdpFailover[rsyncURL] = failover
+
+	var value float64
+	if failover {
+		value = 1
+	}
+	MetricRRDPFailover.With(prometheus.Labels{"address": metricAddress(path)}).Set(value)
+}
+
+func (s *OctoRPKI) getRRDPFailover(rsyncURL string) bool {
+	s.rrdpFailoverMu.RLock()
+	defer s.rrdpFailoverMu.RUnlock()
+
+	return s.rrdpFailover[rsyncURL]
+}
+
 func (s *OctoRPKI) newRRDPSystem(path string, rsync string) *syncpki.RRDPSystem {
 	s.RRDPInfoMu.RLock()
 	defer s.RRDPInfoMu.RUnlock()
@@ -480,6 +1069,7 @@ func (s *OctoRPKI) newRRDPSystem(path string, rsync string) *syncpki.RRDPSystem
 		Fetcher:   s.HTTPFetcher,
 		SessionID: s.RRDPInfo[rsync].SessionID,
 		Serial:    s.RRDPInfo[rsync].Serial,
+		MaxDeltas: *RRDPMaxDeltas,
 		Log:       log.StandardLogger(),
 	}
 }
@@ -496,6 +1086,7 @@ func (s *OctoRPKI) rrdpError(rsyncURL string, path string, err error, rSpan open
 		rrdp.SetSentryScope(scope)
 		scope.SetTag("Rsync", rsyncURL)
 		scope.SetTag("RRDP", path)
+		scope.SetFingerprint(fetchErrorFingerprint("rrdp", path, err))
 		sentry.CaptureException(err)
 	})
 
@@ -503,22 +1094,50 @@ func (s *OctoRPKI) rrdpError(rsyncURL string, path string, err error, rSpan open
 	if *RRDPFailover && err.Error() != "http: request body too large" {
 		log.Errorf("Error when processing %v (for %v): %v. Will add to rsync.", path, rsyncURL, err)
 		rSpan.LogKV("event", "rrdp failure", "type", "failover to rsync", "message", err)
+		s.setRRDPFailover(rsyncURL, path, true)
 	} else {
 		log.Errorf("Error when processing %v (for %v): %v.Skipping failover to rsync.", path, rsyncURL, err)
 		rSpan.LogKV("event", "rrdp failure", "type", "skipping failover to rsync", "message", err)
 		s.rsyncFetchJobManager.delete(rsyncURL)
+		s.setRRDPFailover(rsyncURL, path, false)
+	}
+
+	MetricRRDPErrors.With(prometheus.Labels{"address": metricAddress(path)}).Inc()
+}
+
+// Repositories are fetched when one of the TALs using them is due for a refresh.
+// Repositories not seen during the last validation are always fetched.
+func (s *OctoRPKI) isRepositoryDue(rsyncURL string) bool {
+	talIndexes, ok := s.repositoryTALs[rsyncURL]
+	if !ok {
+		return true
 	}
 
-	MetricRRDPErrors.With(prometheus.Labels{"address": path}).Inc()
+	for _, i := range talIndexes {
+		if s.talScheduler.isDue(i) {
+			return true
+		}
+	}
+	return false
 }
 
-func (s *OctoRPKI) mainRsync(pSpan opentracing.Span) {
+func (s *OctoRPKI) mainRsync(ctx context.Context, pSpan opentracing.Span) {
 	t1 := time.Now()
 	span := s.tracer.StartSpan("rsync", opentracing.ChildOf(pSpan.Context()))
 	defer span.Finish()
 
-	fetcher := newRsyncFetcher(s, int(*MaxConcurrentRetrievals), span)
+	fetcher := newRsyncFetcher(ctx, s, int(*MaxConcurrentRetrievals), span)
 	for rsyncURL := range s.rsyncFetchJobManager.get() {
+		if ctx.Err() != nil {
+			break
+		}
+		if !s.isRepositoryDue(rsyncURL) {
+			continue
+		}
+		if until, ok := s.rsyncRestrictions.restricted(rsyncURL, time.Now()); ok {
+			log.Debugf("Rsync of %v skipped until %v: access was refused", rsyncURL, until.Format(time.RFC3339))
+			continue
+		}
 		fetcher.fetch(rsyncURL)
 	}
 
@@ -526,16 +1145,7 @@ func (s *OctoRPKI) mainRsync(pSpan opentracing.Span) {
 	fetcher.wait()
 
 	t2 := time.Now()
-	MetricOperationTime.With(prometheus.Labels{"type": "rsync"}).Observe(float64(t2.Sub(t1).Seconds()))
-}
-
-func mustExtractFoldersPathFromRsyncURL(rsyncURL string) string {
-	downloadPath, err := syncpki.ExtractFoldersPathFromRsyncURL(rsyncURL)
-	if err != nil {
-		log.Fatalf("Failed to extract folder path from rsync URL: %v", err)
-	}
-
-	return downloadPath
+	observeOperation("rsync", t2.Sub(t1), span)
 }
 
 func mustExtractFilePathFromRsyncURL(rsyncURL string) string {
@@ -547,21 +1157,46 @@ func mustExtractFilePathFromRsyncURL(rsyncURL string) string {
 	return fPath
 }
 
-func (s *OctoRPKI) fetchRsync(uri string, span opentracing.Span) {
+func (s *OctoRPKI) fetchRsync(ctx context.Context, uri string, span opentracing.Span) {
 	rSpan := s.tracer.StartSpan("sync", opentracing.ChildOf(span.Context()))
 	defer rSpan.Finish()
 	rSpan.SetTag("rsync", uri)
 	rSpan.SetTag("type", "rsync")
 
+	if _, ok := s.getStorage().(*syncpki.FileStorage); !ok {
+		log.Errorf("Rsync of %v skipped: rsync requires -cache.storage=%v", uri, syncpki.StorageFilesystem)
+		return
+	}
+
 	log.Infof("Rsync sync %v", uri)
 	downloadPath := mustExtractFilePathFromRsyncURL(uri)
 
 	path := filepath.Join(*Basepath, downloadPath)
-	ctxRsync, cancelRsync := context.WithTimeout(context.Background(), *RsyncTimeout)
+	ctxRsync, cancelRsync := context.WithTimeout(ctx, *RsyncTimeout)
 	defer cancelRsync()
 
-	files, err := syncpki.RunRsync(ctxRsync, uri, *RsyncBin, path)
+	source := s.rsyncSource(uri)
+	if source != uri {
+		rSpan.SetTag("mirror", source)
+		log.Debugf("Rsync %v fetched from mirror %v", uri, source)
+	}
+	var files []*syncpki.FileStat
+	var err error
+	if *ReplayDir != "" {
+		files, err = replayRsync(*ReplayDir, source, path)
+	} else {
+		files, err = syncpki.RunRsync(ctxRsync, source, *RsyncBin, path, s.rsyncEnv)
+	}
 	if err != nil {
+		s.repositoryChanges.mark(uri) // files may have been partially fetched
+	} else {
+		s.repositoryChanges.rsyncFetched(uri, source, files)
+	}
+	if err != nil && ctx.Err() != nil {
+		// The phase timed out, the repository is fetched again at the next iteration
+		log.Warnf("Rsync of %v interrupted: %v", uri, err)
+		rSpan.SetTag("interrupted", true)
+	} else if err != nil {
 		s.rsyncError(uri, path, err, rSpan)
 	} else {
 		rSpan.LogKV("event", "rsync", "type", "success", "message", "rsync successfully fetched")
@@ -572,8 +1207,23 @@ func (s *OctoRPKI) fetchRsync(uri string, span opentracing.Span) {
 		})
 	}
 
-	MetricSIACounts.With(prometheus.Labels{"address": uri, "type": "rsync"}).Set(float64(len(files)))
-	MetricLastFetch.With(prometheus.Labels{"address": uri, "type": "rsync"}).Set(float64(time.Now().Unix()))
+	MetricSIACounts.With(prometheus.Labels{"address": metricAddress(uri), "type": "rsync"}).Set(float64(len(files)))
+	setRsyncFilesChanged(uri, files)
+	MetricLastFetch.With(prometheus.Labels{"address": metricAddress(uri), "type": "rsync"}).Set(float64(time.Now().Unix()))
+}
+
+func setRsyncFilesChanged(uri string, files []*syncpki.FileStat) {
+	counts := map[syncpki.FileChange]int{
+		syncpki.FileNew:     0,
+		syncpki.FileChanged: 0,
+		syncpki.FileDeleted: 0,
+	}
+	for _, file := range files {
+		counts[file.Change]++
+	}
+	for change, count := range counts {
+		MetricRsyncFilesChanged.With(prometheus.Labels{"address": metricAddress(uri), "type": string(change)}).Set(float64(count))
+	}
 }
 
 func (s *OctoRPKI) rsyncError(uri string, path string, err error, rSpan opentracing.Span) {
@@ -588,24 +1238,45 @@ func (s *OctoRPKI) rsyncError(uri string, path string, err error, rSpan opentrac
 			errC.SetSentryScope(scope)
 		}
 		scope.SetTag("Rsync", uri)
+		scope.SetFingerprint(fetchErrorFingerprint("rsync", uri, err))
 		sentry.CaptureException(err)
 	})
 
-	MetricRsyncErrors.With(prometheus.Labels{"address": uri}).Inc()
+	MetricRsyncErrors.With(prometheus.Labels{"address": metricAddress(uri)}).Inc()
+
+	var rsyncErr *syncpki.RsyncError
+	if errors.As(err, &rsyncErr) {
+		MetricRsyncExitErrors.With(prometheus.Labels{"address": metricAddress(uri), "category": rsyncErr.Category}).Inc()
+		if rsyncErr.Permanent() && *RsyncHoldoff > 0 {
+			log.Warnf("Rsync of %v failed permanently (%s): not fetched again for %v (-rsync.restricted.holdoff)", uri, rsyncErr.Category, *RsyncHoldoff)
+			s.rsyncRestrictions.add(uri, time.Now().Add(*RsyncHoldoff))
+		}
+	}
 }
 
-func filterDuplicates(roalist []prefixfile.ROAJson) []prefixfile.ROAJson {
-	roaListNoDup := make([]prefixfile.ROAJson, 0)
-	hmap := make(map[string]bool)
-	for _, roa := range roalist {
-		k := roa.String()
-		_, present := hmap[k]
-		if !present {
-			hmap[k] = true
-			roaListNoDup = append(roaListNoDup, roa)
+// Classifies fetch errors so recurring failures are grouped in Sentry.
+func fetchErrorCategory(err error) string {
+	var rrdpErr *syncpki.RRDPError
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &rrdpErr):
+		if name, ok := syncpki.ErrorTypeToName[rrdpErr.EType]; ok {
+			return name
 		}
+		return "unknown"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.As(err, &exitErr):
+		return fmt.Sprintf("exit-%d", exitErr.ExitCode())
+	case err != nil && err.Error() == "http: request body too large":
+		return "too-large"
 	}
-	return roaListNoDup
+	return "unknown"
+}
+
+// Sentry fingerprint for a fetch error: one issue per repository and category.
+func fetchErrorFingerprint(protocol string, repository string, err error) []string {
+	return []string{"fetch", protocol, repository, fetchErrorCategory(err)}
 }
 
 func setJaegerError(l []interface{}, err error) []interface{} {
@@ -613,32 +1284,45 @@ func setJaegerError(l []interface{}, err error) []interface{} {
 }
 
 // Fetches RFC8630-type TAL
-func (s *OctoRPKI) mainTAL(pSpan opentracing.Span) {
+func (s *OctoRPKI) mainTAL(ctx context.Context, pSpan opentracing.Span) {
 	t1 := time.Now()
 	span := s.tracer.StartSpan("tal", opentracing.ChildOf(pSpan.Context()))
 	defer span.Finish()
 
 	for path, tal := range s.TalsFetch {
-		s.fetchTAL(path, tal, span)
+		if ctx.Err() != nil {
+			break
+		}
+		s.repositoryChanges.fileChanged(tal.GetRsyncURI())
+		s.fetchTAL(ctx, path, tal, span)
 	}
 
 	t2 := time.Now()
-	MetricOperationTime.With(prometheus.Labels{"type": "tal"}).Observe(float64(t2.Sub(t1).Seconds()))
+	observeOperation("tal", t2.Sub(t1), span)
 }
 
-func (s *OctoRPKI) fetchTAL(path string, tal *librpki.RPKITAL, span opentracing.Span) {
+func (s *OctoRPKI) fetchTAL(ctx context.Context, path string, tal *librpki.RPKITAL, span opentracing.Span) {
 	tSpan := s.tracer.StartSpan("tal-fetch", opentracing.ChildOf(span.Context()))
 	defer tSpan.Finish()
 	tSpan.SetTag("tal", path)
 
-	success, successURL := s._fetchTAL(tal, path, span)
+	// TALs without HTTPS URI (RFC 7730) are only fetched using rsync
+	if !hasHTTPURI(tal) && tal.HasRsync() {
+		rsync := tal.GetRsyncURI()
+		log.Infof("Root certificate for %s will be downloaded using rsync: %s", path, rsync)
+		s.rsyncFetchJobManager.set(rsync, "")
+		tSpan.SetTag("rsync-only", true)
+		return
+	}
+
+	success, successURL := s._fetchTAL(ctx, tal, path, span)
 	if success {
 		log.Infof("Successfully downloaded root certificate for %s at %s", path, successURL)
 		return
 	}
 
 	// Fail over to rsync
-	if *RRDPFailover && tal.HasRsync() {
+	if *RRDPFailover && *TALRsync && tal.HasRsync() {
 		rsync := tal.GetRsyncURI()
 		log.Infof("Root certificate for %s will be downloaded using rsync: %s", path, rsync)
 		s.rsyncFetchJobManager.set(rsync, "")
@@ -646,28 +1330,96 @@ func (s *OctoRPKI) fetchTAL(path string, tal *librpki.RPKITAL, span opentracing.
 		return
 	}
 
-	log.Errorf("Could not download root certificate for %s", path)
+	err := fmt.Errorf("could not download root certificate for %s", path)
+	if !*TALRsync && tal.HasRsync() {
+		err = fmt.Errorf("could not download root certificate for %s over HTTPS and rsync failover is disabled", path)
+	}
+	log.Error(err)
 	tSpan.SetTag("error", true)
+	sentry.WithScope(func(scope *sentry.Scope) {
+		scope.SetTag("tal.path", path)
+		sentry.CaptureException(err)
+	})
+}
 
+func hasHTTPURI(tal *librpki.RPKITAL) bool {
+	for _, uri := range tal.URI {
+		if strings.HasPrefix(uri, "http://") || strings.HasPrefix(uri, "https://") {
+			return true
+		}
+	}
+	return false
 }
 
-func (s *OctoRPKI) _fetchTAL(tal *librpki.RPKITAL, path string, tSpan opentracing.Span) (success bool, successURL string) {
+// Downloads the root certificate from the HTTP URIs of the TAL in parallel.
+// The first successful download is kept and cancels the others, so a slow
+// URI does not delay the startup.
+func (s *OctoRPKI) _fetchTAL(ctx context.Context, tal *librpki.RPKITAL, path string, tSpan opentracing.Span) (success bool, successURL string) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type talDownload struct {
+		uri  string
+		data []byte
+		err  error
+	}
+	downloads := make(chan talDownload, len(tal.URI))
+	var count int
 	for _, uri := range tal.URI {
-		success, successURL := s.fetchTALurl(tal, uri, path, tSpan)
-		if success {
-			return success, successURL
+		if !strings.HasPrefix(uri, "http://") && !strings.HasPrefix(uri, "https://") {
+			continue
+		}
+		count++
+		go func(uri string) {
+			data, err := s.fetchTALurl(ctx, uri, path, tSpan)
+			downloads <- talDownload{uri: uri, data: data, err: err}
+		}(uri)
+	}
+
+	for i := 0; i < count; i++ {
+		download := <-downloads
+		if download.err != nil {
+			continue
+		}
+		cancel()
+
+		sHub := sentry.CurrentHub().Clone()
+		sHub.ConfigureScope(func(scope *sentry.Scope) {
+			scope.SetTag("tal.uri", download.uri)
+			scope.SetTag("tal.path", path)
+		})
+
+		err := s.WriteRsyncFileOnDisk(tal.GetRsyncURI(), download.data)
+		if err != nil {
+			if errors.Is(err, syncpki.ErrIllegalPath) {
+				MetricUnsafePaths.With(prometheus.Labels{"source": "tal"}).Inc()
+			}
+			log.Errorf("error while trying to fetch: %s: %v", download.uri, err)
+			sHub.CaptureException(err)
+			return false, ""
 		}
+
+		sHub.WithScope(func(scope *sentry.Scope) {
+			scope.SetLevel(sentry.LevelInfo)
+			sHub.CaptureMessage("fetched http tal cert successfully")
+		})
+		return true, download.uri
 	}
 
 	return false, ""
 }
 
-func (s *OctoRPKI) getHTTP(uri string, tfSpan opentracing.Span, sHub *sentry.Hub) ([]byte, error) {
-	req, err := http.NewRequest("GET", uri, nil)
+// Returns the User-Agent of a type of request (tal, rrdp or output).
+func requestUserAgent(requestType string) string {
+	return strings.ReplaceAll(*UserAgent, "{type}", requestType)
+}
+
+func (s *OctoRPKI) getHTTP(ctx context.Context, uri string, tfSpan opentracing.Span, sHub *sentry.Hub) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
 	if err != nil {
 		return nil, fmt.Errorf("error while trying to fetch: %s: %v", uri, err)
 	}
-	req.Header.Set("User-Agent", s.HTTPFetcher.UserAgent)
+	req.Header.Set("User-Agent", requestUserAgent("tal"))
 
 	sHub.ConfigureScope(func(scope *sentry.Scope) {
 		scope.SetRequest(req)
@@ -678,8 +1430,10 @@ func (s *OctoRPKI) getHTTP(uri string, tfSpan opentracing.Span, sHub *sentry.Hub
 		Category: "http",
 	}
 
-	// maybe add a limit in the client? To avoid downloading huge files (that wouldn't be certs)
 	resp, err := s.HTTPFetcher.Client.Do(req)
+	if err != nil && ctx.Err() != nil {
+		return nil, fmt.Errorf("error while trying to fetch: %s: %v", uri, err)
+	}
 	if err != nil {
 		sbc.Level = sentry.LevelError
 		sHub.AddBreadcrumb(sbc, nil)
@@ -701,21 +1455,25 @@ func (s *OctoRPKI) getHTTP(uri string, tfSpan opentracing.Span, sHub *sentry.Hub
 
 	sHub.AddBreadcrumb(sbc, nil)
 
-	data, err := ioutil.ReadAll(resp.Body)
+	// Avoid downloading huge files (that wouldn't be certs)
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, *TALMaxSize+1))
 	tfSpan.LogKV("size", len(data))
 	if err != nil {
 		sHub.CaptureException(err)
 		return nil, fmt.Errorf("error while trying to fetch: %s: %v", uri, err)
 	}
 
+	if int64(len(data)) > *TALMaxSize {
+		err = fmt.Errorf("response exceeds the maximum size of %d bytes while trying to fetch %s", *TALMaxSize, uri)
+		sHub.CaptureException(err)
+		return nil, err
+	}
+
 	return data, nil
 }
 
-func (s *OctoRPKI) fetchTALurl(tal *librpki.RPKITAL, uri string, path string, tSpan opentracing.Span) (success bool, successURL string) {
-	if !strings.HasPrefix(uri, "http://") && !strings.HasPrefix(uri, "https://") {
-		return false, ""
-	}
-
+// Downloads the root certificate at uri. Canceled downloads are not reported.
+func (s *OctoRPKI) fetchTALurl(ctx context.Context, uri string, path string, tSpan opentracing.Span) ([]byte, error) {
 	tfSpan := s.tracer.StartSpan("tal-fetch-uri", opentracing.ChildOf(tSpan.Context()))
 	defer tfSpan.Finish()
 	tfSpan.SetTag("uri", uri)
@@ -726,35 +1484,46 @@ func (s *OctoRPKI) fetchTALurl(tal *librpki.RPKITAL, uri string, path string, tS
 		scope.SetTag("tal.path", path)
 	})
 
-	data, err := s.getHTTP(uri, tfSpan, sHub)
+	data, err := s.getHTTP(ctx, uri, tfSpan, sHub)
 	if err != nil {
+		if ctx.Err() != nil {
+			tfSpan.SetTag("canceled", true)
+			return nil, err
+		}
 		tfSpan.SetTag("error", true)
 		tfSpan.SetTag("message", err)
 		log.Errorf("error while trying to download: %s: %v", uri, err)
-		return false, ""
+		return nil, err
 	}
 
-	// Plan option to store everything in memory
-	err = s.WriteRsyncFileOnDisk(tal.GetRsyncURI(), data)
-	if err != nil {
-		tfSpan.SetTag("error", true)
-		tfSpan.SetTag("message", err)
+	return data, nil
+}
 
-		log.Errorf("error while trying to fetch: %s: %v", uri, err)
-		sHub.CaptureException(err)
-		return false, ""
-	}
+func observeObjectParse(fileType int, duration time.Duration) {
+	MetricObjectParseTime.With(prometheus.Labels{"type": pki.TypeToName[fileType]}).Observe(duration.Seconds())
+}
 
-	sHub.WithScope(func(scope *sentry.Scope) {
-		scope.SetLevel(sentry.LevelInfo)
-		sHub.CaptureMessage("fetched http tal cert successfully")
-	})
+// Counts the certificates deeper than maxDepth, including the invalidated ones
+func countDepthExceeded(validator *pki.Validator, maxDepth int) int {
+	if maxDepth <= 0 {
+		return 0
+	}
 
-	return true, uri
+	var count int
+	for _, obj := range validator.Objects {
+		if obj.Type == pki.TYPE_CER && obj.Depth > maxDepth {
+			count++
+		}
+	}
+	return count
 }
 
-func logCollector(sm *pki.SimpleManager, tal *pki.PKIFile, tSpan opentracing.Span) {
+func logCollector(sm *pki.SimpleManager, tal *pki.PKIFile, talname string, vlog *validationLog, tSpan opentracing.Span) {
+	if vlog != nil {
+		defer vlog.wg.Done()
+	}
 	for err := range sm.Errors {
+		vlog.add(talname, err)
 		tSpan.SetTag("error", true)
 		tSpan.LogKV("event", "resource issue", "type", "skipping resource", "message", err)
 		log.Error(err)
@@ -777,9 +1546,12 @@ func (s *OctoRPKI) generateROAList(pkiManagers []*pki.SimpleManager, span opentr
 		Resources: make([]*schemas.OutputRes, 0),
 	}
 	resourcesMap := make(map[string]*schemas.OutputRes)
+	sources := make(vrpSources)
 	resourcesjson.Metadata.Generated = int(time.Now().UTC().UnixNano() / 1000000000)
 	s.stats.ROAsTALsCount = make([]ROAsTAL, 0)
+	talTimings := make([]TALTiming, 0, len(s.Tals))
 	for i, tal := range s.Tals {
+		tExtract := time.Now()
 		eSpan := s.tracer.StartSpan("extract", opentracing.ChildOf(span.Context()))
 		eSpan.SetTag("tal", tal.Path)
 		talname := tal.Path
@@ -879,10 +1651,15 @@ func (s *OctoRPKI) generateROAList(pkiManagers []*pki.SimpleManager, span opentr
 			}
 		}
 
-		var counttal int
+		var counttal, countNotYetValid, countPoint, countShort int
 		for _, obj := range pkiManagers[i].Validator.ValidROA {
 			roa := obj.Resource.(*librpki.RPKIROA)
 
+			if IsChainNotYetValid(obj, pkiManagers[i].Validator.Time.Add(pkiManagers[i].Validator.ClockSkew)) {
+				countNotYetValid++
+				continue
+			}
+
 			var path string
 			var hash string
 			if obj.File != nil {
@@ -929,8 +1706,16 @@ func (s *OctoRPKI) generateROAList(pkiManagers []*pki.SimpleManager, span opentr
 					TA:     talname,
 				}
 				roalist.Data = append(roalist.Data, oroa)
+				sources.add(uint32(roa.ASN), entry.IPNet, entry.MaxLength, talname, path)
 				counts++
 				counttal++
+				if IsPointROA(entry) {
+					countPoint++
+				}
+				if IsShortPrefix(entry.IPNet, *ShortPrefixV4, *ShortPrefixV6) {
+					countShort++
+					log.Warnf("VRP %v-%v AS%v of %v covers a short prefix, published by %v", oroa.Prefix, oroa.Length, roa.ASN, talname, path)
+				}
 
 				curResource.ROAs = append(curResource.ROAs, &schemas.OutputROA{
 					Prefix:    entry.IPNet.String(),
@@ -943,6 +1728,10 @@ func (s *OctoRPKI) generateROAList(pkiManagers []*pki.SimpleManager, span opentr
 
 		s.stats.ROAsTALsCount = append(s.stats.ROAsTALsCount, ROAsTAL{TA: talname, Count: counttal})
 		MetricROAsCount.With(prometheus.Labels{"ta": talname}).Set(float64(counttal))
+		MetricROAPointCount.With(prometheus.Labels{"ta": talname}).Set(float64(countPoint))
+		MetricROARangeCount.With(prometheus.Labels{"ta": talname}).Set(float64(counttal - countPoint))
+		MetricNotYetValid.With(prometheus.Labels{"ta": talname}).Set(float64(countNotYetValid))
+		MetricShortPrefixes.With(prometheus.Labels{"ta": talname}).Set(float64(countShort))
 
 		// Complete: Manifests
 		for _, obj := range pkiManagers[i].Validator.ValidManifest {
@@ -995,7 +1784,22 @@ func (s *OctoRPKI) generateROAList(pkiManagers []*pki.SimpleManager, span opentr
 		}
 
 		eSpan.Finish()
+
+		var exploreDuration time.Duration
+		if i < len(s.stats.exploreDurations) {
+			exploreDuration = s.stats.exploreDurations[i]
+		}
+		extractDuration := time.Since(tExtract)
+		talTimings = append(talTimings, TALTiming{
+			TA:              talname,
+			ExploreDuration: exploreDuration.Seconds(),
+			ExtractDuration: extractDuration.Seconds(),
+		})
+		MetricTALValidationTime.With(prometheus.Labels{"ta": talname, "type": "explore"}).Set(exploreDuration.Seconds())
+		MetricTALValidationTime.With(prometheus.Labels{"ta": talname, "type": "extract"}).Set(extractDuration.Seconds())
 	}
+	s.stats.TALTimings = talTimings
+	s.stats.vrpSources = sources
 	curTime := time.Now()
 	s.LastComputed = curTime
 	validTime := curTime.Add(*ValidityDuration)
@@ -1006,10 +1810,34 @@ func (s *OctoRPKI) generateROAList(pkiManagers []*pki.SimpleManager, span opentr
 	}
 
 	if s.Filter {
-		roalist.Data = FilterInvalidPrefixLen(FilterDuplicates(roalist.Data))
+		roalist.Data = FilterInvalidPrefixLen(roalist.Data)
+	}
+
+	var excluded int
+	roalist.Data, excluded = FilterExcluded(roalist.Data, s.outputExclusions)
+	if s.outputExclusions != nil {
+		log.Infof("Excluded %d VRPs (-output.exclude)", excluded)
 	}
+	MetricExcluded.Set(float64(excluded))
 
-	roalist.Data = filterDuplicates(roalist.Data)
+	if *OutputExpand {
+		compact := len(roalist.Data)
+		var skipped int
+		roalist.Data, skipped = ExpandROAs(roalist.Data, *OutputExpandMax)
+		log.Infof("Expanded %d VRPs into %d VRPs (-output.expand)", compact, len(roalist.Data))
+		if skipped > 0 {
+			log.Warnf("%d VRPs were not expanded as they cover more than %d prefixes (-output.expand.max)", skipped, *OutputExpandMax)
+		}
+	}
+
+	var duplicates int
+	roalist.Data, duplicates = FilterDuplicates(roalist.Data)
+	SortROAs(roalist.Data)
+	s.stats.DuplicatesRemoved = duplicates
+	MetricDuplicatesRemoved.Set(float64(duplicates))
+	asns, prefixes := CountDistinct(roalist.Data)
+	MetricDistinctASNs.Set(float64(asns))
+	MetricDistinctPrefixes.Set(float64(prefixes))
 	if *Sign {
 		s.signROAList(roalist, span)
 	}
@@ -1034,7 +1862,7 @@ func (s *OctoRPKI) signROAList(roaList *prefixfile.ROAList, span opentracing.Spa
 	roaList.Metadata.SignatureDate = signdate
 }
 
-func (s *OctoRPKI) mainValidation(pSpan opentracing.Span) [][]*pki.PKIFile {
+func (s *OctoRPKI) mainValidation(ctx context.Context, pSpan opentracing.Span) [][]*pki.PKIFile {
 	t1 := time.Now()
 	ia := make([][]SIA, len(s.Tals))
 	for i := 0; i < len(ia); i++ {
@@ -1046,14 +1874,41 @@ func (s *OctoRPKI) mainValidation(pSpan opentracing.Span) [][]*pki.PKIFile {
 	defer span.Finish()
 
 	ctData := make([][]*pki.PKIFile, 0)
+	repositoryTALs := make(map[string][]int)
+	manifests := make([]*librpki.RPKIManifest, 0)
+	validFiles := make([]*pki.PKIFile, 0)
+	exploreDurations := make([]time.Duration, len(s.Tals))
+	topology := newTopology()
+	rscs := make([]InfoRSC, 0)
+	holdings := make([]CAHoldings, 0)
+	rrdpMappings := newRRDPMappings()
+	roots := make([][]InfoTALRoot, len(s.Tals))
+	validObjects := make(map[string]string)
+
+	var vlog *validationLog
+	if *ValidationLog != "" || *OutputMessages {
+		vlog = newValidationLog()
+	}
 
 	pkiManagers := make([]*pki.SimpleManager, len(s.Tals))
+	tSpans := make([]opentracing.Span, len(s.Tals))
 	for i, tal := range s.Tals {
-		tSpan := s.tracer.StartSpan("explore", opentracing.ChildOf(span.Context()))
-		tSpan.SetTag("tal", tal.Path)
+		tSpans[i] = s.tracer.StartSpan("explore", opentracing.ChildOf(span.Context()))
+		tSpans[i].SetTag("tal", tal.Path)
 
 		validator := pki.NewValidator()
+		if !s.validationTime.IsZero() {
+			validator.Time = s.validationTime
+		}
 		validator.DecoderConfig.ValidateStrict = *StrictCms
+		validator.AllowNotYetValid = *ValidationNotYetValid
+		validator.ClockSkew = *ClockSkew
+		validator.CheckManifestHashAlgorithm = *StrictHash
+		validator.StrictEKU = *StrictEKU
+		validator.MaxDepth = *MaxDepth
+		validator.InvalidateDeep = *InvalidateDeep
+		validator.StrictCrypto = *StrictCrypto
+		validator.MinRSAKeySize = *MinRSAKeySize
 
 		sm := pki.NewSimpleManager()
 		pkiManagers[i] = sm
@@ -1063,11 +1918,43 @@ func (s *OctoRPKI) mainValidation(pSpan opentracing.Span) [][]*pki.PKIFile {
 		pkiManagers[i].Log = log.StandardLogger()
 		pkiManagers[i].StrictHash = *StrictHash
 		pkiManagers[i].StrictManifests = *StrictManifests
+		pkiManagers[i].AllowUnknown = *AllowUnknown
+		pkiManagers[i].ExploreOrder = s.exploreOrder
+		pkiManagers[i].ObserveParse = observeObjectParse
+		pkiManagers[i].Context = ctx
+		if *Incremental {
+			pkiManagers[i].Incremental = true
+			pkiManagers[i].Previous = s.previousValidation(tal, t1)
+			pkiManagers[i].Changed = s.repositoryChanges.changed
+		}
+
+		talname := tal.Path
+		if len(s.TalNames) == len(s.Tals) {
+			talname = s.TalNames[i]
+		}
+		if vlog != nil {
+			vlog.wg.Add(1)
+		}
+		go logCollector(sm, tal, talname, vlog, tSpans[i])
+	}
 
-		go logCollector(sm, tal, tSpan)
+	countExplores := exploreTALs(pkiManagers, s.Tals, *ValidationWorkers, exploreDurations)
+	if ctx.Err() != nil {
+		// The results are incomplete: the previous snapshot is kept
+		log.Warnf("Validation interrupted: %v", ctx.Err())
+		for i, sm := range pkiManagers {
+			sm.Close()
+			tSpans[i].SetTag("interrupted", true)
+			tSpans[i].Finish()
+		}
+		return nil
+	}
 
-		pkiManagers[i].AddInitial([]*pki.PKIFile{tal})
-		countExplore := pkiManagers[i].Explore(!*UseManifest, false)
+	// Results are merged in the order of the TALs
+	for i := range s.Tals {
+		sm := pkiManagers[i]
+		tSpan := tSpans[i]
+		countExplore := countExplores[i]
 
 		// Insertion of SIAs in db to allow rsync to update the repos
 		var count int
@@ -1093,8 +1980,15 @@ func (s *OctoRPKI) mainValidation(pSpan opentracing.Span) [][]*pki.PKIFile {
 				log.Errorf("Could not add cert rsync %s due to %v", rsyncGeneralName, err)
 				continue
 			}
+			if !s.fetchAllowed(gnExtracted) {
+				log.Debugf("Skipping %s not matching -fetch.filter", gnExtracted)
+				continue
+			}
 
 			if cer.HasRRDP() {
+				rrdpMappings.add(rrdpGeneralName, gnExtracted, gnExtractedDomain)
+			}
+			if cer.HasRRDP() && s.preferredProtocol(gnExtracted, rrdpGeneralName) == FetchProtocolRRDP {
 				prev, ok := s.getRRDPDomain(rrdpGeneralName)
 				if ok && prev != gnExtractedDomain {
 					log.Errorf("rrdp %s tries to override %s with %s", rrdpGeneralName, prev, gnExtractedDomain)
@@ -1105,14 +1999,17 @@ func (s *OctoRPKI) mainValidation(pSpan opentracing.Span) [][]*pki.PKIFile {
 			}
 			s.rsyncFetchJobManager.set(gnExtracted, rrdpGeneralName)
 			s.CurrentRepos[gnExtracted] = time.Now()
+			if talIndexes := repositoryTALs[gnExtracted]; len(talIndexes) == 0 || talIndexes[len(talIndexes)-1] != i {
+				repositoryTALs[gnExtracted] = append(talIndexes, i)
+			}
 			count++
 
 			// map the rrdp and rsync by TAL for info page
 			sia, ok := iatmp[gnExtracted]
 			if !ok {
 				tmpSIA := SIA{
-					gnExtracted,
-					rrdpGeneralName,
+					Rsync: gnExtracted,
+					RRDP:  rrdpGeneralName,
 				}
 				ia[i] = append(ia[i], tmpSIA)
 				sia = &(ia[i][len(ia[i])-1])
@@ -1121,8 +2018,42 @@ func (s *OctoRPKI) mainValidation(pSpan opentracing.Span) [][]*pki.PKIFile {
 			sia.Rsync = gnExtracted
 			sia.RRDP = rrdpGeneralName
 		}
+		for _, mft := range pkiManagers[i].Validator.ValidManifest {
+			manifests = append(manifests, mft.Resource.(*librpki.RPKIManifest))
+		}
+
+		talname := s.Tals[i].Path
+		if len(s.TalNames) == len(s.Tals) {
+			talname = s.TalNames[i]
+		}
+		MetricDepthExceeded.With(prometheus.Labels{"ta": talname}).Set(float64(countDepthExceeded(pkiManagers[i].Validator, *MaxDepth)))
+		MetricDuplicateSKI.With(prometheus.Labels{"ta": talname}).Set(float64(pkiManagers[i].Validator.SKIConflicts))
+		MetricWeakCrypto.With(prometheus.Labels{"ta": talname}).Set(float64(pkiManagers[i].Validator.WeakCrypto))
+		MetricManifestHashAlgorithm.With(prometheus.Labels{"ta": talname}).Set(float64(pkiManagers[i].Validator.ManifestHashAlgorithmErrors))
+		MetricMultipleManifests.With(prometheus.Labels{"ta": talname}).Set(float64(pkiManagers[i].Validator.MultipleManifests))
+		MetricEKUMismatches.With(prometheus.Labels{"ta": talname}).Set(float64(pkiManagers[i].Validator.EKUMismatches))
+		s.checkManifestNumbers(talname, pkiManagers[i].Validator, t1)
+		if *RRDPAudit {
+			s.auditRRDPManifests(talname, pkiManagers[i].Validator)
+		}
+		MetricCMSDecodeFailures.With(prometheus.Labels{"strict": "true"}).Add(float64(pkiManagers[i].Validator.CMSStrictFailures))
+		MetricCMSDecodeFailures.With(prometheus.Labels{"strict": "false"}).Add(float64(pkiManagers[i].Validator.CMSLenientFailures))
+		topology.addValidator(talname, pkiManagers[i].Validator)
+		rscs = append(rscs, rscInfos(talname, pkiManagers[i].Validator)...)
+		if *HoldingsPath != "" {
+			holdings = append(holdings, caHoldings(talname, pkiManagers[i].Validator)...)
+		}
+		roots[i] = talRoots(pkiManagers[i].Validator)
+		for uri := range validatedURIs(pkiManagers[i].Validator) {
+			validObjects[uri] = talname
+		}
+
+		if *ExportTar != "" {
+			validFiles = append(validFiles, validObjectFiles(pkiManagers[i].Validator)...)
+		}
+
 		sm.Close()
-		tSpan.LogKV("count-valid", count, "count-total", countExplore)
+		tSpan.LogKV("count-valid", count, "count-total", countExplore, "reused", sm.Reused)
 		tSpan.Finish()
 
 		if s.DoCT {
@@ -1130,17 +2061,104 @@ func (s *OctoRPKI) mainValidation(pSpan opentracing.Span) [][]*pki.PKIFile {
 		}
 	}
 
-	s.setInfoAuthorities(ia)
-	s.setROAList(s.generateROAList(pkiManagers, span))
+	for i := range ia {
+		sort.Slice(ia[i], func(a, b int) bool {
+			return ia[i][a].Rsync < ia[i][b].Rsync
+		})
+	}
+	topology.sort()
+	rrdpConflicts := rrdpMappings.conflicts()
+	reportRRDPConflicts(rrdpConflicts)
+	talNames := s.talNameList()
+	s.updateRRDPCoverage(talNames, repositoryTALs)
+
+	var messages []ValidationMessage
+	if vlog != nil {
+		messages = vlog.wait()
+	}
+	if *ValidationLog != "" {
+		err := writeValidationLog(*ValidationLog, t1, messages, *ValidationKeep)
+		if err != nil {
+			log.Errorf("Unable to write the validation log: %v", err)
+		}
+	}
+
+	if s.publication != nil {
+		validators := make([]*pki.Validator, len(pkiManagers))
+		for i, sm := range pkiManagers {
+			validators[i] = sm.Validator
+		}
+		s.publicationValidated = validatedURIs(validators...)
+	}
+
+	if *Incremental {
+		s.keepValidations(pkiManagers, t1)
+	}
+
+	s.repositoryTALs = repositoryTALs
+	s.validFiles = validFiles
+	s.stats.exploreDurations = exploreDurations
+	s.nextManifestUpdate = nextManifestUpdate(manifests, time.Now())
+	s.allTALsFailed = allTALsFailed(pkiManagers)
+	roaList := s.checkRequiredTALs(pkiManagers, s.checkMaxVRPs(s.generateROAList(pkiManagers, span)))
 
 	t2 := time.Now()
 	s.stats.ValidationDuration = t2.Sub(t1)
-	MetricOperationTime.With(prometheus.Labels{"type": "validation"}).Observe(float64(s.stats.ValidationDuration.Seconds()))
+	s.setSnapshot(&validationSnapshot{
+		TALNames:           talNames,
+		InfoAuthorities:    ia,
+		ROAList:            roaList,
+		ROAsTALs:           s.stats.ROAsTALsCount,
+		DuplicatesRemoved:  s.stats.DuplicatesRemoved,
+		TALTimings:         s.stats.TALTimings,
+		LastValidation:     s.LastComputed,
+		ValidationDuration: s.stats.ValidationDuration,
+		Topology:           topology,
+		RSCs:               rscs,
+		Holdings:           holdings,
+		TALRoots:           roots,
+		RRDPConflicts:      rrdpConflicts,
+		ValidObjects:       validObjects,
+		ValidationMessages: messages,
+		VRPSources:         s.stats.vrpSources,
+	})
+	observeOperation("validation", s.stats.ValidationDuration, span)
 	MetricLastValidation.Set(float64(s.LastComputed.Unix()))
 
 	return ctData
 }
 
+// Explores the TALs using a pool of workers. Each TAL has its own manager.
+func exploreTALs(pkiManagers []*pki.SimpleManager, tals []*pki.PKIFile, workers int, durations []time.Duration) []int {
+	if workers < 1 {
+		workers = 1
+	}
+
+	countExplores := make([]int, len(tals))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				t1 := time.Now()
+				pkiManagers[i].AddInitial([]*pki.PKIFile{tals[i]})
+				countExplores[i] = pkiManagers[i].Explore(!*UseManifest, false)
+				durations[i] = time.Since(t1)
+			}
+		}()
+	}
+
+	for i := range tals {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return countExplores
+}
+
 func (s *OctoRPKI) ct(pkiManagers []*pki.SimpleManager, i int) [][]*pki.PKIFile {
 	skiToAki := make(map[string]string)
 	skiToPath := make(map[string]*pki.PKIFile)
@@ -1179,25 +2197,41 @@ func (s *OctoRPKI) ct(pkiManagers []*pki.SimpleManager, i int) [][]*pki.PKIFile
 	return pathCT
 }
 
-func (s *OctoRPKI) setInfoAuthorities(ia [][]SIA) {
-	s.InfoAuthoritiesLock.Lock()
-	defer s.InfoAuthoritiesLock.Unlock()
-
-	s.InfoAuthorities = ia
+// Returns the time elapsed since the last stable validation.
+func (s *OctoRPKI) outputAge(now time.Time) time.Duration {
+	lastStable := s.LastStable.Load()
+	if lastStable == 0 {
+		return 0
+	}
+	return now.Sub(time.Unix(lastStable, 0))
 }
 
-func (s *OctoRPKI) setROAList(roaList *prefixfile.ROAList) {
-	s.ROAListMu.Lock()
-	defer s.ROAListMu.Unlock()
-
-	s.ROAList = roaList
+// Returns the seconds elapsed since the last stable validation. Before the
+// first stable validation, the time since startup is used so staleness alerts
+// still fire when the validator never converges.
+func (s *OctoRPKI) secondsSinceStable(now time.Time) float64 {
+	since := s.started
+	if lastStable := s.LastStable.Load(); lastStable != 0 {
+		since = time.Unix(lastStable, 0)
+	}
+	return now.Sub(since).Seconds()
 }
 
-func (s *OctoRPKI) getROAList() *prefixfile.ROAList {
-	s.ROAListMu.RLock()
-	defer s.ROAListMu.RUnlock()
+func (s *OctoRPKI) isOutputStale(now time.Time) bool {
+	return *StaleAfter > 0 && s.LastStable.Load() != 0 && s.outputAge(now) > *StaleAfter
+}
 
-	return s.ROAList
+// Value of the Cache-Control header of the ROA list. The stale directives
+// (RFC 5861) let caches keep serving the list during a validator outage.
+func cacheControl(maxAge int) string {
+	directives := []string{fmt.Sprintf("max-age=%v", maxAge)}
+	if *CacheStaleRevalidate > 0 {
+		directives = append(directives, fmt.Sprintf("stale-while-revalidate=%v", int(CacheStaleRevalidate.Seconds())))
+	}
+	if *CacheStaleError > 0 {
+		directives = append(directives, fmt.Sprintf("stale-if-error=%v", int(CacheStaleError.Seconds())))
+	}
+	return strings.Join(directives, ", ")
 }
 
 func (s *OctoRPKI) ServeROAs(w http.ResponseWriter, r *http.Request) {
@@ -1207,32 +2241,116 @@ func (s *OctoRPKI) ServeROAs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if s.isOutputStale(time.Now()) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("File is stale"))
+		return
+	}
+
 	upTo := s.LastComputed.Add(*ValidityDuration)
 	maxAge := int(upTo.Sub(time.Now()).Seconds())
 
-	w.Header().Set("Content-Type", "application/json")
+	mediaType, ok := negotiateROAListMediaType(r.Header.Get("Accept"))
+	if !ok {
+		w.WriteHeader(http.StatusNotAcceptable)
+		w.Write([]byte(fmt.Sprintf("Supported media types are %v and %v", MediaTypeROAListV1, MediaTypeROAListV2)))
+		return
+	}
+
+	// A .gz path serves a gzip file, other paths use the content coding
+	// accepted by the client
+	gzipFile := strings.HasSuffix(r.URL.Path, ".gz")
+	gzipEncoding := !gzipFile && acceptsGzip(r.Header.Get("Accept-Encoding"))
+
+	w.Header().Set("Vary", "Accept, Accept-Encoding")
+	if mediaType != "" {
+		w.Header().Set("Content-Type", mediaType)
+	} else {
+		w.Header().Set("Content-Type", outputContentType(*OutputFormat))
+	}
 
 	if maxAge > 0 && *CacheHeader {
-		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%v", maxAge))
+		w.Header().Set("Cache-Control", cacheControl(maxAge))
 	}
 
 	roaList := s.getROAList()
+	taFilter := r.URL.Query()["ta"]
 
 	etag := sha256.New()
 	etag.Write([]byte(fmt.Sprintf("%v/%v", roaList.Metadata.Generated, roaList.Metadata.Counts)))
+	if len(taFilter) > 0 {
+		etag.Write([]byte(fmt.Sprintf("/%v", strings.Join(taFilter, ","))))
+	}
+	if mediaType != "" {
+		etag.Write([]byte(fmt.Sprintf("/%v", mediaType)))
+	}
+	if gzipFile || gzipEncoding {
+		etag.Write([]byte("/gzip"))
+	}
 	etagSum := etag.Sum(nil)
 	etagSumHex := hex.EncodeToString(etagSum)
 
+	// The list served can be older than the last validation
+	lastModified := time.Unix(int64(roaList.Metadata.Generated), 0).UTC()
+	if roaList.Metadata.Generated != 0 {
+		w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	}
+
+	// If-Modified-Since is ignored when the request has an If-None-Match (RFC 7232)
 	if match := r.Header.Get("If-None-Match"); match != "" {
 		if match == etagSumHex {
 			w.WriteHeader(http.StatusNotModified)
 			return
 		}
+	} else if since := r.Header.Get("If-Modified-Since"); since != "" && roaList.Metadata.Generated != 0 {
+		sinceTime, err := http.ParseTime(since)
+		if err == nil && !lastModified.After(sinceTime) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	var output interface{}
+	var err error
+	if mediaType != "" {
+		output, err = formatROAListMediaType(roaList, mediaType, taFilter)
+	} else {
+		output, err = formatROAList(roaList, *OutputFormat, taFilter)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	var binary []byte
+	if l, ok := output.(*ProtobufROAList); ok {
+		binary, err = l.Marshal()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
 	}
 
 	w.Header().Set("Etag", etagSumHex)
-	enc := json.NewEncoder(w)
-	enc.Encode(roaList)
+	var out io.Writer = w
+	if gzipFile || gzipEncoding {
+		if gzipFile {
+			w.Header().Set("Content-Type", "application/gzip")
+		} else {
+			w.Header().Set("Content-Encoding", "gzip")
+		}
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		out = gz
+	}
+	if binary != nil {
+		out.Write(binary)
+		return
+	}
+	enc := json.NewEncoder(out)
+	enc.Encode(output)
 }
 
 func (s *OctoRPKI) ServeResources(w http.ResponseWriter, r *http.Request) {
@@ -1260,8 +2378,9 @@ func (s *OctoRPKI) ServeHealth(w http.ResponseWriter, r *http.Request) {
 }
 
 type SIA struct {
-	Rsync string `json:"rsync"`
-	RRDP  string `json:"rrdp,omitempty"`
+	Rsync    string `json:"rsync"`
+	RRDP     string `json:"rrdp,omitempty"`
+	Failover bool   `json:"rrdp-failover,omitempty"`
 }
 
 type ROAsTAL struct {
@@ -1269,31 +2388,40 @@ type ROAsTAL struct {
 	Count int    `json:"count,omitempty"`
 }
 
+type TALTiming struct {
+	TA              string  `json:"ta"`
+	ExploreDuration float64 `json:"explore-duration"`
+	ExtractDuration float64 `json:"extract-duration"`
+}
+
 type InfoAuthorities struct {
-	TA  string `json:"name"`
-	Sia []SIA  `json:"sia"`
+	TA    string        `json:"name"`
+	Sia   []SIA         `json:"sia"`
+	Roots []InfoTALRoot `json:"roots"`
 }
 
 type InfoResult struct {
-	Stable             bool              `json:"stable"`
-	TAs                []InfoAuthorities `json:"tas"`
-	Iteration          int               `json:"iteration"`
-	LastValidation     int               `json:"validation-last"`
-	ValidationDuration float64           `json:"validation-duration"`
-	ROAsTALs           []ROAsTAL         `json:"roas-tal-count"`
-	ROACount           int               `json:"roas-count"`
+	Stable             bool                  `json:"stable"`
+	TAs                []InfoAuthorities     `json:"tas"`
+	Iteration          int                   `json:"iteration"`
+	LastValidation     int                   `json:"validation-last"`
+	ValidationDuration float64               `json:"validation-duration"`
+	ROAsTALs           []ROAsTAL             `json:"roas-tal-count"`
+	ROACount           int                   `json:"roas-count"`
+	DuplicatesRemoved  int                   `json:"duplicates-removed"`
+	TALTimings         []TALTiming           `json:"tal-timings"`
+	RSCs               []InfoRSC             `json:"rscs"`
+	RRDPConflicts      []RRDPMappingConflict `json:"rrdp-conflicts"`
 }
 
 func (s *OctoRPKI) ServeInfo(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	s.InfoAuthoritiesLock.RLock()
-	ia := s.InfoAuthorities
-	s.InfoAuthoritiesLock.RUnlock()
+	snapshot := s.getSnapshot()
+	ia := snapshot.InfoAuthorities
 
 	ias := make([]InfoAuthorities, 0)
-	for i, tal := range s.Tals {
-
+	for i, talname := range snapshot.TALNames {
 		if len(ia) <= i {
 			break
 		}
@@ -1301,24 +2429,35 @@ func (s *OctoRPKI) ServeInfo(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
-		talname := tal.Path
-		if len(s.TalNames) == len(s.Tals) {
-			talname = s.TalNames[i]
+		sias := make([]SIA, len(ia[i]))
+		for j, sia := range ia[i] {
+			sia.Failover = sia.RRDP != "" && s.getRRDPFailover(sia.Rsync)
+			sias[j] = sia
+		}
+
+		roots := make([]InfoTALRoot, 0)
+		if i < len(snapshot.TALRoots) && snapshot.TALRoots[i] != nil {
+			roots = snapshot.TALRoots[i]
 		}
 
 		ias = append(ias, InfoAuthorities{
-			TA:  talname,
-			Sia: ia[i],
+			TA:    talname,
+			Sia:   sias,
+			Roots: roots,
 		})
 	}
 
 	ir := InfoResult{
 		TAs:                ias,
-		ROACount:           len(s.ROAList.Data),
-		ROAsTALs:           s.stats.ROAsTALsCount,
+		ROACount:           len(snapshot.ROAList.Data),
+		ROAsTALs:           snapshot.ROAsTALs,
+		DuplicatesRemoved:  snapshot.DuplicatesRemoved,
+		TALTimings:         snapshot.TALTimings,
+		RSCs:               snapshot.RSCs,
+		RRDPConflicts:      snapshot.RRDPConflicts,
 		Stable:             s.Stable.Load(),
-		LastValidation:     int(s.LastComputed.Unix()),
-		ValidationDuration: s.stats.ValidationDuration.Seconds(),
+		LastValidation:     int(snapshot.LastValidation.Unix()),
+		ValidationDuration: snapshot.ValidationDuration.Seconds(),
 		Iteration:          int(s.stats.iterations.Load()),
 	}
 	enc := json.NewEncoder(w)
@@ -1339,7 +2478,21 @@ func (s *OctoRPKI) Serve(addr string, roaPath string, metricsPath string, infoPa
 	r.HandleFunc("/resources.json", s.ServeResources)
 	r.HandleFunc(infoPath, s.ServeInfo)
 	r.HandleFunc(healthPath, s.ServeHealth)
-	r.Handle(metricsPath, promhttp.Handler())
+	r.Handle(metricsPath, metricsHandler(*MetricsExemplars))
+	r.HandleFunc(*ValidatePath, s.ServeValidate)
+	reloadPath := ""
+	if *ReloadToken != "" {
+		reloadPath = *ReloadPath
+		r.HandleFunc(reloadPath, s.ServeReload)
+	}
+	r.HandleFunc(*TopologyPath, s.ServeTopology)
+	r.HandleFunc(*PublicKeyPath, s.ServePublicKey)
+	r.HandleFunc(*ObjectPath, s.ServeObject)
+	if *HoldingsPath != "" {
+		r.HandleFunc(*HoldingsPath, s.ServeHoldings)
+	}
+	r.HandleFunc(*DiffPath, s.ServeDiff)
+	r.HandleFunc(*OpenAPIPath, ServeOpenAPI(GenerateOpenAPI(fullPath, infoPath, healthPath, metricsPath, *ValidatePath, reloadPath, *TopologyPath, *PublicKeyPath, *ObjectPath, *HoldingsPath, *DiffPath)))
 
 	if *Pprof {
 		r.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
@@ -1351,7 +2504,7 @@ func (s *OctoRPKI) Serve(addr string, roaPath string, metricsPath string, infoPa
 
 	corsReq := cors.New(cors.Options{
 		AllowedOrigins:   strings.Split(corsOrigin, ","),
-		AllowedMethods:   []string{"GET", "POST", "OPTIONS"},
+		AllowedMethods:   []string{"GET", "OPTIONS"},
 		AllowCredentials: corsCreds,
 	}).Handler(r)
 
@@ -1360,15 +2513,70 @@ func (s *OctoRPKI) Serve(addr string, roaPath string, metricsPath string, infoPa
 
 func init() {
 	prometheus.MustRegister(MetricSIACounts)
+	prometheus.MustRegister(MetricRsyncFilesChanged)
 	prometheus.MustRegister(MetricRsyncErrors)
+	prometheus.MustRegister(MetricRsyncExitErrors)
+	prometheus.MustRegister(MetricPhaseTimeout)
+	prometheus.MustRegister(MetricManifestNumberRegressions)
+	prometheus.MustRegister(MetricGRPCClients)
+	prometheus.MustRegister(MetricCacheMemory)
+	prometheus.MustRegister(MetricPublicationPoints)
+	prometheus.MustRegister(MetricRRDPCoverage)
 	prometheus.MustRegister(MetricRRDPErrors)
 	prometheus.MustRegister(MetricRRDPSerial)
+	prometheus.MustRegister(MetricRRDPSerialGap)
+	prometheus.MustRegister(MetricRRDPSnapshots)
+	prometheus.MustRegister(MetricRRDPDeltas)
+	prometheus.MustRegister(MetricRRDPTooManyDeltas)
+	prometheus.MustRegister(MetricRRDPFailover)
 	prometheus.MustRegister(MetricROAsCount)
+	prometheus.MustRegister(MetricROAPointCount)
+	prometheus.MustRegister(MetricROARangeCount)
+	prometheus.MustRegister(MetricShortPrefixes)
+	prometheus.MustRegister(MetricNotYetValid)
+	prometheus.MustRegister(MetricDepthExceeded)
+	prometheus.MustRegister(MetricDuplicateSKI)
+	prometheus.MustRegister(MetricWeakCrypto)
+	prometheus.MustRegister(MetricCMSDecodeFailures)
+	prometheus.MustRegister(MetricManifestHashAlgorithm)
+	prometheus.MustRegister(MetricMultipleManifests)
+	prometheus.MustRegister(MetricEKUMismatches)
+	prometheus.MustRegister(MetricRRDPManifestMismatch)
+	prometheus.MustRegister(MetricUnsafePaths)
+	prometheus.MustRegister(MetricDuplicatesRemoved)
+	prometheus.MustRegister(MetricExcluded)
+	prometheus.MustRegister(MetricDistinctASNs)
+	prometheus.MustRegister(MetricDistinctPrefixes)
+	prometheus.MustRegister(MetricOriginChanges)
+	prometheus.MustRegister(MetricOriginChangedPrefixes)
+	prometheus.MustRegister(MetricPublicationDiscrepancies)
+	prometheus.MustRegister(MetricRRDPConflicts)
 	prometheus.MustRegister(MetricState)
 	prometheus.MustRegister(MetricLastStableValidation)
 	prometheus.MustRegister(MetricLastValidation)
+	prometheus.MustRegister(MetricOldestRepository)
+	prometheus.MustRegister(MetricOutputAge)
+	prometheus.MustRegister(MetricLoopBackoff)
+	prometheus.MustRegister(MetricPublicationPointsReused)
+	prometheus.MustRegister(MetricHeapAlloc)
+	prometheus.MustRegister(MetricNumGC)
+	prometheus.MustRegister(MetricGoroutines)
 	prometheus.MustRegister(MetricOperationTime)
 	prometheus.MustRegister(MetricLastFetch)
+	prometheus.MustRegister(MetricTALValidationTime)
+	prometheus.MustRegister(MetricOperationDuration)
+	prometheus.MustRegister(MetricObjectParseTime)
+	prometheus.MustRegister(MetricConvergenceDuration)
+}
+
+func sentryOptions(dsn string) sentry.ClientOptions {
+	return sentry.ClientOptions{
+		Dsn:              dsn,
+		Environment:      *SentryEnvironment,
+		Release:          *SentryRelease,
+		EnableTracing:    *SentryTracesSampleRate > 0,
+		TracesSampleRate: *SentryTracesSampleRate,
+	}
 }
 
 func runningAsRoot() bool {
@@ -1383,6 +2591,18 @@ func main() {
 		fmt.Println(AppVersion)
 		os.Exit(0)
 	}
+	if *PrintConfig {
+		if err := printConfig(os.Stdout, flag.CommandLine); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
+	if flag.Arg(0) == "decode" {
+		if err := runDecode(flag.Args()[1:], os.Stdin, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
 
 	if !*AllowRoot && runningAsRoot() {
 		panic("Running as root is not allowed by default")
@@ -1390,15 +2610,21 @@ func main() {
 
 	lvl, _ := log.ParseLevel(*LogLevel)
 	log.SetLevel(lvl)
+	metricAddresses = newAddressLabels(*MetricsAddresses)
+	if *StatsdAddr != "" {
+		exporter, err := newStatsdExporter(*StatsdAddr, *StatsdPrefix)
+		if err != nil {
+			log.Fatal(err)
+		}
+		statsd = exporter
+	}
 
 	sentryDsn := *SentryDSN
 	if sentryDsn == "" {
 		sentryDsn = os.Getenv("SENTRY_DSN")
 	}
 	if sentryDsn != "" {
-		err := sentry.Init(sentry.ClientOptions{
-			Dsn: sentryDsn,
-		})
+		err := sentry.Init(sentryOptions(sentryDsn))
 		if err != nil {
 			log.Fatalf("failed initializing sentry: %s", err)
 		}
@@ -1420,74 +2646,254 @@ func main() {
 		opentracing.SetGlobalTracer(tracer)
 	}
 
+	if *Mode == "serve-static" {
+		serveStatic()
+		return
+	}
+
 	rootTALs := strings.Split(*RootTAL, ",")
 	talNames := strings.Split(*TALNames, ",")
-	tals := make([]*pki.PKIFile, 0)
-	for _, tal := range rootTALs {
-		tals = append(tals, &pki.PKIFile{
-			Path: tal,
-			Type: pki.TYPE_TAL,
-		})
+	tals, talNames, keptTALs, err := loadTALs(rootTALs, talNames, *TALSkipInvalid)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *TALHashes != "" {
+		expected, err := parseTALHashes(*TALHashes)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = checkTALHashes(tals, talNames, expected)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	err := os.MkdirAll(*Basepath, os.ModePerm)
+	if _, err := formatROAList(newROAList(), *OutputFormat, nil); err != nil {
+		log.Fatal(err)
+	}
+
+	storage, err := syncpki.NewStorage(*CacheStorage, *Basepath)
 	if err != nil {
-		log.Fatalf("Failed to create directories %q: %v", *Basepath, err)
+		log.Fatal(err)
+	}
+	if *CacheStorage == syncpki.StorageFilesystem {
+		err = os.MkdirAll(*Basepath, os.ModePerm)
+		if err != nil {
+			log.Fatalf("Failed to create directories %q: %v", *Basepath, err)
+		}
 	}
 
 	s := NewOctoRPKI(tals, talNames)
+	s.storage = storage
+	if memory, ok := storage.(*syncpki.MemoryStorage); ok {
+		memory.MaxSize = *CacheMemMax
+	}
+	if *CacheStorage != syncpki.StorageFilesystem {
+		s.Fetcher.SetStorage(storage)
+	}
+	if *MftNumbersFile != "" && *CacheStorage == syncpki.StorageFilesystem {
+		err = s.manifestNumbers.load(*MftNumbersFile)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Errorf("Unable to load the manifest numbers: %v", err)
+		}
+	}
 
-	if *Sign {
-		keyFile, err := os.Open(*SignKey)
+	// The intervals are given for every TAL of -tal.root, including the skipped ones
+	refreshIntervals, err := parseTALRefresh(*TALRefresh, len(rootTALs), *Refresh)
+	if err != nil {
+		log.Fatal(err)
+	}
+	refreshIntervals = keptTALValues(refreshIntervals, keptTALs)
+	switch *RefreshMode {
+	case RefreshModeFixed:
+	case RefreshModeManifest:
+		if *TALRefresh != "" {
+			log.Fatal("-tal.refresh cannot be used with the manifest refresh mode")
+		}
+		if *RefreshMin > *RefreshMax {
+			log.Fatal("-refresh.min must not be greater than -refresh.max")
+		}
+		// Every TAL is refreshed when the soonest manifest is due
+		refreshIntervals = make([]time.Duration, len(tals))
+	default:
+		log.Fatalf("Refresh mode %v is not supported. Choose either %v or %v", *RefreshMode, RefreshModeFixed, RefreshModeManifest)
+	}
+	s.talScheduler = newTALScheduler(*Refresh, refreshIntervals)
+	s.backoff = newLoopBackoff(*BackoffMin, *BackoffMax)
+
+	s.exploreOrder, err = pki.ParseExploreOrder(*ExploreOrder)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *ValidationNow != "" {
+		s.validationTime, err = time.Parse(time.RFC3339, *ValidationNow)
+		if err != nil {
+			log.Fatalf("Invalid -validation.now %q: %v", *ValidationNow, err)
+		}
+		log.Warnf("Validating the objects as if the current time was %v", s.validationTime)
+	}
+
+	s.rsyncEnv, err = syncpki.RsyncProxyEnv(*RsyncProxy)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *FetchProtocols != "" {
+		s.fetchProtocols, err = loadFetchProtocols(*FetchProtocols)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *CacheStorage == syncpki.StorageMemory {
+		err = checkMemoryStorage(tals, s.fetchProtocols)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *PublicationURL != "" {
+		s.publication, err = loadPublicationClient(*PublicationURL, *PublicationCert, *PublicationKey, *PublicationCRL)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *FetchFilter != "" {
+		s.fetchFilter, err = parseFetchFilter(*FetchFilter)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *RsyncMirrors != "" {
+		s.rsyncMirrors, err = loadRsyncMirrors(*RsyncMirrors)
 		if err != nil {
 			log.Fatal(err)
 		}
-		keyBytes, err := ioutil.ReadAll(keyFile)
+	}
+
+	if *OutputExclude != "" {
+		s.outputExclusions, err = loadOutputExclusions(*OutputExclude)
 		if err != nil {
 			log.Fatal(err)
 		}
-		keyFile.Close()
-		keyDec, err := ReadKey(keyBytes, true)
+	}
+	if *OutputExpand {
+		log.Warn("-output.expand is enabled: the ROA list can be several orders of magnitude larger")
+	}
+	if *OutputMessages && *OutputFormat != OutputFormatGoRTR {
+		log.Warnf("-output.validation-messages is only supported by the %v output format", OutputFormatGoRTR)
+	}
+
+	outputAgeFunc = func() time.Duration {
+		return s.outputAge(time.Now())
+	}
+	prometheus.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "seconds_since_stable",
+			Help: "Seconds since the last stable validation (since startup if never stable).",
+		},
+		func() float64 {
+			return s.secondsSinceStable(time.Now())
+		},
+	))
+
+	if *Sign {
+		err := s.setupSigningKeys(*SignKey, *SignKeyNext, *SignKeyRotation, *SignKeyMissing, *SignKeyWait, time.Second)
 		if err != nil {
 			log.Fatal(err)
 		}
-		s.Key = keyDec
+	}
+
+	outputTargets, err := parseOutputTargets(*Output)
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	if *Mode == "server" {
-		go s.Serve(*Addr, *Output, *MetricsPath, *InfoPath, *HealthPath, *CorsOrigins, *CorsCreds)
+		roaPath, ok := firstFileTarget(outputTargets)
+		if !ok {
+			roaPath = "output.json"
+		}
+		serve := func() {
+			go s.Serve(*Addr, roaPath, *MetricsPath, *InfoPath, *HealthPath, *CorsOrigins, *CorsCreds)
+		}
+		if *HTTPDelayStart {
+			s.onFirstIteration = serve
+		} else {
+			serve()
+		}
+
+		if *GRPCAddr != "" {
+			s.vrpStream = newVRPStream()
+			go s.vrpStream.serve(*GRPCAddr)
+		}
 	} else if *Mode != "oneoff" {
-		log.Fatalf("Mode %v is not specified. Choose either server or oneoff", *Mode)
+		log.Fatalf("Mode %v is not specified. Choose either server, oneoff or serve-static", *Mode)
 	}
 
 	if *CertTransparencyThreads < 1 {
 		*CertTransparencyThreads = 1
 	}
 
+	if *Watchdog > 0 {
+		s.watchdog = newWatchdog(*Watchdog, exitStalled)
+		go s.watchdog.run(*Watchdog / 4)
+	}
+
 	s.validationLoop()
+
+	if *MetricsFile != "" {
+		err := writeMetricsFile(*MetricsFile, prometheus.DefaultGatherer)
+		if err != nil {
+			log.Errorf("Failed to write metrics to %s: %v", *MetricsFile, err)
+		}
+	}
 }
 
 func NewOctoRPKI(tals []*pki.PKIFile, talNames []string) *OctoRPKI {
-	return &OctoRPKI{
+	refreshIntervals, _ := parseTALRefresh("", len(tals), *Refresh)
+	s := &OctoRPKI{
 		TalsFetch:            make(map[string]*librpki.RPKITAL),
 		Tals:                 tals,
 		TalNames:             talNames,
 		RRDPInfo:             make(map[string]RRDPInfo),
+		rrdpFailover:         make(map[string]bool),
 		PrevRepos:            make(map[string]time.Time),
 		CurrentRepos:         make(map[string]time.Time),
+		talScheduler:         newTALScheduler(*Refresh, refreshIntervals),
+		repositoryTALs:       make(map[string][]int),
 		rsyncFetchJobManager: newRsyncFetchJobManager(),
+		rsyncRestrictions:    newRsyncRestrictions(),
 		rrdpFetch:            make(map[string]string),
 		rrdpFetchDomain:      make(map[string]string),
 		Fetcher:              syncpki.NewLocalFetch(*Basepath),
-		HTTPFetcher:          syncpki.NewHTTPFetcher(*UserAgent),
-		ROAList:              newROAList(),
+		rrdpObjects:          newRRDPObjects(),
+		talValidations:       make(map[string]*talValidation),
+		repositoryChanges:    newRepositoryChanges(),
+		manifestNumbers:      newManifestNumbers(),
+		HTTPFetcher:          syncpki.NewHTTPFetcher(requestUserAgent("rrdp")),
 		stats:                newOctoRPKIStats(),
-		InfoAuthorities:      make([][]SIA, 0),
+		started:              time.Now(),
 		tracer:               opentracing.GlobalTracer(),
 		DoCT:                 *CertTransparency,
 		CTPath:               *CertTransparencyAddr,
 		Filter:               *Filter,
 	}
+	s.Fetcher.SetMaxOpenFiles(*MaxOpenFiles)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if *DNSResolver != "" {
+		transport = newResolverTransport(newResolver(*DNSResolver))
+	}
+	transport.MaxIdleConnsPerHost = *RRDPIdleConns
+	transport.MaxConnsPerHost = *RRDPMaxConns
+	s.HTTPFetcher.Client.Transport = transport
+	if *ReplayDir != "" {
+		s.HTTPFetcher.Client.Transport = newReplayTransport(*ReplayDir)
+	}
+	s.snapshot.Store(newValidationSnapshot())
+	return s
 }
 
 type rsyncFetchJobManager struct {
@@ -1533,15 +2939,27 @@ func newROAList() *prefixfile.ROAList {
 	}
 }
 
+func (s *OctoRPKI) iterationFinished() {
+	if s.onFirstIteration != nil {
+		s.onFirstIteration()
+		s.onFirstIteration = nil
+	}
+}
+
 func (s *OctoRPKI) validationLoop() {
 	var spanActive bool
 	var pSpan opentracing.Span
 	var iterationsUntilStable int
+	var cycleStart time.Time
 	for {
+		s.applyReload()
+
 		if !spanActive {
 			pSpan = s.tracer.StartSpan("multoperation")
 			spanActive = true
 			iterationsUntilStable = 0
+			cycleStart = time.Now()
+			s.talScheduler.begin(time.Now())
 		}
 
 		span := s.tracer.StartSpan("operation", opentracing.ChildOf(pSpan.Context()))
@@ -1550,35 +2968,90 @@ func (s *OctoRPKI) validationLoop() {
 		iterationsUntilStable++
 		span.SetTag("iteration", s.stats.iterations.Load())
 
+		phases := newIterationPhases(span)
 		if *RRDP {
-			s.doRRDP(span)
+			phases.run("rrdp", *TimeoutRRDP, func(ctx context.Context) {
+				s.doRRDP(ctx, span)
+			})
 		}
 
 		// HTTPs TAL
-		s.mainTAL(span)
+		phases.run("tal", *TimeoutTAL, func(ctx context.Context) {
+			s.mainTAL(ctx, span)
+		})
 		s.TalsFetch = make(map[string]*librpki.RPKITAL) // clear decoded TAL for next iteration
 
-		s.mainRsync(span)
+		phases.run("rsync", *TimeoutRsync, func(ctx context.Context) {
+			s.mainRsync(ctx, span)
+		})
 
-		ctData := s.mainValidation(span)
+		var ctData [][]*pki.PKIFile
+		phases.run("validation", *TimeoutValidation, func(ctx context.Context) {
+			ctData = s.mainValidation(ctx, span)
+		})
+		if *MftNumbersFile != "" && *CacheStorage == syncpki.StorageFilesystem {
+			err := s.manifestNumbers.save(*MftNumbersFile)
+			if err != nil {
+				log.Errorf("Unable to save the manifest numbers: %v", err)
+			}
+		}
 
 		// Reduce
 		changed := s.MainReduce()
-		s.Stable.Store(!changed && s.stats.iterations.Load() > 1)
+		s.Stable.Store(!changed && s.stats.iterations.Load() > 1 && !s.requiredTALsFailed && !s.maxVRPsRefused && !phases.timedOut)
 		s.HasPreviousStable.Store(s.Stable.Load())
 
-		if *Mode == "oneoff" && (s.Stable.Load() || !*WaitStable) {
+		// A refused list is the previous one, or the empty list before the first validation
+		if *Mode == "oneoff" && (s.Stable.Load() || !*WaitStable) && !s.maxVRPsRefused {
 			s.mustOutput()
 		}
 
+		if *OutputPerTAL != "" && s.Stable.Load() {
+			err := s.outputPerTAL(*OutputPerTAL, span)
+			if err != nil {
+				log.Errorf("Failed to write the ROA lists of each TAL to %s: %v", *OutputPerTAL, err)
+			}
+		}
+
+		if *ExportTar != "" && s.Stable.Load() {
+			err := s.exportTar(*ExportTar)
+			if err != nil {
+				log.Errorf("Failed to export objects to %s: %v", *ExportTar, err)
+			}
+		}
+
+		if *MetricsRuntime {
+			sampleRuntimeStats()
+		}
+
+		if statsd != nil {
+			err := statsd.push(prometheus.DefaultGatherer)
+			if err != nil {
+				log.Errorf("Failed to push the metrics to StatsD: %v", err)
+			}
+		}
+
 		span.SetTag("stable", s.Stable.Load())
 		span.Finish()
+		s.watchdog.beat(time.Now())
+		s.iterationFinished()
 
 		// GHSA-g5gj-9ggf-9vmq: Prevent infinite repository traversal
 		if iterationsUntilStable > *MaxIterations {
-			// GHSA-pmw9-567p-68pc: Do not crash when MaxIterations is reached
-			log.Warning("Max iterations has been reached. Defining current state as stable and stoppping deeper validation. This number can be adjusted with -max.iterations")
-			s.Stable.Store(true)
+			if s.maxVRPsRefused && *Mode == "oneoff" {
+				log.Fatal("Max iterations has been reached without a ROA list below -max.vrps")
+			} else if s.maxVRPsRefused {
+				// Stabilizing would publish the refused list
+				log.Warning("Max iterations has been reached but the ROA list is above -max.vrps. Revalidating")
+			} else {
+				// GHSA-pmw9-567p-68pc: Do not crash when MaxIterations is reached
+				log.Warning("Max iterations has been reached. Defining current state as stable and stoppping deeper validation. This number can be adjusted with -max.iterations")
+				s.Stable.Store(true)
+			}
+		}
+
+		if s.Stable.Load() {
+			observeConvergence(time.Since(cycleStart), pSpan)
 		}
 
 		if *Mode == "oneoff" && s.Stable.Load() {
@@ -1593,28 +3066,53 @@ func (s *OctoRPKI) validationLoop() {
 			s.SendCertificateTransparency(span, ctData, *CertTransparencyThreads, *CertTransparencyTimeout)
 
 			t2 := time.Now().UTC()
-			MetricOperationTime.With(
-				prometheus.Labels{
-					"type": "ct",
-				}).
-				Observe(float64(t2.Sub(t1).Seconds()))
+			observeOperation("ct", t2.Sub(t1), span)
 		}
 
 		if s.Stable.Load() {
 			MetricLastStableValidation.Set(float64(s.LastComputed.Unix()))
+			s.LastStable.Store(s.LastComputed.Unix())
 			MetricState.Set(float64(1))
+			s.checkOriginChanges()
+			s.checkVRPDiff()
+			if s.vrpStream != nil {
+				s.vrpStream.publish(s.getROAList())
+			}
+			if s.publication != nil {
+				_, err := s.checkPublication(context.Background())
+				if err != nil {
+					log.Errorf("Failed to query the publication server: %v", err)
+				}
+			}
 
 			pSpan.SetTag("iterations", iterationsUntilStable)
 			pSpan.Finish()
 			spanActive = false
 
-			log.Infof("Stable state. Revalidating in %v", *Refresh)
-			<-time.After(*Refresh)
+			refresh := s.talScheduler.finish(time.Now())
+			if *RefreshMode == RefreshModeManifest {
+				refresh = manifestRefresh(s.nextManifestUpdate, time.Now(), *RefreshMin, *RefreshMax)
+			}
+			log.Infof("Stable state. Revalidating in %v", refresh)
+			s.watchdog.pause()
+			<-time.After(refresh)
+			s.watchdog.beat(time.Now())
 			s.Stable.Store(false)
 			continue
 		}
 
 		MetricState.Set(float64(0))
+		if s.allTALsFailed {
+			if delay := s.backoff.next(); delay > 0 {
+				log.Warnf("No TAL validated. Revalidating in %v", delay)
+				s.watchdog.pause()
+				<-time.After(delay)
+				s.watchdog.beat(time.Now())
+				continue
+			}
+		} else {
+			s.backoff.reset()
+		}
 		log.Info("Still exploring. Revalidating now")
 	}
 }
@@ -1627,40 +3125,84 @@ func (s *OctoRPKI) mustOutput() {
 }
 
 func (s *OctoRPKI) output() error {
-	fc, err := json.Marshal(s.ROAList)
+	snapshot := s.getSnapshot()
+	output, err := formatROAList(snapshot.ROAList, *OutputFormat, nil)
+	if err != nil {
+		return err
+	}
+	if *OutputMessages {
+		output = withValidationMessages(output, snapshot.ValidationMessages)
+	}
+
+	fc, err := marshalROAList(output)
 	if err != nil {
 		return fmt.Errorf("unable to marshal ROA list: %v", err)
 	}
 
-	if *Output == "" {
+	targets, err := parseOutputTargets(*Output)
+	if err != nil {
+		return err
+	}
+
+	if len(targets) == 0 {
 		fmt.Println(string(fc))
-	} else {
-		err := ioutil.WriteFile(*Output, fc, 0600)
-		if err != nil {
-			return fmt.Errorf("Unable to write ROA list to %q: %v", *Output, err)
-		}
+		return nil
 	}
 
-	return nil
+	client := &http.Client{Timeout: time.Minute}
+	written, err := writeOutputTargets(client, targets, fc)
+
+	if *OutputWebhook != "" {
+		notification := OutputNotification{
+			Targets:   make([]string, 0, len(written)),
+			Failed:    make([]string, 0),
+			Counts:    snapshot.ROAList.Metadata.Counts,
+			Generated: snapshot.ROAList.Metadata.Generated,
+			Valid:     snapshot.ROAList.Metadata.Valid,
+		}
+		isWritten := make(map[string]bool)
+		for _, target := range written {
+			isWritten[target] = true
+		}
+		for _, target := range targets {
+			if isWritten[target] {
+				notification.Targets = append(notification.Targets, outputTargetName(target))
+			} else {
+				notification.Failed = append(notification.Failed, outputTargetName(target))
+			}
+		}
+		if errWebhook := notifyOutputWebhook(client, *OutputWebhook, notification); errWebhook != nil {
+			errWebhook = fmt.Errorf("unable to notify %q: %v", outputTargetName(*OutputWebhook), errWebhook)
+			if err != nil {
+				return fmt.Errorf("%v; %v", err, errWebhook)
+			}
+			return errWebhook
+		}
+	}
+	return err
 }
 
-func (s *OctoRPKI) doRRDP(span opentracing.Span) {
+func (s *OctoRPKI) doRRDP(ctx context.Context, span opentracing.Span) {
 	t1 := time.Now()
 	defer func() {
 		t2 := time.Now()
-		MetricOperationTime.With(prometheus.Labels{"type": "rrdp"}).Observe(float64(t2.Sub(t1).Seconds()))
+		observeOperation("rrdp", t2.Sub(t1), span)
 	}()
 
-	if *RRDPFile != "" {
+	// The serials saved in the file do not match an empty memory storage
+	if *RRDPFile != "" && *CacheStorage == syncpki.StorageFilesystem {
 		err := s.LoadRRDPInfo(*RRDPFile)
 		if err != nil {
 			sentry.CaptureException(err)
 		}
 	}
 
-	s.mainRRDP(span)
+	s.mainRRDP(ctx, span)
 
-	if *RRDPFile != "" {
+	if memory, ok := s.storage.(*syncpki.MemoryStorage); ok {
+		MetricCacheMemory.Set(float64(memory.Size()))
+	}
+	if *RRDPFile != "" && *CacheStorage == syncpki.StorageFilesystem {
 		err := s.saveRRDPInfo(*RRDPFile)
 		if err != nil {
 			sentry.CaptureException(err)
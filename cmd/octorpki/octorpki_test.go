@@ -0,0 +1,671 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+	"github.com/cloudflare/gortr/prefixfile"
+	"github.com/getsentry/sentry-go"
+	"github.com/opentracing/opentracing-go"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+
+	syncpki "github.com/cloudflare/cfrpki/sync/lib"
+	librpki "github.com/cloudflare/cfrpki/validator/lib"
+)
+
+func TestServeROAsStaleAfter(t *testing.T) {
+	defer func(v time.Duration) { *StaleAfter = v }(*StaleAfter)
+
+	tests := []struct {
+		name       string
+		staleAfter time.Duration
+		lastStable time.Duration
+		expected   int
+	}{
+		{
+			name:       "Disabled",
+			staleAfter: 0,
+			lastStable: 48 * time.Hour,
+			expected:   http.StatusOK,
+		},
+		{
+			name:       "In window",
+			staleAfter: 2 * time.Hour,
+			lastStable: time.Hour,
+			expected:   http.StatusOK,
+		},
+		{
+			name:       "Out of window",
+			staleAfter: 2 * time.Hour,
+			lastStable: 3 * time.Hour,
+			expected:   http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			*StaleAfter = test.staleAfter
+
+			s := NewOctoRPKI(nil, nil)
+			s.Stable.Store(true)
+			s.LastStable.Store(time.Now().Add(-test.lastStable).Unix())
+
+			rec := httptest.NewRecorder()
+			s.ServeROAs(rec, httptest.NewRequest("GET", "/output.json", nil))
+			assert.Equal(t, test.expected, rec.Code)
+
+			assert.InDelta(t, test.lastStable.Seconds(), s.outputAge(time.Now()).Seconds(), 2)
+		})
+	}
+}
+
+func TestOutputAgeNeverStable(t *testing.T) {
+	defer func(v time.Duration) { *StaleAfter = v }(*StaleAfter)
+	*StaleAfter = time.Minute
+
+	s := NewOctoRPKI(nil, nil)
+	assert.Equal(t, time.Duration(0), s.outputAge(time.Now()))
+	assert.False(t, s.isOutputStale(time.Now()))
+}
+
+func getGaugeValue(t *testing.T, g prometheus.Gauge) float64 {
+	m := &dto.Metric{}
+	err := g.Write(m)
+	assert.Nil(t, err)
+	return m.GetGauge().GetValue()
+}
+
+func TestRRDPFailover(t *testing.T) {
+	defer func(v bool) { *RRDPFailover = v }(*RRDPFailover)
+	*RRDPFailover = true
+
+	rsyncURL := "rsync://rpki.example.com/repository"
+	path := "https://rpki.example.com/rrdp/notification.xml"
+
+	s := NewOctoRPKI([]*pki.PKIFile{{Path: "tals/example.tal", Type: pki.TYPE_TAL}}, []string{"Example"})
+	s.updateSnapshot(func(snapshot *validationSnapshot) {
+		snapshot.TALNames = []string{"Example"}
+		snapshot.InfoAuthorities = [][]SIA{{{Rsync: rsyncURL, RRDP: path}}}
+	})
+
+	getFailover := func() bool {
+		rec := httptest.NewRecorder()
+		s.ServeInfo(rec, httptest.NewRequest("GET", "/infos", nil))
+
+		var ir InfoResult
+		err := json.Unmarshal(rec.Body.Bytes(), &ir)
+		assert.Nil(t, err)
+		assert.Len(t, ir.TAs, 1)
+		assert.Len(t, ir.TAs[0].Sia, 1)
+		return ir.TAs[0].Sia[0].Failover
+	}
+
+	assert.False(t, getFailover())
+
+	span := opentracing.NoopTracer{}.StartSpan("test")
+	s.rrdpError(rsyncURL, path, errors.New("connection refused"), span, &syncpki.RRDPSystem{Path: path})
+	assert.True(t, s.getRRDPFailover(rsyncURL))
+	assert.True(t, getFailover())
+	assert.Equal(t, float64(1), getGaugeValue(t, MetricRRDPFailover.With(prometheus.Labels{"address": path})))
+
+	// RRDP succeeds again
+	s.setRRDPFailover(rsyncURL, path, false)
+	assert.False(t, getFailover())
+	assert.Equal(t, float64(0), getGaugeValue(t, MetricRRDPFailover.With(prometheus.Labels{"address": path})))
+}
+
+func TestGetHTTPMaxSize(t *testing.T) {
+	defer func(v int64) { *TALMaxSize = v }(*TALMaxSize)
+	*TALMaxSize = 1024
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		size := 1024
+		if r.URL.Path == "/large.cer" {
+			size = 1025
+		}
+		w.Write(bytes.Repeat([]byte{0x30}, size))
+	}))
+	defer ts.Close()
+
+	s := NewOctoRPKI(nil, nil)
+	span := opentracing.NoopTracer{}.StartSpan("test")
+
+	data, err := s.getHTTP(context.Background(), ts.URL+"/small.cer", span, sentry.CurrentHub().Clone())
+	assert.Nil(t, err)
+	assert.Len(t, data, 1024)
+
+	data, err = s.getHTTP(context.Background(), ts.URL+"/large.cer", span, sentry.CurrentHub().Clone())
+	assert.NotNil(t, err)
+	assert.Nil(t, data)
+}
+
+func TestFetchErrorFingerprint(t *testing.T) {
+	rsyncURL := "rsync://rpki.example.com/repository"
+	path := "https://rpki.example.com/rrdp/notification.xml"
+
+	rrdpErr := syncpki.NewRRDPErrorFetch(nil, errors.New("status is 500"))
+	assert.Equal(t, []string{"fetch", "rrdp", path, "fetch"}, fetchErrorFingerprint("rrdp", path, rrdpErr))
+	assert.Equal(t, []string{"fetch", "rrdp", path, "fetch"}, fetchErrorFingerprint("rrdp", path, fmt.Errorf("wrapped: %w", rrdpErr)))
+	assert.Equal(t, []string{"fetch", "rrdp", path, "unknown"}, fetchErrorFingerprint("rrdp", path, &syncpki.RRDPError{EType: 42}))
+	assert.Equal(t, []string{"fetch", "rrdp", path, "too-large"}, fetchErrorFingerprint("rrdp", path, errors.New("http: request body too large")))
+
+	assert.Equal(t, []string{"fetch", "rsync", rsyncURL, "timeout"}, fetchErrorFingerprint("rsync", rsyncURL, context.DeadlineExceeded))
+	assert.Equal(t, []string{"fetch", "rsync", rsyncURL, "unknown"}, fetchErrorFingerprint("rsync", rsyncURL, errors.New("rsync binary missing")))
+
+	// Same category and repository group together regardless of the message
+	assert.Equal(t,
+		fetchErrorFingerprint("rsync", rsyncURL, errors.New("first")),
+		fetchErrorFingerprint("rsync", rsyncURL, errors.New("second")))
+	assert.NotEqual(t,
+		fetchErrorFingerprint("rsync", rsyncURL, errors.New("first")),
+		fetchErrorFingerprint("rsync", "rsync://other.example.com/repository", errors.New("first")))
+}
+
+func TestServeROAsTAFilter(t *testing.T) {
+	s := NewOctoRPKI(nil, nil)
+	s.Stable.Store(true)
+	s.setROAList(&prefixfile.ROAList{
+		Metadata: prefixfile.MetaData{
+			Counts:        3,
+			Generated:     1626853335,
+			Valid:         1626856935,
+			Signature:     "signature",
+			SignatureDate: "1626853335",
+		},
+		Data: []prefixfile.ROAJson{
+			{Prefix: "1.0.0.0/24", Length: 24, ASN: "AS13335", TA: "APNIC"},
+			{Prefix: "192.0.2.0/24", Length: 24, ASN: "AS64500", TA: "RIPE"},
+			{Prefix: "198.51.100.0/24", Length: 24, ASN: "AS64501", TA: "RIPE"},
+		},
+	})
+
+	serve := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.ServeROAs(rec, httptest.NewRequest("GET", "/output.json"+query, nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		return rec
+	}
+
+	full := serve("")
+	var roaList prefixfile.ROAList
+	assert.Nil(t, json.Unmarshal(full.Body.Bytes(), &roaList))
+	assert.Len(t, roaList.Data, 3)
+	assert.Equal(t, "signature", roaList.Metadata.Signature)
+
+	filtered := serve("?ta=ripe")
+	var filteredList FilteredROAList
+	assert.Nil(t, json.Unmarshal(filtered.Body.Bytes(), &filteredList))
+	assert.Equal(t, FilteredMetaData{
+		Counts:    2,
+		Generated: 1626853335,
+		Valid:     1626856935,
+		Unsigned:  true,
+		TAFilter:  []string{"ripe"},
+	}, filteredList.Metadata)
+	assert.Equal(t, []prefixfile.ROAJson{
+		{Prefix: "192.0.2.0/24", Length: 24, ASN: "AS64500", TA: "RIPE"},
+		{Prefix: "198.51.100.0/24", Length: 24, ASN: "AS64501", TA: "RIPE"},
+	}, filteredList.Data)
+	assert.NotContains(t, filtered.Body.String(), "signature")
+	assert.NotEqual(t, full.Header().Get("Etag"), filtered.Header().Get("Etag"))
+
+	// Several trust anchors can be selected
+	var both FilteredROAList
+	assert.Nil(t, json.Unmarshal(serve("?ta=RIPE&ta=APNIC").Body.Bytes(), &both))
+	assert.Len(t, both.Data, 3)
+
+	var none FilteredROAList
+	assert.Nil(t, json.Unmarshal(serve("?ta=ARIN").Body.Bytes(), &none))
+	assert.Len(t, none.Data, 0)
+	assert.Equal(t, 0, none.Metadata.Counts)
+}
+
+func TestTALRsyncFailover(t *testing.T) {
+	defer func(v bool) { *TALRsync = v }(*TALRsync)
+	defer func(v bool) { *RRDPFailover = v }(*RRDPFailover)
+	*RRDPFailover = true
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	rsyncURI := "rsync://rpki.example.com/repo/root.cer"
+	tal := &librpki.RPKITAL{URI: []string{ts.URL + "/root.cer", rsyncURI}}
+
+	for _, enabled := range []bool{true, false} {
+		*TALRsync = enabled
+
+		s := NewOctoRPKI(nil, nil)
+		s.fetchTAL(context.Background(), "example.tal", tal, opentracing.NoopTracer{}.StartSpan("test"))
+
+		_, scheduled := s.rsyncFetchJobManager.get()[rsyncURI]
+		assert.Equal(t, enabled, scheduled, "failover %v", enabled)
+	}
+}
+
+func TestSecondsSinceStable(t *testing.T) {
+	s := NewOctoRPKI(nil, nil)
+	now := s.started.Add(time.Hour)
+
+	// Never stable: counts from startup
+	assert.Equal(t, float64(3600), s.secondsSinceStable(now))
+
+	s.LastStable.Store(now.Add(-time.Minute).Unix())
+	assert.Equal(t, float64(60), s.secondsSinceStable(now.Truncate(time.Second)))
+}
+
+func TestRsyncOnlyTAL(t *testing.T) {
+	defer func(v bool) { *TALRsync = v }(*TALRsync)
+	defer func(v bool) { *RRDPFailover = v }(*RRDPFailover)
+
+	rsyncURI := "rsync://rpki.example.com/repo/root.cer"
+	tal := &librpki.RPKITAL{URI: []string{rsyncURI}}
+	assert.False(t, hasHTTPURI(tal))
+	assert.True(t, hasHTTPURI(&librpki.RPKITAL{URI: []string{"https://rpki.example.com/root.cer", rsyncURI}}))
+
+	// Not a failover: fetched even when failing over to rsync is disabled
+	*RRDPFailover = false
+	*TALRsync = false
+
+	s := NewOctoRPKI(nil, nil)
+	s.fetchTAL(context.Background(), "example.tal", tal, opentracing.NoopTracer{}.StartSpan("test"))
+	assert.Equal(t, map[string]string{rsyncURI: ""}, s.rsyncFetchJobManager.get())
+}
+
+func TestSetRsyncFilesChanged(t *testing.T) {
+	uri := "rsync://rpki.example.com/repository"
+	setRsyncFilesChanged(uri, []*syncpki.FileStat{
+		{Path: uri + "/a.roa", Change: syncpki.FileNew},
+		{Path: uri + "/b.roa", Change: syncpki.FileNew},
+		{Path: uri + "/c.mft", Change: syncpki.FileChanged},
+	})
+
+	get := func(change syncpki.FileChange) float64 {
+		return getGaugeValue(t, MetricRsyncFilesChanged.With(prometheus.Labels{"address": uri, "type": string(change)}))
+	}
+	assert.Equal(t, float64(2), get(syncpki.FileNew))
+	assert.Equal(t, float64(1), get(syncpki.FileChanged))
+	assert.Equal(t, float64(0), get(syncpki.FileDeleted))
+}
+
+func TestRequestUserAgent(t *testing.T) {
+	defer func(v string) { *UserAgent = v }(*UserAgent)
+
+	userAgents := make(map[string]string)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgents[r.URL.Path] = r.Header.Get("User-Agent")
+	}))
+	defer ts.Close()
+
+	fetch := func() {
+		s := NewOctoRPKI(nil, nil)
+		_, err := s.getHTTP(context.Background(), ts.URL+"/root.cer", opentracing.NoopTracer{}.StartSpan("test"), sentry.CurrentHub().Clone())
+		assert.Nil(t, err)
+		_, err = s.HTTPFetcher.GetXML(ts.URL + "/notification.xml")
+		assert.Nil(t, err)
+		assert.Nil(t, writeOutputTarget(ts.Client(), ts.URL+"/output.json", []byte("{}")))
+	}
+
+	*UserAgent = "octorpki/1.0 ({type})"
+	fetch()
+	assert.Equal(t, map[string]string{
+		"/root.cer":         "octorpki/1.0 (tal)",
+		"/notification.xml": "octorpki/1.0 (rrdp)",
+		"/output.json":      "octorpki/1.0 (output)",
+	}, userAgents)
+
+	// Without placeholder, every request has the same User-Agent
+	*UserAgent = "octorpki/1.0"
+	fetch()
+	for path, userAgent := range userAgents {
+		assert.Equal(t, "octorpki/1.0", userAgent, path)
+	}
+}
+
+func TestOldestRepositoryAge(t *testing.T) {
+	now := time.Now()
+	assert.Equal(t, time.Duration(0), oldestRepositoryAge(map[string]time.Time{}, now))
+
+	s := NewOctoRPKI(nil, nil)
+	s.CurrentRepos["rsync://rpki.example.com/repository"] = now
+	s.CurrentRepos["rsync://stale.example.com/repository"] = now.Add(-2 * time.Hour)
+	assert.Equal(t, 2*time.Hour, oldestRepositoryAge(s.CurrentRepos, now))
+
+	s.MainReduce()
+	age := getGaugeValue(t, MetricOldestRepository)
+	assert.GreaterOrEqual(t, age, (2 * time.Hour).Seconds())
+	assert.Less(t, age, (2*time.Hour + time.Minute).Seconds())
+}
+
+func TestFetchTALParallel(t *testing.T) {
+	defer func(v string) { *Basepath = v }(*Basepath)
+	*Basepath = t.TempDir()
+
+	slowStarted := make(chan struct{})
+	slowCanceled := make(chan bool, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow.cer" {
+			close(slowStarted)
+			select {
+			case <-r.Context().Done():
+				slowCanceled <- true
+			case <-time.After(10 * time.Second):
+				slowCanceled <- false
+			}
+			return
+		}
+		// Answers once the slow download started, so that it is the one canceled
+		select {
+		case <-slowStarted:
+		case <-time.After(5 * time.Second):
+		}
+		w.Write([]byte("certificate"))
+	}))
+	defer ts.Close()
+
+	rsyncURI := "rsync://rpki.example.com/repo/root.cer"
+	tal := &librpki.RPKITAL{URI: []string{ts.URL + "/slow.cer", ts.URL + "/fast.cer", rsyncURI}}
+
+	s := NewOctoRPKI(nil, nil)
+	start := time.Now()
+	success, successURL := s._fetchTAL(context.Background(), tal, "example.tal", opentracing.NoopTracer{}.StartSpan("test"))
+	assert.True(t, success)
+	assert.Equal(t, ts.URL+"/fast.cer", successURL)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	data, err := os.ReadFile(filepath.Join(*Basepath, "rpki.example.com/repo/root.cer"))
+	assert.Nil(t, err)
+	assert.Equal(t, "certificate", string(data))
+
+	// The slow download is canceled
+	assert.True(t, <-slowCanceled)
+	assert.Empty(t, s.rsyncFetchJobManager.get())
+}
+
+func TestSentryOptions(t *testing.T) {
+	defer func(env, release string, rate float64) {
+		*SentryEnvironment, *SentryRelease, *SentryTracesSampleRate = env, release, rate
+	}(*SentryEnvironment, *SentryRelease, *SentryTracesSampleRate)
+
+	options := sentryOptions("https://key@sentry.example.com/1")
+	assert.Equal(t, "https://key@sentry.example.com/1", options.Dsn)
+	assert.Equal(t, AppVersion, options.Release)
+	assert.False(t, options.EnableTracing)
+
+	*SentryEnvironment = "staging"
+	*SentryRelease = "1.2.3"
+	*SentryTracesSampleRate = 0.1
+	options = sentryOptions("https://key@sentry.example.com/1")
+	assert.Equal(t, "staging", options.Environment)
+	assert.Equal(t, "1.2.3", options.Release)
+	assert.True(t, options.EnableTracing)
+	assert.Equal(t, 0.1, options.TracesSampleRate)
+}
+
+func getCounterValue(t *testing.T, c prometheus.Counter) float64 {
+	m := &dto.Metric{}
+	err := c.Write(m)
+	assert.Nil(t, err)
+	return m.GetCounter().GetValue()
+}
+
+func TestRRDPSnapshotDeltaMetrics(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/notification.xml":
+			fmt.Fprintf(w, `<notification xmlns="http://www.ripe.net/rpki/rrdp" version="1" session_id="session" serial="3">
+<snapshot uri="http://%[1]s/snapshot.xml" hash="00"/>
+<delta serial="3" uri="http://%[1]s/3.xml" hash="00"/>
+<delta serial="2" uri="http://%[1]s/2.xml" hash="00"/>
+<delta serial="1" uri="http://%[1]s/1.xml" hash="00"/>
+</notification>`, r.Host)
+		case "/snapshot.xml":
+			w.Write([]byte(`<snapshot xmlns="http://www.ripe.net/rpki/rrdp" version="1" session_id="session" serial="3"></snapshot>`))
+		default:
+			w.Write([]byte(`<delta xmlns="http://www.ripe.net/rpki/rrdp" version="1" session_id="session" serial="2"></delta>`))
+		}
+	}))
+	defer ts.Close()
+
+	path := ts.URL + "/notification.xml"
+	rsyncURL := "rsync://rpki.example.com/repository"
+	snapshots := MetricRRDPSnapshots.With(prometheus.Labels{"address": path})
+	deltas := MetricRRDPDeltas.With(prometheus.Labels{"address": path})
+	span := opentracing.NoopTracer{}.StartSpan("test")
+
+	// Unknown session: the snapshot is applied
+	s := NewOctoRPKI(nil, nil)
+	s.fetchRRDP(context.Background(), path, rsyncURL, span)
+	assert.Equal(t, float64(1), getCounterValue(t, snapshots))
+	assert.Equal(t, float64(0), getCounterValue(t, deltas))
+	assert.Equal(t, int64(3), s.RRDPInfo[rsyncURL].Serial)
+
+	// Known session behind by two serials: the deltas are applied
+	s.RRDPInfo[rsyncURL] = RRDPInfo{RsyncURL: rsyncURL, Path: path, SessionID: "session", Serial: 1}
+	s.fetchRRDP(context.Background(), path, rsyncURL, span)
+	assert.Equal(t, float64(1), getCounterValue(t, snapshots))
+	assert.Equal(t, float64(2), getCounterValue(t, deltas))
+}
+
+func TestUnsafePathMetric(t *testing.T) {
+	defer func(v string) { *Basepath = v }(*Basepath)
+	*Basepath = filepath.Join(t.TempDir(), "cache")
+
+	s := &OctoRPKI{}
+	rrdp := MetricUnsafePaths.With(prometheus.Labels{"source": "rrdp"})
+	before := getCounterValue(t, rrdp)
+
+	// Outside of the repository announced by the CA
+	err := s.ReceiveRRDPFileCallback("https://rrdp.example.com/notification.xml", "", "rsync://other.example.com/repo/a.roa", []byte("data"), false, true, 1, "rsync://rpki.example.com/repo/")
+	assert.Nil(t, err)
+	assert.Equal(t, before+1, getCounterValue(t, rrdp))
+
+	// Parent directory traversal
+	err = s.ReceiveRRDPFileCallback("https://rrdp.example.com/notification.xml", "", "rsync://rpki.example.com/repo/../../a.roa", []byte("data"), false, true, 1, "rsync://rpki.example.com/repo/")
+	assert.NotNil(t, err)
+	assert.Equal(t, before+2, getCounterValue(t, rrdp))
+
+	err = s.ReceiveRRDPFileCallback("https://rrdp.example.com/notification.xml", "", "rsync://rpki.example.com/repo/a.roa", []byte("data"), false, true, 1, "rsync://rpki.example.com/repo/")
+	assert.Nil(t, err)
+	assert.Equal(t, before+2, getCounterValue(t, rrdp))
+}
+
+func TestRRDPSerialGap(t *testing.T) {
+	assert.Equal(t, int64(0), rrdpSerialGap(RRDPInfo{SessionID: "session", Serial: 3}, "other", 500))
+	assert.Equal(t, int64(0), rrdpSerialGap(RRDPInfo{SessionID: "session", Serial: 3}, "session", 2))
+	assert.Equal(t, int64(497), rrdpSerialGap(RRDPInfo{SessionID: "session", Serial: 3}, "session", 500))
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/notification.xml":
+			fmt.Fprintf(w, `<notification xmlns="http://www.ripe.net/rpki/rrdp" version="1" session_id="session" serial="500">
+<snapshot uri="http://%s/snapshot.xml" hash="00"/>
+</notification>`, r.Host)
+		default:
+			w.Write([]byte(`<snapshot xmlns="http://www.ripe.net/rpki/rrdp" version="1" session_id="session" serial="500"></snapshot>`))
+		}
+	}))
+	defer ts.Close()
+
+	path := ts.URL + "/notification.xml"
+	rsyncURL := "rsync://rpki.example.com/repository"
+	span := opentracing.NoopTracer{}.StartSpan("test")
+
+	s := NewOctoRPKI(nil, nil)
+	s.RRDPInfo[rsyncURL] = RRDPInfo{RsyncURL: rsyncURL, Path: path, SessionID: "session", Serial: 3}
+	s.fetchRRDP(context.Background(), path, rsyncURL, span)
+	assert.Equal(t, int64(500), s.RRDPInfo[rsyncURL].Serial)
+	assert.Equal(t, float64(497), getGaugeValue(t, MetricRRDPSerialGap.With(prometheus.Labels{"address": path})))
+}
+
+func TestServeROAsCacheControl(t *testing.T) {
+	defer func(revalidate, stale time.Duration) {
+		*CacheStaleRevalidate, *CacheStaleError = revalidate, stale
+	}(*CacheStaleRevalidate, *CacheStaleError)
+
+	s := NewOctoRPKI(nil, nil)
+	s.Stable.Store(true)
+	s.LastComputed = time.Now()
+	s.setROAList(&prefixfile.ROAList{Data: make([]prefixfile.ROAJson, 0)})
+
+	serve := func() string {
+		rec := httptest.NewRecorder()
+		s.ServeROAs(rec, httptest.NewRequest("GET", "/output.json", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		return rec.Header().Get("Cache-Control")
+	}
+
+	*CacheStaleRevalidate = 0
+	*CacheStaleError = 0
+	assert.Regexp(t, `^max-age=\d+$`, serve())
+
+	*CacheStaleRevalidate = time.Minute
+	*CacheStaleError = time.Hour
+	assert.Regexp(t, `^max-age=\d+, stale-while-revalidate=60, stale-if-error=3600$`, serve())
+}
+
+func TestServeROAsIfModifiedSince(t *testing.T) {
+	s := NewOctoRPKI(nil, nil)
+	s.Stable.Store(true)
+	// The previous list is served after a later validation
+	s.LastComputed = time.Date(2021, 7, 21, 8, 0, 0, 0, time.UTC)
+	s.setROAList(&prefixfile.ROAList{
+		Metadata: prefixfile.MetaData{Generated: int(time.Date(2021, 7, 21, 7, 42, 15, 500, time.UTC).Unix())},
+		Data:     make([]prefixfile.ROAJson, 0),
+	})
+
+	serve := func(header string, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/output.json", nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		rec := httptest.NewRecorder()
+		s.ServeROAs(rec, req)
+		return rec
+	}
+
+	rec := serve("", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "Wed, 21 Jul 2021 07:42:15 GMT", rec.Header().Get("Last-Modified"))
+
+	assert.Equal(t, http.StatusNotModified, serve("If-Modified-Since", "Wed, 21 Jul 2021 07:42:15 GMT").Code)
+	assert.Equal(t, http.StatusNotModified, serve("If-Modified-Since", "Thu, 22 Jul 2021 00:00:00 GMT").Code)
+	assert.Equal(t, http.StatusOK, serve("If-Modified-Since", "Wed, 21 Jul 2021 07:42:14 GMT").Code)
+	assert.Equal(t, http.StatusOK, serve("If-Modified-Since", "not a date").Code)
+
+	// The Etag takes precedence
+	req := httptest.NewRequest("GET", "/output.json", nil)
+	req.Header.Set("If-None-Match", "other")
+	req.Header.Set("If-Modified-Since", "Thu, 22 Jul 2021 00:00:00 GMT")
+	rec = httptest.NewRecorder()
+	s.ServeROAs(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestRRDPMaxDeltas(t *testing.T) {
+	defer func(v int) { *RRDPMaxDeltas = v }(*RRDPMaxDeltas)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/notification.xml":
+			fmt.Fprintf(w, `<notification xmlns="http://www.ripe.net/rpki/rrdp" version="1" session_id="session" serial="501">
+<snapshot uri="http://%s/snapshot.xml" hash="00"/>
+`, r.Host)
+			for serial := 501; serial >= 1; serial-- {
+				fmt.Fprintf(w, "<delta serial=\"%d\" uri=\"http://%s/%d.xml\" hash=\"00\"/>\n", serial, r.Host, serial)
+			}
+			fmt.Fprint(w, "</notification>")
+		case "/snapshot.xml":
+			w.Write([]byte(`<snapshot xmlns="http://www.ripe.net/rpki/rrdp" version="1" session_id="session" serial="501"></snapshot>`))
+		default:
+			w.Write([]byte(`<delta xmlns="http://www.ripe.net/rpki/rrdp" version="1" session_id="session" serial="2"></delta>`))
+		}
+	}))
+	defer ts.Close()
+
+	path := ts.URL + "/notification.xml"
+	rsyncURL := "rsync://rpki.example.com/repository"
+	snapshots := MetricRRDPSnapshots.With(prometheus.Labels{"address": path})
+	deltas := MetricRRDPDeltas.With(prometheus.Labels{"address": path})
+	fallbacks := MetricRRDPTooManyDeltas.With(prometheus.Labels{"address": path})
+	span := opentracing.NoopTracer{}.StartSpan("test")
+
+	// 500 deltas behind: the snapshot is fetched instead
+	*RRDPMaxDeltas = 100
+	s := NewOctoRPKI(nil, nil)
+	s.RRDPInfo[rsyncURL] = RRDPInfo{RsyncURL: rsyncURL, Path: path, SessionID: "session", Serial: 1}
+	s.fetchRRDP(context.Background(), path, rsyncURL, span)
+	assert.Equal(t, float64(1), getCounterValue(t, snapshots))
+	assert.Equal(t, float64(0), getCounterValue(t, deltas))
+	assert.Equal(t, float64(1), getCounterValue(t, fallbacks))
+	assert.Equal(t, int64(501), s.RRDPInfo[rsyncURL].Serial)
+
+	// Without limit every delta is applied
+	*RRDPMaxDeltas = 0
+	s.RRDPInfo[rsyncURL] = RRDPInfo{RsyncURL: rsyncURL, Path: path, SessionID: "session", Serial: 1}
+	s.fetchRRDP(context.Background(), path, rsyncURL, span)
+	assert.Equal(t, float64(1), getCounterValue(t, snapshots))
+	assert.Equal(t, float64(500), getCounterValue(t, deltas))
+	assert.Equal(t, float64(1), getCounterValue(t, fallbacks))
+}
+
+func TestHTTPDelayStart(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+
+	s := NewOctoRPKI(nil, nil)
+	s.onFirstIteration = func() {
+		go s.Serve(addr, "output.json", "/metrics", "/infos", "/health", "*", false)
+	}
+
+	_, err = net.Dial("tcp", addr)
+	assert.NotNil(t, err)
+
+	s.iterationFinished()
+	assert.Nil(t, s.onFirstIteration)
+	assert.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestHTTPConnsPerHost(t *testing.T) {
+	defer func(v int) { *RRDPIdleConns = v }(*RRDPIdleConns)
+	defer func(v int) { *RRDPMaxConns = v }(*RRDPMaxConns)
+	defer func(v string) { *DNSResolver = v }(*DNSResolver)
+
+	transport, ok := NewOctoRPKI(nil, nil).HTTPFetcher.Client.Transport.(*http.Transport)
+	assert.True(t, ok)
+	assert.Equal(t, http.DefaultMaxIdleConnsPerHost, transport.MaxIdleConnsPerHost)
+	assert.Equal(t, 0, transport.MaxConnsPerHost)
+
+	*RRDPIdleConns = 8
+	*RRDPMaxConns = 4
+	*DNSResolver = "192.0.2.53"
+	transport, ok = NewOctoRPKI(nil, nil).HTTPFetcher.Client.Transport.(*http.Transport)
+	assert.True(t, ok)
+	assert.Equal(t, 8, transport.MaxIdleConnsPerHost)
+	assert.Equal(t, 4, transport.MaxConnsPerHost)
+	// The default transport is not modified
+	assert.Equal(t, 0, http.DefaultTransport.(*http.Transport).MaxConnsPerHost)
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/cloudflare/cfrpki/api/schemas"
+	"github.com/cloudflare/gortr/prefixfile"
+)
+
+const OpenAPIVersion = "3.0.3"
+
+// Builds JSON schemas from Go types using their json struct tags.
+// Named structs are placed in components and referenced.
+type openAPISchemas struct {
+	components map[string]interface{}
+}
+
+func newOpenAPISchemas() *openAPISchemas {
+	return &openAPISchemas{
+		components: make(map[string]interface{}),
+	}
+}
+
+func (o *openAPISchemas) ref(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t.Kind() == reflect.Struct && t.Name() != "" {
+		if _, ok := o.components[t.Name()]; !ok {
+			o.components[t.Name()] = nil // prevents infinite recursion
+			o.components[t.Name()] = o.schema(t)
+		}
+		return map[string]interface{}{
+			"$ref": "#/components/schemas/" + t.Name(),
+		}
+	}
+
+	return o.schema(t)
+}
+
+func (o *openAPISchemas) schema(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": o.ref(t.Elem()),
+		}
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": o.ref(t.Elem()),
+		}
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			name := field.Name
+			if tag, ok := field.Tag.Lookup("json"); ok {
+				tagName := strings.Split(tag, ",")[0]
+				if tagName == "-" {
+					continue
+				}
+				if tagName != "" {
+					name = tagName
+				}
+			}
+			properties[name] = o.ref(field.Type)
+		}
+		return map[string]interface{}{
+			"type":       "object",
+			"properties": properties,
+		}
+	}
+
+	// interface{} and other dynamic types can hold any value
+	return map[string]interface{}{}
+}
+
+func openAPIJSONResponse(description string, schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{
+				"schema": schema,
+			},
+		},
+	}
+}
+
+func openAPIGet(summary string, responses map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":   summary,
+			"responses": responses,
+		},
+	}
+}
+
+func openAPIQueryParameter(name string, description string, required bool) map[string]interface{} {
+	return map[string]interface{}{
+		"name":        name,
+		"in":          "query",
+		"required":    required,
+		"description": description,
+		"schema":      map[string]interface{}{"type": "string"},
+	}
+}
+
+// Generates the OpenAPI document describing the HTTP endpoints.
+func GenerateOpenAPI(roaPath, infoPath, healthPath, metricsPath, validatePath, reloadPath, topologyPath, publicKeyPath, objectPath, holdingsPath, diffPath string) map[string]interface{} {
+	o := newOpenAPISchemas()
+
+	unavailable := map[string]interface{}{
+		"description": "Validation has not reached a stable state yet",
+	}
+
+	roaListResponse := openAPIJSONResponse("ROA list (depends on -output.format)", map[string]interface{}{
+		"oneOf": []interface{}{
+			o.ref(reflect.TypeOf(prefixfile.ROAList{})),
+			o.ref(reflect.TypeOf(FilteredROAList{})),
+			o.ref(reflect.TypeOf(RoutinatorOutput{})),
+		},
+	})
+	roaListResponse["content"].(map[string]interface{})["application/x-protobuf"] = map[string]interface{}{
+		"schema": map[string]interface{}{"type": "string", "format": "binary"},
+	}
+	roaListResponse["content"].(map[string]interface{})[MediaTypeROAListV1] = map[string]interface{}{
+		"schema": o.ref(reflect.TypeOf(prefixfile.ROAList{})),
+	}
+	roaListResponse["content"].(map[string]interface{})[MediaTypeROAListV2] = map[string]interface{}{
+		"schema": o.ref(reflect.TypeOf(ROAListV2{})),
+	}
+
+	paths := map[string]interface{}{
+		roaPath: map[string]interface{}{
+			"get": map[string]interface{}{
+				"summary": "List of validated ROA payloads",
+				"parameters": []interface{}{
+					openAPIQueryParameter("ta", "Only return the VRPs of this trust anchor (repeatable). The filtered list is not signed", false),
+				},
+				"responses": map[string]interface{}{
+					"200": roaListResponse,
+					"304": map[string]interface{}{"description": "Not modified"},
+					"406": map[string]interface{}{"description": "Unsupported version of the media type"},
+					"503": unavailable,
+				},
+			},
+		},
+		"/resources.json": openAPIGet("Validated resources", map[string]interface{}{
+			"200": openAPIJSONResponse("Resources", o.ref(reflect.TypeOf(schemas.ResourcesJSON{}))),
+			"503": unavailable,
+		}),
+		infoPath: openAPIGet("Validator information and statistics", map[string]interface{}{
+			"200": openAPIJSONResponse("Information", o.ref(reflect.TypeOf(InfoResult{}))),
+		}),
+		validatePath: map[string]interface{}{
+			"get": map[string]interface{}{
+				"summary": "Origin validation of a route against the ROA list (RFC 6811)",
+				"parameters": []interface{}{
+					openAPIQueryParameter("prefix", "IP prefix of the route", true),
+					openAPIQueryParameter("asn", "Origin AS of the route", true),
+				},
+				"responses": map[string]interface{}{
+					"200": openAPIJSONResponse("Validation state and covering VRPs", o.ref(reflect.TypeOf(ValidateResult{}))),
+					"400": map[string]interface{}{"description": "Malformed prefix or ASN"},
+					"503": unavailable,
+				},
+			},
+		},
+		topologyPath: map[string]interface{}{
+			"get": map[string]interface{}{
+				"summary": "Hierarchy of the valid CAs and their publication points",
+				"parameters": []interface{}{
+					openAPIQueryParameter("format", "Output format: json (default) or dot", false),
+				},
+				"responses": map[string]interface{}{
+					"200": openAPIJSONResponse("Topology graph", o.ref(reflect.TypeOf(Topology{}))),
+					"400": map[string]interface{}{"description": "Unsupported format"},
+					"503": unavailable,
+				},
+			},
+		},
+		publicKeyPath: openAPIGet("Public keys verifying the output signature, the previous key first during a rotation", map[string]interface{}{
+			"200": map[string]interface{}{
+				"description": "PEM encoded public keys",
+				"content": map[string]interface{}{
+					"application/x-pem-file": map[string]interface{}{
+						"schema": map[string]interface{}{"type": "string"},
+					},
+				},
+			},
+			"404": map[string]interface{}{"description": "Output is not signed"},
+		}),
+		objectPath: map[string]interface{}{
+			"get": map[string]interface{}{
+				"summary": "Validated object from the cache (requires a bearer token with -http.object.token)",
+				"parameters": []interface{}{
+					openAPIQueryParameter("uri", "rsync URI of the object", true),
+					openAPIQueryParameter("format", "Output format: json (default) or raw", false),
+				},
+				"responses": map[string]interface{}{
+					"200": openAPIJSONResponse("Object and its parsed summary", o.ref(reflect.TypeOf(ObjectResult{}))),
+					"400": map[string]interface{}{"description": "Not an rsync URI, illegal path or unsupported format"},
+					"401": map[string]interface{}{"description": "Missing or invalid bearer token"},
+					"404": map[string]interface{}{"description": "Object not validated or not in the cache"},
+				},
+			},
+		},
+		diffPath: openAPIGet("Last changes of the VRPs between stable validations, with the ROAs causing them", map[string]interface{}{
+			"200": openAPIJSONResponse("VRP changes", o.ref(reflect.TypeOf(VRPDiff{}))),
+			"503": map[string]interface{}{"description": "No change of the VRPs between stable validations yet"},
+		}),
+		healthPath: openAPIGet("Health check", map[string]interface{}{
+			"200": map[string]interface{}{"description": "Healthy"},
+			"503": unavailable,
+		}),
+		metricsPath: openAPIGet("Prometheus metrics", map[string]interface{}{
+			"200": map[string]interface{}{
+				"description": "Metrics in Prometheus text format",
+				"content": map[string]interface{}{
+					"text/plain": map[string]interface{}{
+						"schema": map[string]interface{}{"type": "string"},
+					},
+				},
+			},
+		}),
+	}
+
+	if reloadPath != "" {
+		paths[reloadPath] = map[string]interface{}{
+			"post": map[string]interface{}{
+				"summary": "Reload the TAL files, applied on the next validation (requires a bearer token with -http.reload.token)",
+				"responses": map[string]interface{}{
+					"200": openAPIJSONResponse("Reloaded TALs", o.ref(reflect.TypeOf(ReloadResult{}))),
+					"401": map[string]interface{}{"description": "Missing or invalid bearer token"},
+					"500": map[string]interface{}{"description": "No valid TAL or invalid refresh intervals"},
+				},
+			},
+		}
+	}
+	if holdingsPath != "" {
+		paths[holdingsPath] = openAPIGet("Resources held by each valid CA certificate", map[string]interface{}{
+			"200": openAPIJSONResponse("CA holdings", o.ref(reflect.TypeOf(HoldingsResult{}))),
+			"503": unavailable,
+		})
+	}
+
+	return map[string]interface{}{
+		"openapi": OpenAPIVersion,
+		"info": map[string]interface{}{
+			"title":   "OctoRPKI",
+			"version": AppVersion,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": o.components,
+		},
+	}
+}
+
+func ServeOpenAPI(document map[string]interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.Encode(document)
+	}
+}
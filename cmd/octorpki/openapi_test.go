@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func collectRefs(v interface{}, refs *[]string) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, e := range t {
+			if ref, ok := e.(string); ok && k == "$ref" {
+				*refs = append(*refs, ref)
+				continue
+			}
+			collectRefs(e, refs)
+		}
+	case []interface{}:
+		for _, e := range t {
+			collectRefs(e, refs)
+		}
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/openapi.json", nil)
+	ServeOpenAPI(GenerateOpenAPI("/output.json", "/infos", "/health", "/metrics", "/validate", "/reload", "/topology", "/publickey", "/object", "/holdings", "/diff"))(rec, req)
+
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var document map[string]interface{}
+	err := json.Unmarshal(rec.Body.Bytes(), &document)
+	assert.Nil(t, err)
+
+	assert.Equal(t, OpenAPIVersion, document["openapi"])
+	info, ok := document["info"].(map[string]interface{})
+	assert.True(t, ok)
+	assert.NotEmpty(t, info["title"])
+	assert.NotEmpty(t, info["version"])
+
+	paths, ok := document["paths"].(map[string]interface{})
+	assert.True(t, ok)
+	for _, path := range []string{"/output.json", "/resources.json", "/infos", "/health", "/metrics", "/validate", "/topology", "/publickey", "/object", "/holdings", "/diff"} {
+		item, ok := paths[path].(map[string]interface{})
+		assert.True(t, ok, path)
+		get, ok := item["get"].(map[string]interface{})
+		assert.True(t, ok, path)
+		assert.NotEmpty(t, get["responses"], path)
+	}
+	reload, ok := paths["/reload"].(map[string]interface{})
+	assert.True(t, ok)
+	assert.Contains(t, reload, "post")
+
+	components := document["components"].(map[string]interface{})
+	schemas := components["schemas"].(map[string]interface{})
+	for _, name := range []string{"ROAList", "ROAJson", "InfoResult", "ROAsTAL", "ResourcesJSON", "ReloadResult", "Topology", "ObjectResult", "HoldingsResult", "CAHoldings", "VRPDiff", "VRPChange", "VRPDiffObject"} {
+		assert.Contains(t, schemas, name)
+	}
+
+	roa := schemas["ROAJson"].(map[string]interface{})
+	assert.Equal(t, "object", roa["type"])
+	assert.Contains(t, roa["properties"], "prefix")
+	assert.Contains(t, roa["properties"], "maxLength")
+
+	var refs []string
+	collectRefs(document, &refs)
+	assert.NotEmpty(t, refs)
+	for _, ref := range refs {
+		assert.True(t, strings.HasPrefix(ref, "#/components/schemas/"), ref)
+		assert.Contains(t, schemas, strings.TrimPrefix(ref, "#/components/schemas/"))
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/cloudflare/gortr/prefixfile"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// Origins authorized for a prefix that appeared or disappeared since the
+// previous stable validation. Changes of the max length alone are ignored.
+type OriginChange struct {
+	Prefix  string   `json:"prefix"`
+	Added   []uint32 `json:"added"`
+	Removed []uint32 `json:"removed"`
+}
+
+// Maps each prefix to its authorized origins.
+func originSets(roas []prefixfile.ROAJson) map[string]map[uint32]bool {
+	origins := make(map[string]map[uint32]bool)
+	for _, roa := range roas {
+		prefix := roa.Prefix
+		if ipnet, err := roa.GetPrefix2(); err == nil {
+			prefix = ipnet.String()
+		}
+		if origins[prefix] == nil {
+			origins[prefix] = make(map[uint32]bool)
+		}
+		origins[prefix][roa.GetASN()] = true
+	}
+	return origins
+}
+
+func missingOrigins(from map[uint32]bool, in map[uint32]bool) []uint32 {
+	missing := make([]uint32, 0)
+	for asn := range from {
+		if !in[asn] {
+			missing = append(missing, asn)
+		}
+	}
+	sort.Slice(missing, func(i, j int) bool {
+		return missing[i] < missing[j]
+	})
+	return missing
+}
+
+// Lists the prefixes whose origins changed, sorted by prefix.
+func compareOrigins(previous map[string]map[uint32]bool, current map[string]map[uint32]bool) []OriginChange {
+	prefixes := make(map[string]bool)
+	for prefix := range previous {
+		prefixes[prefix] = true
+	}
+	for prefix := range current {
+		prefixes[prefix] = true
+	}
+
+	changes := make([]OriginChange, 0)
+	for _, prefix := range sortedKeys(prefixes) {
+		added := missingOrigins(current[prefix], previous[prefix])
+		removed := missingOrigins(previous[prefix], current[prefix])
+		if len(added) > 0 || len(removed) > 0 {
+			changes = append(changes, OriginChange{
+				Prefix:  prefix,
+				Added:   added,
+				Removed: removed,
+			})
+		}
+	}
+	return changes
+}
+
+// Compares the origins of the ROA list with the previous call, meant to be
+// called on stable validations. The first call only records the origins.
+func (s *OctoRPKI) checkOriginChanges() []OriginChange {
+	current := originSets(s.getROAList().Data)
+	previous := s.previousOrigins
+	s.previousOrigins = current
+	if previous == nil {
+		return nil
+	}
+
+	changes := compareOrigins(previous, current)
+	var added, removed int
+	for _, change := range changes {
+		log.Warnf("Origins authorized for %s changed: added %v, removed %v", change.Prefix, change.Added, change.Removed)
+		added += len(change.Added)
+		removed += len(change.Removed)
+	}
+	MetricOriginChanges.With(prometheus.Labels{"type": "added"}).Add(float64(added))
+	MetricOriginChanges.With(prometheus.Labels{"type": "removed"}).Add(float64(removed))
+	MetricOriginChangedPrefixes.Set(float64(len(changes)))
+	return changes
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/cloudflare/gortr/prefixfile"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckOriginChanges(t *testing.T) {
+	s := NewOctoRPKI(nil, nil)
+	s.setROAList(&prefixfile.ROAList{
+		Data: []prefixfile.ROAJson{
+			{Prefix: "192.0.2.0/24", Length: 24, ASN: "AS64500"},
+			{Prefix: "198.51.100.0/24", Length: 24, ASN: "AS64501"},
+			{Prefix: "2001:db8::/32", Length: 48, ASN: "AS64502"},
+		},
+	})
+	assert.Nil(t, s.checkOriginChanges())
+
+	added := getCounterValue(t, MetricOriginChanges.With(prometheus.Labels{"type": "added"}))
+	removed := getCounterValue(t, MetricOriginChanges.With(prometheus.Labels{"type": "removed"}))
+
+	s.setROAList(&prefixfile.ROAList{
+		Data: []prefixfile.ROAJson{
+			// New origin
+			{Prefix: "192.0.2.0/24", Length: 24, ASN: "AS64500"},
+			{Prefix: "192.0.2.0/24", Length: 24, ASN: "AS64666"},
+			// Origin replaced
+			{Prefix: "198.51.100.0/24", Length: 24, ASN: "AS64510"},
+			// Only the max length changed
+			{Prefix: "2001:db8::/32", Length: 32, ASN: "AS64502"},
+		},
+	})
+	assert.Equal(t, []OriginChange{
+		{Prefix: "192.0.2.0/24", Added: []uint32{64666}, Removed: []uint32{}},
+		{Prefix: "198.51.100.0/24", Added: []uint32{64510}, Removed: []uint32{64501}},
+	}, s.checkOriginChanges())
+	assert.Equal(t, added+2, getCounterValue(t, MetricOriginChanges.With(prometheus.Labels{"type": "added"})))
+	assert.Equal(t, removed+1, getCounterValue(t, MetricOriginChanges.With(prometheus.Labels{"type": "removed"})))
+	assert.Equal(t, float64(2), getGaugeValue(t, MetricOriginChangedPrefixes))
+
+	assert.Equal(t, []OriginChange{}, s.checkOriginChanges())
+	assert.Equal(t, float64(0), getGaugeValue(t, MetricOriginChangedPrefixes))
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/cloudflare/gortr/prefixfile"
+	"github.com/opentracing/opentracing-go"
+)
+
+// Splits the comma separated list of output targets. A target is either a
+// file path, an http(s) URL receiving the ROA list with a PUT request (for
+// instance a pre-signed object storage URL) or an s3://bucket/key object.
+func parseOutputTargets(value string) ([]string, error) {
+	targets := make([]string, 0)
+	for _, target := range strings.Split(value, ",") {
+		target = strings.TrimSpace(target)
+		if target == "" {
+			continue
+		}
+
+		if strings.Contains(target, "://") {
+			u, err := url.Parse(target)
+			if err != nil {
+				return nil, fmt.Errorf("invalid output %q: %v", target, err)
+			}
+			switch u.Scheme {
+			case "http", "https":
+			case "s3":
+				if u.Host == "" || strings.TrimPrefix(u.Path, "/") == "" {
+					return nil, fmt.Errorf("output %q: expected s3://bucket/key", target)
+				}
+			default:
+				return nil, fmt.Errorf("output %q: scheme %v is not supported, use a file path, an http(s) URL or s3://bucket/key", target, u.Scheme)
+			}
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// The first file of the output targets, which is the path serving the ROA
+// list. URLs are skipped as they cannot be served.
+func firstFileTarget(targets []string) (string, bool) {
+	for _, target := range targets {
+		if !strings.Contains(target, "://") {
+			return target, true
+		}
+	}
+	return "", false
+}
+
+// A written ROA list is compressed when -output.gzip is set or the target ends in .gz.
+func isGzipOutput(target string) bool {
+	if u, err := url.Parse(target); err == nil && u.Scheme != "" {
+		target = u.Path
+	}
+	return *OutputGzip || strings.HasSuffix(target, ".gz")
+}
+
+// Whether gzip is an accepted content coding of an Accept-Encoding header.
+func acceptsGzip(acceptEncoding string) bool {
+	for _, coding := range parseAccept(acceptEncoding) {
+		if coding.mediaType == "gzip" {
+			return true
+		}
+	}
+	return false
+}
+
+func gzipData(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeOutputTarget(client *http.Client, target string, data []byte) error {
+	contentType := outputContentType(*OutputFormat)
+	if isGzipOutput(target) {
+		var err error
+		data, err = gzipData(data)
+		if err != nil {
+			return err
+		}
+		contentType = "application/gzip"
+	}
+
+	if strings.HasPrefix(target, "s3://") {
+		return writeS3Output(client, target, data, contentType)
+	}
+	if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+		return ioutil.WriteFile(target, data, 0600)
+	}
+
+	req, err := http.NewRequest("PUT", target, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", requestUserAgent("output"))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %v", resp.Status)
+	}
+	return nil
+}
+
+// Writes data to every target concurrently, so a slow upload does not delay
+// the files. A failing target does not prevent writing the others, the
+// errors are returned together. Also returns the written targets.
+func writeOutputTargets(client *http.Client, targets []string, data []byte) ([]string, error) {
+	errs := make([]error, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target string) {
+			defer wg.Done()
+			errs[i] = writeOutputTarget(client, target, data)
+		}(i, target)
+	}
+	wg.Wait()
+
+	written := make([]string, 0, len(targets))
+	failed := make([]string, 0)
+	for i, target := range targets {
+		if errs[i] != nil {
+			failed = append(failed, fmt.Sprintf("unable to write ROA list to %q: %v", outputTargetName(target), errs[i]))
+		} else {
+			written = append(written, target)
+		}
+	}
+
+	if len(failed) > 0 {
+		return written, fmt.Errorf("%s", strings.Join(failed, "; "))
+	}
+	return written, nil
+}
+
+// Target without the credentials a URL can carry (user information,
+// signature of a pre-signed URL), for the logs and the webhook.
+func outputTargetName(target string) string {
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme == "" || (u.User == nil && u.RawQuery == "") {
+		return target
+	}
+	u.User = nil
+	u.RawQuery = ""
+	return u.String()
+}
+
+// Body of the request notifying -output.webhook of a new ROA list.
+type OutputNotification struct {
+	Targets   []string `json:"targets"` // written targets
+	Failed    []string `json:"failed"`
+	Counts    int      `json:"counts"`
+	Generated int      `json:"generated"`
+	Valid     int      `json:"valid,omitempty"`
+}
+
+func notifyOutputWebhook(client *http.Client, webhook string, notification OutputNotification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", requestUserAgent("output"))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %v", resp.Status)
+	}
+	return nil
+}
+
+// Name of the output file of a TAL: "RIPE" and "tals/ripe.tal" give ripe.json.
+func talOutputName(talName string) string {
+	name := strings.TrimSuffix(filepath.Base(talName), ".tal")
+	return strings.ToLower(name) + ".json"
+}
+
+// Writes the VRPs of every TAL to its own file in dir. Each list keeps the
+// generation and validity times of the complete list and is signed separately.
+func (s *OctoRPKI) outputPerTAL(dir string, span opentracing.Span) error {
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	snapshot := s.getSnapshot()
+	roaList := snapshot.ROAList
+	for _, talname := range snapshot.TALNames {
+		talList := &prefixfile.ROAList{
+			Metadata: prefixfile.MetaData{
+				Generated: roaList.Metadata.Generated,
+				Valid:     roaList.Metadata.Valid,
+			},
+			Data: make([]prefixfile.ROAJson, 0),
+		}
+		for _, roa := range roaList.Data {
+			if roa.TA == talname {
+				talList.Data = append(talList.Data, roa)
+			}
+		}
+		talList.Metadata.Counts = len(talList.Data)
+		if *Sign {
+			s.signROAList(talList, span)
+		}
+
+		output, err := formatROAList(talList, *OutputFormat, nil)
+		if err != nil {
+			return err
+		}
+		fc, err := marshalROAList(output)
+		if err != nil {
+			return fmt.Errorf("unable to marshal ROA list of %v: %v", talname, err)
+		}
+
+		target := filepath.Join(dir, talOutputName(talname))
+		err = writeOutputTarget(nil, target, fc)
+		if err != nil {
+			return fmt.Errorf("unable to write ROA list of %v to %q: %v", talname, target, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+	"github.com/cloudflare/gortr/prefixfile"
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseOutputTargets(t *testing.T) {
+	targets, err := parseOutputTargets("output.json, /var/lib/octorpki/output.json,,https://rpki.example.com/upload")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"output.json", "/var/lib/octorpki/output.json", "https://rpki.example.com/upload"}, targets)
+
+	targets, err = parseOutputTargets("")
+	assert.Nil(t, err)
+	assert.Len(t, targets, 0)
+
+	targets, err = parseOutputTargets("output.json,s3://bucket/roas/output.json")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"output.json", "s3://bucket/roas/output.json"}, targets)
+
+	_, err = parseOutputTargets("output.json,s3://bucket")
+	assert.NotNil(t, err)
+	_, err = parseOutputTargets("output.json,ftp://rpki.example.com/output.json")
+	assert.NotNil(t, err)
+}
+
+func TestFirstFileTarget(t *testing.T) {
+	// An upload given first is not the serving path
+	path, ok := firstFileTarget([]string{"https://rpki.example.com/upload", "s3://bucket/output.json", "/var/lib/octorpki/output.json", "output.json"})
+	assert.True(t, ok)
+	assert.Equal(t, "/var/lib/octorpki/output.json", path)
+
+	_, ok = firstFileTarget([]string{"https://rpki.example.com/upload"})
+	assert.False(t, ok)
+	_, ok = firstFileTarget(nil)
+	assert.False(t, ok)
+}
+
+func TestOutputMultipleTargets(t *testing.T) {
+	defer func(v string) { *Output = v }(*Output)
+	defer func(v bool) { *Sign = v }(*Sign)
+	*Sign = false
+
+	var uploaded string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "PUT", r.Method)
+		body, err := io.ReadAll(r.Body)
+		assert.Nil(t, err)
+		uploaded = string(body)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.json")
+	second := filepath.Join(dir, "second.json")
+	missing := filepath.Join(dir, "missing", "output.json")
+	// The failing target is listed before the others
+	*Output = strings.Join([]string{missing, first, second, server.URL}, ",")
+
+	s := NewOctoRPKI(nil, nil)
+	s.setROAList(&prefixfile.ROAList{
+		Data: []prefixfile.ROAJson{
+			{Prefix: "192.0.2.0/24", Length: 24, ASN: "AS64500", TA: "A"},
+		},
+	})
+
+	err := s.output()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), missing)
+
+	firstData, err := os.ReadFile(first)
+	assert.Nil(t, err)
+	secondData, err := os.ReadFile(second)
+	assert.Nil(t, err)
+	assert.Contains(t, string(firstData), "192.0.2.0/24")
+	assert.Equal(t, firstData, secondData)
+	assert.Equal(t, string(firstData), uploaded)
+
+	*Output = strings.Join([]string{first, second}, ",")
+	assert.Nil(t, s.output())
+}
+
+func TestOutputWebhook(t *testing.T) {
+	defer func(v string) { *Output = v }(*Output)
+	defer func(v string) { *OutputWebhook = v }(*OutputWebhook)
+	defer func(v bool) { *Sign = v }(*Sign)
+	*Sign = false
+
+	var notifications []OutputNotification
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		var notification OutputNotification
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&notification))
+		notifications = append(notifications, notification)
+	}))
+	defer webhook.Close()
+	upload := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upload.Close()
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "output.json")
+	missing := filepath.Join(dir, "missing", "output.json")
+	*Output = strings.Join([]string{file, missing, upload.URL + "/output.json?X-Amz-Signature=secret"}, ",")
+	*OutputWebhook = webhook.URL
+
+	s := NewOctoRPKI(nil, nil)
+	s.setROAList(&prefixfile.ROAList{
+		Metadata: prefixfile.MetaData{Counts: 1, Generated: 1626853335, Valid: 1626856935},
+		Data: []prefixfile.ROAJson{
+			{Prefix: "192.0.2.0/24", Length: 24, ASN: "AS64500", TA: "A"},
+		},
+	})
+
+	// Notified even when a target fails, without the signature of the URL
+	assert.NotNil(t, s.output())
+	assert.Equal(t, []OutputNotification{{
+		Targets:   []string{file, upload.URL + "/output.json"},
+		Failed:    []string{missing},
+		Counts:    1,
+		Generated: 1626853335,
+		Valid:     1626856935,
+	}}, notifications)
+
+	webhook.Close()
+	*Output = file
+	err := s.output()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "unable to notify")
+	_, err = os.Stat(file)
+	assert.Nil(t, err)
+}
+
+func gunzip(t *testing.T, data []byte) []byte {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	assert.Nil(t, err)
+	decompressed, err := io.ReadAll(gz)
+	assert.Nil(t, err)
+	return decompressed
+}
+
+func TestOutputGzip(t *testing.T) {
+	defer func(v string) { *Output = v }(*Output)
+	defer func(v bool) { *OutputGzip = v }(*OutputGzip)
+	defer func(v bool) { *Sign = v }(*Sign)
+	*Sign = false
+
+	s := NewOctoRPKI(nil, nil)
+	s.Stable.Store(true)
+	s.setROAList(&prefixfile.ROAList{
+		Data: []prefixfile.ROAJson{
+			{Prefix: "192.0.2.0/24", Length: 24, ASN: "AS64500", TA: "A"},
+		},
+	})
+	expected, err := json.Marshal(s.getROAList())
+	assert.Nil(t, err)
+
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "output.json")
+	compressed := filepath.Join(dir, "output.json.gz")
+	*Output = strings.Join([]string{plain, compressed}, ",")
+	assert.Nil(t, s.output())
+
+	plainData, err := os.ReadFile(plain)
+	assert.Nil(t, err)
+	assert.JSONEq(t, string(expected), string(plainData))
+	compressedData, err := os.ReadFile(compressed)
+	assert.Nil(t, err)
+	assert.JSONEq(t, string(expected), string(gunzip(t, compressedData)))
+
+	// Every output is compressed with the flag
+	*OutputGzip = true
+	assert.Nil(t, s.output())
+	plainData, err = os.ReadFile(plain)
+	assert.Nil(t, err)
+	assert.JSONEq(t, string(expected), string(gunzip(t, plainData)))
+
+	// The flag only applies to the written outputs
+	rec := httptest.NewRecorder()
+	s.ServeROAs(rec, httptest.NewRequest("GET", "/output.json", nil))
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Empty(t, rec.Header().Get("Content-Encoding"))
+	assert.JSONEq(t, string(expected), rec.Body.String())
+	plainETag := rec.Header().Get("Etag")
+
+	*OutputGzip = false
+	rec = httptest.NewRecorder()
+	s.ServeROAs(rec, httptest.NewRequest("GET", "/output.json.gz", nil))
+	assert.Equal(t, "application/gzip", rec.Header().Get("Content-Type"))
+	assert.JSONEq(t, string(expected), string(gunzip(t, rec.Body.Bytes())))
+
+	req := httptest.NewRequest("GET", "/output.json", nil)
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	rec = httptest.NewRecorder()
+	s.ServeROAs(rec, req)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+	assert.Contains(t, rec.Header().Get("Vary"), "Accept-Encoding")
+	assert.NotEqual(t, plainETag, rec.Header().Get("Etag"))
+	assert.JSONEq(t, string(expected), string(gunzip(t, rec.Body.Bytes())))
+
+	req = httptest.NewRequest("GET", "/output.json", nil)
+	req.Header.Set("Accept-Encoding", "gzip;q=0")
+	rec = httptest.NewRecorder()
+	s.ServeROAs(rec, req)
+	assert.Empty(t, rec.Header().Get("Content-Encoding"))
+	assert.JSONEq(t, string(expected), rec.Body.String())
+}
+
+func TestOutputPerTAL(t *testing.T) {
+	defer func(v bool) { *Sign = v }(*Sign)
+	*Sign = true
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	s := NewOctoRPKI([]*pki.PKIFile{{Path: "tals/ripe.tal"}, {Path: "tals/apnic.tal"}, {Path: "tals/arin.tal"}}, []string{"RIPE", "APNIC", "ARIN"})
+	s.Key = key
+	s.updateSnapshot(func(snapshot *validationSnapshot) {
+		snapshot.TALNames = s.talNameList()
+		snapshot.ROAList = &prefixfile.ROAList{
+			Metadata: prefixfile.MetaData{Counts: 3, Generated: 1626853335, Valid: 1626856935},
+			Data: []prefixfile.ROAJson{
+				{Prefix: "192.0.2.0/24", Length: 24, ASN: "AS64500", TA: "RIPE"},
+				{Prefix: "198.51.100.0/24", Length: 24, ASN: "AS64501", TA: "APNIC"},
+				{Prefix: "203.0.113.0/24", Length: 24, ASN: "AS64502", TA: "RIPE"},
+			},
+		}
+	})
+
+	dir := filepath.Join(t.TempDir(), "tals")
+	assert.Nil(t, s.outputPerTAL(dir, opentracing.NoopTracer{}.StartSpan("test")))
+
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	names := make([]string, 0)
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.Equal(t, []string{"apnic.json", "arin.json", "ripe.json"}, names)
+
+	expected := map[string]int{"ripe.json": 2, "apnic.json": 1, "arin.json": 0}
+	for name, count := range expected {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		assert.Nil(t, err)
+
+		var roaList prefixfile.ROAList
+		assert.Nil(t, json.Unmarshal(data, &roaList))
+		assert.Len(t, roaList.Data, count, name)
+		assert.Equal(t, count, roaList.Metadata.Counts, name)
+		assert.Equal(t, 1626853335, roaList.Metadata.Generated, name)
+
+		valid1, valid2, err := roaList.CheckFile(&key.PublicKey)
+		assert.Nil(t, err, name)
+		assert.True(t, valid1 && valid2, name)
+	}
+
+	assert.Equal(t, "ripe.json", talOutputName("tals/ripe.tal"))
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// Phases (rrdp, tal, rsync and validation) of an iteration. A phase exceeding
+// its timeout is cancelled through its context and the iteration is unstable.
+type iterationPhases struct {
+	span     opentracing.Span
+	timedOut bool // a phase exceeded its timeout
+}
+
+func newIterationPhases(span opentracing.Span) *iterationPhases {
+	return &iterationPhases{
+		span: span,
+	}
+}
+
+// Runs a phase with a context cancelled once timeout expires (0 for no limit).
+func (p *iterationPhases) run(phase string, timeout time.Duration, run func(ctx context.Context)) {
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	defer cancel()
+
+	run(ctx)
+
+	var value float64
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		log.Warnf("The %s phase exceeded its timeout of %v (-timeout.%s), the iteration is unstable", phase, timeout, phase)
+		p.span.SetTag("timeout", phase)
+		p.timedOut = true
+		value = 1
+	}
+	MetricPhaseTimeout.With(prometheus.Labels{"phase": phase}).Set(value)
+}
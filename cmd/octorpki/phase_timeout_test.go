@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+	"github.com/opentracing/opentracing-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+
+	syncpki "github.com/cloudflare/cfrpki/sync/lib"
+)
+
+func TestValidationPhaseTimeout(t *testing.T) {
+	defer func(v bool) { *Sign = v }(*Sign)
+	*Sign = false
+
+	basepath := t.TempDir()
+	tals := []*pki.PKIFile{
+		createTestRepository(t, basepath, "rpki.example.com", []testROA{
+			{ASN: 65001, Prefix: "192.0.2.0/24", MaxLength: 24},
+		}),
+	}
+
+	s := NewOctoRPKI(tals, []string{"Example"})
+	s.Fetcher = syncpki.NewLocalFetch(basepath)
+	span := opentracing.NoopTracer{}.StartSpan("test")
+
+	phases := newIterationPhases(span)
+	phases.run("validation", time.Minute, func(ctx context.Context) {
+		s.mainValidation(ctx, span)
+	})
+	assert.False(t, phases.timedOut)
+	assert.Equal(t, float64(0), getGaugeValue(t, MetricPhaseTimeout.With(prometheus.Labels{"phase": "validation"})))
+	previous := s.getSnapshot()
+	assert.Len(t, previous.ROAList.Data, 1)
+
+	// The validation starts after the timeout: it is interrupted and the
+	// results of the previous validation are kept
+	phases = newIterationPhases(span)
+	var ctData [][]*pki.PKIFile
+	phases.run("validation", time.Millisecond, func(ctx context.Context) {
+		<-ctx.Done()
+		ctData = s.mainValidation(ctx, span)
+	})
+	assert.True(t, phases.timedOut)
+	assert.Nil(t, ctData)
+	assert.Equal(t, float64(1), getGaugeValue(t, MetricPhaseTimeout.With(prometheus.Labels{"phase": "validation"})))
+	assert.Same(t, previous, s.getSnapshot())
+
+	// Reset by the next iteration completing in time
+	phases = newIterationPhases(span)
+	phases.run("validation", 0, func(ctx context.Context) {
+		s.mainValidation(ctx, span)
+	})
+	assert.False(t, phases.timedOut)
+	assert.Equal(t, float64(0), getGaugeValue(t, MetricPhaseTimeout.With(prometheus.Labels{"phase": "validation"})))
+	assert.NotSame(t, previous, s.getSnapshot())
+	assert.Len(t, s.getROAList().Data, 1)
+}
+
+func TestExploreContext(t *testing.T) {
+	basepath := t.TempDir()
+	tal := createTestRepository(t, basepath, "rpki.example.com", []testROA{
+		{ASN: 65001, Prefix: "192.0.2.0/24", MaxLength: 24},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	sm := pki.NewSimpleManager()
+	sm.Validator = pki.NewValidator()
+	sm.FileSeeker = syncpki.NewLocalFetch(basepath)
+	sm.Context = ctx
+	sm.AddInitial([]*pki.PKIFile{tal})
+	assert.Equal(t, 0, sm.Explore(false, false))
+	assert.Len(t, sm.Validator.ValidROA, 0)
+	sm.Close()
+}
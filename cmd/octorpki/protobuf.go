@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/cloudflare/gortr/prefixfile"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const OutputFormatProtobuf = "protobuf"
+
+// ROA list encoded as the VRPList message of vrps.proto.
+type ProtobufROAList struct {
+	ROAList  *prefixfile.ROAList
+	TAFilter []string
+}
+
+func appendProtobufString(b []byte, num protowire.Number, value string) []byte {
+	if value == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, value)
+}
+
+func appendProtobufVarint(b []byte, num protowire.Number, value uint64) []byte {
+	if value == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, value)
+}
+
+func appendProtobufMessage(b []byte, num protowire.Number, message []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, message)
+}
+
+func encodeProtobufVRP(roa prefixfile.ROAJson) ([]byte, error) {
+	_, prefix, err := net.ParseCIDR(roa.Prefix)
+	if err != nil {
+		return nil, fmt.Errorf("invalid prefix %q: %v", roa.Prefix, err)
+	}
+	asn, err := roa.GetASN2()
+	if err != nil {
+		return nil, err
+	}
+
+	address := prefix.IP
+	if ip4 := address.To4(); ip4 != nil {
+		address = ip4
+	}
+	length, _ := prefix.Mask.Size()
+
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendBytes(b, address)
+	b = appendProtobufVarint(b, 2, uint64(length))
+	b = appendProtobufVarint(b, 3, uint64(roa.Length))
+	b = appendProtobufVarint(b, 4, uint64(asn))
+	b = appendProtobufString(b, 5, roa.TA)
+	return b, nil
+}
+
+// Encodes a Metadata message. The signature does not cover a list filtered by
+// TA, which is then marked as unsigned.
+func encodeProtobufMetadata(metadata prefixfile.MetaData, taFilter []string) []byte {
+	var m []byte
+	m = appendProtobufVarint(m, 1, uint64(metadata.Generated))
+	m = appendProtobufVarint(m, 2, uint64(metadata.Valid))
+	m = appendProtobufVarint(m, 3, uint64(metadata.Counts))
+	if len(taFilter) > 0 {
+		m = appendProtobufVarint(m, 7, 1)
+		for _, ta := range taFilter {
+			m = protowire.AppendTag(m, 8, protowire.BytesType)
+			m = protowire.AppendString(m, ta)
+		}
+	} else {
+		m = appendProtobufString(m, 4, metadata.Signature)
+		m = appendProtobufString(m, 5, metadata.SignatureDate)
+		m = appendProtobufVarint(m, 6, uint64(metadata.Serial))
+	}
+	return m
+}
+
+func (l *ProtobufROAList) Marshal() ([]byte, error) {
+	b := appendProtobufMessage(nil, 1, encodeProtobufMetadata(l.ROAList.Metadata, l.TAFilter))
+	for _, roa := range l.ROAList.Data {
+		vrp, err := encodeProtobufVRP(roa)
+		if err != nil {
+			return nil, err
+		}
+		b = appendProtobufMessage(b, 2, vrp)
+	}
+	return b, nil
+}
+
+// Calls fn with each field of a message.
+func consumeProtobufFields(b []byte, fn func(num protowire.Number, typ protowire.Type, value []byte, varint uint64) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		var value []byte
+		var varint uint64
+		switch typ {
+		case protowire.VarintType:
+			varint, n = protowire.ConsumeVarint(b)
+		case protowire.BytesType:
+			value, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		err := fn(num, typ, value, varint)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeProtobufVRP(b []byte) (prefixfile.ROAJson, error) {
+	var roa prefixfile.ROAJson
+	var address []byte
+	var length int
+	var asn uint32
+	err := consumeProtobufFields(b, func(num protowire.Number, typ protowire.Type, value []byte, varint uint64) error {
+		switch num {
+		case 1:
+			address = value
+		case 2:
+			length = int(varint)
+		case 3:
+			roa.Length = uint8(varint)
+		case 4:
+			asn = uint32(varint)
+		case 5:
+			roa.TA = string(value)
+		}
+		return nil
+	})
+	if err != nil {
+		return roa, err
+	}
+
+	if len(address) != net.IPv4len && len(address) != net.IPv6len {
+		return roa, fmt.Errorf("invalid address length %d", len(address))
+	}
+	prefix := net.IPNet{
+		IP:   net.IP(address),
+		Mask: net.CIDRMask(length, len(address)*8),
+	}
+	roa.Prefix = prefix.String()
+	roa.SetASN(asn)
+	return roa, nil
+}
+
+// Decodes a Metadata message, returning its TA filter.
+func decodeProtobufMetadata(b []byte, metadata *prefixfile.MetaData) ([]string, error) {
+	var taFilter []string
+	err := consumeProtobufFields(b, func(num protowire.Number, typ protowire.Type, value []byte, varint uint64) error {
+		switch num {
+		case 1:
+			metadata.Generated = int(varint)
+		case 2:
+			metadata.Valid = int(varint)
+		case 3:
+			metadata.Counts = int(varint)
+		case 4:
+			metadata.Signature = string(value)
+		case 5:
+			metadata.SignatureDate = string(value)
+		case 6:
+			metadata.Serial = int(varint)
+		case 8:
+			taFilter = append(taFilter, string(value))
+		}
+		return nil
+	})
+	return taFilter, err
+}
+
+// Decodes a VRPList message.
+func UnmarshalProtobufROAList(b []byte) (*ProtobufROAList, error) {
+	l := &ProtobufROAList{
+		ROAList: &prefixfile.ROAList{
+			Data: make([]prefixfile.ROAJson, 0),
+		},
+	}
+
+	err := consumeProtobufFields(b, func(num protowire.Number, typ protowire.Type, value []byte, varint uint64) error {
+		switch num {
+		case 1:
+			var err error
+			l.TAFilter, err = decodeProtobufMetadata(value, &l.ROAList.Metadata)
+			return err
+		case 2:
+			roa, err := decodeProtobufVRP(value)
+			if err != nil {
+				return err
+			}
+			l.ROAList.Data = append(l.ROAList.Data, roa)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudflare/gortr/prefixfile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProtobufRoundTrip(t *testing.T) {
+	roaList := &prefixfile.ROAList{
+		Metadata: prefixfile.MetaData{
+			Counts:        3,
+			Generated:     1626853335,
+			Valid:         1626856935,
+			Signature:     "signature",
+			SignatureDate: "signaturedate",
+			Serial:        42,
+		},
+		Data: []prefixfile.ROAJson{
+			{Prefix: "1.0.0.0/24", Length: 24, ASN: "AS13335", TA: "APNIC"},
+			{Prefix: "2606:4700::/32", Length: 48, ASN: "AS13335", TA: "ARIN"},
+			{Prefix: "192.0.2.0/24", Length: 24, ASN: "AS0", TA: "RIPE"},
+		},
+	}
+
+	output, err := formatROAList(roaList, OutputFormatProtobuf, nil)
+	assert.Nil(t, err)
+	data, err := marshalROAList(output)
+	assert.Nil(t, err)
+
+	decoded, err := UnmarshalProtobufROAList(data)
+	assert.Nil(t, err)
+	assert.Equal(t, roaList, decoded.ROAList)
+	assert.Empty(t, decoded.TAFilter)
+
+	// A filtered list is not signed
+	output, err = formatROAList(roaList, OutputFormatProtobuf, []string{"ripe"})
+	assert.Nil(t, err)
+	data, err = marshalROAList(output)
+	assert.Nil(t, err)
+	decoded, err = UnmarshalProtobufROAList(data)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ripe"}, decoded.TAFilter)
+	assert.Equal(t, prefixfile.MetaData{Counts: 1, Generated: 1626853335, Valid: 1626856935}, decoded.ROAList.Metadata)
+	assert.Equal(t, roaList.Data[2:], decoded.ROAList.Data)
+
+	_, err = UnmarshalProtobufROAList([]byte{0x12, 0x05})
+	assert.NotNil(t, err)
+}
+
+func TestServeROAsProtobuf(t *testing.T) {
+	defer func(v string) { *OutputFormat = v }(*OutputFormat)
+	*OutputFormat = OutputFormatProtobuf
+
+	roaList := &prefixfile.ROAList{
+		Metadata: prefixfile.MetaData{Counts: 1, Generated: 1626853335},
+		Data: []prefixfile.ROAJson{
+			{Prefix: "192.0.2.0/24", Length: 24, ASN: "AS64500", TA: "RIPE"},
+		},
+	}
+	s := NewOctoRPKI(nil, nil)
+	s.Stable.Store(true)
+	s.setROAList(roaList)
+
+	rec := httptest.NewRecorder()
+	s.ServeROAs(rec, httptest.NewRequest("GET", "/output.json", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/x-protobuf", rec.Header().Get("Content-Type"))
+
+	decoded, err := UnmarshalProtobufROAList(rec.Body.Bytes())
+	assert.Nil(t, err)
+	assert.Equal(t, roaList, decoded.ROAList)
+}
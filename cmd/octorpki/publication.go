@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	syncpki "github.com/cloudflare/cfrpki/sync/lib"
+	librpki "github.com/cloudflare/cfrpki/validator/lib"
+)
+
+const (
+	PublicationNamespace   = "http://www.hactrn.net/uris/rpki/publication-spec/"
+	PublicationContentType = "application/rpki-publication"
+
+	PublicationNotValidated = "not-validated" // published but not a valid object of the last validation
+	PublicationNotPublished = "not-published" // validated in a published directory but not listed
+	PublicationHashMismatch = "hash-mismatch" // the validated copy differs from the published object
+)
+
+// Object listed by the publication server (RFC 8181 section 2.3).
+type PublishedObject struct {
+	URI  string `xml:"uri,attr"`
+	Hash string `xml:"hash,attr"`
+}
+
+type publicationError struct {
+	Code string `xml:"error_code,attr"`
+	Text string `xml:"error_text"`
+}
+
+type publicationMessage struct {
+	XMLName xml.Name           `xml:"msg"`
+	Type    string             `xml:"type,attr"`
+	List    []PublishedObject  `xml:"list"`
+	Errors  []publicationError `xml:"report_error"`
+}
+
+type PublicationDiscrepancy struct {
+	Type string `json:"type"`
+	URI  string `json:"uri"`
+}
+
+// Read-only client of the publication protocol, only sending list queries.
+// The replies are not verified against the BPKI of the server: they are
+// only used for diagnostics.
+type publicationClient struct {
+	url    string
+	key    *rsa.PrivateKey
+	cert   *x509.Certificate
+	crl    []byte // DER, optional
+	client *http.Client
+}
+
+func readPEMOrDER(file string) ([]byte, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		return block.Bytes, nil
+	}
+	return data, nil
+}
+
+func parseRSAPrivateKey(der []byte) (*rsa.PrivateKey, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not RSA")
+	}
+	return rsaKey, nil
+}
+
+// Loads the BPKI certificate and key of the client, and its CRL when set.
+func loadPublicationClient(url string, certFile string, keyFile string, crlFile string) (*publicationClient, error) {
+	certDER, err := readPEMOrDER(certFile)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode %s: %v", certFile, err)
+	}
+
+	keyDER, err := readPEMOrDER(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parseRSAPrivateKey(keyDER)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode %s: %v", keyFile, err)
+	}
+
+	c := &publicationClient{
+		url:    url,
+		key:    key,
+		cert:   cert,
+		client: &http.Client{Timeout: time.Minute},
+	}
+	if crlFile != "" {
+		c.crl, err = readPEMOrDER(crlFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Signs a list query with the BPKI key of the client.
+func (c *publicationClient) listQuery() ([]byte, error) {
+	msg := []byte(`<msg xmlns="` + PublicationNamespace + `" version="4" type="query"><list/></msg>`)
+	content, err := librpki.EncodeXMLData(msg)
+	if err != nil {
+		return nil, err
+	}
+	cms, err := librpki.EncodeCMS(nil, content, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	if c.crl != nil {
+		err = cms.AddCRLs(c.crl)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	encap, err := librpki.EContentToEncapBF(content.EContent.FullBytes, true)
+	if err != nil {
+		return nil, err
+	}
+	err = cms.Sign(rand.Reader, c.cert.SubjectKeyId, encap, c.key, c.cert.Raw)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(*cms)
+}
+
+// Lists the objects of the client on the publication server.
+func (c *publicationClient) list(ctx context.Context) ([]PublishedObject, error) {
+	query, err := c.listQuery()
+	if err != nil {
+		return nil, fmt.Errorf("unable to sign the list query: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.url, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", PublicationContentType)
+	req.Header.Set("User-Agent", requestUserAgent("publication"))
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %v", resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := librpki.DecodeXML(data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode the reply: %v", err)
+	}
+	if !reply.InnerValid {
+		return nil, fmt.Errorf("invalid signature of the reply: %v", reply.InnerValidityError)
+	}
+
+	var msg publicationMessage
+	err = xml.Unmarshal(reply.Content, &msg)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode the reply: %v", err)
+	}
+	if len(msg.Errors) > 0 {
+		return nil, fmt.Errorf("publication server error %s: %s", msg.Errors[0].Code, strings.TrimSpace(msg.Errors[0].Text))
+	}
+	if msg.Type != "reply" {
+		return nil, fmt.Errorf("unexpected message type %q", msg.Type)
+	}
+	return msg.List, nil
+}
+
+// URIs of the valid objects of the validators.
+func validatedURIs(validators ...*pki.Validator) map[string]bool {
+	uris := make(map[string]bool)
+	for _, validator := range validators {
+		for _, objects := range []map[string]*pki.Resource{validator.ValidObjects, validator.ValidManifest, validator.ValidROA, validator.ValidCRL} {
+			for _, res := range objects {
+				if res.File != nil {
+					uris[res.File.ComputePath()] = true
+				}
+			}
+		}
+	}
+	return uris
+}
+
+// Compares the published objects with the validated ones. Only the
+// directories containing published objects are checked for validated objects
+// missing from the publication server. localHash returns the SHA-256 of the
+// validated copy of a file.
+func comparePublication(published []PublishedObject, validated map[string]bool, localHash func(uri string) (string, error)) []PublicationDiscrepancy {
+	discrepancies := make([]PublicationDiscrepancy, 0)
+
+	listed := make(map[string]bool, len(published))
+	directories := make(map[string]bool)
+	for _, object := range published {
+		listed[object.URI] = true
+		directories[path.Dir(object.URI)] = true
+
+		if !validated[object.URI] {
+			discrepancies = append(discrepancies, PublicationDiscrepancy{Type: PublicationNotValidated, URI: object.URI})
+			continue
+		}
+		hash, err := localHash(object.URI)
+		if err != nil || !strings.EqualFold(hash, object.Hash) {
+			discrepancies = append(discrepancies, PublicationDiscrepancy{Type: PublicationHashMismatch, URI: object.URI})
+		}
+	}
+
+	for uri := range validated {
+		if !listed[uri] && directories[path.Dir(uri)] {
+			discrepancies = append(discrepancies, PublicationDiscrepancy{Type: PublicationNotPublished, URI: uri})
+		}
+	}
+
+	sort.Slice(discrepancies, func(i, j int) bool {
+		if discrepancies[i].Type != discrepancies[j].Type {
+			return discrepancies[i].Type < discrepancies[j].Type
+		}
+		return discrepancies[i].URI < discrepancies[j].URI
+	})
+	return discrepancies
+}
+
+func (s *OctoRPKI) storageHash(uri string) (string, error) {
+	data, err := s.getStorage().Get(syncpki.StoragePath(uri))
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// Queries the publication server and reports the differences with the last
+// validation.
+func (s *OctoRPKI) checkPublication(ctx context.Context) ([]PublicationDiscrepancy, error) {
+	published, err := s.publication.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	discrepancies := comparePublication(published, s.publicationValidated, s.storageHash)
+	counts := map[string]int{
+		PublicationNotValidated: 0,
+		PublicationNotPublished: 0,
+		PublicationHashMismatch: 0,
+	}
+	for _, discrepancy := range discrepancies {
+		counts[discrepancy.Type]++
+		log.Warnf("Publication server: %s is %s", discrepancy.URI, discrepancy.Type)
+	}
+	for discrepancyType, count := range counts {
+		MetricPublicationDiscrepancies.With(prometheus.Labels{"type": discrepancyType}).Set(float64(count))
+	}
+	return discrepancies, nil
+}
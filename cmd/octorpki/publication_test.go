@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+	"github.com/opentracing/opentracing-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+
+	syncpki "github.com/cloudflare/cfrpki/sync/lib"
+	librpki "github.com/cloudflare/cfrpki/validator/lib"
+)
+
+func newBPKICertificate(t *testing.T, name string) (*rsa.PrivateKey, []byte) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	ski, err := librpki.HashRSAPublicKey(key.PublicKey)
+	assert.Nil(t, err)
+
+	cert := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		SubjectKeyId: ski,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, cert, cert, key.Public(), key)
+	assert.Nil(t, err)
+	return key, der
+}
+
+func signPublicationMessage(t *testing.T, key *rsa.PrivateKey, certDER []byte, msg string) []byte {
+	cert, err := x509.ParseCertificate(certDER)
+	assert.Nil(t, err)
+	content, err := librpki.EncodeXMLData([]byte(msg))
+	assert.Nil(t, err)
+	cms, err := librpki.EncodeCMS(nil, content, time.Now().UTC())
+	assert.Nil(t, err)
+	encap, err := librpki.EContentToEncapBF(content.EContent.FullBytes, true)
+	assert.Nil(t, err)
+	assert.Nil(t, cms.Sign(rand.Reader, cert.SubjectKeyId, encap, key, certDER))
+	data, err := asn1.Marshal(*cms)
+	assert.Nil(t, err)
+	return data
+}
+
+func TestPublicationDiscrepancies(t *testing.T) {
+	defer func(v bool) { *Sign = v }(*Sign)
+	*Sign = false
+
+	basepath := t.TempDir()
+	tals := []*pki.PKIFile{
+		createTestRepository(t, basepath, "rpki.example.com", []testROA{
+			{ASN: 65001, Prefix: "192.0.2.0/24", MaxLength: 24},
+		}),
+	}
+	hash := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(basepath, "rpki.example.com", "repo", name))
+		assert.Nil(t, err)
+		sum := sha256.Sum256(data)
+		return hex.EncodeToString(sum[:])
+	}
+
+	// The CRL is not published, the ROA differs and another ROA is unknown
+	reply := fmt.Sprintf(`<msg xmlns="%s" version="4" type="reply">
+<list uri="rsync://rpki.example.com/repo/root.cer" hash="%s"/>
+<list uri="rsync://rpki.example.com/repo/root.mft" hash="%s"/>
+<list uri="rsync://rpki.example.com/repo/0.roa" hash="%s"/>
+<list uri="rsync://rpki.example.com/repo/extra.roa" hash="00"/>
+</msg>`, PublicationNamespace, hash("root.cer"), strings.ToUpper(hash("root.mft")), hash("root.crl"))
+
+	serverKey, serverCert := newBPKICertificate(t, "server")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, PublicationContentType, r.Header.Get("Content-Type"))
+		body, _ := io.ReadAll(r.Body)
+		query, err := librpki.DecodeXML(body)
+		if err != nil || !query.InnerValid || !strings.Contains(string(query.Content), "<list/>") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", PublicationContentType)
+		w.Write(signPublicationMessage(t, serverKey, serverCert, reply))
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	clientKey, clientCert := newBPKICertificate(t, "client")
+	certFile := filepath.Join(dir, "client.cer")
+	keyFile := filepath.Join(dir, "client.key")
+	assert.Nil(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: clientCert}), 0600))
+	assert.Nil(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(clientKey)}), 0600))
+
+	publication, err := loadPublicationClient(ts.URL, certFile, keyFile, "")
+	assert.Nil(t, err)
+
+	s := NewOctoRPKI(tals, []string{"Publication"})
+	s.Fetcher = syncpki.NewLocalFetch(basepath)
+	s.storage = syncpki.NewFileStorage(basepath)
+	s.publication = publication
+	s.mainValidation(context.Background(), opentracing.NoopTracer{}.StartSpan("test"))
+
+	discrepancies, err := s.checkPublication(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, []PublicationDiscrepancy{
+		{Type: PublicationHashMismatch, URI: "rsync://rpki.example.com/repo/0.roa"},
+		{Type: PublicationNotPublished, URI: "rsync://rpki.example.com/repo/root.crl"},
+		{Type: PublicationNotValidated, URI: "rsync://rpki.example.com/repo/extra.roa"},
+	}, discrepancies)
+	assert.Equal(t, float64(1), getGaugeValue(t, MetricPublicationDiscrepancies.With(prometheus.Labels{"type": PublicationNotValidated})))
+
+	reply = fmt.Sprintf(`<msg xmlns="%s" version="4" type="reply"><report_error error_code="permission_failure"><error_text>Not allowed</error_text></report_error></msg>`, PublicationNamespace)
+	_, err = s.checkPublication(context.Background())
+	assert.EqualError(t, err, "publication server error permission_failure: Not allowed")
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"time"
+
+	librpki "github.com/cloudflare/cfrpki/validator/lib"
+)
+
+const (
+	RefreshModeFixed    = "fixed"
+	RefreshModeManifest = "manifest"
+)
+
+// Returns the soonest manifest nextUpdate after now.
+// The zero time is returned when no manifest has an upcoming nextUpdate.
+func nextManifestUpdate(manifests []*librpki.RPKIManifest, now time.Time) time.Time {
+	var next time.Time
+	for _, mft := range manifests {
+		if mft == nil {
+			continue
+		}
+		nextUpdate := mft.Content.NextUpdate
+		if !nextUpdate.After(now) {
+			continue
+		}
+		if next.IsZero() || nextUpdate.Before(next) {
+			next = nextUpdate
+		}
+	}
+	return next
+}
+
+// Computes the delay until the next validation based on the soonest
+// manifest nextUpdate, clamped between min and max.
+func manifestRefresh(next time.Time, now time.Time, min, max time.Duration) time.Duration {
+	if next.IsZero() {
+		return max
+	}
+
+	refresh := next.Sub(now)
+	if refresh < min {
+		return min
+	}
+	if refresh > max {
+		return max
+	}
+	return refresh
+}
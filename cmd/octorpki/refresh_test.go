@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	librpki "github.com/cloudflare/cfrpki/validator/lib"
+)
+
+func TestManifestRefresh(t *testing.T) {
+	now := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	manifest := func(nextUpdate time.Time) *librpki.RPKIManifest {
+		return &librpki.RPKIManifest{
+			Content: librpki.ManifestContent{
+				NextUpdate: nextUpdate,
+			},
+		}
+	}
+
+	manifests := []*librpki.RPKIManifest{
+		manifest(now.Add(6 * time.Hour)),
+		manifest(now.Add(-time.Hour)), // already expired
+		manifest(now.Add(25 * time.Minute)),
+		nil,
+		manifest(now.Add(2 * time.Hour)),
+	}
+
+	next := nextManifestUpdate(manifests, now)
+	assert.Equal(t, now.Add(25*time.Minute), next)
+	assert.Equal(t, 25*time.Minute, manifestRefresh(next, now, time.Minute, time.Hour))
+
+	// Clamped to the bounds
+	assert.Equal(t, 10*time.Minute, manifestRefresh(next, now, time.Minute, 10*time.Minute))
+	assert.Equal(t, 30*time.Minute, manifestRefresh(next, now, 30*time.Minute, time.Hour))
+
+	// No upcoming manifest
+	next = nextManifestUpdate(manifests[1:2], now)
+	assert.True(t, next.IsZero())
+	assert.Equal(t, time.Hour, manifestRefresh(next, now, time.Minute, time.Hour))
+}
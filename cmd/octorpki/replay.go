@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	syncpki "github.com/cloudflare/cfrpki/sync/lib"
+)
+
+// Serves the HTTP requests (TAL certificates and RRDP files) from a recorded
+// session: the response of https://host/path is the file dir/http/host/path.
+type replayTransport struct {
+	dir string
+}
+
+func newReplayTransport(dir string) *replayTransport {
+	return &replayTransport{dir: dir}
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Cleaning an absolute path removes the parent directory elements
+	file := filepath.Join(t.dir, "http", req.URL.Host, filepath.FromSlash(path.Clean("/"+req.URL.Path)))
+
+	status := http.StatusOK
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		status = http.StatusNotFound
+		data = nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header),
+		Body:          ioutil.NopCloser(bytes.NewReader(data)),
+		ContentLength: int64(len(data)),
+		Request:       req,
+	}, nil
+}
+
+// Replaces running rsync during a replay: the recorded tree dir/rsync/host/module
+// of the URI is copied to dest like "rsync -r" would.
+func replayRsync(dir string, uri string, dest string) ([]*syncpki.FileStat, error) {
+	uriPath, err := syncpki.ExtractFilePathFromRsyncURL(uri)
+	if err != nil {
+		return nil, err
+	}
+	src := filepath.Join(dir, "rsync", filepath.FromSlash(path.Clean("/"+uriPath)))
+
+	err = os.MkdirAll(dest, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]*syncpki.FileStat, 0)
+	err = filepath.Walk(src, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, file)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, os.ModePerm)
+		}
+
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		change := syncpki.FileNew
+		if previous, err := ioutil.ReadFile(target); err == nil {
+			if bytes.Equal(previous, data) {
+				return nil
+			}
+			change = syncpki.FileChanged
+		}
+
+		err = ioutil.WriteFile(target, data, 0600)
+		if err != nil {
+			return err
+		}
+		files = append(files, &syncpki.FileStat{
+			Path:   strings.TrimPrefix(filepath.ToSlash(rel), "./"),
+			Change: change,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("replay of %v: %v", uri, err)
+	}
+	return files, nil
+}
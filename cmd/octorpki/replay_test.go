@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+
+	syncpki "github.com/cloudflare/cfrpki/sync/lib"
+)
+
+func TestReplay(t *testing.T) {
+	defer func(replay, basepath string) { *ReplayDir, *Basepath = replay, basepath }(*ReplayDir, *Basepath)
+	*ReplayDir = "testdata/replay"
+	*Basepath = t.TempDir()
+
+	s := NewOctoRPKI(nil, nil)
+	span := opentracing.NoopTracer{}.StartSpan("test")
+	readCache := func(uri string) string {
+		data, err := os.ReadFile(filepath.Join(*Basepath, uri))
+		assert.Nil(t, err)
+		return string(data)
+	}
+
+	s.fetchRRDP(context.Background(), "https://rrdp.example.com/notification.xml", "rsync://rpki.example.com/repo", span)
+	assert.Equal(t, int64(7), s.RRDPInfo["rsync://rpki.example.com/repo"].Serial)
+	assert.Equal(t, "replayed from rrdp\n", readCache("rpki.example.com/repo/rrdp.txt"))
+
+	s.fetchRsync(context.Background(), "rsync://rpki.example.com/repo/", span)
+	assert.Equal(t, "replayed from rsync\n", readCache("rpki.example.com/repo/rsync.txt"))
+	assert.Equal(t, "nested\n", readCache("rpki.example.com/repo/sub/nested.txt"))
+
+	// Not recorded
+	resp, err := s.HTTPFetcher.Client.Get("https://rrdp.example.com/../../replay_test.go")
+	assert.Nil(t, err)
+	assert.Equal(t, 404, resp.StatusCode)
+}
+
+func TestReplayRsyncChanges(t *testing.T) {
+	dest := t.TempDir()
+
+	files, err := replayRsync("testdata/replay", "rsync://rpki.example.com/repo/", dest)
+	assert.Nil(t, err)
+	assert.Equal(t, []*syncpki.FileStat{
+		{Path: "rsync.txt", Change: syncpki.FileNew},
+		{Path: "sub/nested.txt", Change: syncpki.FileNew},
+	}, files)
+
+	assert.Nil(t, os.WriteFile(filepath.Join(dest, "rsync.txt"), []byte("modified"), 0600))
+	files, err = replayRsync("testdata/replay", "rsync://rpki.example.com/repo/", dest)
+	assert.Nil(t, err)
+	assert.Equal(t, []*syncpki.FileStat{
+		{Path: "rsync.txt", Change: syncpki.FileChanged},
+	}, files)
+
+	_, err = replayRsync("testdata/replay", "rsync://missing.example.com/repo/", dest)
+	assert.NotNil(t, err)
+}
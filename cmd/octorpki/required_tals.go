@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+	"github.com/cloudflare/gortr/prefixfile"
+	"github.com/getsentry/sentry-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// A TAL validated when its root certificate is valid and it produced ROAs.
+func talValidated(validator *pki.Validator) bool {
+	return len(validator.ValidObjects) > 0 && len(validator.ValidROA) > 0
+}
+
+// Returns the TALs of -require.tals (names or paths) which did not validate.
+func (s *OctoRPKI) failedRequiredTALs(pkiManagers []*pki.SimpleManager) []string {
+	failed := make([]string, 0)
+	for _, required := range strings.Split(*RequireTALs, ",") {
+		required = strings.TrimSpace(required)
+		if required == "" {
+			continue
+		}
+
+		var validated bool
+		for i, tal := range s.Tals {
+			if tal.Path != required && (len(s.TalNames) != len(s.Tals) || s.TalNames[i] != required) {
+				continue
+			}
+			validated = i < len(pkiManagers) && talValidated(pkiManagers[i].Validator)
+		}
+		if !validated {
+			failed = append(failed, required)
+		}
+	}
+	return failed
+}
+
+// Keeps the list currently served when a required TAL did not validate, so
+// the output never misses a whole trust anchor. The iteration is then not
+// considered stable.
+func (s *OctoRPKI) checkRequiredTALs(pkiManagers []*pki.SimpleManager, roaList *prefixfile.ROAList) *prefixfile.ROAList {
+	failed := s.failedRequiredTALs(pkiManagers)
+	s.requiredTALsFailed = len(failed) > 0
+	if len(failed) == 0 {
+		return roaList
+	}
+
+	err := fmt.Errorf("required TALs did not validate: %s: keeping the previous %d VRPs", strings.Join(failed, ", "), len(s.getROAList().Data))
+	log.Error(err)
+	sentry.WithScope(func(scope *sentry.Scope) {
+		scope.SetLevel(sentry.LevelFatal)
+		scope.SetTag("tals", strings.Join(failed, ","))
+		sentry.CaptureException(err)
+	})
+	return s.getROAList()
+}
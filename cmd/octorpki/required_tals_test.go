@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+	"github.com/cloudflare/gortr/prefixfile"
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+
+	syncpki "github.com/cloudflare/cfrpki/sync/lib"
+)
+
+func TestRequiredTALs(t *testing.T) {
+	defer func(required string, sign bool) { *RequireTALs, *Sign = required, sign }(*RequireTALs, *Sign)
+	*Sign = false
+
+	basepath := t.TempDir()
+	tals := []*pki.PKIFile{
+		createTestRepository(t, basepath, "rpki-a.example.com", []testROA{
+			{ASN: 65001, Prefix: "192.0.2.0/24", MaxLength: 24},
+		}),
+		createTestRepository(t, basepath, "rpki-b.example.com", []testROA{
+			{ASN: 65002, Prefix: "198.51.100.0/24", MaxLength: 24},
+		}),
+	}
+	previous := []prefixfile.ROAJson{
+		{Prefix: "192.0.2.0/24", Length: 24, ASN: "AS65001", TA: "A"},
+		{Prefix: "198.51.100.0/24", Length: 24, ASN: "AS65002", TA: "B"},
+	}
+
+	s := NewOctoRPKI(tals, []string{"A", "B"})
+	s.Fetcher = syncpki.NewLocalFetch(basepath)
+	validate := func() []prefixfile.ROAJson {
+		s.mainValidation(context.Background(), opentracing.NoopTracer{}.StartSpan("test"))
+		return s.getROAList().Data
+	}
+
+	*RequireTALs = "A, B"
+	assert.Equal(t, previous, validate())
+	assert.False(t, s.requiredTALsFailed)
+
+	// The root certificate of B cannot be fetched anymore
+	assert.Nil(t, os.Remove(filepath.Join(basepath, "rpki-b.example.com/repo/root.cer")))
+	assert.Equal(t, previous, validate())
+	assert.True(t, s.requiredTALsFailed)
+
+	// B is not required: the output misses its VRPs
+	*RequireTALs = "A"
+	assert.Equal(t, previous[:1], validate())
+	assert.False(t, s.requiredTALsFailed)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	syncpki "github.com/cloudflare/cfrpki/sync/lib"
+	librpki "github.com/cloudflare/cfrpki/validator/lib"
+)
+
+// Objects currently published by the RRDP repositories, by rsync URL. A set
+// is only complete once a snapshot was applied: the deltas received after a
+// restart do not list the objects published before.
+type rrdpObjects struct {
+	mu           sync.Mutex
+	repositories map[string]*rrdpRepositoryObjects
+}
+
+type rrdpRepositoryObjects struct {
+	complete bool
+	uris     map[string]bool
+}
+
+func newRRDPObjects() *rrdpObjects {
+	return &rrdpObjects{
+		repositories: make(map[string]*rrdpRepositoryObjects),
+	}
+}
+
+// Changes received during a fetch
+type rrdpObjectChanges struct {
+	published []string
+	withdrawn []string
+}
+
+func (c *rrdpObjectChanges) record(uri string, withdraw bool) {
+	if withdraw {
+		c.withdrawn = append(c.withdrawn, uri)
+	} else {
+		c.published = append(c.published, uri)
+	}
+}
+
+// Applies the changes of a successful fetch. A snapshot replaces the set.
+func (o *rrdpObjects) apply(rsyncURL string, snapshot bool, changes *rrdpObjectChanges) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	repository, ok := o.repositories[rsyncURL]
+	if !ok || snapshot {
+		repository = &rrdpRepositoryObjects{
+			complete: snapshot,
+			uris:     make(map[string]bool),
+		}
+		o.repositories[rsyncURL] = repository
+	}
+	for _, uri := range changes.published {
+		repository.uris[uri] = true
+	}
+	for _, uri := range changes.withdrawn {
+		delete(repository.uris, uri)
+	}
+}
+
+// Returns the objects published in the directory of a manifest, false when
+// the repository is not fetched over RRDP or its set is incomplete.
+func (o *rrdpObjects) directory(mftURI string) (map[string]bool, bool) {
+	rsyncURL, _, err := syncpki.ExtractRsyncDomainModule(mftURI)
+	if err != nil {
+		return nil, false
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	repository, ok := o.repositories[rsyncURL]
+	if !ok || !repository.complete {
+		return nil, false
+	}
+
+	dir := mftURI[:strings.LastIndex(mftURI, "/")+1]
+	objects := make(map[string]bool)
+	for uri := range repository.uris {
+		if name := strings.TrimPrefix(uri, dir); name != uri && !strings.Contains(name, "/") {
+			objects[name] = true
+		}
+	}
+	return objects, true
+}
+
+// Compares the files listed on a manifest with the objects published over
+// RRDP in its directory. The manifest is not listed on itself.
+func compareManifestRRDP(mft *librpki.RPKIManifest, mftURI string, published map[string]bool) ([]string, []string) {
+	listed := make(map[string]bool, len(mft.Content.FileList))
+	for _, file := range mft.Content.FileList {
+		listed[file.Name] = true
+	}
+	mftName := mftURI[strings.LastIndex(mftURI, "/")+1:]
+
+	rrdpOnly := make([]string, 0)
+	for name := range published {
+		if !listed[name] && name != mftName {
+			rrdpOnly = append(rrdpOnly, name)
+		}
+	}
+	manifestOnly := make([]string, 0)
+	for name := range listed {
+		if !published[name] {
+			manifestOnly = append(manifestOnly, name)
+		}
+	}
+	sort.Strings(rrdpOnly)
+	sort.Strings(manifestOnly)
+	return rrdpOnly, manifestOnly
+}
+
+// Reports the differences between the valid manifests of a TAL and the
+// objects published over RRDP (-audit.rrdp-manifest).
+func (s *OctoRPKI) auditRRDPManifests(talname string, validator *pki.Validator) {
+	var countRRDPOnly, countManifestOnly int
+	for _, obj := range validator.ValidManifest {
+		if obj.File == nil {
+			continue
+		}
+		mftURI := obj.File.ComputePath()
+		published, ok := s.rrdpObjects.directory(mftURI)
+		if !ok {
+			continue
+		}
+
+		rrdpOnly, manifestOnly := compareManifestRRDP(obj.Resource.(*librpki.RPKIManifest), mftURI, published)
+		if len(rrdpOnly) > 0 {
+			log.Warnf("rrdp: objects published next to %s but not on the manifest: %s", mftURI, strings.Join(rrdpOnly, ", "))
+		}
+		if len(manifestOnly) > 0 {
+			log.Warnf("rrdp: files on the manifest %s but not published: %s", mftURI, strings.Join(manifestOnly, ", "))
+		}
+		countRRDPOnly += len(rrdpOnly)
+		countManifestOnly += len(manifestOnly)
+	}
+
+	MetricRRDPManifestMismatch.With(prometheus.Labels{"ta": talname, "type": "rrdp-only"}).Set(float64(countRRDPOnly))
+	MetricRRDPManifestMismatch.With(prometheus.Labels{"ta": talname, "type": "manifest-only"}).Set(float64(countManifestOnly))
+}
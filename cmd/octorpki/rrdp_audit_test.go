@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+	"github.com/opentracing/opentracing-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+
+	syncpki "github.com/cloudflare/cfrpki/sync/lib"
+)
+
+func TestRRDPManifestAudit(t *testing.T) {
+	defer func(audit, sign bool, basepath string) {
+		*RRDPAudit, *Sign, *Basepath = audit, sign, basepath
+	}(*RRDPAudit, *Sign, *Basepath)
+	*RRDPAudit = true
+	*Sign = false
+	*Basepath = t.TempDir()
+
+	// The snapshot misses the ROA of the manifest and publishes another one
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/notification.xml":
+			fmt.Fprintf(w, `<notification xmlns="http://www.ripe.net/rpki/rrdp" version="1" session_id="session" serial="1">
+<snapshot uri="http://%s/snapshot.xml" hash="00"/>
+</notification>`, r.Host)
+		default:
+			w.Write([]byte(`<snapshot xmlns="http://www.ripe.net/rpki/rrdp" version="1" session_id="session" serial="1">
+<publish uri="rsync://rpki.example.com/repo/root.mft">AA==</publish>
+<publish uri="rsync://rpki.example.com/repo/root.crl">AA==</publish>
+<publish uri="rsync://rpki.example.com/repo/extra.roa">AA==</publish>
+<publish uri="rsync://rpki.example.com/repo/child/child.mft">AA==</publish>
+</snapshot>`))
+		}
+	}))
+	defer ts.Close()
+
+	basepath := t.TempDir()
+	tals := []*pki.PKIFile{
+		createTestRepository(t, basepath, "rpki.example.com", []testROA{
+			{ASN: 65001, Prefix: "192.0.2.0/24", MaxLength: 24},
+		}),
+	}
+
+	s := NewOctoRPKI(tals, []string{"Audit"})
+	s.Fetcher = syncpki.NewLocalFetch(basepath)
+	span := opentracing.NoopTracer{}.StartSpan("test")
+	rrdpOnly := MetricRRDPManifestMismatch.With(prometheus.Labels{"ta": "Audit", "type": "rrdp-only"})
+	manifestOnly := MetricRRDPManifestMismatch.With(prometheus.Labels{"ta": "Audit", "type": "manifest-only"})
+
+	// Not fetched over RRDP: nothing to compare
+	s.mainValidation(context.Background(), span)
+	assert.Equal(t, float64(0), getGaugeValue(t, rrdpOnly))
+	assert.Equal(t, float64(0), getGaugeValue(t, manifestOnly))
+
+	s.fetchRRDP(context.Background(), ts.URL+"/notification.xml", "rsync://rpki.example.com/repo", span)
+	s.mainValidation(context.Background(), span)
+	assert.Equal(t, float64(1), getGaugeValue(t, rrdpOnly))
+	assert.Equal(t, float64(1), getGaugeValue(t, manifestOnly))
+}
+
+func TestRRDPObjectsDeltas(t *testing.T) {
+	objects := newRRDPObjects()
+
+	// Deltas received before any snapshot are incomplete
+	objects.apply("rsync://rpki.example.com/repo", false, &rrdpObjectChanges{published: []string{"rsync://rpki.example.com/repo/a.roa"}})
+	_, ok := objects.directory("rsync://rpki.example.com/repo/root.mft")
+	assert.False(t, ok)
+
+	objects.apply("rsync://rpki.example.com/repo", true, &rrdpObjectChanges{published: []string{"rsync://rpki.example.com/repo/b.roa"}})
+	objects.apply("rsync://rpki.example.com/repo", false, &rrdpObjectChanges{
+		published: []string{"rsync://rpki.example.com/repo/c.roa"},
+		withdrawn: []string{"rsync://rpki.example.com/repo/b.roa"},
+	})
+	published, ok := objects.directory("rsync://rpki.example.com/repo/root.mft")
+	assert.True(t, ok)
+	assert.Equal(t, map[string]bool{"c.roa": true}, published)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Publication points of a TAL by protocol.
+type rrdpCoverage struct {
+	RRDP      int // advertising an RRDP notification
+	RsyncOnly int
+}
+
+func (c rrdpCoverage) ratio() float64 {
+	if c.RRDP+c.RsyncOnly == 0 {
+		return 0
+	}
+	return float64(c.RRDP) / float64(c.RRDP+c.RsyncOnly)
+}
+
+// Counts the publication points of each TAL reachable over RRDP and the ones
+// only reachable over rsync. rsyncFetch maps each publication point to the
+// RRDP notification of its certificates, empty when they have none.
+func computeRRDPCoverage(repositoryTALs map[string][]int, rsyncFetch map[string]string, tals int) []rrdpCoverage {
+	coverage := make([]rrdpCoverage, tals)
+	for rsync, talIndexes := range repositoryTALs {
+		for _, i := range talIndexes {
+			if rsyncFetch[rsync] != "" {
+				coverage[i].RRDP++
+			} else {
+				coverage[i].RsyncOnly++
+			}
+		}
+	}
+	return coverage
+}
+
+func (s *OctoRPKI) updateRRDPCoverage(talNames []string, repositoryTALs map[string][]int) {
+	coverage := computeRRDPCoverage(repositoryTALs, s.rsyncFetchJobManager.get(), len(talNames))
+	for i, talname := range talNames {
+		MetricPublicationPoints.With(prometheus.Labels{"ta": talname, "protocol": "rrdp"}).Set(float64(coverage[i].RRDP))
+		MetricPublicationPoints.With(prometheus.Labels{"ta": talname, "protocol": "rsync-only"}).Set(float64(coverage[i].RsyncOnly))
+		MetricRRDPCoverage.With(prometheus.Labels{"ta": talname}).Set(coverage[i].ratio())
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComputeRRDPCoverage(t *testing.T) {
+	repositoryTALs := map[string][]int{
+		"rsync://rpki-a.example.com/repo/":   {0},
+		"rsync://rpki-b.example.com/repo/":   {0, 1},
+		"rsync://rpki-c.example.com/repo/":   {0},
+		"rsync://rpki-d.example.com/module/": {1},
+	}
+	rsyncFetch := map[string]string{
+		"rsync://rpki-a.example.com/repo/":   "https://rpki-a.example.com/notification.xml",
+		"rsync://rpki-b.example.com/repo/":   "https://rpki-b.example.com/notification.xml",
+		"rsync://rpki-c.example.com/repo/":   "",
+		"rsync://rpki-d.example.com/module/": "",
+	}
+	coverage := computeRRDPCoverage(repositoryTALs, rsyncFetch, 3)
+	assert.Equal(t, []rrdpCoverage{{RRDP: 2, RsyncOnly: 1}, {RRDP: 1, RsyncOnly: 1}, {}}, coverage)
+	assert.InDelta(t, 2.0/3, coverage[0].ratio(), 1e-9)
+	assert.Equal(t, 0.5, coverage[1].ratio())
+	assert.Equal(t, float64(0), coverage[2].ratio())
+}
+
+func TestRRDPCoverageMetric(t *testing.T) {
+	defer func(v bool) { *Sign = v }(*Sign)
+	*Sign = false
+
+	basepath := t.TempDir()
+	tals := []*pki.PKIFile{
+		createTestRepository(t, basepath, "rpki.example.com", []testROA{
+			{ASN: 65001, Prefix: "192.0.2.0/24", MaxLength: 24},
+		}),
+	}
+	runValidation(basepath, tals, []string{"coverage"}, 1)
+
+	// The fixture certificate has an RRDP notification
+	assert.Equal(t, float64(1), getGaugeValue(t, MetricRRDPCoverage.With(prometheus.Labels{"ta": "coverage"})))
+	assert.Equal(t, float64(1), getGaugeValue(t, MetricPublicationPoints.With(prometheus.Labels{"ta": "coverage", "protocol": "rrdp"})))
+	assert.Equal(t, float64(0), getGaugeValue(t, MetricPublicationPoints.With(prometheus.Labels{"ta": "coverage", "protocol": "rsync-only"})))
+}
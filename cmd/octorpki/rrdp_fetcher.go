@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"sync"
 
 	"github.com/opentracing/opentracing-go"
@@ -12,14 +13,16 @@ type rrdpFetchJob struct {
 }
 
 type rrdpFetcher struct {
+	ctx      context.Context
 	octoRPKI *OctoRPKI
 	jobsCh   chan rrdpFetchJob
 	wg       sync.WaitGroup
 	span     opentracing.Span
 }
 
-func newRRDPFetcher(octoRPKI *OctoRPKI, workers int, span opentracing.Span) *rrdpFetcher {
+func newRRDPFetcher(ctx context.Context, octoRPKI *OctoRPKI, workers int, span opentracing.Span) *rrdpFetcher {
 	rf := &rrdpFetcher{
+		ctx:      ctx,
 		octoRPKI: octoRPKI,
 		jobsCh:   make(chan rrdpFetchJob),
 		span:     span,
@@ -37,7 +40,7 @@ func (r *rrdpFetcher) worker() {
 	defer r.wg.Done()
 
 	for job := range r.jobsCh {
-		r.octoRPKI.fetchRRDP(job.path, job.rsync, r.span)
+		r.octoRPKI.fetchRRDP(r.ctx, job.path, job.rsync, r.span)
 	}
 }
 
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	RRDPConflictDomains = "rrdp-multiple-domains" // an RRDP URL announced for several rsync domains
+	RRDPConflictRRDP    = "rsync-multiple-rrdp"   // an rsync repository announced with several RRDP URLs
+	RRDPConflictCycle   = "cycle"                 // hosts serving the RRDP of each other's rsync domain
+)
+
+type RRDPMappingConflict struct {
+	Type  string   `json:"type"`
+	RRDP  []string `json:"rrdp"`
+	Rsync []string `json:"rsync"`
+}
+
+// RRDP and rsync pairs announced by the certificates of an iteration.
+type rrdpMappings struct {
+	domains     map[string]map[string]bool // RRDP URL -> rsync domains
+	rrdps       map[string]map[string]bool // rsync repository -> RRDP URLs
+	hosts       map[string]map[string]bool // RRDP host -> rsync domains on other hosts
+	hostsToRRDP map[string]map[string]bool // RRDP host -> RRDP URLs
+}
+
+func newRRDPMappings() *rrdpMappings {
+	return &rrdpMappings{
+		domains:     make(map[string]map[string]bool),
+		rrdps:       make(map[string]map[string]bool),
+		hosts:       make(map[string]map[string]bool),
+		hostsToRRDP: make(map[string]map[string]bool),
+	}
+}
+
+func addMapping(m map[string]map[string]bool, key string, value string) {
+	if m[key] == nil {
+		m[key] = make(map[string]bool)
+	}
+	m[key][value] = true
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (m *rrdpMappings) add(rrdp string, rsync string, domain string) {
+	addMapping(m.domains, rrdp, domain)
+	addMapping(m.rrdps, rsync, rrdp)
+
+	u, err := url.Parse(rrdp)
+	if err != nil {
+		return
+	}
+	host := strings.ToLower(u.Hostname())
+	domain = strings.ToLower(domain)
+	addMapping(m.hostsToRRDP, host, rrdp)
+	if host != domain {
+		addMapping(m.hosts, host, domain)
+	}
+}
+
+// Hosts reachable from a host by following the RRDP host -> rsync domain edges.
+func (m *rrdpMappings) reachable(host string) map[string]bool {
+	seen := make(map[string]bool)
+	next := []string{host}
+	for len(next) > 0 {
+		current := next[0]
+		next = next[1:]
+		for domain := range m.hosts[current] {
+			if !seen[domain] {
+				seen[domain] = true
+				next = append(next, domain)
+			}
+		}
+	}
+	return seen
+}
+
+// Groups the hosts which reach each other.
+func (m *rrdpMappings) cycles() [][]string {
+	reach := make(map[string]map[string]bool, len(m.hosts))
+	for host := range m.hosts {
+		reach[host] = m.reachable(host)
+	}
+
+	grouped := make(map[string]bool)
+	cycles := make([][]string, 0)
+	for _, host := range sortedKeys(m.hosts) {
+		if grouped[host] || !reach[host][host] {
+			continue
+		}
+		cycle := []string{host}
+		for other := range reach[host] {
+			if other != host && reach[other][host] {
+				cycle = append(cycle, other)
+			}
+		}
+		for _, h := range cycle {
+			grouped[h] = true
+		}
+		sort.Strings(cycle)
+		cycles = append(cycles, cycle)
+	}
+	return cycles
+}
+
+// Lists the conflicting mappings, sorted by type then URL.
+func (m *rrdpMappings) conflicts() []RRDPMappingConflict {
+	conflicts := make([]RRDPMappingConflict, 0)
+
+	for _, rrdp := range sortedKeys(m.domains) {
+		if len(m.domains[rrdp]) > 1 {
+			conflicts = append(conflicts, RRDPMappingConflict{
+				Type:  RRDPConflictDomains,
+				RRDP:  []string{rrdp},
+				Rsync: sortedKeys(m.domains[rrdp]),
+			})
+		}
+	}
+
+	for _, rsync := range sortedKeys(m.rrdps) {
+		if len(m.rrdps[rsync]) > 1 {
+			conflicts = append(conflicts, RRDPMappingConflict{
+				Type:  RRDPConflictRRDP,
+				RRDP:  sortedKeys(m.rrdps[rsync]),
+				Rsync: []string{rsync},
+			})
+		}
+	}
+
+	for _, cycle := range m.cycles() {
+		rrdps := make(map[string]bool)
+		for _, host := range cycle {
+			for rrdp := range m.hostsToRRDP[host] {
+				rrdps[rrdp] = true
+			}
+		}
+		conflicts = append(conflicts, RRDPMappingConflict{
+			Type:  RRDPConflictCycle,
+			RRDP:  sortedKeys(rrdps),
+			Rsync: cycle,
+		})
+	}
+
+	return conflicts
+}
+
+// Logs the conflicts and updates their metric.
+func reportRRDPConflicts(conflicts []RRDPMappingConflict) {
+	counts := map[string]int{
+		RRDPConflictDomains: 0,
+		RRDPConflictRRDP:    0,
+		RRDPConflictCycle:   0,
+	}
+	for _, conflict := range conflicts {
+		counts[conflict.Type]++
+		log.Warnf("RRDP mapping conflict (%s): %s <-> %s", conflict.Type, strings.Join(conflict.RRDP, ", "), strings.Join(conflict.Rsync, ", "))
+	}
+	for conflictType, count := range counts {
+		MetricRRDPConflicts.With(prometheus.Labels{"type": conflictType}).Set(float64(count))
+	}
+}
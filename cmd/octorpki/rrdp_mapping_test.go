@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRRDPMappingConflicts(t *testing.T) {
+	m := newRRDPMappings()
+	// Consistent mapping
+	m.add("https://rrdp.example.com/notification.xml", "rsync://rpki.example.com/repo", "rpki.example.com")
+	m.add("https://rrdp.example.com/notification.xml", "rsync://rpki.example.com/repo", "rpki.example.com")
+	// One RRDP URL for two domains
+	m.add("https://rrdp.example.net/notification.xml", "rsync://a.example.net/repo", "a.example.net")
+	m.add("https://rrdp.example.net/notification.xml", "rsync://b.example.net/repo", "b.example.net")
+	// One repository with two RRDP URLs
+	m.add("https://c.example.org/notification.xml", "rsync://c.example.org/repo", "c.example.org")
+	m.add("https://mirror.example.org/notification.xml", "rsync://c.example.org/repo", "c.example.org")
+	// Two hosts serving the RRDP of each other
+	m.add("https://x.example.com/notification.xml", "rsync://y.example.com/repo", "y.example.com")
+	m.add("https://y.example.com/notification.xml", "rsync://x.example.com/repo", "x.example.com")
+
+	conflicts := m.conflicts()
+	assert.Equal(t, []RRDPMappingConflict{
+		{
+			Type:  RRDPConflictDomains,
+			RRDP:  []string{"https://rrdp.example.net/notification.xml"},
+			Rsync: []string{"a.example.net", "b.example.net"},
+		},
+		{
+			Type:  RRDPConflictRRDP,
+			RRDP:  []string{"https://c.example.org/notification.xml", "https://mirror.example.org/notification.xml"},
+			Rsync: []string{"rsync://c.example.org/repo"},
+		},
+		{
+			Type:  RRDPConflictCycle,
+			RRDP:  []string{"https://x.example.com/notification.xml", "https://y.example.com/notification.xml"},
+			Rsync: []string{"x.example.com", "y.example.com"},
+		},
+	}, conflicts)
+
+	reportRRDPConflicts(conflicts)
+	assert.Equal(t, float64(1), getGaugeValue(t, MetricRRDPConflicts.With(prometheus.Labels{"type": RRDPConflictCycle})))
+
+	s := NewOctoRPKI(nil, nil)
+	s.updateSnapshot(func(snapshot *validationSnapshot) {
+		snapshot.RRDPConflicts = conflicts
+	})
+	rec := httptest.NewRecorder()
+	s.ServeInfo(rec, httptest.NewRequest("GET", "/infos", nil))
+	var info InfoResult
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &info))
+	assert.Equal(t, conflicts, info.RRDPConflicts)
+}
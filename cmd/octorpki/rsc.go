@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+
+	librpki "github.com/cloudflare/cfrpki/validator/lib"
+)
+
+type RSCFileHash struct {
+	Name string `json:"name,omitempty"`
+	Hash string `json:"hash"`
+}
+
+// Signed checklist (RFC 9323) found in a repository.
+type InfoRSC struct {
+	TA              string        `json:"ta"`
+	Path            string        `json:"path"`
+	Valid           bool          `json:"valid"`
+	Signer          string        `json:"signer"` // SKI of the EE certificate
+	Issuer          string        `json:"issuer"` // SKI of the CA
+	SigningTime     int           `json:"signing-time"`
+	Resources       []string      `json:"resources"`
+	DigestAlgorithm string        `json:"digest-algorithm"`
+	Files           []RSCFileHash `json:"files"`
+}
+
+func digestAlgorithmName(oid string) string {
+	if oid == librpki.SHA256OID.String() {
+		return "sha256"
+	}
+	return oid
+}
+
+// Formats ASNs and IP resources as AS64496, AS64496-AS64511, prefixes or
+// address ranges.
+func formatResources(asns []librpki.ASNCertificateInformation, ips []librpki.IPCertificateInformation) []string {
+	resources := make([]string, 0, len(asns)+len(ips))
+	for _, asn := range asns {
+		min, max, inherit := asn.GetRange()
+		if inherit {
+			resources = append(resources, "inherit")
+		} else if min == max {
+			resources = append(resources, fmt.Sprintf("AS%d", min))
+		} else {
+			resources = append(resources, fmt.Sprintf("AS%d-AS%d", min, max))
+		}
+	}
+	for _, ip := range ips {
+		if ipnet, ok := ip.(*librpki.IPNet); ok {
+			resources = append(resources, ipnet.IPNet.String())
+			continue
+		}
+		min, max, inherit := ip.GetRange()
+		if inherit {
+			resources = append(resources, "inherit")
+		} else {
+			resources = append(resources, fmt.Sprintf("%v-%v", min, max))
+		}
+	}
+	return resources
+}
+
+// Lists the signed checklists decoded by a validator, sorted by path.
+func rscInfos(ta string, validator *pki.Validator) []InfoRSC {
+	infos := make([]InfoRSC, 0, len(validator.RSC))
+	for key, res := range validator.RSC {
+		rsc, ok := res.Resource.(*librpki.RPKIRSC)
+		if !ok || rsc.Certificate == nil {
+			continue
+		}
+		_, valid := validator.ValidRSC[key]
+
+		info := InfoRSC{
+			TA:              ta,
+			Valid:           valid,
+			Signer:          hex.EncodeToString(rsc.Certificate.Certificate.SubjectKeyId),
+			Issuer:          hex.EncodeToString(rsc.Certificate.Certificate.AuthorityKeyId),
+			SigningTime:     int(rsc.SigningTime.Unix()),
+			Resources:       make([]string, 0, len(rsc.ASNums)+len(rsc.IPAddresses)),
+			DigestAlgorithm: digestAlgorithmName(rsc.Content.DigestAlgorithm.Algorithm.String()),
+			Files:           make([]RSCFileHash, len(rsc.Content.CheckList)),
+		}
+		if res.File != nil {
+			info.Path = res.File.ComputePath()
+		}
+		info.Resources = append(info.Resources, formatResources(rsc.ASNums, rsc.IPAddresses)...)
+		for i, file := range rsc.Content.CheckList {
+			info.Files[i] = RSCFileHash{
+				Name: file.Name,
+				Hash: hex.EncodeToString(file.Hash),
+			}
+		}
+		infos = append(infos, info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Path < infos[j].Path
+	})
+	return infos
+}
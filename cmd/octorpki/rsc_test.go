@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+	"github.com/stretchr/testify/assert"
+
+	librpki "github.com/cloudflare/cfrpki/validator/lib"
+)
+
+func TestRSCInfos(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	genTime := time.Now().UTC().Add(-time.Hour)
+
+	_, net4, _ := net.ParseCIDR("0.0.0.0/0")
+	ipBlocks, err := librpki.EncodeIPAddressBlock([]librpki.IPCertificateInformation{&librpki.IPNet{IPNet: net4}})
+	assert.Nil(t, err)
+	asnBlocks, err := librpki.EncodeASN([]librpki.ASNCertificateInformation{&librpki.ASNRange{Min: 0, Max: 1<<31 - 1}}, nil)
+	assert.Nil(t, err)
+
+	root := &x509.Certificate{
+		Version:               3,
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "root"},
+		ExtraExtensions:       []pkix.Extension{*ipBlocks, *asnBlocks},
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		SubjectKeyId:          []byte{1},
+		NotBefore:             genTime,
+		NotAfter:              genTime.Add(time.Hour * 24),
+	}
+	rootBytes, err := x509.CreateCertificate(rand.Reader, root, root, key.Public(), key)
+	assert.Nil(t, err)
+	rootCert, err := librpki.DecodeCertificate(rootBytes)
+	assert.Nil(t, err)
+
+	// Signed checklist of two files
+	_, prefix, _ := net.ParseCIDR("192.0.2.0/24")
+	resources, err := librpki.EncodeRSCResources(
+		[]librpki.ASNCertificateInformation{&librpki.ASN{ASN: 65001}},
+		[]librpki.IPCertificateInformation{&librpki.IPNet{IPNet: prefix}},
+	)
+	assert.Nil(t, err)
+	hash := sha256.Sum256([]byte("document"))
+	content, err := librpki.EncodeRSCContent(librpki.RSCContent{
+		Resources:       resources,
+		DigestAlgorithm: pkix.AlgorithmIdentifier{Algorithm: librpki.SHA256OID},
+		CheckList: []librpki.RSCFile{
+			{Name: "document.txt", Hash: hash[:]},
+			{Hash: hash[:]},
+		},
+	})
+	assert.Nil(t, err)
+	cms, err := librpki.EncodeCMS(nil, content, genTime)
+	assert.Nil(t, err)
+
+	ee := &x509.Certificate{
+		Version:         3,
+		SerialNumber:    big.NewInt(2),
+		Subject:         pkix.Name{CommonName: "rsc"},
+		ExtraExtensions: []pkix.Extension{*ipBlocks, *asnBlocks},
+		NotBefore:       genTime,
+		NotAfter:        genTime.Add(time.Hour * 24),
+		SubjectKeyId:    []byte{2},
+		AuthorityKeyId:  root.SubjectKeyId,
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+	}
+	eeBytes, err := x509.CreateCertificate(rand.Reader, ee, root, key.Public(), key)
+	assert.Nil(t, err)
+	encap, err := librpki.RSCToEncap(content)
+	assert.Nil(t, err)
+	assert.Nil(t, cms.Sign(rand.Reader, ee.SubjectKeyId, encap, key, eeBytes))
+	rscBytes, err := asn1.Marshal(*cms)
+	assert.Nil(t, err)
+
+	validator := pki.NewValidator()
+	validator.DecoderConfig = &librpki.DecoderConfig{ValidateStrict: false}
+	_, _, _, err = validator.AddCert(rootCert, true)
+	assert.Nil(t, err)
+
+	file := &pki.PKIFile{Path: "rsync://rpki.example.com/repo/checklist.sig"}
+	file.Type = pki.DetermineType(file.Path)
+	assert.Equal(t, pki.TYPE_RSC, file.Type)
+	valid, _, _, err := validator.AddResource(file, rscBytes)
+	assert.Nil(t, err)
+	assert.True(t, valid)
+
+	infos := rscInfos("Example", validator)
+	assert.Equal(t, []InfoRSC{{
+		TA:              "Example",
+		Path:            "rsync://rpki.example.com/repo/checklist.sig",
+		Valid:           true,
+		Signer:          "02",
+		Issuer:          "01",
+		SigningTime:     int(genTime.Unix()),
+		Resources:       []string{"AS65001", "192.0.2.0/24"},
+		DigestAlgorithm: "sha256",
+		Files: []RSCFileHash{
+			{Name: "document.txt", Hash: hex.EncodeToString(hash[:])},
+			{Hash: hex.EncodeToString(hash[:])},
+		},
+	}}, infos)
+
+	// Strict CMS decoding rejects the Golang generated certificate
+	validator = pki.NewValidator()
+	validator.DecoderConfig = &librpki.DecoderConfig{ValidateStrict: true}
+	_, _, _, err = validator.AddCert(rootCert, true)
+	assert.Nil(t, err)
+	valid, _, _, err = validator.AddResource(file, rscBytes)
+	assert.NotNil(t, err)
+	assert.False(t, valid)
+	assert.Empty(t, rscInfos("Example", validator))
+}
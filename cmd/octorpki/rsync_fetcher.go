@@ -1,20 +1,23 @@
 package main
 
 import (
+	"context"
 	"sync"
 
 	"github.com/opentracing/opentracing-go"
 )
 
 type rsyncFetcher struct {
+	ctx      context.Context
 	octoRPKI *OctoRPKI
 	jobsCh   chan string
 	wg       sync.WaitGroup
 	span     opentracing.Span
 }
 
-func newRsyncFetcher(octoRPKI *OctoRPKI, workers int, span opentracing.Span) *rsyncFetcher {
+func newRsyncFetcher(ctx context.Context, octoRPKI *OctoRPKI, workers int, span opentracing.Span) *rsyncFetcher {
 	rf := &rsyncFetcher{
+		ctx:      ctx,
 		octoRPKI: octoRPKI,
 		jobsCh:   make(chan string),
 		span:     span,
@@ -32,7 +35,7 @@ func (r *rsyncFetcher) worker() {
 	defer r.wg.Done()
 
 	for rsyncURL := range r.jobsCh {
-		r.octoRPKI.fetchRsync(rsyncURL, r.span)
+		r.octoRPKI.fetchRsync(r.ctx, rsyncURL, r.span)
 	}
 }
 
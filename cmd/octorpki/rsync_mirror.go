@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	syncpki "github.com/cloudflare/cfrpki/sync/lib"
+)
+
+// Loads a JSON object mapping rsync URI prefixes to the prefix of a mirror,
+// for instance {"rsync://rpki.example.com/repo/": "rsync://mirror.internal/example/"}.
+func loadRsyncMirrors(file string) (map[string]string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var mirrors map[string]string
+	err = json.Unmarshal(data, &mirrors)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode %s: %v", file, err)
+	}
+
+	for source, mirror := range mirrors {
+		if !strings.HasPrefix(source, syncpki.RsyncProtoPrefix) || !strings.HasPrefix(mirror, syncpki.RsyncProtoPrefix) {
+			return nil, fmt.Errorf("invalid mirror %q for %q: both must be rsync URIs", mirror, source)
+		}
+	}
+	return mirrors, nil
+}
+
+// Returns the URI rsync fetches uri from: the longest matching prefix is
+// replaced by its mirror. The objects are still stored and validated under
+// the original URI.
+func (s *OctoRPKI) rsyncSource(uri string) string {
+	var source string
+	for prefix := range s.rsyncMirrors {
+		if strings.HasPrefix(uri, prefix) && len(prefix) > len(source) {
+			source = prefix
+		}
+	}
+	if source == "" {
+		return uri
+	}
+	return s.rsyncMirrors[source] + strings.TrimPrefix(uri, source)
+}
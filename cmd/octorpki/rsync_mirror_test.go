@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadRsyncMirrors(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "mirrors.json")
+
+	assert.Nil(t, os.WriteFile(file, []byte(`{"rsync://rpki.example.com/repo/": "rsync://mirror.internal/example/"}`), 0644))
+	mirrors, err := loadRsyncMirrors(file)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"rsync://rpki.example.com/repo/": "rsync://mirror.internal/example/"}, mirrors)
+
+	assert.Nil(t, os.WriteFile(file, []byte(`{"rsync://rpki.example.com/repo/": "https://mirror.internal/"}`), 0644))
+	_, err = loadRsyncMirrors(file)
+	assert.NotNil(t, err)
+
+	_, err = loadRsyncMirrors(filepath.Join(dir, "missing.json"))
+	assert.NotNil(t, err)
+}
+
+func TestRsyncMirror(t *testing.T) {
+	defer func(bin, basepath string) { *RsyncBin, *Basepath = bin, basepath }(*RsyncBin, *Basepath)
+
+	dir := t.TempDir()
+	*Basepath = filepath.Join(dir, "cache")
+	args := filepath.Join(dir, "args")
+	*RsyncBin = filepath.Join(dir, "rsync")
+	assert.Nil(t, os.WriteFile(*RsyncBin, []byte("#!/bin/sh\necho \"$@\" >> "+args+"\n"), 0755))
+
+	s := NewOctoRPKI(nil, nil)
+	s.rsyncMirrors = map[string]string{
+		"rsync://rpki.example.com/":      "rsync://mirror.internal/example/",
+		"rsync://rpki.example.com/repo/": "rsync://mirror.internal/repo/",
+	}
+	assert.Equal(t, "rsync://mirror.internal/repo/ca/", s.rsyncSource("rsync://rpki.example.com/repo/ca/"))
+	assert.Equal(t, "rsync://mirror.internal/example/other/", s.rsyncSource("rsync://rpki.example.com/other/"))
+	assert.Equal(t, "rsync://rpki.example.net/repo/", s.rsyncSource("rsync://rpki.example.net/repo/"))
+
+	uri := "rsync://rpki.example.com/repo/ca/"
+	s.fetchRsync(context.Background(), uri, opentracing.NoopTracer{}.StartSpan("test"))
+
+	// Fetched from the mirror into the directory of the original URI
+	data, err := os.ReadFile(args)
+	assert.Nil(t, err)
+	fields := strings.Fields(string(data))
+	if assert.Len(t, fields, 3) {
+		assert.Equal(t, "rsync://mirror.internal/repo/ca/", fields[1])
+		assert.Equal(t, filepath.Join(*Basepath, "rpki.example.com/repo/ca"), filepath.Clean(fields[2]))
+	}
+
+	// Reported under the original URI
+	assert.NotZero(t, getGaugeValue(t, MetricLastFetch.With(prometheus.Labels{"address": uri, "type": "rsync"})))
+}
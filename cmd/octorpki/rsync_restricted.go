@@ -0,0 +1,43 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Rsync repositories refusing access (permission or path restriction, for
+// instance by rrsync). They are not fetched again before the holdoff expires
+// while transient errors are retried at the next iteration.
+type rsyncRestrictions struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+func newRsyncRestrictions() *rsyncRestrictions {
+	return &rsyncRestrictions{
+		until: make(map[string]time.Time),
+	}
+}
+
+func (r *rsyncRestrictions) add(uri string, until time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.until[uri] = until
+}
+
+// Returns the end of the holdoff of uri, expired holdoffs are removed.
+func (r *rsyncRestrictions) restricted(uri string, now time.Time) (time.Time, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	until, ok := r.until[uri]
+	if !ok {
+		return time.Time{}, false
+	}
+	if !now.Before(until) {
+		delete(r.until, uri)
+		return time.Time{}, false
+	}
+	return until, true
+}
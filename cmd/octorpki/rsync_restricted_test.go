@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+
+	syncpki "github.com/cloudflare/cfrpki/sync/lib"
+)
+
+func TestRsyncRestricted(t *testing.T) {
+	defer func(v time.Duration) { *RsyncHoldoff = v }(*RsyncHoldoff)
+	*RsyncHoldoff = time.Hour
+
+	s := NewOctoRPKI(nil, nil)
+	span := opentracing.NoopTracer{}.StartSpan("test")
+	now := time.Now()
+
+	transient := "rsync://transient.example.com/repo"
+	s.rsyncError(transient, "", &syncpki.RsyncError{ExitCode: 10, Category: syncpki.RsyncErrorNetwork, Err: errors.New("exit status 10")}, span)
+	_, ok := s.rsyncRestrictions.restricted(transient, now)
+	assert.False(t, ok)
+
+	restricted := "rsync://restricted.example.com/repo"
+	s.rsyncError(restricted, "", &syncpki.RsyncError{ExitCode: 5, Category: syncpki.RsyncErrorRestricted, Err: errors.New("exit status 5")}, span)
+	until, ok := s.rsyncRestrictions.restricted(restricted, now)
+	assert.True(t, ok)
+	assert.WithinDuration(t, now.Add(time.Hour), until, time.Minute)
+	assert.Equal(t, float64(1), getCounterValue(t, MetricRsyncExitErrors.With(prometheus.Labels{"address": metricAddress(restricted), "category": syncpki.RsyncErrorRestricted})))
+
+	// Fetched again once the holdoff expired
+	_, ok = s.rsyncRestrictions.restricted(restricted, now.Add(2*time.Hour))
+	assert.False(t, ok)
+	_, ok = s.rsyncRestrictions.restricted(restricted, now)
+	assert.False(t, ok)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Behaviors when the signing key cannot be read (-output.sign.key.missing)
+const (
+	SignKeyMissingFail      = "fail"
+	SignKeyMissingRetry     = "retry"
+	SignKeyMissingEphemeral = "ephemeral"
+)
+
+func loadSigningKey(path string) (*ecdsa.PrivateKey, error) {
+	keyBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ReadKey(keyBytes, true)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return key, nil
+}
+
+// Loads the signing keys. When a next key is configured, the output is signed
+// with it while the current key keeps being advertised until the end of the
+// rotation window, letting the consumers update their pins.
+func (s *OctoRPKI) loadSigningKeys(currentPath string, nextPath string, rotationEnd string) error {
+	current, err := loadSigningKey(currentPath)
+	if err != nil {
+		return err
+	}
+	if nextPath == "" {
+		s.Key = current
+		return nil
+	}
+
+	next, err := loadSigningKey(nextPath)
+	if err != nil {
+		return err
+	}
+	end, err := time.Parse(time.RFC3339, rotationEnd)
+	if err != nil {
+		return fmt.Errorf("invalid end of the key rotation %q: %v", rotationEnd, err)
+	}
+	s.Key = next
+	s.PreviousKey = &current.PublicKey
+	s.PreviousKeyUntil = end
+	return nil
+}
+
+// Retries loading the signing keys until they are readable or the wait is
+// over, for keys provisioned after startup. A key file being written can also
+// fail to parse until it is complete.
+func (s *OctoRPKI) waitSigningKeys(currentPath string, nextPath string, rotationEnd string, wait time.Duration, interval time.Duration) error {
+	deadline := time.Now().Add(wait)
+	for {
+		err := s.loadSigningKeys(currentPath, nextPath, rotationEnd)
+		if err == nil || !time.Now().Add(interval).Before(deadline) {
+			return err
+		}
+		log.Warnf("Unable to load the signing key, retrying in %v: %v", interval, err)
+		time.Sleep(interval)
+	}
+}
+
+// Signs the output with a key generated at startup, lost on restart. Its
+// public key is logged and served on -http.publickey.
+func (s *OctoRPKI) generateEphemeralKey() error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return err
+	}
+	s.Key = key
+	s.PreviousKey = nil
+	log.Warnf("Signing with an ephemeral key, public key:\n%s", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	return nil
+}
+
+// Loads the signing keys, handling a missing key as configured with
+// -output.sign.key.missing.
+func (s *OctoRPKI) setupSigningKeys(currentPath string, nextPath string, rotationEnd string, missing string, wait time.Duration, interval time.Duration) error {
+	var err error
+	switch missing {
+	case SignKeyMissingFail, SignKeyMissingEphemeral:
+		err = s.loadSigningKeys(currentPath, nextPath, rotationEnd)
+	case SignKeyMissingRetry:
+		err = s.waitSigningKeys(currentPath, nextPath, rotationEnd, wait, interval)
+	default:
+		return fmt.Errorf("-output.sign.key.missing %v is not supported. Choose either %v, %v or %v", missing, SignKeyMissingFail, SignKeyMissingRetry, SignKeyMissingEphemeral)
+	}
+
+	// Only when a key file is missing or unreadable, not for an invalid key
+	var pathErr *os.PathError
+	if err != nil && missing == SignKeyMissingEphemeral && errors.As(err, &pathErr) {
+		log.Warnf("Unable to load the signing key: %v", err)
+		return s.generateEphemeralKey()
+	}
+	return err
+}
+
+// Public keys verifying the output: the signing key, preceded by the
+// previous one during a rotation.
+func (s *OctoRPKI) advertisedKeys(now time.Time) []*ecdsa.PublicKey {
+	keys := make([]*ecdsa.PublicKey, 0, 2)
+	if s.PreviousKey != nil && now.Before(s.PreviousKeyUntil) {
+		keys = append(keys, s.PreviousKey)
+	}
+	if s.Key != nil {
+		keys = append(keys, &s.Key.PublicKey)
+	}
+	return keys
+}
+
+// Serves the PEM encoded public keys verifying the signature of the output.
+func (s *OctoRPKI) ServePublicKey(w http.ResponseWriter, r *http.Request) {
+	keys := s.advertisedKeys(time.Now())
+	if len(keys) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Output is not signed"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	for _, key := range keys {
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		pem.Encode(w, &pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/gortr/prefixfile"
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestSigningKey(t *testing.T, path string) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	der, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600))
+	return key
+}
+
+func TestSigningKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+	currentPath := filepath.Join(dir, "current.pem")
+	nextPath := filepath.Join(dir, "next.pem")
+	current := writeTestSigningKey(t, currentPath)
+	next := writeTestSigningKey(t, nextPath)
+
+	s := NewOctoRPKI(nil, nil)
+	assert.NotNil(t, s.loadSigningKeys(currentPath, nextPath, "tomorrow"))
+
+	end := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	assert.Nil(t, s.loadSigningKeys(currentPath, nextPath, end.Format(time.RFC3339)))
+
+	// The output is signed with the next key
+	roaList := &prefixfile.ROAList{Data: []prefixfile.ROAJson{{Prefix: "192.0.2.0/24", Length: 24, ASN: "AS64500"}}}
+	s.signROAList(roaList, opentracing.NoopTracer{}.StartSpan("test"))
+	valid, _, err := roaList.CheckFile(&next.PublicKey)
+	assert.Nil(t, err)
+	assert.True(t, valid)
+
+	// Both keys are advertised during the overlap window
+	assert.Equal(t, []*ecdsa.PublicKey{&current.PublicKey, &next.PublicKey}, s.advertisedKeys(end.Add(-time.Minute)))
+	assert.Equal(t, []*ecdsa.PublicKey{&next.PublicKey}, s.advertisedKeys(end))
+
+	rec := httptest.NewRecorder()
+	s.ServePublicKey(rec, httptest.NewRequest("GET", "/publickey", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rest := rec.Body.Bytes()
+	served := make([]interface{}, 0)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		assert.Nil(t, err)
+		served = append(served, key)
+	}
+	assert.Equal(t, []interface{}{&current.PublicKey, &next.PublicKey}, served)
+
+	// Without rotation only the current key is used
+	s = NewOctoRPKI(nil, nil)
+	rec = httptest.NewRecorder()
+	s.ServePublicKey(rec, httptest.NewRequest("GET", "/publickey", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	assert.Nil(t, s.loadSigningKeys(currentPath, "", ""))
+	assert.Equal(t, []*ecdsa.PublicKey{&current.PublicKey}, s.advertisedKeys(time.Now()))
+}
+
+func TestSigningKeyMissing(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "private.pem")
+
+	s := NewOctoRPKI(nil, nil)
+	assert.NotNil(t, s.setupSigningKeys(path, "", "", SignKeyMissingFail, 0, 0))
+	assert.NotNil(t, s.setupSigningKeys(path, "", "", "ignore", 0, 0))
+	assert.Nil(t, s.Key)
+
+	// The key is written while waiting for it
+	written := make(chan *ecdsa.PrivateKey, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		key := writeTestSigningKey(t, path+".tmp")
+		os.Rename(path+".tmp", path)
+		written <- key
+	}()
+	assert.Nil(t, s.setupSigningKeys(path, "", "", SignKeyMissingRetry, 5*time.Second, 10*time.Millisecond))
+	assert.Equal(t, <-written, s.Key)
+
+	s = NewOctoRPKI(nil, nil)
+	t1 := time.Now()
+	assert.NotNil(t, s.setupSigningKeys(filepath.Join(dir, "missing.pem"), "", "", SignKeyMissingRetry, 50*time.Millisecond, 10*time.Millisecond))
+	assert.Less(t, time.Since(t1), time.Second)
+	assert.Nil(t, s.Key)
+
+	// An ephemeral key is only generated when the file cannot be read
+	assert.Nil(t, s.setupSigningKeys(filepath.Join(dir, "missing.pem"), "", "", SignKeyMissingEphemeral, 0, 0))
+	assert.NotNil(t, s.Key)
+	assert.Equal(t, []*ecdsa.PublicKey{&s.Key.PublicKey}, s.advertisedKeys(time.Now()))
+
+	invalid := filepath.Join(dir, "invalid.pem")
+	assert.Nil(t, os.WriteFile(invalid, []byte("invalid"), 0600))
+	s = NewOctoRPKI(nil, nil)
+	assert.NotNil(t, s.setupSigningKeys(invalid, "", "", SignKeyMissingEphemeral, 0, 0))
+	assert.Nil(t, s.Key)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"time"
+
+	"github.com/cloudflare/gortr/prefixfile"
+)
+
+// Results of a validation. A snapshot is published at once at the end of the
+// validation and never modified afterwards, so readers get consistent values.
+type validationSnapshot struct {
+	TALNames           []string // name of each validated TAL, in the order of InfoAuthorities
+	InfoAuthorities    [][]SIA
+	ROAList            *prefixfile.ROAList
+	ROAsTALs           []ROAsTAL
+	DuplicatesRemoved  int
+	TALTimings         []TALTiming
+	LastValidation     time.Time
+	ValidationDuration time.Duration
+	Topology           *Topology
+	RSCs               []InfoRSC
+	Holdings           []CAHoldings
+	TALRoots           [][]InfoTALRoot // root certificates of each TAL
+	RRDPConflicts      []RRDPMappingConflict
+	ValidationMessages []ValidationMessage
+	ValidObjects       map[string]string // URI of the valid objects -> TA
+	VRPSources         vrpSources
+}
+
+func newValidationSnapshot() *validationSnapshot {
+	return &validationSnapshot{
+		TALNames:        make([]string, 0),
+		InfoAuthorities: make([][]SIA, 0),
+		ROAList:         newROAList(),
+		ROAsTALs:        make([]ROAsTAL, 0),
+		TALTimings:      make([]TALTiming, 0),
+		Topology:        newTopology(),
+		RSCs:            make([]InfoRSC, 0),
+		Holdings:        make([]CAHoldings, 0),
+		TALRoots:        make([][]InfoTALRoot, 0),
+		RRDPConflicts:   make([]RRDPMappingConflict, 0),
+		ValidObjects:    make(map[string]string),
+	}
+}
+
+func (s *OctoRPKI) getSnapshot() *validationSnapshot {
+	return s.snapshot.Load()
+}
+
+func (s *OctoRPKI) setSnapshot(snapshot *validationSnapshot) {
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+
+	s.snapshot.Store(snapshot)
+}
+
+// Replaces a field by publishing a modified copy of the current snapshot
+func (s *OctoRPKI) updateSnapshot(update func(*validationSnapshot)) {
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+
+	snapshot := *s.snapshot.Load()
+	update(&snapshot)
+	s.snapshot.Store(&snapshot)
+}
+
+func (s *OctoRPKI) setROAList(roaList *prefixfile.ROAList) {
+	s.updateSnapshot(func(snapshot *validationSnapshot) {
+		snapshot.ROAList = roaList
+	})
+}
+
+func (s *OctoRPKI) getROAList() *prefixfile.ROAList {
+	return s.getSnapshot().ROAList
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+	"github.com/cloudflare/gortr/prefixfile"
+	"github.com/stretchr/testify/assert"
+)
+
+// Builds the results of a validation where every count equals n
+func testSnapshot(n int) *validationSnapshot {
+	roaList := newROAList()
+	sias := make([]SIA, n)
+	for i := 0; i < n; i++ {
+		roaList.Data = append(roaList.Data, prefixfile.ROAJson{Prefix: fmt.Sprintf("10.%d.0.0/16", i), Length: 16, ASN: "AS65001", TA: "A"})
+		sias[i] = SIA{Rsync: fmt.Sprintf("rsync://rpki-%d.example.com/repo", i)}
+	}
+	return &validationSnapshot{
+		TALNames:           []string{"A"},
+		InfoAuthorities:    [][]SIA{sias},
+		ROAList:            roaList,
+		ROAsTALs:           []ROAsTAL{{TA: "A", Count: n}},
+		DuplicatesRemoved:  n,
+		TALTimings:         []TALTiming{{TA: "A", ExploreDuration: float64(n)}},
+		LastValidation:     time.Unix(int64(n), 0),
+		ValidationDuration: time.Duration(n) * time.Second,
+	}
+}
+
+func TestServeInfoSnapshot(t *testing.T) {
+	s := NewOctoRPKI([]*pki.PKIFile{{Path: "a.tal", Type: pki.TYPE_TAL}}, []string{"A"})
+
+	rec := httptest.NewRecorder()
+	s.ServeInfo(rec, httptest.NewRequest("GET", "/infos", nil))
+	var ir InfoResult
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &ir))
+	assert.Equal(t, 0, ir.ROACount)
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for n := 1; n <= 200; n++ {
+			s.setSnapshot(testSnapshot(n))
+		}
+		close(done)
+	}()
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				rec := httptest.NewRecorder()
+				s.ServeInfo(rec, httptest.NewRequest("GET", "/infos", nil))
+				var ir InfoResult
+				assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &ir))
+				if ir.ROACount == 0 {
+					continue
+				}
+
+				// Every field comes from the same validation
+				n := ir.ROACount
+				assert.Len(t, ir.TAs, 1)
+				assert.Equal(t, "A", ir.TAs[0].TA)
+				assert.Len(t, ir.TAs[0].Sia, n)
+				assert.Equal(t, []ROAsTAL{{TA: "A", Count: n}}, ir.ROAsTALs)
+				assert.Equal(t, n, ir.DuplicatesRemoved)
+				assert.Equal(t, float64(n), ir.TALTimings[0].ExploreDuration)
+				assert.Equal(t, n, ir.LastValidation)
+				assert.Equal(t, float64(n), ir.ValidationDuration)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Updating a single field keeps the others
+	s.setROAList(newROAList())
+	snapshot := s.getSnapshot()
+	assert.Len(t, snapshot.ROAList.Data, 0)
+	assert.Len(t, snapshot.InfoAuthorities[0], 200)
+}
+
+func TestServeInfoReload(t *testing.T) {
+	s := NewOctoRPKI([]*pki.PKIFile{{Path: "a.tal", Type: pki.TYPE_TAL}}, []string{"A"})
+	s.setSnapshot(testSnapshot(1))
+
+	// The TALs are replaced before the next validation
+	s.pendingReload.Store(&talReload{
+		tals:      []*pki.PKIFile{{Path: "b.tal", Type: pki.TYPE_TAL}, {Path: "a.tal", Type: pki.TYPE_TAL}},
+		talNames:  []string{"B", "A"},
+		intervals: []time.Duration{time.Hour, time.Hour},
+	})
+	assert.True(t, s.applyReload())
+
+	// The results of the previous validation keep their names
+	rec := httptest.NewRecorder()
+	s.ServeInfo(rec, httptest.NewRequest("GET", "/infos", nil))
+	var ir InfoResult
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &ir))
+	assert.Len(t, ir.TAs, 1)
+	assert.Equal(t, "A", ir.TAs[0].TA)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/cloudflare/gortr/prefixfile"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// Loads the ECDSA public keys of a PEM file, such as the one served on
+// -http.publickey during a key rotation.
+func loadVerifyingKeys(path string) ([]*ecdsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*ecdsa.PublicKey, 0)
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("%s: public key is not ECDSA", path)
+		}
+		keys = append(keys, ecKey)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: %v", path, errKeyNotParsed)
+	}
+	return keys, nil
+}
+
+// Reads a ROA list in the GoRTR format, compressed with gzip or not. When keys
+// are set, the list must be signed by one of them.
+func readStaticROAList(path string, keys []*ecdsa.PublicKey) (*prefixfile.ROAList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		data, err = io.ReadAll(gz)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	roaList := newROAList()
+	err = json.Unmarshal(data, roaList)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode %s: %v", path, err)
+	}
+	if len(keys) == 0 {
+		return roaList, nil
+	}
+
+	if roaList.Metadata.Signature == "" {
+		return nil, fmt.Errorf("%s is not signed", path)
+	}
+	for _, key := range keys {
+		valid, validDate, err := roaList.CheckFile(key)
+		if err == nil && valid && validDate {
+			return roaList, nil
+		}
+	}
+	return nil, fmt.Errorf("signature of %s does not match the public keys", path)
+}
+
+// ROA list file served by -mode=serve-static.
+type staticOutput struct {
+	path string
+	keys []*ecdsa.PublicKey
+
+	// Last file loaded, or which failed to load
+	modTime time.Time
+	size    int64
+}
+
+// Publishes a ROA list which was validated elsewhere.
+func (s *OctoRPKI) setStaticROAList(roaList *prefixfile.ROAList) {
+	generated := time.Unix(int64(roaList.Metadata.Generated), 0)
+	counts := make(map[string]int)
+	for _, roa := range roaList.Data {
+		counts[roa.TA]++
+	}
+
+	snapshot := newValidationSnapshot()
+	snapshot.ROAList = roaList
+	snapshot.LastValidation = generated
+	for _, ta := range sortedKeys(counts) {
+		snapshot.ROAsTALs = append(snapshot.ROAsTALs, ROAsTAL{TA: ta, Count: counts[ta]})
+	}
+	s.LastComputed = generated
+	s.setSnapshot(snapshot)
+
+	s.Stable.Store(true)
+	s.HasPreviousStable.Store(true)
+	s.LastStable.Store(generated.Unix())
+	MetricState.Set(1)
+	MetricLastStableValidation.Set(float64(generated.Unix()))
+	MetricROAsCount.Reset()
+	for ta, count := range counts {
+		MetricROAsCount.With(prometheus.Labels{"ta": ta}).Set(float64(count))
+	}
+}
+
+// Loads the file when it changed since the last call. A file which fails to
+// load is not retried until it changes, the previous list is still served.
+func (s *OctoRPKI) reloadStaticOutput(o *staticOutput) (bool, error) {
+	info, err := os.Stat(o.path)
+	if err != nil {
+		return false, err
+	}
+	if info.ModTime().Equal(o.modTime) && info.Size() == o.size {
+		return false, nil
+	}
+	o.modTime = info.ModTime()
+	o.size = info.Size()
+
+	roaList, err := readStaticROAList(o.path, o.keys)
+	if err != nil {
+		return false, err
+	}
+	s.setStaticROAList(roaList)
+	return true, nil
+}
+
+func (s *OctoRPKI) watchStaticOutput(o *staticOutput, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		reloaded, err := s.reloadStaticOutput(o)
+		if err != nil {
+			log.Errorf("Unable to reload the static ROA list: %v", err)
+		} else if reloaded {
+			log.Infof("Reloaded the static ROA list %s (%d VRPs)", o.path, len(s.getROAList().Data))
+		}
+	}
+}
+
+// Serves a ROA list file without fetching nor validating, reloading it when
+// it changes (-mode=serve-static).
+func serveStatic() {
+	outputTargets, err := parseOutputTargets(*Output)
+	if err != nil {
+		log.Fatal(err)
+	}
+	roaPath, ok := firstFileTarget(outputTargets)
+	if !ok {
+		roaPath = "output.json"
+	}
+
+	o := &staticOutput{
+		path: *StaticFile,
+	}
+	if o.path == "" && !ok {
+		log.Fatal("-static.file is required when -output.roa has no file")
+	} else if o.path == "" {
+		o.path = roaPath
+	}
+	if *StaticPublicKey != "" {
+		o.keys, err = loadVerifyingKeys(*StaticPublicKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else if *Sign {
+		log.Fatal("-mode=serve-static requires -static.publickey to verify the ROA list, or -output.sign=false")
+	}
+
+	s := NewOctoRPKI(nil, nil)
+	_, err = s.reloadStaticOutput(o)
+	if err != nil {
+		log.Fatalf("Unable to load the static ROA list: %v", err)
+	}
+	log.Infof("Serving the static ROA list %s (%d VRPs)", o.path, len(s.getROAList().Data))
+
+	if *StaticInterval > 0 {
+		go s.watchStaticOutput(o, *StaticInterval)
+	}
+	s.Serve(*Addr, roaPath, *MetricsPath, *InfoPath, *HealthPath, *CorsOrigins, *CorsCreds)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/gortr/prefixfile"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeStaticROAList(t *testing.T, path string, key *ecdsa.PrivateKey, generated int, roas []prefixfile.ROAJson) {
+	roaList := &prefixfile.ROAList{
+		Metadata: prefixfile.MetaData{
+			Counts:    len(roas),
+			Generated: generated,
+		},
+		Data: roas,
+	}
+	var err error
+	roaList.Metadata.SignatureDate, roaList.Metadata.Signature, err = roaList.Sign(key)
+	assert.Nil(t, err)
+
+	data, err := json.Marshal(roaList)
+	assert.Nil(t, err)
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(data)
+	assert.Nil(t, gz.Close())
+	assert.Nil(t, os.WriteFile(path, buf.Bytes(), 0600))
+}
+
+func TestServeStatic(t *testing.T) {
+	dir := t.TempDir()
+	key := writeTestSigningKey(t, filepath.Join(dir, "private.pem"))
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	assert.Nil(t, err)
+	publicKey := filepath.Join(dir, "public.pem")
+	assert.Nil(t, os.WriteFile(publicKey, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600))
+
+	keys, err := loadVerifyingKeys(publicKey)
+	assert.Nil(t, err)
+	assert.Len(t, keys, 1)
+
+	path := filepath.Join(dir, "output.json.gz")
+	generated := time.Now().Add(-time.Minute).Truncate(time.Second)
+	writeStaticROAList(t, path, key, int(generated.Unix()), []prefixfile.ROAJson{
+		{Prefix: "192.0.2.0/24", Length: 24, ASN: "AS65001", TA: "Example"},
+	})
+
+	s := NewOctoRPKI(nil, nil)
+	o := &staticOutput{path: path, keys: keys}
+	reloaded, err := s.reloadStaticOutput(o)
+	assert.Nil(t, err)
+	assert.True(t, reloaded)
+
+	serve := func() (int, string) {
+		w := httptest.NewRecorder()
+		s.ServeROAs(w, httptest.NewRequest("GET", "/output.json", nil))
+		return w.Code, w.Body.String()
+	}
+	code, body := serve()
+	assert.Equal(t, 200, code)
+	assert.Contains(t, body, "192.0.2.0/24")
+	assert.Contains(t, body, `"signature"`)
+	assert.Equal(t, generated.Unix(), s.LastStable.Load())
+
+	w := httptest.NewRecorder()
+	s.ServeInfo(w, httptest.NewRequest("GET", "/infos", nil))
+	var info InfoResult
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &info))
+	assert.Equal(t, 1, info.ROACount)
+	assert.Equal(t, []ROAsTAL{{TA: "Example", Count: 1}}, info.ROAsTALs)
+
+	// Unchanged file
+	reloaded, err = s.reloadStaticOutput(o)
+	assert.Nil(t, err)
+	assert.False(t, reloaded)
+
+	// The synced file is replaced
+	writeStaticROAList(t, path, key, int(generated.Unix())+30, []prefixfile.ROAJson{
+		{Prefix: "198.51.100.0/24", Length: 24, ASN: "AS65002", TA: "Example"},
+	})
+	later := time.Now().Add(time.Minute)
+	assert.Nil(t, os.Chtimes(path, later, later))
+	reloaded, err = s.reloadStaticOutput(o)
+	assert.Nil(t, err)
+	assert.True(t, reloaded)
+	_, body = serve()
+	assert.Contains(t, body, "198.51.100.0/24")
+	assert.NotContains(t, body, "192.0.2.0/24")
+
+	// A list signed by another key is refused and the previous one is served
+	other := writeTestSigningKey(t, filepath.Join(dir, "other.pem"))
+	writeStaticROAList(t, path, other, int(generated.Unix())+60, []prefixfile.ROAJson{
+		{Prefix: "203.0.113.0/24", Length: 24, ASN: "AS65003", TA: "Example"},
+	})
+	later = later.Add(time.Minute)
+	assert.Nil(t, os.Chtimes(path, later, later))
+	reloaded, err = s.reloadStaticOutput(o)
+	assert.NotNil(t, err)
+	assert.False(t, reloaded)
+	_, body = serve()
+	assert.Contains(t, body, "198.51.100.0/24")
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Metrics pushed to StatsD at the end of each iteration. Gauges are sent as
+// is, counters as the increase since the previous push.
+var statsdMetrics = map[string]bool{
+	"roas":                   true,
+	"state":                  true,
+	"last_stable_validation": true,
+	"rrdp_errors":            true,
+	"rsync_errors":           true,
+	"unsafe_paths_rejected":  true,
+	"cms_decode_failures":    true,
+}
+
+// Keeps the packets under the usual MTU
+const statsdMaxPacket = 1432
+
+var statsdInvalid = regexp.MustCompile(`[^a-zA-Z0-9_\-]+`)
+
+type statsdExporter struct {
+	conn   net.Conn
+	prefix string
+
+	counters   map[string]float64 // last value of the counters
+	countersMu sync.Mutex
+}
+
+// Pushes the operation durations as timings when set.
+var statsd *statsdExporter
+
+func newStatsdExporter(addr string, prefix string) (*statsdExporter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to use StatsD address %v: %v", addr, err)
+	}
+	return &statsdExporter{
+		conn:     conn,
+		prefix:   prefix,
+		counters: make(map[string]float64),
+	}, nil
+}
+
+// Name of a metric: prefix.metric.label-values, labels sorted by name.
+func (e *statsdExporter) name(metric string, labels []*dto.LabelPair) string {
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].GetName() < labels[j].GetName()
+	})
+	parts := make([]string, 0, len(labels)+2)
+	if e.prefix != "" {
+		parts = append(parts, e.prefix)
+	}
+	parts = append(parts, metric)
+	for _, label := range labels {
+		parts = append(parts, statsdInvalid.ReplaceAllString(label.GetValue(), "_"))
+	}
+	return strings.Join(parts, ".")
+}
+
+func (e *statsdExporter) send(lines []string) {
+	var packet bytes.Buffer
+	flush := func() {
+		if packet.Len() == 0 {
+			return
+		}
+		if _, err := e.conn.Write(packet.Bytes()); err != nil {
+			log.Debugf("Unable to send metrics to StatsD: %v", err)
+		}
+		packet.Reset()
+	}
+
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+len(line)+1 > statsdMaxPacket {
+			flush()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	flush()
+}
+
+func (e *statsdExporter) timing(operation string, duration time.Duration) {
+	e.send([]string{fmt.Sprintf("%s:%d|ms", e.name("operation_time", []*dto.LabelPair{{Value: &operation}}), duration.Milliseconds())})
+}
+
+// Sends the selected metrics of the gatherer.
+func (e *statsdExporter) push(gatherer prometheus.Gatherer) error {
+	families, err := gatherer.Gather()
+	if err != nil {
+		return err
+	}
+
+	e.countersMu.Lock()
+	defer e.countersMu.Unlock()
+
+	lines := make([]string, 0)
+	for _, family := range families {
+		if !statsdMetrics[family.GetName()] {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			name := e.name(family.GetName(), metric.GetLabel())
+			switch family.GetType() {
+			case dto.MetricType_GAUGE:
+				lines = append(lines, fmt.Sprintf("%s:%v|g", name, metric.GetGauge().GetValue()))
+			case dto.MetricType_COUNTER:
+				value := metric.GetCounter().GetValue()
+				if delta := value - e.counters[name]; delta > 0 {
+					lines = append(lines, fmt.Sprintf("%s:%v|c", name, delta))
+				}
+				e.counters[name] = value
+			}
+		}
+	}
+	e.send(lines)
+	return nil
+}
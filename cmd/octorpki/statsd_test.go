@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatsdExporter(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+
+	receive := func() []string {
+		buf := make([]byte, 65536)
+		listener.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := listener.ReadFrom(buf)
+		assert.Nil(t, err)
+		return strings.Split(string(buf[:n]), "\n")
+	}
+
+	exporter, err := newStatsdExporter(listener.LocalAddr().String(), "octorpki")
+	assert.Nil(t, err)
+
+	registry := prometheus.NewRegistry()
+	roas := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "roas"}, []string{"ta", "ip_version"})
+	unsafePaths := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "unsafe_paths_rejected"}, []string{"source"})
+	other := prometheus.NewGauge(prometheus.GaugeOpts{Name: "not_pushed"})
+	registry.MustRegister(roas, unsafePaths, other)
+
+	roas.With(prometheus.Labels{"ta": "RIPE", "ip_version": "ipv4"}).Set(10)
+	unsafePaths.With(prometheus.Labels{"source": "rrdp"}).Add(2)
+	other.Set(1)
+
+	assert.Nil(t, exporter.push(registry))
+	assert.ElementsMatch(t, []string{
+		"octorpki.roas.ipv4.RIPE:10|g",
+		"octorpki.unsafe_paths_rejected.rrdp:2|c",
+	}, receive())
+
+	// Counters are sent as increments
+	unsafePaths.With(prometheus.Labels{"source": "rrdp"}).Add(3)
+	assert.Nil(t, exporter.push(registry))
+	assert.ElementsMatch(t, []string{
+		"octorpki.roas.ipv4.RIPE:10|g",
+		"octorpki.unsafe_paths_rejected.rrdp:3|c",
+	}, receive())
+
+	defer func() { statsd = nil }()
+	statsd = exporter
+	observeOperation("rrdp", 1500*time.Millisecond, opentracing.NoopTracer{}.StartSpan("test"))
+	assert.Equal(t, []string{"octorpki.operation_time.rrdp:1500|ms"}, receive())
+}
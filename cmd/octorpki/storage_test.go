@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+
+	syncpki "github.com/cloudflare/cfrpki/sync/lib"
+)
+
+func TestValidationMemoryStorage(t *testing.T) {
+	defer func(v bool) { *Sign = v }(*Sign)
+	*Sign = false
+
+	basepath := t.TempDir()
+	tals := []*pki.PKIFile{
+		createTestRepository(t, basepath, "rpki.example.com", []testROA{
+			{ASN: 65001, Prefix: "192.0.2.0/24", MaxLength: 24},
+		}),
+	}
+
+	// Moves the repository written by the fixture to memory
+	storage := syncpki.NewMemoryStorage()
+	s := &OctoRPKI{storage: storage}
+	err := filepath.Walk(filepath.Join(basepath, "rpki.example.com"), func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(basepath, path)
+		if err := s.WriteRsyncFileOnDisk("rsync://"+filepath.ToSlash(rel), data); err != nil {
+			return err
+		}
+		return os.Remove(path)
+	})
+	assert.Nil(t, err)
+
+	result := runValidation(basepath, tals, []string{"Memory"}, 1, func(s *OctoRPKI) {
+		s.storage = storage
+		s.Fetcher.SetStorage(storage)
+	})
+	assert.Len(t, result.ROAs, 1)
+
+	// Nothing was written on disk
+	_, err = os.Stat(filepath.Join(basepath, "rpki.example.com", "repo", "root.cer"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+// Serves the files of the repository written by the fixture as an RRDP
+// snapshot, and removes them from basepath.
+func serveTestRepositoryRRDP(t *testing.T, basepath, host string) *httptest.Server {
+	var snapshot strings.Builder
+	err := filepath.Walk(filepath.Join(basepath, host), func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(basepath, path)
+		fmt.Fprintf(&snapshot, "<publish uri=\"rsync://%s\">%s</publish>\n", filepath.ToSlash(rel), base64.StdEncoding.EncodeToString(data))
+		return os.Remove(path)
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, os.RemoveAll(filepath.Join(basepath, host)))
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/notification.xml":
+			fmt.Fprintf(w, `<notification xmlns="http://www.ripe.net/rpki/rrdp" version="1" session_id="session" serial="1">
+<snapshot uri="http://%s/snapshot.xml" hash="00"/>
+</notification>`, r.Host)
+		default:
+			fmt.Fprintf(w, `<snapshot xmlns="http://www.ripe.net/rpki/rrdp" version="1" session_id="session" serial="1">
+%s</snapshot>`, snapshot.String())
+		}
+	}))
+}
+
+func TestMemoryStorageNoDisk(t *testing.T) {
+	defer func(v bool) { *Sign = v }(*Sign)
+	*Sign = false
+	defer func(v string) { *CacheStorage = v }(*CacheStorage)
+	*CacheStorage = syncpki.StorageMemory
+	defer func(v string) { *RRDPFile = v }(*RRDPFile)
+	*RRDPFile = filepath.Join(t.TempDir(), "rrdp.json")
+	defer func(v string) { *Basepath = v }(*Basepath)
+	*Basepath = t.TempDir()
+
+	fixture := t.TempDir()
+	tals := []*pki.PKIFile{
+		createTestRepository(t, fixture, "rpki.example.com", []testROA{
+			{ASN: 65001, Prefix: "192.0.2.0/24", MaxLength: 24},
+		}),
+	}
+	ts := serveTestRepositoryRRDP(t, fixture, "rpki.example.com")
+	defer ts.Close()
+
+	storage := syncpki.NewMemoryStorage()
+	result := runValidation(*Basepath, tals, []string{"Memory"}, 1, func(s *OctoRPKI) {
+		s.storage = storage
+		s.Fetcher.SetStorage(storage)
+		s.setRRDPFetch(ts.URL+"/notification.xml", "rsync://rpki.example.com/repo/")
+		s.doRRDP(context.Background(), opentracing.NoopTracer{}.StartSpan("test"))
+	})
+	assert.Len(t, result.ROAs, 1)
+	assert.Equal(t, float64(storage.Size()), getGaugeValue(t, MetricCacheMemory))
+
+	// Neither the repository nor the RRDP state was written on disk
+	entries, err := os.ReadDir(*Basepath)
+	assert.Nil(t, err)
+	assert.Empty(t, entries)
+	_, err = os.Stat(*RRDPFile)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestMemoryStorageFull(t *testing.T) {
+	defer func(v string) { *CacheStorage = v }(*CacheStorage)
+	*CacheStorage = syncpki.StorageMemory
+
+	fixture := t.TempDir()
+	createTestRepository(t, fixture, "rpki.example.com", []testROA{
+		{ASN: 65001, Prefix: "192.0.2.0/24", MaxLength: 24},
+	})
+	ts := serveTestRepositoryRRDP(t, fixture, "rpki.example.com")
+	defer ts.Close()
+
+	storage := syncpki.NewMemoryStorage()
+	storage.MaxSize = 100
+	s := NewOctoRPKI(nil, nil)
+	s.storage = storage
+	s.fetchRRDP(context.Background(), ts.URL+"/notification.xml", "rsync://rpki.example.com/repo/", opentracing.NoopTracer{}.StartSpan("test"))
+	assert.LessOrEqual(t, storage.Size(), int64(100))
+
+	err := s.ReceiveRRDPFileCallback(ts.URL+"/notification.xml", "", "rsync://rpki.example.com/repo/large.roa", make([]byte, 200), false, true, 1)
+	assert.True(t, errors.Is(err, syncpki.ErrStorageFull))
+	assert.Contains(t, err.Error(), "-cache.memory.max")
+}
+
+func TestCheckMemoryStorage(t *testing.T) {
+	defer func(rrdp, failover bool) { *RRDP, *RRDPFailover = rrdp, failover }(*RRDP, *RRDPFailover)
+
+	// The TAL of the fixture only has an rsync URI
+	dir := t.TempDir()
+	rsync := createTestRepository(t, dir, "rpki.example.com", nil)
+	data, err := os.ReadFile(rsync.Path)
+	assert.Nil(t, err)
+	https := &pki.PKIFile{Path: filepath.Join(dir, "https.tal"), Type: pki.TYPE_TAL}
+	assert.Nil(t, os.WriteFile(https.Path, append([]byte("https://rpki.example.com/root.cer\n"), data...), 0644))
+	assert.Nil(t, checkTAL(https.Path))
+
+	*RRDP = true
+	*RRDPFailover = false
+	assert.Nil(t, checkMemoryStorage([]*pki.PKIFile{https}, nil))
+	assert.NotNil(t, checkMemoryStorage([]*pki.PKIFile{https, rsync}, nil))
+	assert.NotNil(t, checkMemoryStorage([]*pki.PKIFile{https}, map[string]string{"rpki.example.com": FetchProtocolRsync}))
+
+	*RRDPFailover = true
+	assert.NotNil(t, checkMemoryStorage([]*pki.PKIFile{https}, nil))
+
+	*RRDPFailover = false
+	*RRDP = false
+	assert.NotNil(t, checkMemoryStorage([]*pki.PKIFile{https}, nil))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+
+	librpki "github.com/cloudflare/cfrpki/validator/lib"
+)
+
+// Root certificate fetched from the URI of a TAL, to confirm which key is in
+// use during a rollover.
+type InfoTALRoot struct {
+	Path          string `json:"path"`
+	Subject       string `json:"subject"`
+	Serial        string `json:"serial"`
+	NotBefore     int    `json:"not-before"`
+	NotAfter      int    `json:"not-after"`
+	SKI           string `json:"ski"`
+	PublicKeyHash string `json:"public-key-sha256"`
+}
+
+// Lists the valid root certificates of a validator, sorted by path.
+func talRoots(validator *pki.Validator) []InfoTALRoot {
+	roots := make([]InfoTALRoot, 0)
+	for _, res := range validator.ValidObjects {
+		if res.Type != pki.TYPE_CER || res.File == nil || res.File.Parent == nil || res.File.Parent.Type != pki.TYPE_TAL {
+			continue
+		}
+		cer, ok := res.Resource.(*librpki.RPKICertificate)
+		if !ok {
+			continue
+		}
+
+		keyHash := sha256.Sum256(cer.Certificate.RawSubjectPublicKeyInfo)
+		roots = append(roots, InfoTALRoot{
+			Path:          res.File.Path,
+			Subject:       cer.Certificate.Subject.String(),
+			Serial:        cer.Certificate.SerialNumber.Text(16),
+			NotBefore:     int(cer.Certificate.NotBefore.Unix()),
+			NotAfter:      int(cer.Certificate.NotAfter.Unix()),
+			SKI:           hex.EncodeToString(cer.Certificate.SubjectKeyId),
+			PublicKeyHash: hex.EncodeToString(keyHash[:]),
+		})
+	}
+
+	sort.Slice(roots, func(i, j int) bool {
+		return roots[i].Path < roots[j].Path
+	})
+	return roots
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+
+	syncpki "github.com/cloudflare/cfrpki/sync/lib"
+	librpki "github.com/cloudflare/cfrpki/validator/lib"
+)
+
+func TestServeInfoTALRoots(t *testing.T) {
+	defer func(v bool) { *Sign = v }(*Sign)
+	*Sign = false
+
+	basepath := t.TempDir()
+	tal := createTestRepository(t, basepath, "rpki.example.com", []testROA{
+		{ASN: 65001, Prefix: "192.0.2.0/24", MaxLength: 24},
+	})
+
+	s := NewOctoRPKI([]*pki.PKIFile{tal}, []string{"Fixture"})
+	s.Fetcher = syncpki.NewLocalFetch(basepath)
+	s.mainValidation(context.Background(), opentracing.NoopTracer{}.StartSpan("test"))
+
+	rec := httptest.NewRecorder()
+	s.ServeInfo(rec, httptest.NewRequest("GET", "/infos", nil))
+	var info InfoResult
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &info))
+	assert.Len(t, info.TAs, 1)
+	assert.Len(t, info.TAs[0].Roots, 1)
+
+	data, err := os.ReadFile(tal.Path)
+	assert.Nil(t, err)
+	decoded, err := librpki.DecodeTAL(data)
+	assert.Nil(t, err)
+	spki, err := x509.MarshalPKIXPublicKey(decoded.PublicKey)
+	assert.Nil(t, err)
+	keyHash := sha256.Sum256(spki)
+
+	root := info.TAs[0].Roots[0]
+	assert.Equal(t, "rsync://rpki.example.com/repo/root.cer", root.Path)
+	assert.Equal(t, "CN=rpki.example.com", root.Subject)
+	assert.Equal(t, "1", root.Serial)
+	assert.Less(t, root.NotBefore, root.NotAfter)
+	assert.Len(t, root.SKI, 40)
+	assert.Equal(t, hex.EncodeToString(keyHash[:]), root.PublicKeyHash)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Parses the comma separated list of refresh intervals (one per TAL).
+// Empty entries and missing trailing entries use the default interval.
+func parseTALRefresh(value string, count int, def time.Duration) ([]time.Duration, error) {
+	intervals := make([]time.Duration, count)
+	for i := range intervals {
+		intervals[i] = def
+	}
+
+	if value == "" {
+		return intervals, nil
+	}
+
+	entries := strings.Split(value, ",")
+	if len(entries) > count {
+		return nil, fmt.Errorf("%d refresh intervals given for %d TALs", len(entries), count)
+	}
+
+	for i, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		interval, err := time.ParseDuration(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid refresh interval %q: %v", entry, err)
+		}
+		if interval <= 0 {
+			return nil, fmt.Errorf("refresh interval %q must be positive", entry)
+		}
+		intervals[i] = interval
+	}
+
+	return intervals, nil
+}
+
+// Decides which TALs have their repositories fetched on a validation cycle.
+// TALs that are not due are still validated from the local cache.
+type talScheduler struct {
+	refresh     time.Duration // used when there are no TALs
+	intervals   []time.Duration
+	lastRefresh []time.Time
+	due         []bool
+}
+
+func newTALScheduler(refresh time.Duration, intervals []time.Duration) *talScheduler {
+	due := make([]bool, len(intervals))
+	for i := range due {
+		due[i] = true
+	}
+
+	return &talScheduler{
+		refresh:     refresh,
+		intervals:   intervals,
+		lastRefresh: make([]time.Time, len(intervals)),
+		due:         due,
+	}
+}
+
+// Selects the TALs to refresh for the cycle starting at now.
+func (t *talScheduler) begin(now time.Time) {
+	for i := range t.intervals {
+		t.due[i] = t.lastRefresh[i].IsZero() || !now.Before(t.lastRefresh[i].Add(t.intervals[i]))
+	}
+}
+
+func (t *talScheduler) isDue(i int) bool {
+	if i < 0 || i >= len(t.due) {
+		return true
+	}
+	return t.due[i]
+}
+
+// Records the refresh of the due TALs and returns the delay
+// until the next TAL is due.
+func (t *talScheduler) finish(now time.Time) time.Duration {
+	next := t.refresh
+	for i := range t.intervals {
+		if t.due[i] {
+			t.lastRefresh[i] = now
+		}
+
+		wait := t.lastRefresh[i].Add(t.intervals[i]).Sub(now)
+		if wait < 0 {
+			wait = 0
+		}
+		if i == 0 || wait < next {
+			next = wait
+		}
+	}
+
+	return next
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTALRefresh(t *testing.T) {
+	intervals, err := parseTALRefresh("", 3, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, []time.Duration{time.Minute, time.Minute, time.Minute}, intervals)
+
+	intervals, err = parseTALRefresh("5m,,1h", 3, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, []time.Duration{5 * time.Minute, time.Minute, time.Hour}, intervals)
+
+	intervals, err = parseTALRefresh("5m", 2, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, []time.Duration{5 * time.Minute, time.Minute}, intervals)
+
+	_, err = parseTALRefresh("5m,5m", 1, time.Minute)
+	assert.NotNil(t, err)
+
+	_, err = parseTALRefresh("abc", 1, time.Minute)
+	assert.NotNil(t, err)
+
+	_, err = parseTALRefresh("-5m", 1, time.Minute)
+	assert.NotNil(t, err)
+}
+
+func TestTALSchedulerStaggered(t *testing.T) {
+	ts := newTALScheduler(time.Minute, []time.Duration{10 * time.Minute, 30 * time.Minute})
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	steps := []struct {
+		elapsed  time.Duration
+		due      []bool
+		nextWait time.Duration
+	}{
+		{0, []bool{true, true}, 10 * time.Minute},
+		{10 * time.Minute, []bool{true, false}, 10 * time.Minute},
+		{20 * time.Minute, []bool{true, false}, 10 * time.Minute},
+		{30 * time.Minute, []bool{true, true}, 10 * time.Minute},
+		// A long validation delays the next refresh of the fast TAL only
+		{45 * time.Minute, []bool{true, false}, 10 * time.Minute},
+	}
+
+	for _, step := range steps {
+		now := start.Add(step.elapsed)
+		ts.begin(now)
+		for i, due := range step.due {
+			assert.Equal(t, due, ts.isDue(i), "TAL %d at %v", i, step.elapsed)
+		}
+		assert.Equal(t, step.nextWait, ts.finish(now), "wait at %v", step.elapsed)
+	}
+}
+
+func TestTALSchedulerNoTALs(t *testing.T) {
+	ts := newTALScheduler(time.Minute, nil)
+	ts.begin(time.Now())
+	assert.True(t, ts.isDue(0))
+	assert.Equal(t, time.Minute, ts.finish(time.Now()))
+}
+
+func TestIsRepositoryDue(t *testing.T) {
+	s := NewOctoRPKI(nil, nil)
+	s.talScheduler = newTALScheduler(time.Minute, []time.Duration{time.Minute, time.Hour})
+	s.repositoryTALs = map[string][]int{
+		"rsync://fast.example.com/repo":   {0},
+		"rsync://slow.example.com/repo":   {1},
+		"rsync://shared.example.com/repo": {0, 1},
+	}
+
+	start := time.Now()
+	s.talScheduler.begin(start)
+	s.talScheduler.finish(start)
+
+	s.talScheduler.begin(start.Add(time.Minute))
+	assert.True(t, s.isRepositoryDue("rsync://fast.example.com/repo"))
+	assert.False(t, s.isRepositoryDue("rsync://slow.example.com/repo"))
+	assert.True(t, s.isRepositoryDue("rsync://shared.example.com/repo"))
+	assert.True(t, s.isRepositoryDue("rsync://new.example.com/repo"))
+}
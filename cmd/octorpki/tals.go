@@ -0,0 +1,274 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+	log "github.com/sirupsen/logrus"
+
+	librpki "github.com/cloudflare/cfrpki/validator/lib"
+)
+
+func checkTAL(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	tal, err := librpki.DecodeTAL(data)
+	if err != nil {
+		return err
+	}
+	if len(tal.URI) == 0 {
+		return fmt.Errorf("no URI")
+	}
+	return nil
+}
+
+// Loads the TAL files. Invalid TALs are skipped (with their name) when skipInvalid
+// is set, otherwise they are kept and will fail during validation. Also returns
+// the indices of the kept TALs in paths. A name must be given for every TAL.
+func loadTALs(paths []string, names []string, skipInvalid bool) ([]*pki.PKIFile, []string, []int, error) {
+	if len(names) != len(paths) {
+		return nil, nil, nil, fmt.Errorf("%d TAL names given for %d TALs", len(names), len(paths))
+	}
+
+	tals := make([]*pki.PKIFile, 0, len(paths))
+	kept := make([]int, 0, len(paths))
+	for i, path := range paths {
+		err := checkTAL(path)
+		if err != nil && skipInvalid {
+			log.Errorf("Skipping invalid TAL %s: %v", path, err)
+			continue
+		} else if err != nil {
+			log.Errorf("Invalid TAL %s: %v", path, err)
+		}
+
+		tals = append(tals, &pki.PKIFile{
+			Path: path,
+			Type: pki.TYPE_TAL,
+		})
+		kept = append(kept, i)
+	}
+
+	if len(tals) == 0 && len(paths) > 0 {
+		return nil, nil, nil, fmt.Errorf("no valid TAL")
+	}
+
+	return tals, keptTALValues(names, kept), kept, nil
+}
+
+// Values given per TAL in the -tal.root order, for the TALs kept by loadTALs.
+func keptTALValues[V any](values []V, kept []int) []V {
+	keptValues := make([]V, 0, len(kept))
+	for _, i := range kept {
+		keptValues = append(keptValues, values[i])
+	}
+	return keptValues
+}
+
+// Parses the expected public key hashes of -tal.expected-hashes: TAL names
+// (or paths) and SHA-256 of the public key separated by =, separated by comma.
+func parseTALHashes(value string) (map[string]string, error) {
+	hashes := make(map[string]string)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid TAL hash %q: expected name=hash", entry)
+		}
+		hash := strings.ToLower(strings.TrimSpace(parts[1]))
+		if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("invalid TAL hash %q: expected a hex encoded SHA-256", entry)
+		}
+		hashes[strings.TrimSpace(parts[0])] = hash
+	}
+	return hashes, nil
+}
+
+// SHA-256 of the DER encoded public key of a TAL, as shown in /infos.
+func talKeyHash(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	tal, err := librpki.DecodeTAL(data)
+	if err != nil {
+		return "", err
+	}
+	if tal.Algorithm != x509.RSA {
+		return "", fmt.Errorf("unsupported public key algorithm %v", tal.OID)
+	}
+	key, err := x509.MarshalPKIXPublicKey(tal.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(key)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// Checks the public keys of the TALs against the pinned hashes. Every pinned
+// TAL must be loaded.
+func checkTALHashes(tals []*pki.PKIFile, talNames []string, expected map[string]string) error {
+	pinned := make(map[string]bool, len(expected))
+	for i, tal := range tals {
+		name := tal.Path
+		hash, ok := expected[name]
+		if !ok && len(talNames) == len(tals) {
+			name = talNames[i]
+			hash, ok = expected[name]
+		}
+		if !ok {
+			continue
+		}
+		pinned[name] = true
+
+		keyHash, err := talKeyHash(tal.Path)
+		if err != nil {
+			return fmt.Errorf("unable to hash the public key of TAL %s: %v", name, err)
+		}
+		if keyHash != hash {
+			return fmt.Errorf("public key of TAL %s (%s) does not match the expected hash %s", name, keyHash, hash)
+		}
+	}
+
+	for name := range expected {
+		if !pinned[name] {
+			return fmt.Errorf("no TAL %s to check against the expected hash", name)
+		}
+	}
+	return nil
+}
+
+// TAL set loaded by the reload endpoint, applied at the beginning of the next
+// validation iteration.
+type talReload struct {
+	tals      []*pki.PKIFile
+	talNames  []string
+	intervals []time.Duration
+}
+
+type ReloadTAL struct {
+	TA   string `json:"name"`
+	Path string `json:"path"`
+}
+
+type ReloadResult struct {
+	TALs []ReloadTAL `json:"tals"`
+}
+
+// Re-reads the TAL files given by -tal.root and -tal.name.
+func reloadTALs() (*talReload, error) {
+	rootTALs := strings.Split(*RootTAL, ",")
+	tals, talNames, kept, err := loadTALs(rootTALs, strings.Split(*TALNames, ","), *TALSkipInvalid)
+	if err != nil {
+		return nil, err
+	}
+	if *TALHashes != "" {
+		expected, err := parseTALHashes(*TALHashes)
+		if err != nil {
+			return nil, err
+		}
+		err = checkTALHashes(tals, talNames, expected)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Every TAL is refreshed when the soonest manifest is due
+	intervals := make([]time.Duration, len(tals))
+	if *RefreshMode != RefreshModeManifest {
+		intervals, err = parseTALRefresh(*TALRefresh, len(rootTALs), *Refresh)
+		if err != nil {
+			return nil, err
+		}
+		intervals = keptTALValues(intervals, kept)
+	}
+
+	return &talReload{
+		tals:      tals,
+		talNames:  talNames,
+		intervals: intervals,
+	}, nil
+}
+
+// Name of each TAL, its path when the TALs were given without names. Only the
+// validation goroutine reads the TALs: the other ones use the snapshot.
+func (s *OctoRPKI) talNameList() []string {
+	names := make([]string, len(s.Tals))
+	for i, tal := range s.Tals {
+		names[i] = tal.Path
+		if len(s.TalNames) == len(s.Tals) {
+			names[i] = s.TalNames[i]
+		}
+	}
+	return names
+}
+
+// Replaces the TALs by the ones of the last reload, if any.
+func (s *OctoRPKI) applyReload() bool {
+	reload := s.pendingReload.Swap(nil)
+	if reload == nil {
+		return false
+	}
+
+	s.Tals = reload.tals
+	s.TalNames = reload.talNames
+	s.talScheduler = newTALScheduler(*Refresh, reload.intervals)
+	s.talValidations = make(map[string]*talValidation)
+	log.Infof("Validating with %d reloaded TALs", len(s.Tals))
+	return true
+}
+
+// Reloading needs a token: without it any web page could make a browser swap the
+// TALs with a cross-origin POST.
+func (s *OctoRPKI) ServeReload(w http.ResponseWriter, r *http.Request) {
+	if *ReloadToken == "" || !tokenAuthorized(r, *ReloadToken) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	reload, err := reloadTALs()
+	if err != nil {
+		log.Errorf("Failed to reload TALs: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	s.pendingReload.Store(reload)
+
+	result := ReloadResult{
+		TALs: make([]ReloadTAL, len(reload.tals)),
+	}
+	for i, tal := range reload.tals {
+		talname := tal.Path
+		if len(reload.talNames) == len(reload.tals) {
+			talname = reload.talNames[i]
+		}
+		result.TALs[i] = ReloadTAL{
+			TA:   talname,
+			Path: tal.Path,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.Encode(result)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+	"github.com/stretchr/testify/assert"
+
+	librpki "github.com/cloudflare/cfrpki/validator/lib"
+)
+
+func TestLoadTALs(t *testing.T) {
+	dir := t.TempDir()
+	valid := createTestRepository(t, dir, "rpki.example.com", nil).Path
+
+	broken := filepath.Join(dir, "broken.tal")
+	assert.Nil(t, os.WriteFile(broken, []byte("not a TAL"), 0644))
+	missing := filepath.Join(dir, "missing.tal")
+
+	paths := []string{broken, valid, missing}
+	names := []string{"Broken", "Valid", "Missing"}
+
+	tals, talNames, kept, err := loadTALs(paths, names, false)
+	assert.Nil(t, err)
+	assert.Len(t, tals, 3)
+	assert.Equal(t, names, talNames)
+	assert.Equal(t, []int{0, 1, 2}, kept)
+
+	tals, talNames, kept, err = loadTALs(paths, names, true)
+	assert.Nil(t, err)
+	assert.Len(t, tals, 1)
+	assert.Equal(t, valid, tals[0].Path)
+	assert.Equal(t, []string{"Valid"}, talNames)
+	assert.Equal(t, []int{1}, kept)
+
+	_, _, _, err = loadTALs([]string{broken, missing}, []string{"Broken", "Missing"}, true)
+	assert.NotNil(t, err)
+
+	// The names could not be matched with the kept TALs
+	_, _, _, err = loadTALs(paths, []string{"Broken", "Valid"}, true)
+	assert.NotNil(t, err)
+}
+
+func TestReloadTALsRefreshSkipInvalid(t *testing.T) {
+	defer func(root, names, refresh string, skip bool) {
+		*RootTAL, *TALNames, *TALRefresh, *TALSkipInvalid = root, names, refresh, skip
+	}(*RootTAL, *TALNames, *TALRefresh, *TALSkipInvalid)
+
+	dir := t.TempDir()
+	valid := createTestRepository(t, dir, "rpki.example.com", nil).Path
+	broken := filepath.Join(dir, "broken.tal")
+	assert.Nil(t, os.WriteFile(broken, []byte("not a TAL"), 0644))
+
+	// The intervals stay attached to the TALs given at the same position
+	*RootTAL = broken + "," + valid
+	*TALNames = "Broken,Valid"
+	*TALRefresh = "5m,1h"
+	*TALSkipInvalid = true
+	reload, err := reloadTALs()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Valid"}, reload.talNames)
+	assert.Equal(t, []time.Duration{time.Hour}, reload.intervals)
+}
+
+func TestServeReload(t *testing.T) {
+	defer func(root, names, token string, skip bool) {
+		*RootTAL, *TALNames, *ReloadToken, *TALSkipInvalid = root, names, token, skip
+	}(*RootTAL, *TALNames, *ReloadToken, *TALSkipInvalid)
+
+	dir := t.TempDir()
+	valid := createTestRepository(t, dir, "rpki.example.com", nil).Path
+	added := filepath.Join(dir, "added.tal")
+
+	*RootTAL = valid + "," + added
+	*TALNames = "Valid,Added"
+	*TALSkipInvalid = true
+	tals, talNames, _, err := loadTALs([]string{valid, added}, []string{"Valid", "Added"}, true)
+	assert.Nil(t, err)
+	s := NewOctoRPKI(tals, talNames)
+	assert.Len(t, s.Tals, 1)
+
+	reloadWithToken := func(method string, token string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, "/reload", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		s.ServeReload(rec, req)
+		return rec
+	}
+	reload := func(method string) *httptest.ResponseRecorder {
+		return reloadWithToken(method, "secret")
+	}
+
+	// Disabled without a token
+	*ReloadToken = ""
+	assert.Equal(t, http.StatusUnauthorized, reloadWithToken("POST", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, reload("POST").Code)
+
+	*ReloadToken = "secret"
+	assert.Equal(t, http.StatusUnauthorized, reloadWithToken("POST", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, reloadWithToken("POST", "wrong").Code)
+	assert.False(t, s.applyReload())
+	assert.Equal(t, http.StatusMethodNotAllowed, reload("GET").Code)
+
+	// The second TAL now exists
+	data, err := os.ReadFile(valid)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(added, data, 0644))
+
+	rec := reload("POST")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var result ReloadResult
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	assert.Equal(t, []ReloadTAL{{TA: "Valid", Path: valid}, {TA: "Added", Path: added}}, result.TALs)
+
+	// Applied on the next iteration only
+	assert.Len(t, s.Tals, 1)
+	assert.True(t, s.applyReload())
+	assert.Len(t, s.Tals, 2)
+	assert.Equal(t, []string{"Valid", "Added"}, s.TalNames)
+	assert.True(t, s.talScheduler.isDue(1))
+	assert.False(t, s.applyReload())
+
+	// A failed reload keeps the current TALs
+	*RootTAL = filepath.Join(dir, "missing.tal")
+	*TALNames = "Missing"
+	assert.Equal(t, http.StatusInternalServerError, reload("POST").Code)
+	assert.False(t, s.applyReload())
+	assert.Len(t, s.Tals, 2)
+}
+
+func TestCheckTALHashes(t *testing.T) {
+	dir := t.TempDir()
+	tal := createTestRepository(t, dir, "rpki.example.com", nil)
+	tals := []*pki.PKIFile{tal}
+
+	data, err := os.ReadFile(filepath.Join(dir, "rpki.example.com/repo/root.cer"))
+	assert.Nil(t, err)
+	cert, err := librpki.DecodeCertificate(data)
+	assert.Nil(t, err)
+	keyHash := sha256.Sum256(cert.Certificate.RawSubjectPublicKeyInfo)
+	hash := hex.EncodeToString(keyHash[:])
+
+	expected, err := parseTALHashes(" Example=" + strings.ToUpper(hash) + ", ")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"Example": hash}, expected)
+	assert.Nil(t, checkTALHashes(tals, []string{"Example"}, expected))
+	assert.Nil(t, checkTALHashes(tals, nil, map[string]string{tal.Path: hash}))
+
+	// Mismatched pin
+	mismatch := strings.Repeat("00", sha256.Size)
+	err = checkTALHashes(tals, []string{"Example"}, map[string]string{"Example": mismatch})
+	assert.EqualError(t, err, "public key of TAL Example ("+hash+") does not match the expected hash "+mismatch)
+
+	err = checkTALHashes(tals, []string{"Example"}, map[string]string{"Other": hash})
+	assert.EqualError(t, err, "no TAL Other to check against the expected hash")
+
+	_, err = parseTALHashes("Example")
+	assert.NotNil(t, err)
+	_, err = parseTALHashes("Example=abcd")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+
+	librpki "github.com/cloudflare/cfrpki/validator/lib"
+)
+
+const (
+	TopologyNodeTA               = "ta"
+	TopologyNodeCA               = "ca"
+	TopologyNodePublicationPoint = "publication-point"
+)
+
+type TopologyNode struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	TA   string `json:"ta"`
+}
+
+type TopologyEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Hierarchy of the valid CAs discovered during the validation: trust anchor
+// -> CA -> child CA, each CA linked to its publication point.
+type Topology struct {
+	Nodes []TopologyNode `json:"nodes"`
+	Edges []TopologyEdge `json:"edges"`
+
+	nodes map[string]bool
+	edges map[TopologyEdge]bool
+}
+
+func newTopology() *Topology {
+	return &Topology{
+		Nodes: make([]TopologyNode, 0),
+		Edges: make([]TopologyEdge, 0),
+		nodes: make(map[string]bool),
+		edges: make(map[TopologyEdge]bool),
+	}
+}
+
+func (t *Topology) addNode(node TopologyNode) {
+	if !t.nodes[node.ID] {
+		t.nodes[node.ID] = true
+		t.Nodes = append(t.Nodes, node)
+	}
+}
+
+func (t *Topology) addEdge(from string, to string) {
+	edge := TopologyEdge{From: from, To: to}
+	if !t.edges[edge] {
+		t.edges[edge] = true
+		t.Edges = append(t.Edges, edge)
+	}
+}
+
+// CAs are identified by the URI of their certificate, or by their SKI when
+// the file is unknown.
+func topologyCAID(res *pki.Resource) string {
+	if res.File != nil {
+		return res.File.Path
+	}
+	_, ski := res.GetIdentifier()
+	return hex.EncodeToString(ski)
+}
+
+// Adds the valid CAs of a TAL.
+func (t *Topology) addValidator(ta string, validator *pki.Validator) {
+	taID := TopologyNodeTA + ":" + ta
+	t.addNode(TopologyNode{ID: taID, Type: TopologyNodeTA, TA: ta})
+
+	for _, res := range validator.ValidObjects {
+		if res.Type != pki.TYPE_CER {
+			continue
+		}
+		cer, ok := res.Resource.(*librpki.RPKICertificate)
+		if !ok {
+			continue
+		}
+
+		id := topologyCAID(res)
+		t.addNode(TopologyNode{ID: id, Type: TopologyNodeCA, TA: ta})
+		if res.Parent == nil {
+			t.addEdge(taID, id)
+		} else {
+			t.addEdge(topologyCAID(res.Parent), id)
+		}
+
+		if repository := cer.GetRsyncGeneralName(); repository != "" {
+			t.addNode(TopologyNode{ID: repository, Type: TopologyNodePublicationPoint, TA: ta})
+			t.addEdge(id, repository)
+		}
+	}
+}
+
+// Sorts the nodes and edges so the output does not depend on map ordering.
+func (t *Topology) sort() {
+	sort.Slice(t.Nodes, func(i, j int) bool {
+		return t.Nodes[i].ID < t.Nodes[j].ID
+	})
+	sort.Slice(t.Edges, func(i, j int) bool {
+		if t.Edges[i].From != t.Edges[j].From {
+			return t.Edges[i].From < t.Edges[j].From
+		}
+		return t.Edges[i].To < t.Edges[j].To
+	})
+}
+
+// Writes the graph in the Graphviz DOT language.
+func (t *Topology) writeDOT(w io.Writer) error {
+	shapes := map[string]string{
+		TopologyNodeTA:               "doubleoctagon",
+		TopologyNodeCA:               "box",
+		TopologyNodePublicationPoint: "folder",
+	}
+
+	if _, err := fmt.Fprintln(w, "digraph rpki {"); err != nil {
+		return err
+	}
+	for _, node := range t.Nodes {
+		if _, err := fmt.Fprintf(w, "\t%q [shape=%s];\n", node.ID, shapes[node.Type]); err != nil {
+			return err
+		}
+	}
+	for _, edge := range t.Edges {
+		if _, err := fmt.Fprintf(w, "\t%q -> %q;\n", edge.From, edge.To); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+func (s *OctoRPKI) ServeTopology(w http.ResponseWriter, r *http.Request) {
+	if !s.Stable.Load() && *WaitStable && !s.HasPreviousStable.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("File not ready yet"))
+		return
+	}
+
+	topology := s.getSnapshot().Topology
+	switch format := r.URL.Query().Get("format"); format {
+	case "dot":
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		topology.writeDOT(w)
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.Encode(topology)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("format %q is not supported, use json or dot", format)))
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+	"github.com/stretchr/testify/assert"
+
+	librpki "github.com/cloudflare/cfrpki/validator/lib"
+)
+
+func TestTopology(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	genTime := time.Now().UTC().Add(-time.Hour)
+	createCA := func(serial int64, ski []byte, parent *x509.Certificate, repository string) (*x509.Certificate, *librpki.RPKICertificate) {
+		sias, err := librpki.EncodeSIA([]*librpki.SIA{
+			{AccessMethod: librpki.CertRepository, GeneralName: []byte(repository)},
+			{AccessMethod: librpki.SIAManifest, GeneralName: []byte(repository + "ca.mft")},
+		})
+		assert.Nil(t, err)
+		template := &x509.Certificate{
+			Version:               3,
+			SerialNumber:          big.NewInt(serial),
+			Subject:               pkix.Name{CommonName: repository},
+			ExtraExtensions:       []pkix.Extension{*sias},
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+			SubjectKeyId:          ski,
+			NotBefore:             genTime,
+			NotAfter:              genTime.Add(time.Hour * 24),
+		}
+		if parent == nil {
+			parent = template
+		} else {
+			template.AuthorityKeyId = parent.SubjectKeyId
+		}
+		// Keys are shared, only the identifiers need to be unique
+		certBytes, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), key)
+		assert.Nil(t, err)
+		cert, err := librpki.DecodeCertificate(certBytes)
+		assert.Nil(t, err)
+		return template, cert
+	}
+
+	// Root -> CA -> child CA, the CA and its child share a publication point
+	root, rootCert := createCA(1, []byte{1}, nil, "rsync://root.example.com/repo/")
+	ca, caCert := createCA(2, []byte{2}, root, "rsync://ca.example.com/repo/")
+	_, childCert := createCA(3, []byte{3}, ca, "rsync://ca.example.com/repo/")
+
+	validator := pki.NewValidator()
+	files := []string{"rsync://root.example.com/root.cer", "rsync://root.example.com/repo/ca.cer", "rsync://ca.example.com/repo/child.cer"}
+	for i, cert := range []*librpki.RPKICertificate{rootCert, caCert, childCert} {
+		_, _, res, err := validator.AddCert(cert, i == 0)
+		assert.Nil(t, err)
+		res.File = &pki.PKIFile{Path: files[i], Type: pki.TYPE_CER}
+		res.Type = pki.TYPE_CER
+	}
+
+	topology := newTopology()
+	topology.addValidator("Test", validator)
+	topology.sort()
+
+	assert.ElementsMatch(t, []TopologyEdge{
+		{From: "ta:Test", To: "rsync://root.example.com/root.cer"},
+		{From: "rsync://root.example.com/root.cer", To: "rsync://root.example.com/repo/"},
+		{From: "rsync://root.example.com/root.cer", To: "rsync://root.example.com/repo/ca.cer"},
+		{From: "rsync://root.example.com/repo/ca.cer", To: "rsync://ca.example.com/repo/"},
+		{From: "rsync://root.example.com/repo/ca.cer", To: "rsync://ca.example.com/repo/child.cer"},
+		{From: "rsync://ca.example.com/repo/child.cer", To: "rsync://ca.example.com/repo/"},
+	}, topology.Edges)
+	assert.Len(t, topology.Nodes, 6)
+	assert.Contains(t, topology.Nodes, TopologyNode{ID: "rsync://ca.example.com/repo/", Type: TopologyNodePublicationPoint, TA: "Test"})
+
+	s := NewOctoRPKI(nil, nil)
+	s.Stable.Store(true)
+	s.updateSnapshot(func(snapshot *validationSnapshot) {
+		snapshot.Topology = topology
+	})
+
+	serve := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.ServeTopology(rec, httptest.NewRequest("GET", "/topology"+query, nil))
+		return rec
+	}
+
+	rec := serve("")
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var decoded Topology
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &decoded))
+	assert.Equal(t, topology.Edges, decoded.Edges)
+
+	rec = serve("?format=dot")
+	assert.Equal(t, "text/vnd.graphviz", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), "digraph rpki {\n")
+	assert.Contains(t, rec.Body.String(), "\t\"ta:Test\" [shape=doubleoctagon];\n")
+	assert.Contains(t, rec.Body.String(), "\t\"rsync://root.example.com/repo/ca.cer\" -> \"rsync://ca.example.com/repo/child.cer\";\n")
+
+	assert.Equal(t, http.StatusBadRequest, serve("?format=svg").Code)
+}
+
+func TestValidationTopology(t *testing.T) {
+	defer func(v bool) { *Sign = v }(*Sign)
+	*Sign = false
+
+	basepath := t.TempDir()
+	tals := []*pki.PKIFile{createTestRepository(t, basepath, "rpki.example.com", nil)}
+
+	var s *OctoRPKI
+	runValidation(basepath, tals, []string{"Example"}, 1, func(o *OctoRPKI) { s = o })
+
+	topology := s.getSnapshot().Topology
+	assert.Contains(t, topology.Edges, TopologyEdge{From: "rsync://rpki.example.com/repo/root.cer", To: "rsync://rpki.example.com/repo/"})
+	assert.Contains(t, topology.Edges, TopologyEdge{From: "ta:Example", To: "rsync://rpki.example.com/repo/root.cer"})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cloudflare/cfrpki/ov"
+	"github.com/cloudflare/gortr/prefixfile"
+)
+
+var validationStateToName = map[int]string{
+	ov.STATE_UNKNOWN: "unknown",
+	ov.STATE_INVALID: "invalid",
+	ov.STATE_VALID:   "valid",
+}
+
+type route struct {
+	Prefix *net.IPNet
+	ASN    uint32
+}
+
+func (r *route) GetPrefix() *net.IPNet {
+	return r.Prefix
+}
+
+func (r *route) GetASN() uint32 {
+	return r.ASN
+}
+
+type ValidateResult struct {
+	Prefix string               `json:"prefix"`
+	ASN    string               `json:"asn"`
+	State  string               `json:"state"`
+	VRPs   []prefixfile.ROAJson `json:"vrps"`
+}
+
+func parseRoute(prefix string, asn string) (*route, error) {
+	_, ipnet, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("invalid prefix %q", prefix)
+	}
+
+	asnInt, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(asn), "AS"), 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ASN %q", asn)
+	}
+
+	return &route{
+		Prefix: ipnet,
+		ASN:    uint32(asnInt),
+	}, nil
+}
+
+// Returns the origin validator of the current ROA list, rebuilding it when the list changed.
+func (s *OctoRPKI) getOriginValidator() *ov.OriginValidator {
+	roaList := s.getROAList()
+
+	s.originValidatorMu.Lock()
+	defer s.originValidatorMu.Unlock()
+
+	if s.originValidator == nil || s.originValidatorList != roaList {
+		vrps := make([]ov.AbstractROA, len(roaList.Data))
+		for i := range roaList.Data {
+			vrps[i] = &roaList.Data[i]
+		}
+		s.originValidator = ov.NewOV(vrps)
+		s.originValidatorList = roaList
+	}
+
+	return s.originValidator
+}
+
+func (s *OctoRPKI) ServeValidate(w http.ResponseWriter, r *http.Request) {
+	if !s.Stable.Load() && *WaitStable && !s.HasPreviousStable.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("File not ready yet"))
+		return
+	}
+
+	query := r.URL.Query()
+	rt, err := parseRoute(query.Get("prefix"), query.Get("asn"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	matching, state, err := s.getOriginValidator().Validate(rt)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	vrps := make([]prefixfile.ROAJson, 0, len(matching))
+	for _, vrp := range matching {
+		vrps = append(vrps, *vrp.(*prefixfile.ROAJson))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.Encode(ValidateResult{
+		Prefix: rt.Prefix.String(),
+		ASN:    fmt.Sprintf("AS%d", rt.ASN),
+		State:  validationStateToName[state],
+		VRPs:   vrps,
+	})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/cloudflare/gortr/prefixfile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServeValidate(t *testing.T) {
+	s := NewOctoRPKI(nil, nil)
+	s.Stable.Store(true)
+	s.setROAList(&prefixfile.ROAList{
+		Data: []prefixfile.ROAJson{
+			{Prefix: "192.0.2.0/24", Length: 24, ASN: "AS64500", TA: "A"},
+			{Prefix: "198.51.100.0/22", Length: 24, ASN: "AS64501", TA: "A"},
+			{Prefix: "2001:db8::/32", Length: 48, ASN: "AS64502", TA: "B"},
+		},
+	})
+
+	validate := func(prefix, asn string) (int, ValidateResult) {
+		rec := httptest.NewRecorder()
+		query := url.Values{}
+		query.Set("prefix", prefix)
+		query.Set("asn", asn)
+		s.ServeValidate(rec, httptest.NewRequest("GET", "/validate?"+query.Encode(), nil))
+
+		var res ValidateResult
+		if rec.Code == http.StatusOK {
+			assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		}
+		return rec.Code, res
+	}
+
+	tests := []struct {
+		name   string
+		prefix string
+		asn    string
+		state  string
+		vrps   int
+	}{
+		{"Valid", "192.0.2.0/24", "64500", "valid", 1},
+		{"Valid with AS prefix", "198.51.100.0/24", "AS64501", "valid", 1},
+		{"Valid IPv6", "2001:db8:1::/48", "64502", "valid", 1},
+		{"Invalid origin", "192.0.2.0/24", "64501", "invalid", 1},
+		{"Invalid length", "198.51.100.0/25", "64501", "invalid", 1},
+		{"Unknown", "203.0.113.0/24", "64500", "unknown", 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, res := validate(test.prefix, test.asn)
+			assert.Equal(t, http.StatusOK, code)
+			assert.Equal(t, test.state, res.State)
+			assert.Len(t, res.VRPs, test.vrps)
+		})
+	}
+
+	for _, bad := range [][2]string{
+		{"", "64500"},
+		{"192.0.2.0", "64500"},
+		{"192.0.2.0/24", ""},
+		{"192.0.2.0/24", "ASabc"},
+		{"192.0.2.0/24", "4294967296"},
+	} {
+		code, _ := validate(bad[0], bad[1])
+		assert.Equal(t, http.StatusBadRequest, code, "%v", bad)
+	}
+
+	// The validator follows the current ROA list
+	s.setROAList(&prefixfile.ROAList{
+		Data: []prefixfile.ROAJson{
+			{Prefix: "203.0.113.0/24", Length: 24, ASN: "AS64500", TA: "A"},
+		},
+	})
+	_, res := validate("203.0.113.0/24", "64500")
+	assert.Equal(t, "valid", res.State)
+}
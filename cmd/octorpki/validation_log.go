@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/gortr/prefixfile"
+)
+
+const validationLogPrefix = "validation-"
+
+type ValidationMessage struct {
+	TA      string `json:"ta"`
+	Message string `json:"message"`
+}
+
+type ValidationLogFile struct {
+	Generated int                 `json:"generated"`
+	Messages  []ValidationMessage `json:"messages"`
+}
+
+// GoRTR metadata with the messages of the validation. The signature only
+// covers the VRPs, so the messages are added to a signed list.
+type MessagesMetaData struct {
+	prefixfile.MetaData
+	ValidationMessages []ValidationMessage `json:"validation-messages"`
+}
+
+type MessagesROAList struct {
+	Metadata MessagesMetaData     `json:"metadata"`
+	Data     []prefixfile.ROAJson `json:"roas"`
+}
+
+// Adds the messages to an output returned by formatROAList. Only the gortr
+// format has them, other outputs are returned unchanged.
+func withValidationMessages(output interface{}, messages []ValidationMessage) interface{} {
+	roaList, ok := output.(*prefixfile.ROAList)
+	if !ok {
+		return output
+	}
+	if messages == nil {
+		messages = make([]ValidationMessage, 0)
+	}
+	return &MessagesROAList{
+		Metadata: MessagesMetaData{
+			MetaData:           roaList.Metadata,
+			ValidationMessages: messages,
+		},
+		Data: roaList.Data,
+	}
+}
+
+// Collects the messages reported while exploring the TALs of an iteration.
+// A nil log discards them.
+type validationLog struct {
+	mu       sync.Mutex
+	messages []ValidationMessage
+
+	// Waits for the collectors to receive every message
+	wg sync.WaitGroup
+}
+
+func newValidationLog() *validationLog {
+	return &validationLog{
+		messages: make([]ValidationMessage, 0),
+	}
+}
+
+func (l *validationLog) add(ta string, err error) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, ValidationMessage{TA: ta, Message: err.Error()})
+}
+
+// Returns the messages once the error channels of the managers are closed.
+func (l *validationLog) wait() []ValidationMessage {
+	l.wg.Wait()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.messages
+}
+
+// Writes the messages of an iteration to a timestamped file in dir and
+// removes the oldest files to keep at most keep of them (0 keeps all).
+func writeValidationLog(dir string, generated time.Time, messages []ValidationMessage, keep int) error {
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	fc, err := json.Marshal(ValidationLogFile{
+		Generated: int(generated.Unix()),
+		Messages:  messages,
+	})
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s%s.json", validationLogPrefix, generated.UTC().Format("20060102T150405.000000000Z"))
+	err = ioutil.WriteFile(filepath.Join(dir, name), fc, 0600)
+	if err != nil {
+		return err
+	}
+
+	if keep <= 0 {
+		return nil
+	}
+
+	// Names sort in chronological order
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	logs := make([]string, 0, len(files))
+	for _, file := range files {
+		if !file.IsDir() && strings.HasPrefix(file.Name(), validationLogPrefix) && strings.HasSuffix(file.Name(), ".json") {
+			logs = append(logs, file.Name())
+		}
+	}
+	sort.Strings(logs)
+	for len(logs) > keep {
+		err = os.Remove(filepath.Join(dir, logs[0]))
+		if err != nil {
+			return err
+		}
+		logs = logs[1:]
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+
+	syncpki "github.com/cloudflare/cfrpki/sync/lib"
+)
+
+func TestValidationLog(t *testing.T) {
+	defer func(dir string, keep int, sign bool) {
+		*ValidationLog, *ValidationKeep, *Sign = dir, keep, sign
+	}(*ValidationLog, *ValidationKeep, *Sign)
+
+	basepath := t.TempDir()
+	tals := []*pki.PKIFile{
+		createTestRepository(t, basepath, "rpki.example.com", []testROA{
+			{ASN: 65001, Prefix: "192.0.2.0/24", MaxLength: 24},
+		}),
+	}
+	// Listed on the manifest but missing
+	assert.Nil(t, os.Remove(filepath.Join(basepath, "rpki.example.com/repo/root.crl")))
+
+	*Sign = false
+	*ValidationLog = filepath.Join(t.TempDir(), "validation")
+	*ValidationKeep = 2
+
+	readLogs := func() []ValidationLogFile {
+		files, err := filepath.Glob(filepath.Join(*ValidationLog, "validation-*.json"))
+		assert.Nil(t, err)
+		logs := make([]ValidationLogFile, 0, len(files))
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			assert.Nil(t, err)
+			var l ValidationLogFile
+			assert.Nil(t, json.Unmarshal(data, &l))
+			logs = append(logs, l)
+		}
+		return logs
+	}
+
+	runValidation(basepath, tals, []string{"Example"}, 1)
+	logs := readLogs()
+	assert.Len(t, logs, 1)
+	assert.NotEmpty(t, logs[0].Messages)
+	for _, message := range logs[0].Messages {
+		assert.Equal(t, "Example", message.TA)
+	}
+
+	// One file per iteration, the oldest are removed
+	runValidation(basepath, tals, []string{"Example"}, 1)
+	assert.Len(t, readLogs(), 2)
+	runValidation(basepath, tals, []string{"Example"}, 1)
+	assert.Len(t, readLogs(), 2)
+}
+
+func TestWriteValidationLogKeepAll(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, writeValidationLog(dir, now.Add(time.Duration(i)*time.Second), []ValidationMessage{}, 0))
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "validation-*.json"))
+	assert.Nil(t, err)
+	assert.Len(t, files, 3)
+}
+
+func TestOutputValidationMessages(t *testing.T) {
+	defer func(output string, messages bool, sign bool) {
+		*Output, *OutputMessages, *Sign = output, messages, sign
+	}(*Output, *OutputMessages, *Sign)
+
+	basepath := t.TempDir()
+	tals := []*pki.PKIFile{
+		createTestRepository(t, basepath, "rpki.example.com", []testROA{
+			{ASN: 65001, Prefix: "192.0.2.0/24", MaxLength: 24},
+		}),
+	}
+	// Listed on the manifest but missing
+	assert.Nil(t, os.Remove(filepath.Join(basepath, "rpki.example.com/repo/root.crl")))
+
+	*Sign = false
+	*Output = filepath.Join(t.TempDir(), "output.json")
+
+	readMetadata := func() map[string]json.RawMessage {
+		s := NewOctoRPKI(tals, []string{"Example"})
+		s.Fetcher = syncpki.NewLocalFetch(basepath)
+		s.mainValidation(context.Background(), opentracing.NoopTracer{}.StartSpan("test"))
+		assert.Nil(t, s.output())
+
+		data, err := os.ReadFile(*Output)
+		assert.Nil(t, err)
+		var output struct {
+			Metadata map[string]json.RawMessage `json:"metadata"`
+		}
+		assert.Nil(t, json.Unmarshal(data, &output))
+		return output.Metadata
+	}
+
+	// Off by default
+	*OutputMessages = false
+	assert.NotContains(t, readMetadata(), "validation-messages")
+
+	*OutputMessages = true
+	metadata := readMetadata()
+	assert.Contains(t, metadata, "counts")
+	var messages []ValidationMessage
+	assert.Nil(t, json.Unmarshal(metadata["validation-messages"], &messages))
+	assert.NotEmpty(t, messages)
+	for _, message := range messages {
+		assert.Equal(t, "Example", message.TA)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+	"github.com/cloudflare/gortr/prefixfile"
+	"github.com/opentracing/opentracing-go"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+
+	syncpki "github.com/cloudflare/cfrpki/sync/lib"
+)
+
+type validationResult struct {
+	ROAs            []prefixfile.ROAJson
+	InfoAuthorities [][]SIA
+	RsyncFetch      map[string]string
+	RRDPFetch       map[string]string
+	RepositoryTALs  map[string][]int
+}
+
+func runValidation(basepath string, tals []*pki.PKIFile, talNames []string, workers int, configure ...func(*OctoRPKI)) validationResult {
+	defer func(v int) { *ValidationWorkers = v }(*ValidationWorkers)
+	*ValidationWorkers = workers
+
+	s := NewOctoRPKI(tals, talNames)
+	s.Fetcher = syncpki.NewLocalFetch(basepath)
+	for _, f := range configure {
+		f(s)
+	}
+	s.mainValidation(context.Background(), opentracing.NoopTracer{}.StartSpan("test"))
+
+	return validationResult{
+		ROAs:            s.getROAList().Data,
+		InfoAuthorities: s.getSnapshot().InfoAuthorities,
+		RsyncFetch:      s.rsyncFetchJobManager.get(),
+		RRDPFetch:       s.getRRDPFetch(),
+		RepositoryTALs:  s.repositoryTALs,
+	}
+}
+
+func TestConcurrentValidation(t *testing.T) {
+	defer func(v bool) { *Sign = v }(*Sign)
+	*Sign = false
+
+	basepath := t.TempDir()
+	tals := []*pki.PKIFile{
+		createTestRepository(t, basepath, "rpki-a.example.com", []testROA{
+			{ASN: 65001, Prefix: "192.0.2.0/24", MaxLength: 24},
+			{ASN: 65001, Prefix: "2001:db8::/32", MaxLength: 48},
+		}),
+		createTestRepository(t, basepath, "rpki-b.example.com", []testROA{
+			{ASN: 65002, Prefix: "198.51.100.0/24", MaxLength: 24},
+		}),
+		createTestRepository(t, basepath, "rpki-c.example.com", []testROA{
+			{ASN: 65003, Prefix: "203.0.113.0/24", MaxLength: 24},
+			// Also covered by the first TAL
+			{ASN: 65001, Prefix: "192.0.2.0/24", MaxLength: 24},
+		}),
+	}
+	talNames := []string{"A", "B", "C"}
+
+	sequential := runValidation(basepath, tals, talNames, 1)
+	assert.Equal(t, []prefixfile.ROAJson{
+		{Prefix: "192.0.2.0/24", Length: 24, ASN: "AS65001", TA: "A"},
+		{Prefix: "198.51.100.0/24", Length: 24, ASN: "AS65002", TA: "B"},
+		{Prefix: "2001:db8::/32", Length: 48, ASN: "AS65001", TA: "A"},
+		{Prefix: "203.0.113.0/24", Length: 24, ASN: "AS65003", TA: "C"},
+	}, sequential.ROAs)
+	assert.Len(t, sequential.InfoAuthorities, 3)
+	assert.Len(t, sequential.RsyncFetch, 3)
+	assert.Len(t, sequential.RRDPFetch, 3)
+
+	for _, workers := range []int{2, 3, 8} {
+		concurrent := runValidation(basepath, tals, talNames, workers)
+		assert.Equal(t, sequential, concurrent, "%d workers", workers)
+	}
+}
+
+func getHistogramCount(t *testing.T, o prometheus.Observer) uint64 {
+	m := &dto.Metric{}
+	err := o.(prometheus.Histogram).Write(m)
+	assert.Nil(t, err)
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestObjectParseMetric(t *testing.T) {
+	defer func(v bool) { *Sign = v }(*Sign)
+	*Sign = false
+
+	basepath := t.TempDir()
+	tals := []*pki.PKIFile{
+		createTestRepository(t, basepath, "rpki.example.com", []testROA{
+			{ASN: 65001, Prefix: "192.0.2.0/24", MaxLength: 24},
+			{ASN: 65001, Prefix: "198.51.100.0/24", MaxLength: 24},
+		}),
+	}
+
+	before := make(map[string]uint64)
+	for _, objectType := range []string{"tal", "certificate", "manifest", "crl", "roa"} {
+		before[objectType] = getHistogramCount(t, MetricObjectParseTime.With(prometheus.Labels{"type": objectType}))
+	}
+
+	runValidation(basepath, tals, []string{"Example"}, 1)
+
+	expected := map[string]uint64{"tal": 1, "certificate": 1, "manifest": 1, "crl": 1, "roa": 2}
+	for objectType, count := range expected {
+		after := getHistogramCount(t, MetricObjectParseTime.With(prometheus.Labels{"type": objectType}))
+		assert.Equal(t, count, after-before[objectType], objectType)
+	}
+}
+
+func TestCountDepthExceeded(t *testing.T) {
+	validator := pki.NewValidator()
+	for i, depth := range []int{0, 1, 2, 3, 3} {
+		validator.Objects[string(rune('a'+i))] = &pki.Resource{Type: pki.TYPE_CER, Depth: depth}
+	}
+	// EE certificate of a ROA below the deepest CA
+	validator.Objects["roa"] = &pki.Resource{Type: pki.TYPE_ROACER, Depth: 4}
+
+	assert.Equal(t, 0, countDepthExceeded(validator, 0))
+	assert.Equal(t, 2, countDepthExceeded(validator, 2))
+	assert.Equal(t, 0, countDepthExceeded(validator, 3))
+}
+
+func TestROAPointRangeMetric(t *testing.T) {
+	defer func(v bool) { *Sign = v }(*Sign)
+	*Sign = false
+
+	basepath := t.TempDir()
+	tals := []*pki.PKIFile{
+		createTestRepository(t, basepath, "rpki.example.com", []testROA{
+			{ASN: 65001, Prefix: "192.0.2.0/24", MaxLength: 24},
+			{ASN: 65001, Prefix: "198.51.100.0/22", MaxLength: 24},
+			{ASN: 65001, Prefix: "2001:db8::/32", MaxLength: 32},
+			{ASN: 65001, Prefix: "2001:db8:1::/48", MaxLength: 48},
+			{ASN: 65001, Prefix: "2001:db8:2::/40", MaxLength: 48},
+		}),
+	}
+
+	runValidation(basepath, tals, []string{"Mixed"}, 1)
+
+	assert.Equal(t, float64(3), getGaugeValue(t, MetricROAPointCount.With(prometheus.Labels{"ta": "Mixed"})))
+	assert.Equal(t, float64(2), getGaugeValue(t, MetricROARangeCount.With(prometheus.Labels{"ta": "Mixed"})))
+}
+
+func TestValidationExploreOrder(t *testing.T) {
+	defer func(v bool) { *Sign = v }(*Sign)
+	*Sign = false
+
+	basepath := t.TempDir()
+	tals := []*pki.PKIFile{
+		createTestRepository(t, basepath, "rpki.example.com", []testROA{
+			{ASN: 65001, Prefix: "192.0.2.0/24", MaxLength: 24},
+			{ASN: 65001, Prefix: "198.51.100.0/24", MaxLength: 24},
+		}),
+	}
+
+	bfs := runValidation(basepath, tals, []string{"Example"}, 1)
+	dfs := runValidation(basepath, tals, []string{"Example"}, 1, func(s *OctoRPKI) {
+		s.exploreOrder = pki.EXPLORE_DFS
+	})
+	assert.Len(t, bfs.ROAs, 2)
+	assert.Equal(t, bfs, dfs)
+}
+
+func TestValidationStrictCrypto(t *testing.T) {
+	defer func(v bool) { *Sign = v }(*Sign)
+	defer func(v bool) { *StrictCrypto = v }(*StrictCrypto)
+	defer func(v int) { *MinRSAKeySize = v }(*MinRSAKeySize)
+	*Sign = false
+
+	basepath := t.TempDir()
+	tals := []*pki.PKIFile{
+		createTestRepository(t, basepath, "rpki.example.com", []testROA{
+			{ASN: 65001, Prefix: "192.0.2.0/24", MaxLength: 24},
+		}),
+	}
+
+	*StrictCrypto = true
+	result := runValidation(basepath, tals, []string{"Crypto"}, 1)
+	assert.Len(t, result.ROAs, 1)
+	assert.Equal(t, float64(0), getGaugeValue(t, MetricWeakCrypto.With(prometheus.Labels{"ta": "Crypto"})))
+
+	// The fixture uses 2048 bits keys
+	*MinRSAKeySize = 4096
+	result = runValidation(basepath, tals, []string{"Crypto"}, 1)
+	assert.Len(t, result.ROAs, 0)
+	assert.Equal(t, float64(1), getGaugeValue(t, MetricWeakCrypto.With(prometheus.Labels{"ta": "Crypto"})))
+}
+
+func TestValidationShortPrefix(t *testing.T) {
+	defer func(v bool) { *Sign = v }(*Sign)
+	*Sign = false
+
+	basepath := t.TempDir()
+	tals := []*pki.PKIFile{
+		createTestRepository(t, basepath, "rpki.example.com", []testROA{
+			{ASN: 65001, Prefix: "0.0.0.0/0", MaxLength: 0},
+			{ASN: 65001, Prefix: "10.0.0.0/7", MaxLength: 8},
+			{ASN: 65001, Prefix: "192.0.2.0/24", MaxLength: 24},
+		}),
+	}
+
+	result := runValidation(basepath, tals, []string{"Short"}, 1)
+	assert.Len(t, result.ROAs, 3)
+	assert.Equal(t, float64(2), getGaugeValue(t, MetricShortPrefixes.With(prometheus.Labels{"ta": "Short"})))
+}
+
+func TestValidationNow(t *testing.T) {
+	defer func(v bool) { *Sign = v }(*Sign)
+	*Sign = false
+
+	basepath := t.TempDir()
+	tals := []*pki.PKIFile{
+		createTestRepository(t, basepath, "rpki.example.com", []testROA{
+			{ASN: 65001, Prefix: "192.0.2.0/24", MaxLength: 24},
+		}),
+	}
+	validateAt := func(now time.Time) (validationResult, *OctoRPKI) {
+		var s *OctoRPKI
+		result := runValidation(basepath, tals, []string{"Now"}, 1, func(o *OctoRPKI) {
+			s = o
+			s.validationTime = now
+		})
+		return result, s
+	}
+
+	result, _ := validateAt(time.Time{})
+	assert.Len(t, result.ROAs, 1)
+
+	// The fixture certificates are valid for a year
+	t1 := time.Now()
+	result, s := validateAt(t1.AddDate(2, 0, 0))
+	assert.Len(t, result.ROAs, 0)
+	// The output keeps the real time
+	assert.WithinDuration(t, t1, time.Unix(int64(s.getROAList().Metadata.Generated), 0), time.Minute)
+
+	// Before the fixture was issued
+	result, _ = validateAt(t1.Add(-2 * time.Hour))
+	assert.Len(t, result.ROAs, 0)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/cloudflare/gortr/prefixfile"
+)
+
+// Payload of a valid ROA.
+type vrpSource struct {
+	prefix    *net.IPNet
+	maxLength int
+	ta        string
+	uri       string
+}
+
+// Payloads of the valid ROAs by ASN, to find the ROAs authorizing a VRP.
+type vrpSources map[uint32][]vrpSource
+
+func (v vrpSources) add(asn uint32, prefix *net.IPNet, maxLength int, ta string, uri string) {
+	v[asn] = append(v[asn], vrpSource{
+		prefix:    prefix,
+		maxLength: maxLength,
+		ta:        ta,
+		uri:       uri,
+	})
+}
+
+// Payloads covering a VRP, which is a sub-prefix of its payload with
+// -output.expand.
+func (v vrpSources) lookup(roa prefixfile.ROAJson) []vrpSource {
+	_, prefix, err := net.ParseCIDR(roa.Prefix)
+	if err != nil {
+		return nil
+	}
+	length, bits := prefix.Mask.Size()
+
+	sources := make([]vrpSource, 0)
+	for _, source := range v[roa.GetASN()] {
+		sourceLength, sourceBits := source.prefix.Mask.Size()
+		if bits == sourceBits && sourceLength <= length && int(roa.Length) <= source.maxLength && source.prefix.Contains(prefix.IP) {
+			sources = append(sources, source)
+		}
+	}
+	return sources
+}
+
+// VRP added or removed, with the ROAs authorizing it in the list it was
+// added to or removed from.
+type VRPChange struct {
+	Prefix    string   `json:"prefix"`
+	MaxLength uint8    `json:"maxLength"`
+	ASN       string   `json:"asn"`
+	TA        string   `json:"ta"`
+	Objects   []string `json:"objects"` // URIs of the ROAs
+}
+
+// ROA whose VRPs were added or removed, as prefix/maxLength/ASN.
+type VRPDiffObject struct {
+	URI        string   `json:"uri"`
+	TA         string   `json:"ta"`
+	Repository string   `json:"repository"` // publication point of the ROA
+	Added      []string `json:"added"`
+	Removed    []string `json:"removed"`
+}
+
+// Changes of the VRPs between two stable validations.
+type VRPDiff struct {
+	From    int             `json:"from"` // generation time of the previous list
+	To      int             `json:"to"`   // generation time of the current list
+	Added   []VRPChange     `json:"added"`
+	Removed []VRPChange     `json:"removed"`
+	Objects []VRPDiffObject `json:"objects"` // sorted by URI
+}
+
+// VRPs of the first list missing from the second one, sorted.
+func missingVRPs(from []prefixfile.ROAJson, in []prefixfile.ROAJson) []prefixfile.ROAJson {
+	keys := make(map[string]bool, len(in))
+	for _, roa := range in {
+		keys[roa.String()] = true
+	}
+	missing := make([]prefixfile.ROAJson, 0)
+	for _, roa := range from {
+		if !keys[roa.String()] {
+			keys[roa.String()] = true
+			missing = append(missing, roa)
+		}
+	}
+	SortROAs(missing)
+	return missing
+}
+
+// Lists the VRPs which changed between two lists, linked to the ROAs they
+// come from. VRPs only differing by their TA are not changes.
+func computeVRPDiff(previous *prefixfile.ROAList, previousSources vrpSources, current *prefixfile.ROAList, currentSources vrpSources) *VRPDiff {
+	diff := &VRPDiff{
+		From:    previous.Metadata.Generated,
+		To:      current.Metadata.Generated,
+		Added:   make([]VRPChange, 0),
+		Removed: make([]VRPChange, 0),
+		Objects: make([]VRPDiffObject, 0),
+	}
+
+	objects := make(map[string]*VRPDiffObject)
+	link := func(roas []prefixfile.ROAJson, sources vrpSources, added bool) []VRPChange {
+		changes := make([]VRPChange, 0, len(roas))
+		for _, roa := range roas {
+			change := VRPChange{
+				Prefix:    roa.Prefix,
+				MaxLength: roa.Length,
+				ASN:       fmt.Sprintf("AS%d", roa.GetASN()),
+				TA:        roa.TA,
+				Objects:   make([]string, 0),
+			}
+			for _, source := range sources.lookup(roa) {
+				change.Objects = append(change.Objects, source.uri)
+
+				object, ok := objects[source.uri]
+				if !ok {
+					object = &VRPDiffObject{
+						URI:        source.uri,
+						TA:         source.ta,
+						Repository: source.uri[:strings.LastIndex(source.uri, "/")+1],
+						Added:      make([]string, 0),
+						Removed:    make([]string, 0),
+					}
+					objects[source.uri] = object
+				}
+				if added {
+					object.Added = append(object.Added, roa.String())
+				} else {
+					object.Removed = append(object.Removed, roa.String())
+				}
+			}
+			sort.Strings(change.Objects)
+			changes = append(changes, change)
+		}
+		return changes
+	}
+	diff.Added = link(missingVRPs(current.Data, previous.Data), currentSources, true)
+	diff.Removed = link(missingVRPs(previous.Data, current.Data), previousSources, false)
+
+	for _, uri := range sortedKeys(objects) {
+		diff.Objects = append(diff.Objects, *objects[uri])
+	}
+	return diff
+}
+
+// Compares the ROA list with the previous call, meant to be called on stable
+// validations. The diff is kept until the next stable validation changing
+// the VRPs. The first call only records the list.
+func (s *OctoRPKI) checkVRPDiff() {
+	snapshot := s.getSnapshot()
+	previous, previousSources := s.previousVRPs, s.previousSources
+	s.previousVRPs, s.previousSources = snapshot.ROAList, snapshot.VRPSources
+	if previous == nil {
+		return
+	}
+
+	diff := computeVRPDiff(previous, previousSources, snapshot.ROAList, snapshot.VRPSources)
+	if len(diff.Added) > 0 || len(diff.Removed) > 0 {
+		s.vrpDiff.Store(diff)
+	}
+}
+
+func (s *OctoRPKI) ServeDiff(w http.ResponseWriter, r *http.Request) {
+	diff := s.vrpDiff.Load()
+	if diff == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("No change of the VRPs between stable validations yet"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.Encode(diff)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+	"github.com/cloudflare/gortr/prefixfile"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVRPSourcesLookup(t *testing.T) {
+	_, prefix, _ := net.ParseCIDR("192.0.2.0/23")
+	sources := make(vrpSources)
+	sources.add(65001, prefix, 24, "Example", "rsync://rpki.example.com/repo/0.roa")
+
+	// Expanded VRP of the ROA
+	assert.Len(t, sources.lookup(prefixfile.ROAJson{Prefix: "192.0.3.0/24", Length: 24, ASN: "AS65001"}), 1)
+	assert.Empty(t, sources.lookup(prefixfile.ROAJson{Prefix: "192.0.3.0/24", Length: 25, ASN: "AS65001"}))
+	assert.Empty(t, sources.lookup(prefixfile.ROAJson{Prefix: "192.0.2.0/23", Length: 24, ASN: "AS65002"}))
+	assert.Empty(t, sources.lookup(prefixfile.ROAJson{Prefix: "198.51.100.0/24", Length: 24, ASN: "AS65001"}))
+}
+
+func TestVRPDiff(t *testing.T) {
+	defer func(v bool) { *Sign = v }(*Sign)
+	*Sign = false
+
+	basepath := t.TempDir()
+	tals := []*pki.PKIFile{createTestRepository(t, basepath, "rpki.example.com", []testROA{
+		{ASN: 65001, Prefix: "192.0.2.0/24", MaxLength: 24},
+		{ASN: 65002, Prefix: "198.51.100.0/24", MaxLength: 24},
+	})}
+
+	var s *OctoRPKI
+	runValidation(basepath, tals, []string{"Example"}, 1, func(o *OctoRPKI) { s = o })
+	s.checkVRPDiff()
+
+	rr := httptest.NewRecorder()
+	s.ServeDiff(rr, httptest.NewRequest("GET", "/diff", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+
+	// The second ROA now authorizes another prefix and ASN
+	createTestRepository(t, basepath, "rpki.example.com", []testROA{
+		{ASN: 65001, Prefix: "192.0.2.0/24", MaxLength: 24},
+		{ASN: 65003, Prefix: "203.0.113.0/24", MaxLength: 24},
+	})
+	s.mainValidation(context.Background(), opentracing.NoopTracer{}.StartSpan("test"))
+	s.checkVRPDiff()
+
+	rr = httptest.NewRecorder()
+	s.ServeDiff(rr, httptest.NewRequest("GET", "/diff", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var diff VRPDiff
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &diff))
+	assert.Equal(t, []VRPChange{{
+		Prefix:    "203.0.113.0/24",
+		MaxLength: 24,
+		ASN:       "AS65003",
+		TA:        "Example",
+		Objects:   []string{"rsync://rpki.example.com/repo/1.roa"},
+	}}, diff.Added)
+	assert.Equal(t, []VRPChange{{
+		Prefix:    "198.51.100.0/24",
+		MaxLength: 24,
+		ASN:       "AS65002",
+		TA:        "Example",
+		Objects:   []string{"rsync://rpki.example.com/repo/1.roa"},
+	}}, diff.Removed)
+	assert.Equal(t, []VRPDiffObject{{
+		URI:        "rsync://rpki.example.com/repo/1.roa",
+		TA:         "Example",
+		Repository: "rsync://rpki.example.com/repo/",
+		Added:      []string{"203.0.113.0/24/24/AS65003"},
+		Removed:    []string{"198.51.100.0/24/24/AS65002"},
+	}}, diff.Objects)
+
+	// A validation without change keeps the last diff
+	s.mainValidation(context.Background(), opentracing.NoopTracer{}.StartSpan("test"))
+	s.checkVRPDiff()
+	assert.Len(t, s.vrpDiff.Load().Added, 1)
+}
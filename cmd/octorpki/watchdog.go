@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// Detects a validation loop which stopped making progress (for instance a
+// deadlock while fetching) so the process can exit and be restarted.
+type watchdog struct {
+	timeout time.Duration
+
+	// Unix nanoseconds of the last completed iteration. Zero while waiting
+	// for the next revalidation, which does not count as a stall.
+	lastBeat atomic.Int64
+
+	// Called once when the loop is stalled
+	onStall func(stalled time.Duration)
+}
+
+func newWatchdog(timeout time.Duration, onStall func(time.Duration)) *watchdog {
+	w := &watchdog{
+		timeout: timeout,
+		onStall: onStall,
+	}
+	w.beat(time.Now())
+	return w
+}
+
+// Records a completed iteration (or the start of a new validation).
+func (w *watchdog) beat(now time.Time) {
+	if w != nil {
+		w.lastBeat.Store(now.UnixNano())
+	}
+}
+
+// Suspends the detection until the next beat.
+func (w *watchdog) pause() {
+	if w != nil {
+		w.lastBeat.Store(0)
+	}
+}
+
+// Returns how long the loop has been running without completing an iteration
+// when it exceeds the timeout.
+func (w *watchdog) stalled(now time.Time) (time.Duration, bool) {
+	last := w.lastBeat.Load()
+	if last == 0 {
+		return 0, false
+	}
+	since := now.Sub(time.Unix(0, last))
+	return since, since > w.timeout
+}
+
+func (w *watchdog) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if since, stalled := w.stalled(now); stalled {
+			w.onStall(since)
+			return
+		}
+	}
+}
+
+func exitStalled(stalled time.Duration) {
+	log.Errorf("No validation iteration completed for %v, exiting", stalled.Round(time.Second))
+	sentry.WithScope(func(scope *sentry.Scope) {
+		scope.SetLevel(sentry.LevelFatal)
+		scope.SetExtra("Stalled", stalled.String())
+		sentry.CaptureMessage("validation loop stalled")
+	})
+	sentry.Flush(2 * time.Second)
+	os.Exit(1)
+}
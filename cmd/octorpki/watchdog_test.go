@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchdogStalled(t *testing.T) {
+	now := time.Now()
+	w := newWatchdog(time.Minute, nil)
+	w.beat(now)
+
+	_, stalled := w.stalled(now.Add(30 * time.Second))
+	assert.False(t, stalled)
+	since, stalled := w.stalled(now.Add(2 * time.Minute))
+	assert.True(t, stalled)
+	assert.Equal(t, 2*time.Minute, since)
+
+	// Waiting for the next revalidation is not a stall
+	w.pause()
+	_, stalled = w.stalled(now.Add(time.Hour))
+	assert.False(t, stalled)
+
+	// Disabled watchdog
+	var disabled *watchdog
+	disabled.beat(now)
+	disabled.pause()
+}
+
+func TestWatchdogRun(t *testing.T) {
+	stalls := make(chan time.Duration, 1)
+	w := newWatchdog(200*time.Millisecond, func(stalled time.Duration) {
+		stalls <- stalled
+	})
+
+	// Iterations keep completing
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			time.Sleep(10 * time.Millisecond)
+			w.beat(time.Now())
+		}
+		close(done)
+	}()
+	go w.run(5 * time.Millisecond)
+	<-done
+	assert.Len(t, stalls, 0)
+
+	// The iteration hangs
+	select {
+	case stalled := <-stalls:
+		assert.Greater(t, stalled, 200*time.Millisecond)
+	case <-time.After(5 * time.Second):
+		t.Fatal("stalled iteration was not detected")
+	}
+}
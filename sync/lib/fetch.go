@@ -18,6 +18,12 @@ type LocalFetch struct {
 	Basepath     string
 	MapDirectory map[string]string
 	repositories map[string]time.Time
+
+	// Limits the files read at the same time, nil when unlimited
+	openFiles chan struct{}
+
+	// Reads the files from a storage instead of the mapped directories
+	storage Storage
 }
 
 func NewLocalFetch(basepath string) *LocalFetch {
@@ -32,6 +38,36 @@ func (s *LocalFetch) SetRepositories(repositories map[string]time.Time) {
 	s.repositories = repositories
 }
 
+// Limits the number of files read concurrently. 0 removes the limit.
+func (s *LocalFetch) SetMaxOpenFiles(max int) {
+	if max <= 0 {
+		s.openFiles = nil
+		return
+	}
+	s.openFiles = make(chan struct{}, max)
+}
+
+// Reads the files from the storage. nil reads the mapped directories.
+func (s *LocalFetch) SetStorage(storage Storage) {
+	s.storage = storage
+}
+
+// Reads a local file (like a TAL) or, with a storage, the file of an rsync URI.
+func (s *LocalFetch) fetchFile(path string, derEncoding bool) ([]byte, []byte, error) {
+	if s.openFiles != nil {
+		s.openFiles <- struct{}{}
+		defer func() { <-s.openFiles }()
+	}
+	if s.storage != nil && isRsyncURL(path) {
+		fc, err := s.storage.Get(StoragePath(path))
+		if err != nil {
+			return nil, nil, fmt.Errorf("Unable to read file %q: %v", path, err)
+		}
+		return decodeFile(fc, derEncoding)
+	}
+	return FetchFile(path, derEncoding)
+}
+
 func GetLocalPath(pathRep string, replace map[string]string) string {
 	sep := fmt.Sprintf("%c", os.PathSeparator)
 
@@ -56,7 +92,10 @@ func FetchFile(path string, derEncoding bool) ([]byte, []byte, error) {
 	if err != nil {
 		return nil, nil, fmt.Errorf("Unable to read file %q: %v", path, err)
 	}
+	return decodeFile(fc, derEncoding)
+}
 
+func decodeFile(fc []byte, derEncoding bool) ([]byte, []byte, error) {
 	tmpSha265 := sha256.Sum256(fc)
 	sha256 := tmpSha265[:]
 
@@ -64,7 +103,7 @@ func FetchFile(path string, derEncoding bool) ([]byte, []byte, error) {
 		return fc, sha256, nil
 	}
 
-	fc, err = librpki.BER2DER(fc)
+	fc, err := librpki.BER2DER(fc)
 	if err != nil {
 		return nil, nil, fmt.Errorf("librpki.BER2DER failed: %v", err)
 	}
@@ -89,10 +128,13 @@ func (s *LocalFetch) GetFile(file *pki.PKIFile) (*pki.SeekFile, error) {
 }
 
 func (s *LocalFetch) GetFileConv(file *pki.PKIFile, derEncoding bool) (*pki.SeekFile, error) {
-	newPath := ReplacePath(file, s.MapDirectory)
+	newPath := file.ComputePath()
+	if s.storage == nil {
+		newPath = ReplacePath(file, s.MapDirectory)
+	}
 	log.Debugf("Fetching %v->%v", file.Path, newPath)
 
-	data, sha256, err := FetchFile(newPath, derEncoding)
+	data, sha256, err := s.fetchFile(newPath, derEncoding)
 	if os.IsNotExist(err) {
 		return nil, nil
 	}
@@ -116,24 +158,62 @@ func (s *LocalFetch) GetFileConv(file *pki.PKIFile, derEncoding bool) (*pki.Seek
 	}, err
 }
 
-func (s *LocalFetch) GetRepository(file *pki.PKIFile, callback pki.CallbackExplore) error {
-	newPath := GetLocalPath(file.Repo, s.MapDirectory)
-	files, err := ioutil.ReadDir(file.Repo)
+// Lists the file names of an rsync repository. A missing repository is empty.
+func (s *LocalFetch) ListRepository(repo string) ([]string, error) {
+	if s.storage != nil {
+		names, err := s.storage.List(StoragePath(repo))
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return names, err
+	}
+
+	files, err := ioutil.ReadDir(GetLocalPath(repo, s.MapDirectory))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
 	if err != nil {
-		return fmt.Errorf("Unable to read dir %q: %v", file.Repo, err)
+		return nil, err
+	}
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		if !file.IsDir() {
+			names = append(names, file.Name())
+		}
 	}
+	return names, nil
+}
 
-	for _, fileDir := range files {
-		if fileDir == nil || fileDir.IsDir() {
-			continue
+func (s *LocalFetch) GetRepository(file *pki.PKIFile, callback pki.CallbackExplore) error {
+	newPath := GetLocalPath(file.Repo, s.MapDirectory)
+	var names []string
+	if s.storage != nil {
+		newPath = file.Repo
+		var err error
+		names, err = s.storage.List(StoragePath(file.Repo))
+		if err != nil {
+			return fmt.Errorf("Unable to read dir %q: %v", file.Repo, err)
+		}
+	} else {
+		files, err := ioutil.ReadDir(file.Repo)
+		if err != nil {
+			return fmt.Errorf("Unable to read dir %q: %v", file.Repo, err)
+		}
+		for _, fileDir := range files {
+			if fileDir == nil || fileDir.IsDir() {
+				continue
+			}
+			names = append(names, fileDir.Name())
 		}
+	}
 
-		data, sha256, err := FetchFile(newPath+fileDir.Name(), true)
+	for _, name := range names {
+		data, sha256, err := s.fetchFile(newPath+name, true)
 		if err != nil {
 			return fmt.Errorf("FetchFile failed: %v", err)
 		}
 
-		fullnameSplit := strings.Split(fileDir.Name(), ".")
+		fullnameSplit := strings.Split(name, ".")
 
 		extension := pki.TYPE_UNKNOWN
 		if len(fullnameSplit) > 0 {
@@ -154,7 +234,7 @@ func (s *LocalFetch) GetRepository(file *pki.PKIFile, callback pki.CallbackExplo
 				Parent: file,
 				Type:   extension,
 				Repo:   file.Repo,
-				Path:   file.Repo + fileDir.Name(),
+				Path:   file.Repo + name,
 			},
 			&pki.SeekFile{
 				File:   file.Path,
@@ -1,8 +1,12 @@
 package syncpki
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/cloudflare/cfrpki/validator/pki"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -36,3 +40,58 @@ func TestGetLocalPath(t *testing.T) {
 		assert.Equal(t, test.expected, res, test.name)
 	}
 }
+
+func TestMaxOpenFiles(t *testing.T) {
+	dir := t.TempDir()
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "rpki.example.com", "repo"), os.ModePerm))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "rpki.example.com", "repo", "a.tal"), []byte("tal"), 0644))
+
+	s := NewLocalFetch(dir)
+	s.SetMaxOpenFiles(1)
+
+	// Holds the only slot
+	s.openFiles <- struct{}{}
+
+	done := make(chan *pki.SeekFile)
+	go func() {
+		file, _ := s.GetFile(&pki.PKIFile{Path: "rsync://rpki.example.com/repo/a.tal", Type: pki.TYPE_TAL})
+		done <- file
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("file read while the limit is reached")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	<-s.openFiles
+	select {
+	case file := <-done:
+		assert.Equal(t, []byte("tal"), file.Data)
+	case <-time.After(5 * time.Second):
+		t.Fatal("file not read after a slot was released")
+	}
+	assert.Len(t, s.openFiles, 0)
+}
+
+func TestListRepository(t *testing.T) {
+	dir := t.TempDir()
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "rpki.example.com", "repo", "child"), os.ModePerm))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "rpki.example.com", "repo", "b.mft"), []byte("mft"), 0644))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "rpki.example.com", "repo", "a.mft"), []byte("mft"), 0644))
+
+	s := NewLocalFetch(dir)
+	names, err := s.ListRepository("rsync://rpki.example.com/repo/")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a.mft", "b.mft"}, names)
+	names, err = s.ListRepository("rsync://rpki.example.com/missing/")
+	assert.Nil(t, err)
+	assert.Empty(t, names)
+
+	storage := NewMemoryStorage()
+	assert.Nil(t, storage.Put("rpki.example.com/repo/a.mft", []byte("mft")))
+	s.SetStorage(storage)
+	names, err = s.ListRepository("rsync://rpki.example.com/repo/")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a.mft"}, names)
+}
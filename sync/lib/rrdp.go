@@ -2,6 +2,7 @@ package syncpki
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/xml"
 	"errors"
@@ -20,6 +21,11 @@ type RRDPFetcher interface {
 	GetXML(string) (string, error)
 }
 
+// Fetchers whose requests can be cancelled with a context
+type RRDPContextFetcher interface {
+	GetXMLContext(context.Context, string) (string, error)
+}
+
 type HTTPFetcher struct {
 	UserAgent string
 	Client    *http.Client
@@ -36,7 +42,11 @@ func NewHTTPFetcher(userAgent string) *HTTPFetcher {
 }
 
 func (f *HTTPFetcher) GetXML(url string) (string, error) {
-	req, err := http.NewRequest("GET", url, nil)
+	return f.GetXMLContext(context.Background(), url)
+}
+
+func (f *HTTPFetcher) GetXMLContext(ctx context.Context, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return "", NewRRDPErrorFetch(req, err)
 	}
@@ -156,12 +166,24 @@ type RRDPSystem struct {
 	Log     Logger
 	Fetcher RRDPFetcher
 
+	// Cancels the fetch when set
+	Context context.Context
+
 	Callback RRDPFile
 
 	Path      string
 	SessionID string
 	Serial    int64
 
+	// The snapshot is fetched instead of more deltas than this (0 for no limit)
+	MaxDeltas int
+
+	// What the last fetch applied: the snapshot or a number of deltas
+	SnapshotApplied bool
+	DeltasApplied   int
+	// The snapshot was applied because of MaxDeltas
+	TooManyDeltas bool
+
 	fetches []string
 }
 
@@ -179,8 +201,25 @@ func DecodeRRDPBase64(value string) ([]byte, error) {
 	return base64.StdEncoding.DecodeString(value)
 }
 
+// Fetches a file, using the context of the system when set.
+func (s *RRDPSystem) getXML(url string) (string, error) {
+	if s.Context == nil {
+		return s.Fetcher.GetXML(url)
+	}
+	if err := s.Context.Err(); err != nil {
+		return "", err
+	}
+	if f, ok := s.Fetcher.(RRDPContextFetcher); ok {
+		return f.GetXMLContext(s.Context, url)
+	}
+	return s.Fetcher.GetXML(url)
+}
+
 func (s *RRDPSystem) FetchRRDP(cbArgs ...interface{}) error {
 	s.fetches = make([]string, 0)
+	s.SnapshotApplied = false
+	s.DeltasApplied = 0
+	s.TooManyDeltas = false
 
 	sHub := sentry.CurrentHub().Clone()
 	sHub.ConfigureScope(func(scope *sentry.Scope) {
@@ -191,7 +230,7 @@ func (s *RRDPSystem) FetchRRDP(cbArgs ...interface{}) error {
 	if s.Log != nil {
 		s.Log.Infof("RRDP: Downloading root notification %v", s.Path)
 	}
-	data, err := s.Fetcher.GetXML(s.Path)
+	data, err := s.getXML(s.Path)
 
 	if err != nil {
 		sHub.CaptureException(err)
@@ -237,12 +276,19 @@ func (s *RRDPSystem) FetchRRDP(cbArgs ...interface{}) error {
 		}
 	}
 
-	if lastSerial == 0 || lastSessionID != curSessionID || missingFiles {
+	if lastSerial != 0 && lastSessionID == curSessionID && !missingFiles && s.MaxDeltas > 0 && curSerial-lastSerial > int64(s.MaxDeltas) {
+		if s.Log != nil {
+			s.Log.Infof("RRDP: %s is %d deltas behind, above the limit of %d", s.Path, curSerial-lastSerial, s.MaxDeltas)
+		}
+		s.TooManyDeltas = true
+	}
+
+	if lastSerial == 0 || lastSessionID != curSessionID || missingFiles || s.TooManyDeltas {
 		if s.Log != nil {
 			s.Log.Infof("RRDP: %s downloading snapshot at: %s", s.Path, root.Snapshot.URI)
 		}
 
-		data, err := s.Fetcher.GetXML(root.Snapshot.URI)
+		data, err := s.getXML(root.Snapshot.URI)
 		if err != nil {
 			sHub.CaptureException(err)
 			return err
@@ -287,6 +333,7 @@ func (s *RRDPSystem) FetchRRDP(cbArgs ...interface{}) error {
 				}
 			}
 		}
+		s.SnapshotApplied = true
 	} else {
 		msg := fmt.Sprintf("RRDP: %s has %d deltas to parse (cur: %d, last: %d)", s.Path, curSerial-lastSerial, curSerial, lastSerial)
 		if s.Log != nil {
@@ -310,7 +357,7 @@ func (s *RRDPSystem) FetchRRDP(cbArgs ...interface{}) error {
 				s.Log.Debugf("RRDP: Fetching serial: %d (%s) for %s", serial, elNode.URI, s.Path)
 			}
 			s.fetches = append(s.fetches, elNode.URI)
-			data, err := s.Fetcher.GetXML(elNode.URI)
+			data, err := s.getXML(elNode.URI)
 			if err != nil {
 				sHub.CaptureException(err)
 				return err
@@ -359,6 +406,7 @@ func (s *RRDPSystem) FetchRRDP(cbArgs ...interface{}) error {
 			}
 			tmpCurSerial = serial
 			processed++
+			s.DeltasApplied = processed
 		}
 		curSerial = tmpCurSerial
 		msg = fmt.Sprintf("RRDP: finished processing notifications (%d). Last serial %d", processed, curSerial)
@@ -5,6 +5,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
 	"os/exec"
 	"regexp"
@@ -17,11 +19,77 @@ const (
 	RsyncProtoPrefix = "rsync://"
 )
 
+// Categories of rsync errors
+const (
+	RsyncErrorUsage      = "usage"      // invalid arguments, unsupported action or protocol
+	RsyncErrorRestricted = "restricted" // access denied or path restricted by the server (rrsync)
+	RsyncErrorNetwork    = "network"    // connection, protocol stream or I/O timeout
+	RsyncErrorPartial    = "partial"    // some files could not be transferred
+	RsyncErrorLocal      = "local"      // local file, memory or process error
+	RsyncErrorTimeout    = "timeout"    // killed at the end of the context
+	RsyncErrorUnknown    = "unknown"
+)
+
 var (
-	reDeletion            = regexp.MustCompile("^deleting (.*)")
+	reDeletion            = regexp.MustCompile(`^\*?deleting +(.*)`)
 	wantedFileExtensionRE = regexp.MustCompile("(.*\\.(cer|mft|crl|roa|gbr))$")
+
+	// Messages of the daemon and of rrsync refusing a module or a path
+	reRsyncRestricted = regexp.MustCompile(`(?i)@ERROR: (access denied|unknown module|auth failed)|rrsync`)
 )
 
+// Number of lines of the standard error kept in an RsyncError
+const rsyncStderrLines = 10
+
+// Error of an rsync process exiting with a non-zero code.
+type RsyncError struct {
+	ExitCode int
+	Category string
+	Stderr   []string // last lines written on the standard error
+
+	Err error
+}
+
+func (e *RsyncError) Error() string {
+	if len(e.Stderr) == 0 {
+		return fmt.Sprintf("%v (%s)", e.Err, e.Category)
+	}
+	return fmt.Sprintf("%v (%s): %s", e.Err, e.Category, e.Stderr[len(e.Stderr)-1])
+}
+
+func (e *RsyncError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent errors are not solved by retrying: the server refuses the path or
+// the command itself is wrong.
+func (e *RsyncError) Permanent() bool {
+	return e.Category == RsyncErrorRestricted || e.Category == RsyncErrorUsage
+}
+
+// Returns the category of an rsync exit code (see EXIT VALUES in rsync(1)).
+// A daemon or rrsync refusing the path is reported on the standard error,
+// usually with the generic exit codes 1, 5 or 23.
+func ClassifyRsyncExit(code int, stderr []string) string {
+	for _, line := range stderr {
+		if reRsyncRestricted.MatchString(line) {
+			return RsyncErrorRestricted
+		}
+	}
+
+	switch code {
+	case 1, 2, 4:
+		return RsyncErrorUsage
+	case 5, 10, 12, 30, 35:
+		return RsyncErrorNetwork
+	case 23, 24:
+		return RsyncErrorPartial
+	case 3, 6, 11, 13, 14, 20, 21, 22, 25:
+		return RsyncErrorLocal
+	}
+	return RsyncErrorUnknown
+}
+
 func ExtractFoldersPathFromRsyncURL(url string) (string, error) {
 	if !isRsyncURL(url) {
 		return "", fmt.Errorf("%q is not an rsync URL", url)
@@ -53,13 +121,87 @@ func FilterMatch(line string) (string, bool, error) {
 	return line, false, nil
 }
 
+type FileChange string
+
+const (
+	FileNew     FileChange = "new"
+	FileChanged FileChange = "changed"
+	FileDeleted FileChange = "deleted"
+)
+
 type FileStat struct {
 	Path    string
 	Deleted bool
+	Change  FileChange
+}
+
+// Parses a line of rsync --itemize-changes output ("YXcstpoguax name").
+// Only files received or deleted are reported: other lines return false.
+func ParseItemizedChange(line string) (string, FileChange, bool) {
+	if file, deleted, _ := FilterMatch(line); deleted {
+		return file, FileDeleted, true
+	}
+
+	if len(line) < 13 || line[11] != ' ' || line[1] != 'f' {
+		return "", "", false
+	}
+	flags, file := line[:11], line[12:]
+	switch flags[0] {
+	case '>', 'c':
+		if strings.Trim(flags[2:], "+") == "" {
+			return file, FileNew, true
+		}
+		return file, FileChanged, true
+	}
+	return "", "", false
+}
+
+// Returns the environment variables making rsync connect through a proxy.
+// HTTP proxies (host:port or http://[user:password@]host:port) use RSYNC_PROXY,
+// SOCKS5 proxies (socks5://host:port) are reached with netcat using RSYNC_CONNECT_PROG.
+func RsyncProxyEnv(proxy string) ([]string, error) {
+	if proxy == "" {
+		return nil, nil
+	}
+
+	if !strings.Contains(proxy, "://") {
+		proxy = "http://" + proxy
+	}
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rsync proxy: %v", err)
+	}
+	if u.Hostname() == "" || u.Port() == "" {
+		return nil, fmt.Errorf("rsync proxy %q must contain a host and a port", proxy)
+	}
+
+	switch u.Scheme {
+	case "http":
+		hostPort := u.Host
+		if u.User != nil {
+			hostPort = u.User.String() + "@" + hostPort
+		}
+		return []string{"RSYNC_PROXY=" + hostPort}, nil
+	case "socks5":
+		if u.User != nil {
+			return nil, errors.New("rsync SOCKS5 proxy does not support authentication")
+		}
+		return []string{fmt.Sprintf("RSYNC_CONNECT_PROG=nc -X 5 -x %s %%H 873", u.Host)}, nil
+	}
+	return nil, fmt.Errorf("rsync proxy scheme %q is not supported. Choose either http or socks5", u.Scheme)
+}
+
+func rsyncCommand(ctx context.Context, uri string, bin string, dirPath string, env []string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, bin, "-vrlti", uri, dirPath)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	return cmd
 }
 
-// Runs the rsync binary on a URL
-func RunRsync(ctx context.Context, uri string, bin string, dirPath string) ([]*FileStat, error) {
+// Runs the rsync binary on a URL. env is added to the environment of the process.
+// A non-zero exit code is returned as an RsyncError.
+func RunRsync(ctx context.Context, uri string, bin string, dirPath string, env []string) ([]*FileStat, error) {
 	if bin == "" {
 		return nil, errors.New("rsync binary missing")
 	}
@@ -69,7 +211,7 @@ func RunRsync(ctx context.Context, uri string, bin string, dirPath string) ([]*F
 		return nil, err
 	}
 
-	cmd := exec.CommandContext(ctx, bin, "-vrlt", uri, dirPath)
+	cmd := rsyncCommand(ctx, uri, bin, dirPath, env)
 	log.Debugf("Command ran: %v", cmd)
 
 	stdout, err := cmd.StdoutPipe()
@@ -85,11 +227,18 @@ func RunRsync(ctx context.Context, uri string, bin string, dirPath string) ([]*F
 		return nil, err
 	}
 
+	stderrLines := make([]string, 0)
+	stderrDone := make(chan struct{})
 	go func() {
+		defer close(stderrDone)
 		scanner := bufio.NewScanner(stderr)
 		for scanner.Scan() {
 			errorStr := scanner.Text()
 			log.Error(errorStr)
+			if len(stderrLines) == rsyncStderrLines {
+				stderrLines = stderrLines[1:]
+			}
+			stderrLines = append(stderrLines, errorStr)
 
 			err := scanner.Err()
 			if err != nil {
@@ -99,6 +248,35 @@ func RunRsync(ctx context.Context, uri string, bin string, dirPath string) ([]*F
 		}
 	}()
 
+	files, err := parseRsyncOutput(stdout, uri)
+	if err != nil {
+		cmd.Wait()
+		return files, err
+	}
+
+	// The pipes must be read before waiting for the process
+	<-stderrDone
+	err = cmd.Wait()
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		category := ClassifyRsyncExit(exitErr.ExitCode(), stderrLines)
+		if ctx.Err() != nil {
+			category = RsyncErrorTimeout
+		}
+		err = &RsyncError{
+			ExitCode: exitErr.ExitCode(),
+			Category: category,
+			Stderr:   stderrLines,
+			Err:      err,
+		}
+	}
+	return files, err
+}
+
+// Reads the itemized output of rsync and returns the files which were added,
+// modified or deleted.
+func parseRsyncOutput(r io.Reader, uri string) ([]*FileStat, error) {
 	newuri := uri
 	uriSplit := strings.Split(newuri[8:], "/")
 	if uri[len(uri)-1] != '/' && len(uriSplit) > 2 {
@@ -109,31 +287,22 @@ func RunRsync(ctx context.Context, uri string, bin string, dirPath string) ([]*F
 
 	files := make([]*FileStat, 0)
 
-	scanner := bufio.NewScanner(stdout)
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		line := scanner.Text()
 
-		match := wantedFileExtensionRE.MatchString(line)
+		file, change, ok := ParseItemizedChange(line)
+		match := ok && wantedFileExtensionRE.MatchString(file)
 		log.Debugf("Rsync received from %v: %v (match=%v)", uri, line, match)
 
 		if match {
-			file, deleted, err := FilterMatch(line)
-			if err != nil {
-				return nil, err
-			}
-
 			files = append(files, &FileStat{
 				Path:    fmt.Sprintf("%v/%v", newuri, file),
-				Deleted: deleted,
+				Deleted: change == FileDeleted,
+				Change:  change,
 			})
 		}
-
-		if err != nil {
-			return files, err
-		}
 	}
 
-	err = scanner.Err()
-	err = cmd.Wait()
-	return files, err
+	return files, scanner.Err()
 }
@@ -0,0 +1,225 @@
+package syncpki
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	StorageFilesystem = "filesystem"
+	StorageMemory     = "memory"
+)
+
+// Returned when a path would be stored outside of the storage.
+var ErrIllegalPath = errors.New("contains illegal path element")
+
+// Returned when a file would exceed the maximum size of a memory storage.
+var ErrStorageFull = errors.New("memory storage is full")
+
+// Cache of the repository files. Paths are relative to the storage and use
+// the rsync layout without scheme: rpki.example.com/repo/file.roa.
+// Get returns an error matching fs.ErrNotExist when the file is missing.
+type Storage interface {
+	Put(path string, data []byte) error
+	Get(path string) ([]byte, error)
+	Delete(path string) error
+	List(dir string) ([]string, error) // names of the files of the directory
+}
+
+func NewStorage(kind string, basepath string) (Storage, error) {
+	switch kind {
+	case StorageFilesystem, "":
+		return NewFileStorage(basepath), nil
+	case StorageMemory:
+		return NewMemoryStorage(), nil
+	}
+	return nil, fmt.Errorf("storage %v is not supported. Choose either %v or %v", kind, StorageFilesystem, StorageMemory)
+}
+
+// Path of the file of an rsync URI in a storage.
+func StoragePath(rsyncURL string) string {
+	return strings.TrimPrefix(rsyncURL, RsyncProtoPrefix)
+}
+
+type FileStorage struct {
+	Basepath string
+}
+
+func NewFileStorage(basepath string) *FileStorage {
+	return &FileStorage{
+		Basepath: basepath,
+	}
+}
+
+// GHSA-8459-6rc9-8vf8: Prevent parent directory writes outside of the storage
+func checkStoragePath(p string) error {
+	if strings.Contains(p, "../") || strings.Contains(p, "..\\") {
+		return fmt.Errorf("Path %q %w", p, ErrIllegalPath)
+	}
+	return nil
+}
+
+func (s *FileStorage) localPath(p string) (string, error) {
+	if err := checkStoragePath(p); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.Basepath, p), nil
+}
+
+func (s *FileStorage) Put(p string, data []byte) error {
+	fp, err := s.localPath(p)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(fp), os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("Failed to create directories: %v", err)
+	}
+	err = os.WriteFile(fp, data, 0600)
+	if err != nil {
+		return fmt.Errorf("Unable to write file %q: %v", fp, err)
+	}
+	return nil
+}
+
+func (s *FileStorage) Get(p string) ([]byte, error) {
+	fp, err := s.localPath(p)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(fp)
+}
+
+func (s *FileStorage) Delete(p string) error {
+	fp, err := s.localPath(p)
+	if err != nil {
+		return err
+	}
+	return os.Remove(fp)
+}
+
+func (s *FileStorage) List(dir string) ([]string, error) {
+	fp, err := s.localPath(dir)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(fp)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// Keeps the files in memory, for containers without persistent volume.
+type MemoryStorage struct {
+	MaxSize int64 // total size of the files in bytes, 0 for no limit
+
+	files   map[string][]byte
+	dirs    map[string]map[string]bool // names of the files of each directory
+	size    int64
+	filesMu sync.RWMutex
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		files: make(map[string][]byte),
+		dirs:  make(map[string]map[string]bool),
+	}
+}
+
+func (s *MemoryStorage) Put(p string, data []byte) error {
+	if err := checkStoragePath(p); err != nil {
+		return err
+	}
+	s.filesMu.Lock()
+	defer s.filesMu.Unlock()
+
+	p = path.Clean(p)
+	size := s.size - int64(len(s.files[p])) + int64(len(data))
+	if s.MaxSize > 0 && size > s.MaxSize {
+		return fmt.Errorf("Unable to store %q: %w (%d of %d bytes used)", p, ErrStorageFull, s.size, s.MaxSize)
+	}
+	s.files[p] = append([]byte(nil), data...)
+	s.size = size
+	dir := path.Dir(p)
+	if s.dirs[dir] == nil {
+		s.dirs[dir] = make(map[string]bool)
+	}
+	s.dirs[dir][path.Base(p)] = true
+	return nil
+}
+
+func (s *MemoryStorage) Get(p string) ([]byte, error) {
+	if err := checkStoragePath(p); err != nil {
+		return nil, err
+	}
+	s.filesMu.RLock()
+	defer s.filesMu.RUnlock()
+
+	data, ok := s.files[path.Clean(p)]
+	if !ok {
+		return nil, &fs.PathError{Op: "get", Path: p, Err: fs.ErrNotExist}
+	}
+	return data, nil
+}
+
+func (s *MemoryStorage) Delete(p string) error {
+	if err := checkStoragePath(p); err != nil {
+		return err
+	}
+	s.filesMu.Lock()
+	defer s.filesMu.Unlock()
+
+	clean := path.Clean(p)
+	data, ok := s.files[clean]
+	if !ok {
+		return &fs.PathError{Op: "delete", Path: p, Err: fs.ErrNotExist}
+	}
+	delete(s.files, clean)
+	s.size -= int64(len(data))
+	dir := path.Dir(clean)
+	delete(s.dirs[dir], path.Base(clean))
+	if len(s.dirs[dir]) == 0 {
+		delete(s.dirs, dir)
+	}
+	return nil
+}
+
+// Total size of the files in bytes.
+func (s *MemoryStorage) Size() int64 {
+	s.filesMu.RLock()
+	defer s.filesMu.RUnlock()
+
+	return s.size
+}
+
+func (s *MemoryStorage) List(dir string) ([]string, error) {
+	if err := checkStoragePath(dir); err != nil {
+		return nil, err
+	}
+	s.filesMu.RLock()
+	defer s.filesMu.RUnlock()
+
+	files := s.dirs[path.Clean(dir)]
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
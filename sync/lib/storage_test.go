@@ -0,0 +1,108 @@
+package syncpki
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+
+	"github.com/cloudflare/cfrpki/validator/pki"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	s := NewMemoryStorage()
+
+	_, err := s.Get("rpki.example.com/repo/a.roa")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+
+	assert.Nil(t, s.Put("rpki.example.com/repo/a.roa", []byte("a")))
+	assert.Nil(t, s.Put("rpki.example.com/repo/b.roa", []byte("b")))
+	assert.Nil(t, s.Put("rpki.example.com/repo/sub/c.roa", []byte("c")))
+
+	data, err := s.Get("rpki.example.com/repo/a.roa")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), data)
+
+	names, err := s.List("rpki.example.com/repo/")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a.roa", "b.roa"}, names)
+
+	assert.Nil(t, s.Delete("rpki.example.com/repo/a.roa"))
+	assert.True(t, errors.Is(s.Delete("rpki.example.com/repo/a.roa"), fs.ErrNotExist))
+	names, err = s.List("rpki.example.com/repo")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"b.roa"}, names)
+}
+
+func TestMemoryStorageMaxSize(t *testing.T) {
+	s := NewMemoryStorage()
+	s.MaxSize = 10
+
+	assert.Nil(t, s.Put("rpki.example.com/repo/a.roa", []byte("aaaa")))
+	assert.Nil(t, s.Put("rpki.example.com/repo/b.roa", []byte("bbbb")))
+	assert.Equal(t, int64(8), s.Size())
+
+	err := s.Put("rpki.example.com/repo/c.roa", []byte("cccc"))
+	assert.True(t, errors.Is(err, ErrStorageFull))
+	_, err = s.Get("rpki.example.com/repo/c.roa")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+
+	// Replacing a file only counts the difference
+	assert.Nil(t, s.Put("rpki.example.com/repo/a.roa", []byte("aaaaaa")))
+	assert.Equal(t, int64(10), s.Size())
+
+	assert.Nil(t, s.Delete("rpki.example.com/repo/b.roa"))
+	assert.Equal(t, int64(6), s.Size())
+	assert.Nil(t, s.Put("rpki.example.com/repo/c.roa", []byte("cccc")))
+}
+
+func TestFileStorageIllegalPath(t *testing.T) {
+	s := NewFileStorage(t.TempDir())
+
+	err := s.Put("rpki.example.com/repo/../../a.roa", []byte("a"))
+	assert.True(t, errors.Is(err, ErrIllegalPath))
+
+	assert.Nil(t, s.Put("rpki.example.com/repo/a.roa", []byte("a")))
+	names, err := s.List("rpki.example.com/repo")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a.roa"}, names)
+}
+
+func TestMemoryStorageIllegalPath(t *testing.T) {
+	s := NewMemoryStorage()
+	assert.Nil(t, s.Put("rpki.ripe.net/repo/x.roa", []byte("x")))
+
+	err := s.Put("evil/repo/../../rpki.ripe.net/repo/x.roa", []byte("evil"))
+	assert.True(t, errors.Is(err, ErrIllegalPath))
+	_, err = s.Get("evil/repo/../../rpki.ripe.net/repo/x.roa")
+	assert.True(t, errors.Is(err, ErrIllegalPath))
+	assert.True(t, errors.Is(s.Delete("evil/repo/../../rpki.ripe.net/repo/x.roa"), ErrIllegalPath))
+	_, err = s.List("evil/repo/../../rpki.ripe.net/repo")
+	assert.True(t, errors.Is(err, ErrIllegalPath))
+
+	data, err := s.Get("rpki.ripe.net/repo/x.roa")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("x"), data)
+}
+
+func TestLocalFetchStorage(t *testing.T) {
+	storage := NewMemoryStorage()
+	assert.Nil(t, storage.Put("rpki.example.com/repo/a.tal", []byte("tal")))
+	assert.Nil(t, storage.Put("rpki.example.com/repo/b.roa", []byte{0x04, 0x01, 0x00}))
+
+	s := NewLocalFetch(t.TempDir())
+	s.SetStorage(storage)
+
+	file, err := s.GetFile(&pki.PKIFile{Path: "rsync://rpki.example.com/repo/a.tal", Type: pki.TYPE_TAL})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("tal"), file.Data)
+
+	// The files of a repository are converted to DER
+	assert.Nil(t, storage.Delete("rpki.example.com/repo/a.tal"))
+	paths := make([]string, 0)
+	err = s.GetRepository(&pki.PKIFile{Repo: "rsync://rpki.example.com/repo/"}, func(file *pki.PKIFile, data *pki.SeekFile, _ bool) {
+		paths = append(paths, file.Path)
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"rsync://rpki.example.com/repo/b.roa"}, paths)
+}
@@ -10,8 +10,6 @@ import (
   https://github.com/fullsailor/pkcs7/blob/master/ber.go
 */
 
-var encodeIndent = 0
-
 type asn1Object interface {
 	encodeTo(writer *bytes.Buffer) error
 }
@@ -22,7 +20,6 @@ type asn1Structured struct {
 }
 
 func (s asn1Structured) encodeTo(out *bytes.Buffer) error {
-	encodeIndent++
 	inner := new(bytes.Buffer)
 	for _, obj := range s.content {
 		err := obj.encodeTo(inner)
@@ -30,7 +27,6 @@ func (s asn1Structured) encodeTo(out *bytes.Buffer) error {
 			return err
 		}
 	}
-	encodeIndent--
 	out.Write(s.tagBytes)
 	encodeLength(out, inner.Len())
 	out.Write(inner.Bytes())
@@ -277,6 +277,23 @@ func (cms *CMS) AddCRLs(crls []byte) error {
 	return nil
 }
 
+// Runs the additional check of the strict decoding on a CMS which may
+// decode without it.
+func CheckStrictCMS(data []byte) error {
+	c, err := DecodeCMS(data)
+	if err != nil {
+		return err
+	}
+	vs, err := c.CheckSignaturesMatch()
+	if err != nil {
+		return err
+	}
+	if !vs {
+		return errors.New("CMS is not valid due to strict signature matching")
+	}
+	return nil
+}
+
 // Checks for an explicit NULL object in AlgorithmIdentifier
 // for both CMS and EE certificate.
 func (cms *CMS) CheckSignaturesMatch() (bool, error) {
@@ -452,8 +469,15 @@ func EncodeCMS(certificate []byte, encapContent interface{}, signingTime time.Ti
 		}
 		val.FullBytes = xmlBytes
 		signOid = XMLOID
+	case *RSC:
+		rscBytes, err := asn1.Marshal(*ec)
+		if err != nil {
+			return nil, err
+		}
+		val.FullBytes = rscBytes
+		signOid = RSCOID
 	default:
-		return nil, errors.New("Unknown type of content (not ROA, Manifest or XML)")
+		return nil, errors.New("Unknown type of content (not ROA, Manifest, XML or RSC)")
 	}
 
 	certificateBytes, err := asn1.MarshalWithParams(certificate, "tag:0,implicit")
@@ -0,0 +1,179 @@
+package librpki
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"time"
+)
+
+var (
+	RSCOID = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 48}
+)
+
+// https://tools.ietf.org/html/rfc9323
+type RSCFile struct {
+	Name string `asn1:"ia5,optional"`
+	Hash []byte
+}
+
+type RSCResourceBlock struct {
+	ASID         asn1.RawValue `asn1:"tag:0,optional"`
+	IPAddrBlocks asn1.RawValue `asn1:"tag:1,optional"`
+}
+
+type RSCContent struct {
+	Version         int `asn1:"optional,explicit,default:0,tag:0"`
+	Resources       RSCResourceBlock
+	DigestAlgorithm pkix.AlgorithmIdentifier
+	CheckList       []RSCFile
+}
+
+type RSC struct {
+	OID      asn1.ObjectIdentifier
+	EContent asn1.RawValue `asn1:"tag:0,explicit,optional"`
+}
+
+type RPKIRSC struct {
+	Certificate *RPKICertificate
+	Content     RSCContent
+	ASNums      []ASNCertificateInformation
+	IPAddresses []IPCertificateInformation
+	BadFormat   bool
+	SigningTime time.Time
+
+	InnerValid         bool
+	InnerValidityError error
+}
+
+func RSCToEncap(rsc *RSC) ([]byte, error) {
+	return EContentToEncap(rsc.EContent.FullBytes)
+}
+
+// Encodes the resources listed by a signed checklist. The encoding is the same
+// as the one of the certificate extensions, without inheritance.
+func EncodeRSCResources(asns []ASNCertificateInformation, ips []IPCertificateInformation) (RSCResourceBlock, error) {
+	var resources RSCResourceBlock
+	if len(asns) > 0 {
+		asnExtension, err := EncodeASN(asns, nil)
+		if err != nil {
+			return resources, err
+		}
+		resources.ASID = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: asnExtension.Value}
+	}
+	if len(ips) > 0 {
+		ipExtension, err := EncodeIPAddressBlock(ips)
+		if err != nil {
+			return resources, err
+		}
+		resources.IPAddrBlocks = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true, Bytes: ipExtension.Value}
+	}
+	return resources, nil
+}
+
+func EncodeRSCContent(eContent RSCContent) (*RSC, error) {
+	eContentEnc, err := asn1.Marshal(eContent)
+	if err != nil {
+		return nil, err
+	}
+
+	eContentEnc, err = asn1.MarshalWithParams(eContentEnc, "tag:0,explicit")
+	if err != nil {
+		return nil, err
+	}
+
+	rsc := &RSC{
+		OID:      RSCOID,
+		EContent: asn1.RawValue{FullBytes: eContentEnc},
+	}
+	return rsc, nil
+}
+
+func DecodeRSC(data []byte) (*RPKIRSC, error) {
+	return DefaultDecoderConfig.DecodeRSC(data)
+}
+
+func (cf *DecoderConfig) DecodeRSC(data []byte) (*RPKIRSC, error) {
+	c, err := DecodeCMS(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if cf.ValidateStrict {
+		vs, err := c.CheckSignaturesMatch()
+		if err != nil {
+			return nil, err
+		}
+		if !vs {
+			return nil, errors.New("CMS is not valid due to strict signature matching")
+		}
+	}
+
+	var rsc RSC
+	_, err = asn1.Unmarshal(c.SignedData.EncapContentInfo.FullBytes, &rsc)
+	if err != nil {
+		return nil, err
+	}
+	if !rsc.OID.Equal(RSCOID) {
+		return nil, errors.New("CMS does not contain a signed checklist")
+	}
+
+	var inner asn1.RawValue
+	_, err = asn1.Unmarshal(rsc.EContent.Bytes, &inner)
+	if err != nil {
+		return nil, err
+	}
+
+	fullbytes, badformat, err := BadFormatGroup(inner.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	fullbytes, _ = BER2DER(fullbytes)
+	var content RSCContent
+	_, err = asn1.Unmarshal(fullbytes, &content)
+	if err != nil {
+		return nil, err
+	}
+	if len(content.CheckList) == 0 {
+		return nil, errors.New("Signed checklist is empty")
+	}
+
+	rpkiRSC := &RPKIRSC{
+		Content:     content,
+		ASNums:      make([]ASNCertificateInformation, 0),
+		IPAddresses: make([]IPCertificateInformation, 0),
+		BadFormat:   badformat,
+	}
+
+	if content.Resources.ASID.Class != 0 {
+		rpkiRSC.ASNums, _, err = DecodeASN(content.Resources.ASID.Bytes)
+		if err != nil {
+			return rpkiRSC, err
+		}
+	}
+	if content.Resources.IPAddrBlocks.Class != 0 {
+		rpkiRSC.IPAddresses, err = DecodeIPAddressBlock(content.Resources.IPAddrBlocks.Bytes)
+		if err != nil {
+			return rpkiRSC, err
+		}
+	}
+
+	rpkiRSC.SigningTime, _ = c.GetSigningTime()
+
+	cert, err := c.GetRPKICertificate()
+	if err != nil {
+		return rpkiRSC, err
+	}
+	rpkiRSC.Certificate = cert
+
+	// Validate the content of the CMS
+	err = c.Validate(fullbytes, cert.Certificate)
+	if err != nil {
+		rpkiRSC.InnerValidityError = err
+	} else {
+		rpkiRSC.InnerValid = true
+	}
+
+	return rpkiRSC, nil
+}
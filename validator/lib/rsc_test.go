@@ -0,0 +1,99 @@
+package librpki
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeRSC(t *testing.T) {
+	_, prefix, _ := net.ParseCIDR("192.0.2.0/24")
+	resources, err := EncodeRSCResources(
+		[]ASNCertificateInformation{&ASN{ASN: 65001}},
+		[]IPCertificateInformation{&IPNet{IPNet: prefix}},
+	)
+	assert.Nil(t, err)
+
+	hashA := sha256.Sum256([]byte("a"))
+	hashB := sha256.Sum256([]byte("b"))
+	content, err := EncodeRSCContent(RSCContent{
+		Resources:       resources,
+		DigestAlgorithm: pkix.AlgorithmIdentifier{Algorithm: SHA256OID},
+		CheckList: []RSCFile{
+			{Name: "a.txt", Hash: hashA[:]},
+			{Hash: hashB[:]},
+		},
+	})
+	assert.Nil(t, err)
+
+	now := time.Now().UTC()
+	cms, err := EncodeCMS(nil, content, now)
+	assert.Nil(t, err)
+
+	privkey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	ski := []byte{1, 2, 3, 4, 5, 1, 2, 3, 4, 5, 1, 2, 3, 4, 5, 1, 2, 3, 4, 5}
+	cert := &x509.Certificate{
+		Version:      1,
+		SerialNumber: big.NewInt(42),
+		Subject: pkix.Name{
+			CommonName: "OctoRPKI-RSC",
+		},
+		NotBefore:    now.Add(-time.Minute * 5),
+		NotAfter:     now.Add(time.Hour),
+		SubjectKeyId: ski,
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, cert, cert, privkey.Public(), privkey)
+	assert.Nil(t, err)
+
+	encap, err := RSCToEncap(content)
+	assert.Nil(t, err)
+	assert.Nil(t, cms.Sign(rand.Reader, ski, encap, privkey, certBytes))
+	rscBytes, err := asn1.Marshal(*cms)
+	assert.Nil(t, err)
+
+	// Certificates created by Golang do not pass strict signature matching
+	_, err = DecodeRSC(rscBytes)
+	assert.NotNil(t, err)
+
+	dc := &DecoderConfig{
+		ValidateStrict: false,
+	}
+	rsc, err := dc.DecodeRSC(rscBytes)
+	assert.Nil(t, err)
+	assert.True(t, rsc.InnerValid)
+	assert.Equal(t, ski, rsc.Certificate.Certificate.SubjectKeyId)
+	assert.True(t, rsc.Content.DigestAlgorithm.Algorithm.Equal(SHA256OID))
+	assert.Equal(t, []RSCFile{
+		{Name: "a.txt", Hash: hashA[:]},
+		{Hash: hashB[:]},
+	}, rsc.Content.CheckList)
+	if assert.Len(t, rsc.ASNums, 1) {
+		assert.Equal(t, "65001", rsc.ASNums[0].String())
+	}
+	if assert.Len(t, rsc.IPAddresses, 1) {
+		assert.Equal(t, "192.0.2.0/24", rsc.IPAddresses[0].String())
+	}
+
+	// A ROA is not a signed checklist
+	roaEnc, err := EncodeROAEntries(65001, MakeROAEntries())
+	assert.Nil(t, err)
+	roaCms, err := EncodeCMS(nil, roaEnc, now)
+	assert.Nil(t, err)
+	encap, err = ROAToEncap(roaEnc)
+	assert.Nil(t, err)
+	assert.Nil(t, roaCms.Sign(rand.Reader, ski, encap, privkey, certBytes))
+	roaBytes, err := asn1.Marshal(*roaCms)
+	assert.Nil(t, err)
+	_, err = dc.DecodeRSC(roaBytes)
+	assert.NotNil(t, err)
+}
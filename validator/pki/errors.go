@@ -20,6 +20,9 @@ const (
 	ERROR_CERTIFICATE_MANIFEST
 	ERROR_CERTIFICATE_HASH
 	ERROR_CERTIFICATE_CRL
+	ERROR_CERTIFICATE_DEPTH
+	ERROR_CERTIFICATE_CRYPTO
+	ERROR_CERTIFICATE_MANIFESTS
 )
 
 type stack []uintptr
@@ -37,6 +40,9 @@ var (
 		ERROR_CERTIFICATE_MANIFEST:   "manifest",
 		ERROR_CERTIFICATE_HASH:       "hash",
 		ERROR_CERTIFICATE_CRL:        "crl",
+		ERROR_CERTIFICATE_DEPTH:      "depth",
+		ERROR_CERTIFICATE_CRYPTO:     "crypto",
+		ERROR_CERTIFICATE_MANIFESTS:  "manifests",
 	}
 )
 
@@ -121,7 +127,12 @@ func (e *CertificateError) Error() string {
 		asns = fmt.Sprintf(" invalid ASN resources (%d): [%v]", len(e.ASNs), merged)
 	}
 
-	return fmt.Sprintf("%s %s%v%s%s", e.Message, certinfo, err, ips, asns)
+	var files string
+	if e.EType == ERROR_CERTIFICATE_CONFLICT && e.File != nil && e.InnerFile != nil {
+		files = fmt.Sprintf(" between %s and %s", e.File.ComputePath(), e.InnerFile.ComputePath())
+	}
+
+	return fmt.Sprintf("%s %s%v%s%s%s", e.Message, certinfo, err, ips, asns, files)
 }
 
 func (e *CertificateError) SetSentryScope(scope *sentry.Scope) {
@@ -270,6 +281,36 @@ func NewCertificateErrorCRLRevocation(cert *librpki.RPKICertificate, err error,
 	}
 }
 
+func NewCertificateErrorDepth(cert *librpki.RPKICertificate, depth int, maxDepth int) *CertificateError {
+	return &CertificateError{
+		EType:       ERROR_CERTIFICATE_DEPTH,
+		Certificate: cert,
+		InnerErr:    fmt.Errorf("depth %d exceeds maximum of %d", depth, maxDepth),
+		Message:     "certificate chain too deep",
+		Stack:       callers(),
+	}
+}
+
+func NewCertificateErrorCrypto(cert *librpki.RPKICertificate, err error) *CertificateError {
+	return &CertificateError{
+		EType:       ERROR_CERTIFICATE_CRYPTO,
+		Certificate: cert,
+		InnerErr:    err,
+		Message:     "weak cryptography",
+		Stack:       callers(),
+	}
+}
+
+func NewCertificateErrorManifests(cert *librpki.RPKICertificate, manifests []string, selected string) *CertificateError {
+	return &CertificateError{
+		EType:       ERROR_CERTIFICATE_MANIFESTS,
+		Certificate: cert,
+		InnerErr:    fmt.Errorf("%d manifests published (%s), using %s", len(manifests), strings.Join(manifests, ", "), selected),
+		Message:     "multiple manifests",
+		Stack:       callers(),
+	}
+}
+
 type FileError CertificateError
 
 func (e *FileError) Error() string {
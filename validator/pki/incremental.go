@@ -0,0 +1,353 @@
+package pki
+
+import (
+	"bytes"
+	"crypto/x509/pkix"
+	"time"
+
+	librpki "github.com/cloudflare/cfrpki/validator/lib"
+)
+
+// Counters of the Validator, recorded by publication point
+type validatorCounters struct {
+	SKIConflicts                int
+	MultipleManifests           int
+	WeakCrypto                  int
+	CMSStrictFailures           int
+	CMSLenientFailures          int
+	ManifestHashAlgorithmErrors int
+	EKUMismatches               int
+}
+
+func (v *Validator) counters() validatorCounters {
+	return validatorCounters{
+		SKIConflicts:                v.SKIConflicts,
+		MultipleManifests:           v.MultipleManifests,
+		WeakCrypto:                  v.WeakCrypto,
+		CMSStrictFailures:           v.CMSStrictFailures,
+		CMSLenientFailures:          v.CMSLenientFailures,
+		ManifestHashAlgorithmErrors: v.ManifestHashAlgorithmErrors,
+		EKUMismatches:               v.EKUMismatches,
+	}
+}
+
+func (v *Validator) addCounters(c validatorCounters) {
+	v.SKIConflicts += c.SKIConflicts
+	v.MultipleManifests += c.MultipleManifests
+	v.WeakCrypto += c.WeakCrypto
+	v.CMSStrictFailures += c.CMSStrictFailures
+	v.CMSLenientFailures += c.CMSLenientFailures
+	v.ManifestHashAlgorithmErrors += c.ManifestHashAlgorithmErrors
+	v.EKUMismatches += c.EKUMismatches
+}
+
+func (c *validatorCounters) add(o validatorCounters) {
+	c.SKIConflicts += o.SKIConflicts
+	c.MultipleManifests += o.MultipleManifests
+	c.WeakCrypto += o.WeakCrypto
+	c.CMSStrictFailures += o.CMSStrictFailures
+	c.CMSLenientFailures += o.CMSLenientFailures
+	c.ManifestHashAlgorithmErrors += o.ManifestHashAlgorithmErrors
+	c.EKUMismatches += o.EKUMismatches
+}
+
+func (c validatorCounters) sub(o validatorCounters) validatorCounters {
+	return validatorCounters{
+		SKIConflicts:                c.SKIConflicts - o.SKIConflicts,
+		MultipleManifests:           c.MultipleManifests - o.MultipleManifests,
+		WeakCrypto:                  c.WeakCrypto - o.WeakCrypto,
+		CMSStrictFailures:           c.CMSStrictFailures - o.CMSStrictFailures,
+		CMSLenientFailures:          c.CMSLenientFailures - o.CMSLenientFailures,
+		ManifestHashAlgorithmErrors: c.ManifestHashAlgorithmErrors - o.ManifestHashAlgorithmErrors,
+		EKUMismatches:               c.EKUMismatches - o.EKUMismatches,
+	}
+}
+
+// Errors and counters of the files of a publication point (the manifest and
+// the files it lists, except the child CA certificates), replayed when the
+// publication point is reused.
+type publicationPoint struct {
+	errors   []error
+	counters validatorCounters
+}
+
+func (sm *SimpleManager) publicationPointRecord(ski string) *publicationPoint {
+	if sm.publicationPoints == nil {
+		sm.publicationPoints = make(map[string]*publicationPoint)
+	}
+	record, ok := sm.publicationPoints[ski]
+	if !ok {
+		record = &publicationPoint{}
+		sm.publicationPoints[ski] = record
+	}
+	return record
+}
+
+// Records an error reported while exploring a file of a publication point
+func (sm *SimpleManager) recordError(err error) {
+	if sm.Incremental && sm.currentPublicationPoint != "" {
+		record := sm.publicationPointRecord(sm.currentPublicationPoint)
+		record.errors = append(record.errors, err)
+	}
+}
+
+// Returns the SubjectKeyIdentifier of the CA whose publication point holds a
+// file, or an empty string for the certificates and the files outside of
+// a publication point.
+func (sm *SimpleManager) publicationPointOf(file *PKIFile) string {
+	if file == nil || file.Type == TYPE_CER {
+		return ""
+	}
+	ca := file.Parent
+	if file.Type != TYPE_MFT {
+		if ca == nil || ca.Type != TYPE_MFT {
+			return ""
+		}
+		ca = ca.Parent
+	}
+	if ca == nil || ca.Type != TYPE_CER {
+		return ""
+	}
+	res, ok := sm.ResourceOfPath[ca]
+	if !ok || res == nil {
+		return ""
+	}
+	cert, ok := res.Resource.(*librpki.RPKICertificate)
+	if !ok || cert.Certificate == nil {
+		return ""
+	}
+	return string(cert.Certificate.SubjectKeyId)
+}
+
+// EE certificates of the signed objects of each publication point, by CA
+func (sm *SimpleManager) publicationPointObjects() map[string][]*Resource {
+	if sm.ownedObjects != nil {
+		return sm.ownedObjects
+	}
+	sm.ownedObjects = make(map[string][]*Resource)
+	for _, res := range sm.Validator.Objects {
+		if res.Type != TYPE_ROACER && res.Type != TYPE_MFTCER && res.Type != TYPE_RSCCER {
+			continue
+		}
+		if ski := sm.publicationPointOf(res.File); ski != "" {
+			sm.ownedObjects[ski] = append(sm.ownedObjects[ski], res)
+		}
+	}
+	return sm.ownedObjects
+}
+
+// Tells whether the validity of an object may have changed between two
+// validation times, the clock skew included.
+func validityCrossed(from, to time.Time, skew time.Duration, times ...time.Time) bool {
+	for _, t := range times {
+		for _, boundary := range []time.Time{t.Add(-skew), t, t.Add(skew)} {
+			if !boundary.Before(from) && !boundary.After(to) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Reuses the objects of the publication point of a valid CA certificate from
+// the previous validation. The certificate and its ancestors must not have
+// changed, nor the files of the repository and the validity of the objects.
+// The child CA certificates listed on the manifest are explored again.
+func (sm *SimpleManager) reusePublicationPoint(file *PKIFile, res *Resource, subFiles []*PKIFile) bool {
+	prev := sm.Previous
+	if prev == nil || prev.Validator == nil || file.Type != TYPE_CER || !sm.exploreManifests {
+		return false
+	}
+	cert, ok := res.Resource.(*librpki.RPKICertificate)
+	if !ok || cert.Certificate == nil {
+		return false
+	}
+	ski := string(cert.Certificate.SubjectKeyId)
+	aki := string(cert.Certificate.AuthorityKeyId)
+
+	prevCA, ok := prev.Validator.ValidObjects[ski]
+	if !ok || prevCA.Type != TYPE_CER {
+		return false
+	}
+	prevCert, ok := prevCA.Resource.(*librpki.RPKICertificate)
+	if !ok || !bytes.Equal(prevCert.Certificate.Raw, cert.Certificate.Raw) {
+		return false
+	}
+	// Resources inherited by the objects are validated against the ancestors
+	if !file.Trust && !sm.unchanged[aki] {
+		return false
+	}
+	if sm.unchanged == nil {
+		sm.unchanged = make(map[string]bool)
+	}
+	sm.unchanged[ski] = true
+
+	record, ok := prev.publicationPoints[ski]
+	if !ok || record.counters.SKIConflicts > 0 {
+		return false
+	}
+
+	v := sm.Validator
+	from, to := prev.Validator.Time, v.Time
+	if to.Before(from) {
+		return false
+	}
+	eeCerts := prev.publicationPointObjects()[ski]
+	var mft *Resource
+	for _, ee := range eeCerts {
+		eeCert, ok := ee.Resource.(*librpki.RPKICertificate)
+		if !ok || eeCert.Certificate == nil || !bytes.Equal(eeCert.Certificate.AuthorityKeyId, cert.Certificate.SubjectKeyId) {
+			return false
+		}
+		eeSKI := string(eeCert.Certificate.SubjectKeyId)
+		if _, exists := v.Objects[eeSKI]; exists {
+			return false
+		}
+		if validityCrossed(from, to, v.ClockSkew, eeCert.Certificate.NotBefore, eeCert.Certificate.NotAfter) {
+			return false
+		}
+		for _, child := range ee.Childs {
+			if child.Type == TYPE_MFT && prev.Validator.ValidManifest[eeSKI] == child {
+				mft = child
+			}
+		}
+	}
+	if mft == nil {
+		return false
+	}
+	manifest := mft.Resource.(*librpki.RPKIManifest)
+	if validityCrossed(from, to, v.ClockSkew, manifest.Content.ThisUpdate, manifest.Content.NextUpdate) {
+		return false
+	}
+	listed, err := ExtractPathManifest(manifest)
+	if err != nil {
+		return false
+	}
+
+	var repo string
+	for _, sia := range cert.SubjectInformationAccess {
+		if sia.AccessMethod.Equal(CARepository) {
+			repo = string(sia.GeneralName)
+		}
+	}
+	if sm.Changed == nil || sm.Changed(repo) || sm.Changed(mft.File.Path) {
+		return false
+	}
+
+	// The files are attached to the new CA certificate
+	mftFile := &PKIFile{Parent: file, Repo: mft.File.Repo, Path: mft.File.Path, Type: TYPE_MFT}
+	files := map[*PKIFile]*PKIFile{mft.File: mftFile}
+	copyFile := func(f *PKIFile) *PKIFile {
+		if copied, ok := files[f]; ok {
+			return copied
+		}
+		copied := *f
+		copied.Parent = mftFile
+		files[f] = &copied
+		return &copied
+	}
+	copyResource := func(prevRes *Resource, parent *Resource) *Resource {
+		copied := &Resource{
+			Type:     prevRes.Type,
+			Parent:   parent,
+			File:     copyFile(prevRes.File),
+			Resource: prevRes.Resource,
+			Childs:   make([]*Resource, 0),
+			Depth:    prevRes.Depth - prevCA.Depth + res.Depth,
+		}
+		if prev.ResourceOfPath[prevRes.File] == prevRes {
+			sm.PathOfResource[copied] = copied.File
+			sm.ResourceOfPath[copied.File] = copied
+		}
+		sm.Explored[copied.File.ComputePath()] = true
+		return copied
+	}
+	childOfCA := make(map[*Resource]bool, len(prevCA.Childs))
+	for _, child := range prevCA.Childs {
+		childOfCA[child] = true
+	}
+
+	for _, ee := range eeCerts {
+		eeCert := ee.Resource.(*librpki.RPKICertificate)
+		eeSKI := string(eeCert.Certificate.SubjectKeyId)
+		eeCopy := copyResource(ee, res)
+		v.Objects[eeSKI] = eeCopy
+		if _, valid := prev.Validator.ValidObjects[eeSKI]; valid {
+			v.ValidObjects[eeSKI] = eeCopy
+		}
+		serial := ski + eeCert.Certificate.SerialNumber.String()
+		if prev.Validator.CertsSerial[serial] == ee {
+			v.CertsSerial[serial] = eeCopy
+		}
+		if childOfCA[ee] {
+			res.Childs = append(res.Childs, eeCopy)
+		}
+
+		for _, object := range ee.Childs {
+			objectCopy := copyResource(object, eeCopy)
+			eeCopy.Childs = append(eeCopy.Childs, objectCopy)
+			v.ObjectsPath[object.File.Path] = objectCopy
+
+			objects, validObjects := prev.Validator.ROA, prev.Validator.ValidROA
+			newObjects, newValidObjects := v.ROA, v.ValidROA
+			switch object.Type {
+			case TYPE_MFT:
+				objects, validObjects = prev.Validator.Manifest, prev.Validator.ValidManifest
+				newObjects, newValidObjects = v.Manifest, v.ValidManifest
+			case TYPE_RSC:
+				objects, validObjects = prev.Validator.RSC, prev.Validator.ValidRSC
+				newObjects, newValidObjects = v.RSC, v.ValidRSC
+			}
+			if objects[eeSKI] == object {
+				newObjects[eeSKI] = objectCopy
+			}
+			if validObjects[eeSKI] == object {
+				newValidObjects[eeSKI] = objectCopy
+			}
+		}
+	}
+
+	if crl, ok := prev.Validator.CRL[ski]; ok && crl.File != nil && prev.publicationPointOf(crl.File) == ski {
+		crlCopy := copyResource(crl, res)
+		v.CRL[ski] = crlCopy
+		v.ObjectsPath[crl.File.Path] = crlCopy
+		if prev.Validator.ValidCRL[ski] == crl {
+			v.ValidCRL[ski] = crlCopy
+			res.Childs = append(res.Childs, crlCopy)
+			for _, revoked := range crl.Resource.(*pkix.CertificateList).TBSCertList.RevokedCertificates {
+				v.Revoked[ski+revoked.SerialNumber.String()] = true
+			}
+		}
+	}
+
+	// The child CAs are explored, the other files are already validated
+	toExplore := make([]*PKIFile, 0)
+	for _, subFile := range subFiles {
+		if subFile.Type == TYPE_MFT {
+			sm.Explored[subFile.ComputePath()] = true
+		} else {
+			toExplore = append(toExplore, subFile)
+		}
+	}
+	sm.Explored[mftFile.ComputePath()] = true
+	for _, listedFile := range listed {
+		listedFile.Parent = mftFile
+		if listedFile.Type == TYPE_CER {
+			toExplore = append(toExplore, listedFile)
+		} else {
+			sm.Explored[listedFile.ComputePath()] = true
+		}
+	}
+	sm.PutFiles(toExplore)
+
+	if sm.publicationPoints == nil {
+		sm.publicationPoints = make(map[string]*publicationPoint)
+	}
+	sm.publicationPoints[ski] = record
+	for _, err := range record.errors {
+		sm.reportError(err)
+	}
+	v.addCounters(record.counters)
+	sm.Reused++
+	return true
+}
@@ -0,0 +1,127 @@
+package pki
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	librpki "github.com/cloudflare/cfrpki/validator/lib"
+)
+
+func TestIncrementalExplore(t *testing.T) {
+	// 7 CAs: root, root-0, root-1, root-0-0, root-0-1, root-1-0 and root-1-1
+	fs, talPath := createTestTree(t, 2, 2, map[string][]byte{"ca.gbr": []byte("ghostbusters record")})
+
+	explore := func(previous *SimpleManager, changed ...string) (*SimpleManager, []string, []error) {
+		seeker := &recordingFileSeeker{TestingFileSeeker: fs}
+		errs := make([]error, 0)
+		done := make(chan struct{})
+		manager := exploreTree(seeker, talPath, EXPLORE_BFS, func(sm *SimpleManager) {
+			logger := logrus.New()
+			logger.Out = io.Discard
+			sm.Log = logger
+			sm.ReportErrors = true
+			sm.Incremental = true
+			sm.Previous = previous
+			sm.Changed = func(uri string) bool {
+				for _, repo := range changed {
+					if strings.HasPrefix(uri, repo) {
+						return true
+					}
+				}
+				return false
+			}
+			go func() {
+				for err := range sm.Errors {
+					errs = append(errs, err)
+				}
+				close(done)
+			}()
+		})
+		<-done
+		sort.Strings(seeker.Order)
+		return manager, seeker.Order, errs
+	}
+	validROAs := func(sm *SimpleManager) []string {
+		uris := make([]string, 0)
+		for _, roa := range sm.Validator.ValidROA {
+			uris = append(uris, roa.File.ComputePath())
+		}
+		sort.Strings(uris)
+		return uris
+	}
+
+	first, fetched, errs := explore(nil)
+	assert.Equal(t, 0, first.Reused)
+	assert.Len(t, fetched, len(fs.Files))
+	assert.Len(t, first.Validator.ValidROA, 7)
+	assert.Len(t, errs, 7)
+
+	// Only the publication point of root-1 changed: the other ones are
+	// reused and only the certificates of the CAs are validated again
+	second, fetched, secondErrs := explore(first, "rsync://tree.example.com/root-1/")
+	assert.Equal(t, 6, second.Reused)
+	assert.Equal(t, []string{
+		"rsync://tree.example.com/root-0/root-0-0.cer",
+		"rsync://tree.example.com/root-0/root-0-1.cer",
+		"rsync://tree.example.com/root-1/ca.crl",
+		"rsync://tree.example.com/root-1/ca.gbr",
+		"rsync://tree.example.com/root-1/ca.mft",
+		"rsync://tree.example.com/root-1/ca.roa",
+		"rsync://tree.example.com/root-1/root-1-0.cer",
+		"rsync://tree.example.com/root-1/root-1-1.cer",
+		"rsync://tree.example.com/root.cer",
+		"rsync://tree.example.com/root/root-0.cer",
+		"rsync://tree.example.com/root/root-1.cer",
+		"rsync://tree.example.com/tree.tal",
+	}, fetched)
+	assert.Equal(t, validROAs(first), validROAs(second))
+	assert.Len(t, second.Validator.ValidManifest, 7)
+	assert.Len(t, second.Validator.ValidCRL, 7)
+	assert.Len(t, second.Validator.ValidObjects, len(first.Validator.ValidObjects))
+	// The errors of the reused publication points are reported again
+	assert.ElementsMatch(t, errorMessages(errs), errorMessages(secondErrs))
+
+	// The reused objects are attached to the new certificates
+	for ski, roa := range second.Validator.ValidROA {
+		assert.Same(t, second.Validator.ValidObjects[ski], roa.Parent)
+		ca := roa.Parent.Parent.Resource.(*librpki.RPKICertificate)
+		assert.Same(t, second.Validator.ValidObjects[string(ca.Certificate.SubjectKeyId)], roa.Parent.Parent)
+	}
+
+	// A change below a reused publication point is seen
+	fs.AddFile("rsync://tree.example.com/root-0-0/ca.roa", []byte("invalid"))
+	third, _, _ := explore(second, "rsync://tree.example.com/root-0-0/")
+	assert.Equal(t, 6, third.Reused)
+	assert.Len(t, third.Validator.ValidROA, 6)
+	assert.NotContains(t, validROAs(third), "rsync://tree.example.com/root-0-0/ca.roa")
+
+	// Without a previous record of the errors nothing is reused
+	fourth, _, _ := explore(exploreTree(fs, talPath, EXPLORE_BFS))
+	assert.Equal(t, 0, fourth.Reused)
+}
+
+func TestValidityCrossed(t *testing.T) {
+	from := time.Now()
+	to := from.Add(time.Hour)
+
+	assert.False(t, validityCrossed(from, to, 0, from.Add(-time.Minute), to.Add(time.Minute)))
+	assert.True(t, validityCrossed(from, to, 0, from.Add(time.Minute)))
+	assert.True(t, validityCrossed(from, to, 0, to))
+	// The clock skew moves the boundaries
+	assert.True(t, validityCrossed(from, to, 2*time.Minute, to.Add(time.Minute)))
+	assert.False(t, validityCrossed(from, to, 2*time.Minute, to.Add(3*time.Minute)))
+}
+
+func errorMessages(errs []error) []string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return messages
+}
@@ -2,11 +2,14 @@ package pki
 
 import (
 	"bytes"
+	"context"
+	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
 	"errors"
 	"fmt"
+	"math/big"
 	"strings"
 	"time"
 
@@ -23,6 +26,14 @@ const (
 	TYPE_MFTCER
 	TYPE_CAREPO
 	TYPE_TAL
+	TYPE_RSC
+	TYPE_RSCCER
+)
+
+// Order in which the files discovered by a SimpleManager are explored
+const (
+	EXPLORE_BFS = iota
+	EXPLORE_DFS
 )
 
 var (
@@ -39,9 +50,25 @@ var (
 		TYPE_MFTCER:  "manifest-ee",
 		TYPE_CAREPO:  "ca-repo",
 		TYPE_TAL:     "tal",
+		TYPE_RSC:     "rsc",
+		TYPE_RSCCER:  "rsc-ee",
+	}
+
+	ExploreOrderToName = map[int]string{
+		EXPLORE_BFS: "bfs",
+		EXPLORE_DFS: "dfs",
 	}
 )
 
+func ParseExploreOrder(name string) (int, error) {
+	for order, orderName := range ExploreOrderToName {
+		if strings.EqualFold(name, orderName) {
+			return order, nil
+		}
+	}
+	return EXPLORE_BFS, fmt.Errorf("unknown explore order %q. Choose either bfs or dfs", name)
+}
+
 type Resource struct {
 	Type     int
 	Parent   *Resource
@@ -49,6 +76,9 @@ type Resource struct {
 	Resource interface{}
 	Childs   []*Resource
 
+	// Number of certificates between this certificate and the trust anchor
+	Depth int
+
 	CertTALValid bool // currently used for TALs: indicates the child is valid and does not need to be fetched again
 }
 
@@ -60,6 +90,8 @@ func (res *Resource) GetIdentifier() (bool, []byte) {
 		return true, res.Certificate.Certificate.SubjectKeyId
 	case *librpki.RPKIManifest:
 		return true, res.Certificate.Certificate.SubjectKeyId
+	case *librpki.RPKIRSC:
+		return true, res.Certificate.Certificate.SubjectKeyId
 	}
 	return false, nil
 }
@@ -76,6 +108,12 @@ type FileSeeker interface {
 	GetRepository(*PKIFile, CallbackExplore) error
 }
 
+// Implemented by the FileSeekers able to list the file names of a
+// repository. The manager then chooses between the manifests of a CA.
+type RepositoryLister interface {
+	ListRepository(repo string) ([]string, error)
+}
+
 type Log interface {
 	Debugf(string, ...interface{})
 	Printf(string, ...interface{})
@@ -98,6 +136,39 @@ type SimpleManager struct {
 
 	StrictManifests bool
 	StrictHash      bool
+
+	// Files of an unknown type listed on a manifest are reported. Unless
+	// AllowUnknown is set, they also invalidate the CA with StrictManifests.
+	AllowUnknown bool
+
+	// Breadth-first (the default) reaches the top-level CAs sooner while
+	// depth-first completes each CA subtree before exploring its siblings
+	ExploreOrder int
+
+	// Called with the time spent decoding and validating each object, when set
+	ObserveParse func(fileType int, duration time.Duration)
+
+	// Explore stops before the next file once the context is done, when set
+	Context context.Context
+
+	// Publication points of the Previous validation are reused when neither
+	// the CA certificate, its ancestors, the files of the repository (as told
+	// by Changed with the repository and the manifest URIs) nor the validity
+	// of the objects changed. Their errors are reported again. The Previous
+	// manager must have been explored with Incremental set, which records the
+	// errors and counters of each publication point.
+	Incremental bool
+	Previous    *SimpleManager
+	Changed     func(uri string) bool
+
+	// Number of publication points reused by Explore
+	Reused int
+
+	publicationPoints       map[string]*publicationPoint // by CA SubjectKeyIdentifier
+	currentPublicationPoint string
+	ownedObjects            map[string][]*Resource
+	unchanged               map[string]bool // CA certificates identical to the Previous ones
+	exploreManifests        bool
 }
 
 func NewSimpleManager() *SimpleManager {
@@ -109,6 +180,7 @@ func NewSimpleManager() *SimpleManager {
 		Errors:          make(chan error, 50),
 		StrictManifests: true,
 		StrictHash:      true,
+		AllowUnknown:    true,
 	}
 }
 
@@ -117,6 +189,7 @@ func (sm *SimpleManager) Close() {
 }
 
 func (sm *SimpleManager) reportError(err error) {
+	sm.recordError(err)
 	if sm.ReportErrors {
 		sm.Errors <- err
 	}
@@ -129,6 +202,7 @@ func (sm *SimpleManager) reportErrorFile(err error, file *PKIFile, seek *SeekFil
 }
 
 func (sm *SimpleManager) PutFiles(fileList []*PKIFile) {
+	added := make([]*PKIFile, 0, len(fileList))
 	for _, file := range fileList {
 		path := file.ComputePath()
 		_, ok1 := sm.Explored[path]
@@ -139,9 +213,18 @@ func (sm *SimpleManager) PutFiles(fileList []*PKIFile) {
 			}
 		} else {
 			sm.ToExploreUnique[path] = true
-			sm.ToExplore = append(sm.ToExplore, file)
+			added = append(added, file)
+		}
+	}
+
+	if sm.ExploreOrder == EXPLORE_DFS {
+		// Files are taken from the end: reversed to keep siblings in their original order
+		for i := len(added) - 1; i >= 0; i-- {
+			sm.ToExplore = append(sm.ToExplore, added[i])
 		}
+		return
 	}
+	sm.ToExplore = append(sm.ToExplore, added...)
 }
 
 func (sm *SimpleManager) HasMore() bool {
@@ -152,8 +235,14 @@ func (sm *SimpleManager) GetNextExplore() (*PKIFile, bool, error) {
 	if len(sm.ToExplore) == 0 {
 		return nil, false, errors.New("EOF")
 	}
-	curExplore := sm.ToExplore[0]
-	sm.ToExplore = sm.ToExplore[1:]
+	var curExplore *PKIFile
+	if sm.ExploreOrder == EXPLORE_DFS {
+		curExplore = sm.ToExplore[len(sm.ToExplore)-1]
+		sm.ToExplore = sm.ToExplore[:len(sm.ToExplore)-1]
+	} else {
+		curExplore = sm.ToExplore[0]
+		sm.ToExplore = sm.ToExplore[1:]
+	}
 	return curExplore, len(sm.ToExplore) > 0, nil
 }
 
@@ -212,9 +301,58 @@ type Validator struct {
 	ValidManifest map[string]*Resource // Make sure EE certificates are unique for a ROA
 	Manifest      map[string]*Resource
 
+	// Signed checklists (RFC 9323), key by EE certificate
+	ValidRSC map[string]*Resource
+	RSC      map[string]*Resource
+
 	DecoderConfig *librpki.DecoderConfig
 
 	Time time.Time
+
+	// Accept certificates whose validity period has not started yet.
+	// Callers are expected to filter out the resulting objects.
+	AllowNotYetValid bool
+
+	// Tolerance applied to the validity periods of the certificates and
+	// manifests, absorbing the clock skew between the CAs and the validator.
+	ClockSkew time.Duration
+
+	// CA certificates deeper than MaxDepth in the hierarchy are reported (0 disables the check).
+	// They are also considered invalid when InvalidateDeep is set.
+	MaxDepth       int
+	InvalidateDeep bool
+
+	// Number of certificates rejected because their SubjectKeyIdentifier was already used
+	SKIConflicts int
+
+	// Number of CAs publishing several manifests in their repository
+	MultipleManifests int
+
+	// Certificates signed with SHA-1 (or MD5) or holding an RSA key shorter than
+	// MinRSAKeySize bits are invalid when StrictCrypto is set. This includes the
+	// EE certificates, whose keys sign the ROAs and manifests.
+	StrictCrypto  bool
+	MinRSAKeySize int
+
+	// Number of certificates rejected by StrictCrypto
+	WeakCrypto int
+
+	// Number of signed objects (ROAs, manifests and checklists) failing to
+	// decode with the strict CMS settings and without them, whichever
+	// DecoderConfig uses.
+	CMSStrictFailures  int
+	CMSLenientFailures int
+
+	// Manifests must list the hashes of their files using SHA-256 (RFC 9286).
+	// When CheckManifestHashAlgorithm is set, the other manifests are invalid
+	// and counted.
+	CheckManifestHashAlgorithm  bool
+	ManifestHashAlgorithmErrors int
+
+	// Number of signed objects whose EE certificate carries an Extended Key
+	// Usage. They are invalid when StrictEKU is set.
+	EKUMismatches int
+	StrictEKU     bool
 }
 
 func NewValidator() *Validator {
@@ -238,6 +376,9 @@ func NewValidator() *Validator {
 		ValidManifest: make(map[string]*Resource),
 		Manifest:      make(map[string]*Resource),
 
+		ValidRSC: make(map[string]*Resource),
+		RSC:      make(map[string]*Resource),
+
 		DecoderConfig: librpki.DefaultDecoderConfig,
 
 		Time: time.Now().UTC(),
@@ -321,6 +462,10 @@ func (v *Validator) AddResource(pkifile *PKIFile, data []byte) (bool, []*PKIFile
 		return valid, pathCert, res, err
 	case TYPE_ROA:
 		roa, err := v.DecoderConfig.DecodeROA(data)
+		v.countCMSDecodeFailure(data, err, func(cf *librpki.DecoderConfig) error {
+			_, err := cf.DecodeROA(data)
+			return err
+		})
 		if err != nil {
 			return false, nil, nil, err
 		}
@@ -330,10 +475,31 @@ func (v *Validator) AddResource(pkifile *PKIFile, data []byte) (bool, []*PKIFile
 		}
 		res.File = pkifile
 
+		v.ObjectsPath[pkifile.Path] = res
+		return valid, nil, res, err
+	case TYPE_RSC:
+		rsc, err := v.DecoderConfig.DecodeRSC(data)
+		v.countCMSDecodeFailure(data, err, func(cf *librpki.DecoderConfig) error {
+			_, err := cf.DecodeRSC(data)
+			return err
+		})
+		if err != nil {
+			return false, nil, nil, err
+		}
+		valid, res, err := v.AddRSC(pkifile, rsc)
+		if res == nil {
+			return valid, nil, res, fmt.Errorf("Resource is empty: %v", err)
+		}
+		res.File = pkifile
+
 		v.ObjectsPath[pkifile.Path] = res
 		return valid, nil, res, err
 	case TYPE_MFT:
 		mft, err := v.DecoderConfig.DecodeManifest(data)
+		v.countCMSDecodeFailure(data, err, func(cf *librpki.DecoderConfig) error {
+			_, err := cf.DecodeManifest(data)
+			return err
+		})
 		if err != nil {
 			return false, nil, nil, err
 		}
@@ -370,6 +536,29 @@ func (v *Validator) AddResource(pkifile *PKIFile, data []byte) (bool, []*PKIFile
 	return false, nil, nil, errors.New("Unknown file type")
 }
 
+// Counts the decoding failures of a signed object in both modes, err being
+// the result of the configured decoding. A lenient failure is also a strict
+// one. The other mode is only evaluated when it can change the outcome.
+func (v *Validator) countCMSDecodeFailure(data []byte, err error, decode func(*librpki.DecoderConfig) error) {
+	strict := v.DecoderConfig != nil && v.DecoderConfig.ValidateStrict
+	switch {
+	case err != nil && !strict:
+		v.CMSLenientFailures++
+		v.CMSStrictFailures++
+	case err != nil:
+		v.CMSStrictFailures++
+		lenient := *v.DecoderConfig
+		lenient.ValidateStrict = false
+		if decode(&lenient) != nil {
+			v.CMSLenientFailures++
+		}
+	case !strict:
+		if librpki.CheckStrictCMS(data) != nil {
+			v.CMSStrictFailures++
+		}
+	}
+}
+
 func (v *Validator) InvalidateObject(keyid []byte) {
 	invalidated := make(map[string]bool)
 	invalidateList := make([][]byte, 1)
@@ -388,6 +577,7 @@ func (v *Validator) InvalidateObject(keyid []byte) {
 		res, hasCert := v.Objects[ski]
 		delete(v.ValidObjects, ski)
 		delete(v.ValidROA, ski)
+		delete(v.ValidRSC, ski)
 		delete(v.ValidCRL, ski)
 		invalidated[ski] = true
 
@@ -431,13 +621,19 @@ func (v *Validator) AddCert(cert *librpki.RPKICertificate, trust bool) (bool, []
 
 	conflict, exists := v.Objects[ski]
 	if exists {
+		v.SKIConflicts++
 		conflictCert, _ := conflict.Resource.(*librpki.RPKICertificate)
-		return false, nil, res, NewCertificateErrorConflict(cert, conflictCert)
+		err := NewCertificateErrorConflict(cert, conflictCert)
+		err.InnerFile = conflict.File
+		return false, nil, res, err
 	}
 
 	_, hasParentValid := v.ValidObjects[aki]
 	parent, hasParent := v.Objects[aki]
 	res.Parent = parent
+	if parent != nil && !trust {
+		res.Depth = parent.Depth + 1
+	}
 
 	var valid bool
 	if hasParentValid || trust {
@@ -449,6 +645,25 @@ func (v *Validator) AddCert(cert *librpki.RPKICertificate, trust bool) (bool, []
 		valid = false
 	}
 
+	if v.StrictCrypto {
+		errCrypto := v.ValidateCrypto(cert)
+		if errCrypto != nil {
+			v.WeakCrypto++
+			valid = false
+			err = NewCertificateErrorCrypto(cert, errCrypto)
+		}
+	}
+
+	// Only CA certificates are limited: the objects of a CA at the maximum depth stay valid
+	if v.MaxDepth > 0 && cert.Certificate.IsCA && res.Depth > v.MaxDepth {
+		if v.InvalidateDeep {
+			valid = false
+		}
+		if err == nil {
+			err = NewCertificateErrorDepth(cert, res.Depth, v.MaxDepth)
+		}
+	}
+
 	if hasParent && parent != nil && valid {
 		parent.Childs = append(parent.Childs, res)
 
@@ -465,7 +680,16 @@ func (v *Validator) AddCert(cert *librpki.RPKICertificate, trust bool) (bool, []
 
 func (v *Validator) ValidateCertificate(cert *librpki.RPKICertificate, trust bool) error {
 	// Check time validity
-	err := cert.ValidateTime(v.Time)
+	validationTime := v.Time
+	if cert.Certificate != nil {
+		notBefore, notAfter := cert.Certificate.NotBefore, cert.Certificate.NotAfter
+		if notBefore.After(validationTime) && (v.AllowNotYetValid || !notBefore.After(validationTime.Add(v.ClockSkew))) {
+			validationTime = notBefore
+		} else if validationTime.After(notAfter) && !validationTime.Add(-v.ClockSkew).After(notAfter) {
+			validationTime = notAfter
+		}
+	}
+	err := cert.ValidateTime(validationTime)
 	if err != nil {
 		return NewCertificateErrorValidity(cert, err)
 	}
@@ -496,47 +720,35 @@ func (v *Validator) ValidateCertificate(cert *librpki.RPKICertificate, trust boo
 		return NewCertificateErrorRevocation(cert)
 	}
 
-	// Check IPs
+	// Check IPs. Resources the parent inherits are checked against the
+	// closest ancestor listing them (RFC 3779 section 2.2.3.5).
 	validIPs, invalidIPs, checkParent := cert.ValidateIPCertificate(parentCert)
-	chain := parent.Parent
-	for chain != nil && len(checkParent) > 0 {
-		key := parentCert.Certificate.AuthorityKeyId
-		upperCert, found := v.ValidObjects[string(key)]
-		if !found {
-			//return errors.New(fmt.Sprintf("One of the parents (%x) of %x is not valid", key, ski))
-			return NewCertificateErrorParent(cert, parentCert, errors.New(fmt.Sprintf("ancestor %x is missing", key)))
-		}
-		chainCert, ok := upperCert.Resource.(*librpki.RPKICertificate)
+	for chain := parent.Parent; chain != nil && len(checkParent) > 0; chain = chain.Parent {
+		chainCert, ok := chain.Resource.(*librpki.RPKICertificate)
 		if !ok {
-			//return errors.New(fmt.Sprintf("One of the parents (%x) of %x is not a RPKI Certificate", key, ski))
-			return NewCertificateErrorParent(cert, parentCert, errors.New(fmt.Sprintf("ancestor %x is not a rpki certificate", key)))
+			return NewCertificateErrorParent(cert, parentCert, errors.New("ancestor is not a rpki certificate"))
 		}
 		validTmp, invalidTmp, checkParentTmp := librpki.ValidateIPCertificateList(checkParent, chainCert)
 		validIPs = append(validIPs, validTmp...)
 		invalidIPs = append(invalidIPs, invalidTmp...)
 		checkParent = checkParentTmp
-		chain = chain.Parent
 	}
+	// Resources inherited up to the trust anchor are not held by any certificate
+	invalidIPs = append(invalidIPs, checkParent...)
 
 	// Check ASNs
 	validASNs, invalidASNs, checkParentASN := cert.ValidateASNCertificate(parentCert)
-	chain = parent.Parent
-	for chain != nil && len(checkParentASN) > 0 {
-		key := parentCert.Certificate.AuthorityKeyId
-		upperCert, found := v.ValidObjects[string(key)]
-		if !found {
-			return NewCertificateErrorParent(cert, parentCert, errors.New(fmt.Sprintf("ancestor %x is not valid", key)))
-		}
-		chainCert, ok := upperCert.Resource.(*librpki.RPKICertificate)
+	for chain := parent.Parent; chain != nil && len(checkParentASN) > 0; chain = chain.Parent {
+		chainCert, ok := chain.Resource.(*librpki.RPKICertificate)
 		if !ok {
-			return NewCertificateErrorParent(cert, parentCert, errors.New(fmt.Sprintf("ancestor %x is not a rpki certificate", key)))
+			return NewCertificateErrorParent(cert, parentCert, errors.New("ancestor is not a rpki certificate"))
 		}
 		validTmp, invalidTmp, checkParentTmp := librpki.ValidateASNCertificateList(checkParentASN, chainCert)
 		validASNs = append(validASNs, validTmp...)
 		invalidASNs = append(invalidASNs, invalidTmp...)
 		checkParentASN = checkParentTmp
-		chain = chain.Parent
 	}
+	invalidASNs = append(invalidASNs, checkParentASN...)
 
 	if len(invalidIPs) > 0 || len(invalidASNs) > 0 {
 		//return errors.New(fmt.Sprintf("%x contains invalid ASNs: %v", ski, invalidsASN))
@@ -547,6 +759,31 @@ func (v *Validator) ValidateCertificate(cert *librpki.RPKICertificate, trust boo
 	return nil
 }
 
+var weakSignatureAlgorithms = map[x509.SignatureAlgorithm]bool{
+	x509.MD2WithRSA:    true,
+	x509.MD5WithRSA:    true,
+	x509.SHA1WithRSA:   true,
+	x509.DSAWithSHA1:   true,
+	x509.ECDSAWithSHA1: true,
+}
+
+// Checks the signature algorithm and the key size of a certificate.
+func (v *Validator) ValidateCrypto(cert *librpki.RPKICertificate) error {
+	if cert.Certificate == nil {
+		return errors.New("No certificate found")
+	}
+
+	algorithm := cert.Certificate.SignatureAlgorithm
+	if weakSignatureAlgorithms[algorithm] {
+		return fmt.Errorf("signature algorithm %v is not allowed", algorithm)
+	}
+
+	if key, ok := cert.Certificate.PublicKey.(*rsa.PublicKey); ok && key.N.BitLen() < v.MinRSAKeySize {
+		return fmt.Errorf("RSA key of %d bits is shorter than %d bits", key.N.BitLen(), v.MinRSAKeySize)
+	}
+	return nil
+}
+
 func (v *Validator) AddROA(pkifile *PKIFile, roa *librpki.RPKIROA) (bool, *Resource, error) {
 	valid, _, res, err := v.AddCert(roa.Certificate, false)
 	if res == nil {
@@ -554,6 +791,12 @@ func (v *Validator) AddROA(pkifile *PKIFile, roa *librpki.RPKIROA) (bool, *Resou
 	}
 	res.File = pkifile
 	res.Type = TYPE_ROACER
+	v.ValidateROAInherited(roa, res)
+
+	if errEKU := v.ValidateEEKeyUsage(roa.Certificate); errEKU != nil {
+		valid = valid && !v.StrictEKU
+		err = errEKU
+	}
 
 	errValidity := v.ValidateROA(roa)
 	if errValidity != nil {
@@ -587,6 +830,38 @@ func (v *Validator) AddROA(pkifile *PKIFile, roa *librpki.RPKIROA) (bool, *Resou
 	return valid, res_roa, err
 }
 
+// The EE certificates of signed objects must not carry an Extended Key Usage,
+// which is reserved to the router certificates (RFC 6487 section 4.8.5).
+func (v *Validator) ValidateEEKeyUsage(cert *librpki.RPKICertificate) error {
+	if cert == nil || cert.Certificate == nil {
+		return nil
+	}
+	if len(cert.Certificate.ExtKeyUsage) == 0 && len(cert.Certificate.UnknownExtKeyUsage) == 0 {
+		return nil
+	}
+	v.EKUMismatches++
+	return errors.New("EE certificate of a signed object must not have an extended key usage")
+}
+
+// Validates the ROA entries covered by resources the EE certificate inherits
+// against the closest ancestor listing them. Entries not held by any ancestor
+// are invalid.
+func (v *Validator) ValidateROAInherited(roa *librpki.RPKIROA, res *Resource) {
+	checkParent := roa.CheckParent
+	for chain := res.Parent; chain != nil && len(checkParent) > 0; chain = chain.Parent {
+		chainCert, ok := chain.Resource.(*librpki.RPKICertificate)
+		if !ok {
+			break
+		}
+		validTmp, invalidTmp, checkParentTmp := librpki.ValidateIPRoaCertificateList(checkParent, chainCert)
+		roa.Valids = append(roa.Valids, validTmp...)
+		roa.Invalids = append(roa.Invalids, invalidTmp...)
+		checkParent = checkParentTmp
+	}
+	roa.Invalids = append(roa.Invalids, checkParent...)
+	roa.CheckParent = make([]*librpki.ROAEntry, 0)
+}
+
 func (v *Validator) ValidateROA(roa *librpki.RPKIROA) error {
 	err := roa.ValidateEntries()
 	if err != nil {
@@ -595,6 +870,46 @@ func (v *Validator) ValidateROA(roa *librpki.RPKIROA) error {
 	return nil
 }
 
+// Signed checklists are only reported: they do not carry VRPs.
+func (v *Validator) AddRSC(pkifile *PKIFile, rsc *librpki.RPKIRSC) (bool, *Resource, error) {
+	valid, _, res, err := v.AddCert(rsc.Certificate, false)
+	if res == nil {
+		return valid, res, errors.New(fmt.Sprintf("Resource is empty: %v", err))
+	}
+	res.File = pkifile
+	res.Type = TYPE_RSCCER
+
+	if errEKU := v.ValidateEEKeyUsage(rsc.Certificate); errEKU != nil {
+		valid = valid && !v.StrictEKU
+		err = errEKU
+	}
+
+	if !rsc.InnerValid {
+		valid = false
+		err = errors.New(fmt.Sprintf("RSC inner validity error: %v", rsc.InnerValidityError))
+	}
+
+	res_rsc := ObjectToResource(rsc)
+	res_rsc.Type = TYPE_RSC
+	res_rsc.File = pkifile
+	res.Childs = append(res.Childs, res_rsc)
+	res_rsc.Parent = res
+	key := rsc.Certificate.Certificate.SubjectKeyId
+
+	if valid {
+		v.ValidRSC[string(key)] = res_rsc
+	}
+	v.RSC[string(key)] = res_rsc
+
+	if err != nil {
+		errRes := NewResourceErrorWrap(rsc, err)
+		errRes.InnerValidity = valid
+		err = errRes
+	}
+
+	return valid, res_rsc, err
+}
+
 func (v *Validator) AddManifest(pkifile *PKIFile, mft *librpki.RPKIManifest) (bool, []*PKIFile, *Resource, error) {
 	pathCert, err := ExtractPathManifest(mft)
 	if err != nil {
@@ -608,10 +923,20 @@ func (v *Validator) AddManifest(pkifile *PKIFile, mft *librpki.RPKIManifest) (bo
 	res.File = pkifile
 	res.Type = TYPE_MFTCER
 
+	if errEKU := v.ValidateEEKeyUsage(mft.Certificate); errEKU != nil {
+		valid = valid && !v.StrictEKU
+		err = errEKU
+	}
+
 	if !mft.InnerValid {
 		valid = false
 		err = errors.New(fmt.Sprintf("Manifest inner validity error: %v", mft.InnerValidityError))
 	}
+	if v.CheckManifestHashAlgorithm && !mft.Content.FileHashAlg.Equal(librpki.SHA256OID) {
+		v.ManifestHashAlgorithmErrors++
+		valid = false
+		err = fmt.Errorf("Manifest uses the hash algorithm %v instead of SHA-256", mft.Content.FileHashAlg)
+	}
 
 	res_mft := ObjectToResource(mft)
 	res_mft.Type = TYPE_MFT
@@ -706,6 +1031,8 @@ func DetermineType(path string) int {
 			return TYPE_CRL
 		} else if path[len(path)-4:] == ".roa" {
 			return TYPE_ROA
+		} else if path[len(path)-4:] == ".sig" {
+			return TYPE_RSC
 		}
 	}
 	return TYPE_UNKNOWN
@@ -814,7 +1141,15 @@ func (sm *SimpleManager) InvalidateCRLParent(file *PKIFile, crlError error) {
 
 func (sm *SimpleManager) ExploreAdd(file *PKIFile, data *SeekFile, addInvalidChilds bool) {
 	sm.Explored[file.ComputePath()] = true
+
+	var t1 time.Time
+	if sm.ObserveParse != nil {
+		t1 = time.Now()
+	}
 	valid, subFiles, res, err := sm.Validator.AddResource(file, data.Data)
+	if sm.ObserveParse != nil {
+		sm.ObserveParse(file.Type, time.Since(t1))
+	}
 
 	if err != nil {
 		switch err.(type) {
@@ -838,19 +1173,111 @@ func (sm *SimpleManager) ExploreAdd(file *PKIFile, data *SeekFile, addInvalidChi
 		subFile.Parent = file
 	}
 	if addInvalidChilds || valid {
-		sm.PutFiles(subFiles)
 		sm.PathOfResource[res] = file
 		sm.ResourceOfPath[file] = res
+		if !valid || !sm.reusePublicationPoint(file, res, subFiles) {
+			sm.PutFiles(subFiles)
+		}
+	}
+}
+
+// Decodes a manifest published in the repository of a CA. It is valid when
+// its EE certificate is and when it is current.
+func (sm *SimpleManager) manifestCandidate(file *PKIFile, ca *librpki.RPKICertificate) (*librpki.RPKIManifest, bool) {
+	data, err := sm.FileSeeker.GetFile(file)
+	if err != nil || data == nil {
+		return nil, false
+	}
+	mft, err := sm.Validator.DecoderConfig.DecodeManifest(data.Data)
+	if err != nil || mft.Certificate == nil || !bytes.Equal(mft.Certificate.Certificate.AuthorityKeyId, ca.Certificate.SubjectKeyId) {
+		return nil, false
+	}
+
+	now, skew := sm.Validator.Time, sm.Validator.ClockSkew
+	valid := mft.InnerValid &&
+		sm.Validator.ValidateCertificate(mft.Certificate, false) == nil &&
+		!now.Add(-skew).After(mft.Content.NextUpdate) && !now.Add(skew).Before(mft.Content.ThisUpdate)
+	return mft, valid
+}
+
+// A CA may publish several manifests in its repository (during a rollover or
+// by mistake). Returns the valid one with the highest manifestNumber, or the
+// manifest of the CA certificate when none is valid or on a tie.
+// CAs with several manifests are reported.
+func (sm *SimpleManager) selectManifest(file *PKIFile) *PKIFile {
+	lister, ok := sm.FileSeeker.(RepositoryLister)
+	if !ok || sm.Validator == nil || file.Repo == "" || file.Parent == nil || file.Parent.Type != TYPE_CER {
+		return file
+	}
+	names, err := lister.ListRepository(file.Repo)
+	if err != nil {
+		return file
+	}
+	paths := []string{file.Path}
+	for _, name := range names {
+		if DetermineType(name) == TYPE_MFT && file.Repo+name != file.Path {
+			paths = append(paths, file.Repo+name)
+		}
+	}
+	if len(paths) < 2 {
+		return file
+	}
+	res, ok := sm.ResourceOfPath[file.Parent]
+	if !ok || res == nil {
+		return file
+	}
+	ca, ok := res.Resource.(*librpki.RPKICertificate)
+	if !ok {
+		return file
+	}
+
+	selected := file
+	var selectedNumber *big.Int
+	issued := make([]string, 0, len(paths))
+	for _, path := range paths {
+		candidate := file
+		if path != file.Path {
+			candidate = &PKIFile{
+				Parent: file.Parent,
+				Repo:   file.Repo,
+				Path:   path,
+				Type:   TYPE_MFT,
+			}
+		}
+		mft, valid := sm.manifestCandidate(candidate, ca)
+		if mft == nil {
+			continue
+		}
+		issued = append(issued, path)
+		if !valid {
+			continue
+		}
+		// The manifest of the CA certificate comes first and is kept on a tie
+		if selectedNumber == nil || mft.Content.ManifestNumber.Cmp(selectedNumber) > 0 {
+			selected = candidate
+			selectedNumber = mft.Content.ManifestNumber
+		}
+	}
+
+	if len(issued) > 1 {
+		sm.Validator.MultipleManifests++
+		sm.reportErrorFile(NewCertificateErrorManifests(ca, issued, selected.Path), file.Parent, nil)
 	}
+	return selected
 }
 
 // addInvalidChilds is a strict mode: visible at LACNIC with
 // manifests with short expiration date.
 // The certificate can still be valid while its discovery path will not
 func (sm *SimpleManager) Explore(notMFT bool, addInvalidChilds bool) int {
+	sm.exploreManifests = !notMFT
 	hasMore := sm.HasMore()
 	var count int
 	for hasMore {
+		if sm.Context != nil && sm.Context.Err() != nil {
+			break
+		}
+
 		// Log errors
 		var err error
 		var file *PKIFile
@@ -861,7 +1288,18 @@ func (sm *SimpleManager) Explore(notMFT bool, addInvalidChilds bool) int {
 		} else {
 			count++
 		}
+
+		// The errors and counters of the files of a publication point are
+		// recorded to be reused
+		var counters validatorCounters
+		if sm.Incremental && sm.Validator != nil {
+			sm.currentPublicationPoint = sm.publicationPointOf(file)
+			counters = sm.Validator.counters()
+		}
 		if !notMFT || file.Type != TYPE_MFT {
+			if file != nil && file.Type == TYPE_MFT {
+				file = sm.selectManifest(file)
+			}
 			data, err := sm.GetNextFile(file)
 
 			if err == nil && data != nil && sm.StrictHash && data.Sha256 != nil && file.ManifestHash != nil {
@@ -871,7 +1309,13 @@ func (sm *SimpleManager) Explore(notMFT bool, addInvalidChilds bool) int {
 					if ok && res != nil && res.Resource != nil {
 						cert, ok := res.Resource.(*librpki.RPKIManifest)
 						if ok {
-							if time.Now().After(cert.Content.NextUpdate) || time.Now().Before(cert.Content.ThisUpdate) {
+							var skew time.Duration
+							now := time.Now()
+							if sm.Validator != nil {
+								skew = sm.Validator.ClockSkew
+								now = sm.Validator.Time
+							}
+							if now.Add(-skew).After(cert.Content.NextUpdate) || now.Add(skew).Before(cert.Content.ThisUpdate) {
 								sm.InvalidateManifestParent(file, nil)
 							}
 						} else {
@@ -901,6 +1345,9 @@ func (sm *SimpleManager) Explore(notMFT bool, addInvalidChilds bool) int {
 
 				sm.InvalidateCRLParent(file, err)
 			} else if data != nil {
+				if file.Type == TYPE_UNKNOWN && sm.StrictManifests && !sm.AllowUnknown {
+					sm.InvalidateManifestParent(file, fmt.Errorf("%v has an unknown object type", file.Path))
+				}
 				sm.ExploreAdd(file, data, addInvalidChilds)
 				hasMore = sm.HasMore()
 			} else { // data == nil && err == nil -> file was not found
@@ -915,6 +1362,11 @@ func (sm *SimpleManager) Explore(notMFT bool, addInvalidChilds bool) int {
 				sm.reportErrorFile(err, file, nil)
 			}
 		}
+		if sm.currentPublicationPoint != "" {
+			record := sm.publicationPointRecord(sm.currentPublicationPoint)
+			record.counters.add(sm.Validator.counters().sub(counters))
+			sm.currentPublicationPoint = ""
+		}
 		hasMore = sm.HasMore()
 	}
 	return count
@@ -9,11 +9,17 @@ import (
 	"encoding/asn1"
 	"encoding/pem"
 	"errors"
+	"fmt"
+	"io"
 	"math/big"
 	"net"
+	"path"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 
 	//"fmt"
@@ -475,3 +481,961 @@ func TestPKI(t *testing.T) {
 	count := Validate(talPath, fs)
 	assert.Equal(t, 1, count)
 }
+
+func TestValidateNotYetValid(t *testing.T) {
+	key := CreateKeys()[0]
+	ski, err := librpki.HashPublicKey(key.Public())
+	assert.Nil(t, err)
+
+	notBefore := time.Now().UTC().Add(time.Hour * 24)
+	template := &x509.Certificate{
+		Version:      3,
+		SerialNumber: big.NewInt(42),
+		Subject: pkix.Name{
+			CommonName: "OctoRPKI-Future",
+		},
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		SubjectKeyId:          ski,
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(time.Hour * 24 * 365),
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	assert.Nil(t, err)
+	cert, err := librpki.DecodeCertificate(certBytes)
+	assert.Nil(t, err)
+
+	validator := NewValidator()
+	assert.NotNil(t, validator.ValidateCertificate(cert, true))
+
+	validator.AllowNotYetValid = true
+	assert.Nil(t, validator.ValidateCertificate(cert, true))
+
+	validator.Time = notBefore.Add(time.Hour * 24 * 366)
+	assert.NotNil(t, validator.ValidateCertificate(cert, true))
+}
+
+func TestValidateClockSkew(t *testing.T) {
+	key := CreateKeys()[0]
+	ski, err := librpki.HashPublicKey(key.Public())
+	assert.Nil(t, err)
+
+	now := time.Now().UTC()
+	notBefore := now.Add(time.Minute * 2)
+	template := &x509.Certificate{
+		Version:      3,
+		SerialNumber: big.NewInt(42),
+		Subject: pkix.Name{
+			CommonName: "OctoRPKI-Skew",
+		},
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		SubjectKeyId:          ski,
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(time.Hour),
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	assert.Nil(t, err)
+	cert, err := librpki.DecodeCertificate(certBytes)
+	assert.Nil(t, err)
+
+	validator := NewValidator()
+	validator.Time = now
+	assert.NotNil(t, validator.ValidateCertificate(cert, true))
+
+	// Slightly in the future, within the tolerance
+	validator.ClockSkew = time.Minute * 5
+	assert.Nil(t, validator.ValidateCertificate(cert, true))
+
+	// Expired a few minutes ago
+	validator.Time = notBefore.Add(time.Hour + time.Minute*3)
+	assert.Nil(t, validator.ValidateCertificate(cert, true))
+
+	// Beyond the tolerance
+	validator.Time = now.Add(-time.Minute * 5)
+	assert.NotNil(t, validator.ValidateCertificate(cert, true))
+	validator.Time = notBefore.Add(time.Hour + time.Minute*10)
+	assert.NotNil(t, validator.ValidateCertificate(cert, true))
+}
+
+func TestCertificateDepth(t *testing.T) {
+	_, net4, _ := net.ParseCIDR("0.0.0.0/0")
+	ipBlocks, err := librpki.EncodeIPAddressBlock([]librpki.IPCertificateInformation{
+		&librpki.IPNet{IPNet: net4},
+	})
+	assert.Nil(t, err)
+	asnBlocks, err := librpki.EncodeASN([]librpki.ASNCertificateInformation{
+		&librpki.ASNRange{Min: 0, Max: 1<<31 - 1},
+	}, nil)
+	assert.Nil(t, err)
+
+	// Creates a chain of CA certificates, the first one being the trust anchor
+	genTime := time.Now().UTC().Add(-time.Hour)
+	chain := make([]*librpki.RPKICertificate, 6)
+	keys := make([]*rsa.PrivateKey, len(chain))
+	templates := make([]*x509.Certificate, len(chain))
+	var parent *x509.Certificate
+	var parentKey *rsa.PrivateKey
+	for i := range chain {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		assert.Nil(t, err)
+		ski, err := librpki.HashPublicKey(key.Public())
+		assert.Nil(t, err)
+
+		template := &x509.Certificate{
+			Version:               3,
+			SerialNumber:          big.NewInt(int64(i + 1)),
+			Subject:               pkix.Name{CommonName: "OctoRPKI-Depth"},
+			ExtraExtensions:       []pkix.Extension{*ipBlocks, *asnBlocks},
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+			SubjectKeyId:          ski,
+			NotBefore:             genTime,
+			NotAfter:              genTime.Add(time.Hour * 24),
+		}
+		if parent == nil {
+			parent, parentKey = template, key
+		} else {
+			template.AuthorityKeyId = parent.SubjectKeyId
+		}
+
+		certBytes, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+		assert.Nil(t, err)
+		chain[i], err = librpki.DecodeCertificate(certBytes)
+		assert.Nil(t, err)
+
+		parent, parentKey = template, key
+		keys[i], templates[i] = key, template
+	}
+
+	// EE certificate of a signed object issued by the CA at depth 3
+	eeKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	eeSKI, err := librpki.HashPublicKey(eeKey.Public())
+	assert.Nil(t, err)
+	eeBytes, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		Version:         3,
+		SerialNumber:    big.NewInt(100),
+		Subject:         pkix.Name{CommonName: "OctoRPKI-Depth-EE"},
+		ExtraExtensions: []pkix.Extension{*ipBlocks, *asnBlocks},
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		SubjectKeyId:    eeSKI,
+		AuthorityKeyId:  templates[3].SubjectKeyId,
+		NotBefore:       genTime,
+		NotAfter:        genTime.Add(time.Hour * 24),
+	}, templates[3], eeKey.Public(), keys[3])
+	assert.Nil(t, err)
+	ee, err := librpki.DecodeCertificate(eeBytes)
+	assert.Nil(t, err)
+
+	addChain := func(v *Validator) ([]bool, []*Resource, []error) {
+		valids := make([]bool, len(chain))
+		resources := make([]*Resource, len(chain))
+		errs := make([]error, len(chain))
+		for i, cert := range chain {
+			valids[i], _, resources[i], errs[i] = v.AddCert(cert, i == 0)
+		}
+		return valids, resources, errs
+	}
+
+	// Depth is tracked without a maximum
+	v := NewValidator()
+	valids, resources, errs := addChain(v)
+	for i := range chain {
+		assert.True(t, valids[i])
+		assert.Nil(t, errs[i])
+		assert.Equal(t, i, resources[i].Depth)
+	}
+
+	// Deep certificates are only reported
+	v = NewValidator()
+	v.MaxDepth = 3
+	valids, _, errs = addChain(v)
+	for i := range chain {
+		assert.True(t, valids[i])
+		if i > 3 {
+			certErr, ok := errs[i].(*CertificateError)
+			assert.True(t, ok)
+			assert.Equal(t, ERROR_CERTIFICATE_DEPTH, certErr.EType)
+		} else {
+			assert.Nil(t, errs[i])
+		}
+	}
+
+	// Deep certificates and their children are invalidated
+	v = NewValidator()
+	v.MaxDepth = 3
+	v.InvalidateDeep = true
+	valids, _, errs = addChain(v)
+	assert.Equal(t, []bool{true, true, true, true, false, false}, valids)
+	assert.NotNil(t, errs[4])
+	assert.NotNil(t, errs[5])
+	assert.Len(t, v.ValidObjects, 4)
+
+	// Objects of the deepest valid CA stay valid
+	valid, _, res, err := v.AddCert(ee, false)
+	assert.True(t, valid)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, res.Depth)
+}
+
+func TestDuplicateSKI(t *testing.T) {
+	keys := CreateKeys()
+	ski, err := librpki.HashPublicKey(keys[0].Public())
+	assert.Nil(t, err)
+
+	genTime := time.Now().UTC().Add(-time.Hour)
+	createCert := func(serial int64, key *rsa.PrivateKey) []byte {
+		template := &x509.Certificate{
+			Version:               3,
+			SerialNumber:          big.NewInt(serial),
+			Subject:               pkix.Name{CommonName: "OctoRPKI-SKI"},
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+			// Same identifier whatever the key
+			SubjectKeyId: ski,
+			NotBefore:    genTime,
+			NotAfter:     genTime.Add(time.Hour * 24),
+		}
+		certBytes, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+		assert.Nil(t, err)
+		return certBytes
+	}
+
+	first := &PKIFile{Path: "rsync://rpki.example.com/repo/first.cer", Type: TYPE_CER, Trust: true}
+	second := &PKIFile{Path: "rsync://rpki.example.com/repo/second.cer", Type: TYPE_CER, Trust: true}
+
+	v := NewValidator()
+	valid, _, _, err := v.AddResource(first, createCert(1, keys[0]))
+	assert.True(t, valid)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, v.SKIConflicts)
+
+	valid, _, _, err = v.AddResource(second, createCert(2, keys[1]))
+	assert.False(t, valid)
+	assert.Equal(t, 1, v.SKIConflicts)
+
+	certErr, ok := err.(*CertificateError)
+	assert.True(t, ok)
+	assert.Equal(t, ERROR_CERTIFICATE_CONFLICT, certErr.EType)
+	assert.Equal(t, first, certErr.InnerFile)
+
+	// The file is added when the error is reported
+	certErr.AddFileErrorInfo(second, nil)
+	assert.Contains(t, certErr.Error(), "between rsync://rpki.example.com/repo/second.cer and rsync://rpki.example.com/repo/first.cer")
+
+	// The first certificate is kept
+	assert.Equal(t, first, v.ValidObjects[string(ski)].File)
+}
+
+// Alters the objects of a test tree before they are signed.
+type testTreeOptions struct {
+	manifest func(*librpki.ManifestContent)
+	eeCert   func(object string, template *x509.Certificate)
+
+	// Other manifests published by every CA, by file name
+	manifests map[string]func(*librpki.ManifestContent)
+}
+
+// Creates a repository where every CA has a manifest, a CRL, a ROA and fanout
+// child CAs, down to depth levels below the trust anchor. The extra files are
+// published and listed on the manifest of every CA.
+func createTestTree(tb testing.TB, fanout int, depth int, extraFiles map[string][]byte, options ...testTreeOptions) (*TestingFileSeeker, string) {
+	fs := NewFileSeeker()
+	keys := CreateKeys()
+	caKey, eeKey := keys[0], keys[1]
+
+	genTime := time.Now().UTC().Add(-time.Hour)
+	validity := time.Hour * 24
+
+	_, net4, _ := net.ParseCIDR("0.0.0.0/0")
+	ipBlocks, err := librpki.EncodeIPAddressBlock([]librpki.IPCertificateInformation{
+		&librpki.IPNet{IPNet: net4},
+	})
+	assert.Nil(tb, err)
+	ipBlocksInherit, err := librpki.EncodeIPAddressBlock([]librpki.IPCertificateInformation{
+		&librpki.IPAddressNull{Family: 1},
+	})
+	assert.Nil(tb, err)
+	asnBlocks, err := librpki.EncodeASN([]librpki.ASNCertificateInformation{
+		&librpki.ASNRange{Min: 0, Max: 1<<31 - 1},
+	}, nil)
+	assert.Nil(tb, err)
+	asnBlocksInherit, err := librpki.EncodeASN([]librpki.ASNCertificateInformation{
+		&librpki.ASNull{},
+	}, nil)
+	assert.Nil(tb, err)
+	policy, err := librpki.EncodePolicyInformation("http://example.com/cps.html")
+	assert.Nil(tb, err)
+
+	// Keys are shared: only the identifiers need to be unique
+	var serial int64
+	nextSKI := func() []byte {
+		serial++
+		ski := sha256.Sum256(big.NewInt(serial).Bytes())
+		return ski[:20]
+	}
+	fileHash := func(data []byte) asn1.BitString {
+		hash := sha256.Sum256(data)
+		return asn1.BitString{Bytes: hash[:], BitLength: 256}
+	}
+
+	var addCA func(name string, certURI string, ca *x509.Certificate, level int)
+	addCA = func(name string, certURI string, ca *x509.Certificate, level int) {
+		repo := "rsync://tree.example.com/" + name + "/"
+		parentPath, err := librpki.EncodeInfoAccess(true, certURI)
+		assert.Nil(tb, err)
+
+		eeCert := func(object string, ipExt *pkix.Extension, asnExt *pkix.Extension) (*x509.Certificate, []byte) {
+			objectPath, err := librpki.EncodeInfoAccess(false, repo+object)
+			assert.Nil(tb, err)
+			extensions := []pkix.Extension{*policy, *ipExt, *parentPath, *objectPath}
+			if asnExt != nil {
+				extensions = append(extensions, *asnExt)
+			}
+			ski := nextSKI()
+			template := &x509.Certificate{
+				Version:               3,
+				SerialNumber:          big.NewInt(serial),
+				Subject:               pkix.Name{CommonName: object},
+				ExtraExtensions:       extensions,
+				NotBefore:             genTime,
+				NotAfter:              genTime.Add(validity),
+				SubjectKeyId:          ski,
+				AuthorityKeyId:        ca.SubjectKeyId,
+				KeyUsage:              x509.KeyUsageDigitalSignature,
+				CRLDistributionPoints: []string{repo + "ca.crl"},
+			}
+			for _, option := range options {
+				if option.eeCert != nil {
+					option.eeCert(object, template)
+				}
+			}
+			certBytes, err := x509.CreateCertificate(rand.Reader, template, ca, eeKey.Public(), caKey)
+			assert.Nil(tb, err)
+			return template, certBytes
+		}
+
+		crlBytes, err := librpki.CreateCRL(ca, rand.Reader, caKey, []pkix.RevokedCertificate{}, genTime, genTime.Add(validity), big.NewInt(1))
+		assert.Nil(tb, err)
+		fs.AddFile(repo+"ca.crl", crlBytes)
+		files := []librpki.File{{Name: "ca.crl", Hash: fileHash(crlBytes)}}
+
+		// ROA
+		_, prefix, _ := net.ParseCIDR(fmt.Sprintf("10.%d.%d.0/24", serial/256, serial%256))
+		roaContent, err := librpki.EncodeROAEntries(65001, []*librpki.ROAEntry{{IPNet: prefix, MaxLength: 24}})
+		assert.Nil(tb, err)
+		roaCms, err := librpki.EncodeCMS(nil, roaContent, genTime)
+		assert.Nil(tb, err)
+		roaCert, roaCertBytes := eeCert("ca.roa", ipBlocks, nil)
+		encap, err := librpki.ROAToEncap(roaContent)
+		assert.Nil(tb, err)
+		assert.Nil(tb, roaCms.Sign(rand.Reader, roaCert.SubjectKeyId, encap, eeKey, roaCertBytes))
+		roaBytes, err := asn1.Marshal(*roaCms)
+		assert.Nil(tb, err)
+		fs.AddFile(repo+"ca.roa", roaBytes)
+		files = append(files, librpki.File{Name: "ca.roa", Hash: fileHash(roaBytes)})
+
+		for name, data := range extraFiles {
+			fs.AddFile(repo+name, data)
+			files = append(files, librpki.File{Name: name, Hash: fileHash(data)})
+		}
+
+		// Child CAs
+		for i := 0; level < depth && i < fanout; i++ {
+			childName := fmt.Sprintf("%s-%d", name, i)
+			childRepo := "rsync://tree.example.com/" + childName + "/"
+			sias, err := librpki.EncodeSIA([]*librpki.SIA{
+				{AccessMethod: librpki.CertRepository, GeneralName: []byte(childRepo)},
+				{AccessMethod: librpki.SIAManifest, GeneralName: []byte(childRepo + "ca.mft")},
+			})
+			assert.Nil(tb, err)
+
+			child := &x509.Certificate{
+				Version:               3,
+				SerialNumber:          big.NewInt(serial),
+				Subject:               pkix.Name{CommonName: childName},
+				ExtraExtensions:       []pkix.Extension{*sias, *ipBlocks, *asnBlocks, *policy, *parentPath},
+				KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+				BasicConstraintsValid: true,
+				IsCA:                  true,
+				SubjectKeyId:          nextSKI(),
+				AuthorityKeyId:        ca.SubjectKeyId,
+				NotBefore:             genTime,
+				NotAfter:              genTime.Add(validity),
+				CRLDistributionPoints: []string{repo + "ca.crl"},
+			}
+			childBytes, err := x509.CreateCertificate(rand.Reader, child, ca, caKey.Public(), caKey)
+			assert.Nil(tb, err)
+			fs.AddFile(repo+childName+".cer", childBytes)
+			files = append(files, librpki.File{Name: childName + ".cer", Hash: fileHash(childBytes)})
+
+			addCA(childName, repo+childName+".cer", child, level+1)
+		}
+
+		// Manifests
+		alter := map[string]func(*librpki.ManifestContent){"ca.mft": nil}
+		for _, option := range options {
+			if option.manifest != nil {
+				alter["ca.mft"] = option.manifest
+			}
+			for name, f := range option.manifests {
+				alter[name] = f
+			}
+		}
+		for name, f := range alter {
+			content := librpki.ManifestContent{
+				ManifestNumber: big.NewInt(1),
+				ThisUpdate:     genTime,
+				NextUpdate:     genTime.Add(validity),
+				FileHashAlg:    librpki.SHA256OID,
+				FileList:       files,
+			}
+			if f != nil {
+				f(&content)
+			}
+			mftContent, err := librpki.EncodeManifestContent(content)
+			assert.Nil(tb, err)
+			mftCms, err := librpki.EncodeCMS(nil, mftContent, genTime)
+			assert.Nil(tb, err)
+			mftCert, mftCertBytes := eeCert(name, ipBlocksInherit, asnBlocksInherit)
+			encap, err = librpki.ManifestToEncap(mftContent)
+			assert.Nil(tb, err)
+			assert.Nil(tb, mftCms.Sign(rand.Reader, mftCert.SubjectKeyId, encap, eeKey, mftCertBytes))
+			mftBytes, err := asn1.Marshal(*mftCms)
+			assert.Nil(tb, err)
+			fs.AddFile(repo+name, mftBytes)
+		}
+	}
+
+	// Trust anchor
+	rootURI := "rsync://tree.example.com/root.cer"
+	sias, err := librpki.EncodeSIA([]*librpki.SIA{
+		{AccessMethod: librpki.CertRepository, GeneralName: []byte("rsync://tree.example.com/root/")},
+		{AccessMethod: librpki.SIAManifest, GeneralName: []byte("rsync://tree.example.com/root/ca.mft")},
+	})
+	assert.Nil(tb, err)
+	root := &x509.Certificate{
+		Version:               3,
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "root"},
+		ExtraExtensions:       []pkix.Extension{*sias, *ipBlocks, *asnBlocks, *policy},
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		SubjectKeyId:          nextSKI(),
+		NotBefore:             genTime,
+		NotAfter:              genTime.Add(validity),
+	}
+	rootBytes, err := x509.CreateCertificate(rand.Reader, root, root, caKey.Public(), caKey)
+	assert.Nil(tb, err)
+	fs.AddFile(rootURI, rootBytes)
+	addCA("root", rootURI, root, 0)
+
+	tal, err := librpki.CreateTAL([]string{rootURI}, caKey.Public())
+	assert.Nil(tb, err)
+	talBytes, err := librpki.EncodeTAL(tal)
+	assert.Nil(tb, err)
+	talPath := "rsync://tree.example.com/tree.tal"
+	fs.AddFile(talPath, talBytes)
+
+	return fs, talPath
+}
+
+type recordingFileSeeker struct {
+	*TestingFileSeeker
+	Order []string
+}
+
+func (fs *recordingFileSeeker) GetFile(file *PKIFile) (*SeekFile, error) {
+	fs.Order = append(fs.Order, file.ComputePath())
+	return fs.TestingFileSeeker.GetFile(file)
+}
+
+func exploreTree(fs FileSeeker, talPath string, order int, configure ...func(*SimpleManager)) *SimpleManager {
+	validator := NewValidator()
+	validator.DecoderConfig.ValidateStrict = false
+	validator.Time = time.Now().UTC()
+
+	manager := NewSimpleManager()
+	manager.Validator = validator
+	manager.FileSeeker = fs
+	manager.ExploreOrder = order
+	for _, f := range configure {
+		f(manager)
+	}
+	manager.AddInitial([]*PKIFile{{Path: talPath, Type: TYPE_TAL}})
+	manager.Explore(false, false)
+	manager.Close()
+	return manager
+}
+
+func TestExploreOrder(t *testing.T) {
+	fs, talPath := createTestTree(t, 2, 3, nil)
+
+	bfs := &recordingFileSeeker{TestingFileSeeker: fs}
+	bfsManager := exploreTree(bfs, talPath, EXPLORE_BFS)
+	dfs := &recordingFileSeeker{TestingFileSeeker: fs}
+	dfsManager := exploreTree(dfs, talPath, EXPLORE_DFS)
+
+	// 1 + 2 + 4 + 8 CAs, each with a ROA
+	assert.Len(t, bfsManager.Validator.ValidROA, 15)
+	var cas int
+	for _, res := range bfsManager.Validator.ValidObjects {
+		if res.Type == TYPE_CER {
+			cas++
+		}
+	}
+	assert.Equal(t, 15, cas)
+	assert.Len(t, fs.Files, len(bfs.Order))
+
+	assert.Equal(t, bfsManager.Explored, dfsManager.Explored)
+	assert.ElementsMatch(t, bfs.Order, dfs.Order)
+	assert.NotEqual(t, bfs.Order, dfs.Order)
+
+	// The deepest CA of the first branch is reached before the second top-level CA
+	indexOf := func(order []string, path string) int {
+		for i, p := range order {
+			if p == path {
+				return i
+			}
+		}
+		return -1
+	}
+	deepest := "rsync://tree.example.com/root-0-0/root-0-0-0.cer"
+	second := "rsync://tree.example.com/root/root-1.cer"
+	assert.Less(t, indexOf(dfs.Order, deepest), indexOf(dfs.Order, second))
+	assert.Greater(t, indexOf(bfs.Order, deepest), indexOf(bfs.Order, second))
+
+	order, err := ParseExploreOrder("DFS")
+	assert.Nil(t, err)
+	assert.Equal(t, EXPLORE_DFS, order)
+	_, err = ParseExploreOrder("random")
+	assert.NotNil(t, err)
+}
+
+func BenchmarkExplore(b *testing.B) {
+	fs, talPath := createTestTree(b, 4, 4, nil)
+
+	for _, order := range []int{EXPLORE_BFS, EXPLORE_DFS} {
+		b.Run(ExploreOrderToName[order], func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				exploreTree(fs, talPath, order)
+			}
+		})
+	}
+}
+
+func TestStrictCrypto(t *testing.T) {
+	keys := CreateKeys()
+	smallKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.Nil(t, err)
+
+	genTime := time.Now().UTC().Add(-time.Hour)
+	createCert := func(serial int64, parent *x509.Certificate, key *rsa.PrivateKey, parentKey *rsa.PrivateKey, algorithm x509.SignatureAlgorithm) (*x509.Certificate, *librpki.RPKICertificate) {
+		ski, err := librpki.HashPublicKey(key.Public())
+		assert.Nil(t, err)
+		template := &x509.Certificate{
+			Version:               3,
+			SerialNumber:          big.NewInt(serial),
+			Subject:               pkix.Name{CommonName: "OctoRPKI-Crypto"},
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+			SubjectKeyId:          ski,
+			NotBefore:             genTime,
+			NotAfter:              genTime.Add(time.Hour * 24),
+			SignatureAlgorithm:    algorithm,
+		}
+		if parent == nil {
+			parent, parentKey = template, key
+		} else {
+			template.AuthorityKeyId = parent.SubjectKeyId
+		}
+		certBytes, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+		assert.Nil(t, err)
+		cert, err := librpki.DecodeCertificate(certBytes)
+		assert.Nil(t, err)
+		return template, cert
+	}
+
+	// A trust anchor signed with SHA-1 and a certificate with a 1024 bits key
+	_, weakRoot := createCert(1, nil, keys[0], nil, x509.SHA1WithRSA)
+	root, strongRoot := createCert(2, nil, keys[1], nil, x509.SHA256WithRSA)
+	_, smallCert := createCert(3, root, smallKey, keys[1], x509.SHA256WithRSA)
+	_, strongCert := createCert(4, root, keys[2], keys[1], x509.SHA256WithRSA)
+	assert.Equal(t, x509.SHA1WithRSA, weakRoot.Certificate.SignatureAlgorithm)
+
+	addCerts := func(v *Validator) []error {
+		errs := make([]error, 0)
+		for i, cert := range []*librpki.RPKICertificate{weakRoot, strongRoot, smallCert, strongCert} {
+			_, _, _, err := v.AddCert(cert, i < 2)
+			errs = append(errs, err)
+		}
+		return errs
+	}
+
+	v := NewValidator()
+	for _, err := range addCerts(v) {
+		assert.Nil(t, err)
+	}
+	assert.Len(t, v.ValidObjects, 4)
+
+	v = NewValidator()
+	v.StrictCrypto = true
+	v.MinRSAKeySize = 2048
+	errs := addCerts(v)
+	for i, err := range errs {
+		if i == 0 || i == 2 {
+			certErr, ok := err.(*CertificateError)
+			assert.True(t, ok)
+			assert.Equal(t, ERROR_CERTIFICATE_CRYPTO, certErr.EType)
+		} else {
+			assert.Nil(t, err)
+		}
+	}
+	assert.Contains(t, errs[0].Error(), "SHA1-RSA")
+	assert.Contains(t, errs[2].Error(), "1024 bits")
+	assert.Equal(t, 2, v.WeakCrypto)
+	assert.Len(t, v.ValidObjects, 2)
+}
+
+func TestInheritedResources(t *testing.T) {
+	keys := CreateKeys()
+	genTime := time.Now().UTC().Add(-time.Hour)
+
+	_, net10, _ := net.ParseCIDR("10.0.0.0/8")
+	ipBlocks, err := librpki.EncodeIPAddressBlock([]librpki.IPCertificateInformation{
+		&librpki.IPNet{IPNet: net10},
+	})
+	assert.Nil(t, err)
+	ipBlocksInherit, err := librpki.EncodeIPAddressBlock([]librpki.IPCertificateInformation{
+		&librpki.IPAddressNull{Family: 1},
+	})
+	assert.Nil(t, err)
+	asnBlocks, err := librpki.EncodeASN([]librpki.ASNCertificateInformation{
+		&librpki.ASNRange{Min: 65000, Max: 65010},
+	}, nil)
+	assert.Nil(t, err)
+	asnBlocksInherit, err := librpki.EncodeASN([]librpki.ASNCertificateInformation{
+		&librpki.ASNull{},
+	}, nil)
+	assert.Nil(t, err)
+
+	createCert := func(serial int64, parent *x509.Certificate, key *rsa.PrivateKey, parentKey *rsa.PrivateKey, extensions ...pkix.Extension) (*x509.Certificate, []byte) {
+		ski, err := librpki.HashPublicKey(key.Public())
+		assert.Nil(t, err)
+		template := &x509.Certificate{
+			Version:               3,
+			SerialNumber:          big.NewInt(serial),
+			Subject:               pkix.Name{CommonName: "OctoRPKI-Inherit"},
+			ExtraExtensions:       extensions,
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+			SubjectKeyId:          ski,
+			NotBefore:             genTime,
+			NotAfter:              genTime.Add(time.Hour * 24),
+		}
+		if parent == nil {
+			parent, parentKey = template, key
+		} else {
+			template.AuthorityKeyId = parent.SubjectKeyId
+		}
+		certBytes, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+		assert.Nil(t, err)
+		return template, certBytes
+	}
+	addCert := func(v *Validator, certBytes []byte, trust bool) error {
+		cert, err := librpki.DecodeCertificate(certBytes)
+		assert.Nil(t, err)
+		_, _, _, err = v.AddCert(cert, trust)
+		return err
+	}
+
+	// The trust anchor lists the resources, the two CAs below inherit them
+	root, rootBytes := createCert(1, nil, keys[0], nil, *ipBlocks, *asnBlocks)
+	ca, caBytes := createCert(2, root, keys[1], keys[0], *ipBlocksInherit, *asnBlocksInherit)
+	subCA, subCABytes := createCert(3, ca, keys[2], keys[1], *ipBlocksInherit, *asnBlocksInherit)
+
+	createROA := func(serial int64, key *rsa.PrivateKey, prefixes ...string) []byte {
+		entries := make([]*librpki.ROAEntry, 0)
+		for _, prefix := range prefixes {
+			_, ipnet, _ := net.ParseCIDR(prefix)
+			entries = append(entries, &librpki.ROAEntry{IPNet: ipnet, MaxLength: 24})
+		}
+		roaContent, err := librpki.EncodeROAEntries(65001, entries)
+		assert.Nil(t, err)
+		roaCms, err := librpki.EncodeCMS(nil, roaContent, genTime)
+		assert.Nil(t, err)
+
+		ski, err := librpki.HashPublicKey(key.Public())
+		assert.Nil(t, err)
+		eeCert := &x509.Certificate{
+			Version:         3,
+			SerialNumber:    big.NewInt(serial),
+			Subject:         pkix.Name{CommonName: "OctoRPKI-Inherit-ROA"},
+			ExtraExtensions: []pkix.Extension{*ipBlocksInherit},
+			NotBefore:       genTime,
+			NotAfter:        genTime.Add(time.Hour * 24),
+			SubjectKeyId:    ski,
+			AuthorityKeyId:  subCA.SubjectKeyId,
+			KeyUsage:        x509.KeyUsageDigitalSignature,
+		}
+		eeBytes, err := x509.CreateCertificate(rand.Reader, eeCert, subCA, key.Public(), keys[2])
+		assert.Nil(t, err)
+
+		encap, err := librpki.ROAToEncap(roaContent)
+		assert.Nil(t, err)
+		assert.Nil(t, roaCms.Sign(rand.Reader, ski, encap, key, eeBytes))
+		roaBytes, err := asn1.Marshal(*roaCms)
+		assert.Nil(t, err)
+		return roaBytes
+	}
+
+	v := NewValidator()
+	v.DecoderConfig.ValidateStrict = false
+	assert.Nil(t, addCert(v, rootBytes, true))
+	assert.Nil(t, addCert(v, caBytes, false))
+	assert.Nil(t, addCert(v, subCABytes, false))
+	assert.Len(t, v.ValidObjects, 3)
+
+	roa, err := v.DecoderConfig.DecodeROA(createROA(4, keys[3], "10.1.0.0/24", "192.168.0.0/24"))
+	assert.Nil(t, err)
+	assert.Len(t, roa.CheckParent, 2)
+
+	valid, _, err := v.AddROA(&PKIFile{Path: "inherit.roa", Type: TYPE_ROA}, roa)
+	assert.Nil(t, err)
+	assert.True(t, valid)
+	assert.Len(t, v.ValidROA, 1)
+	assert.Empty(t, roa.CheckParent)
+	if assert.Len(t, roa.Valids, 1) {
+		assert.Equal(t, "10.1.0.0/24", roa.Valids[0].IPNet.String())
+	}
+	if assert.Len(t, roa.Invalids, 1) {
+		assert.Equal(t, "192.168.0.0/24", roa.Invalids[0].IPNet.String())
+	}
+
+	// A trust anchor cannot inherit resources
+	v = NewValidator()
+	_, inheritRootBytes := createCert(5, nil, keys[0], nil, *ipBlocksInherit, *asnBlocks)
+	assert.Nil(t, addCert(v, inheritRootBytes, true))
+	_, explicitCABytes := createCert(6, root, keys[1], keys[0], *ipBlocks, *asnBlocks)
+	err = addCert(v, explicitCABytes, false)
+	certErr, ok := err.(*CertificateError)
+	if assert.True(t, ok) {
+		assert.Equal(t, ERROR_CERTIFICATE_RESOURCE, certErr.EType)
+	}
+}
+
+func TestManifestAllowUnknown(t *testing.T) {
+	fs, talPath := createTestTree(t, 1, 1, map[string][]byte{"ca.gbr": []byte("ghostbusters record")})
+
+	explore := func(allowUnknown bool) (*SimpleManager, []error) {
+		errs := make([]error, 0)
+		done := make(chan struct{})
+		manager := exploreTree(fs, talPath, EXPLORE_BFS, func(sm *SimpleManager) {
+			logger := logrus.New()
+			logger.Out = io.Discard
+			sm.Log = logger
+			sm.AllowUnknown = allowUnknown
+			sm.ReportErrors = true
+			go func() {
+				for err := range sm.Errors {
+					errs = append(errs, err)
+				}
+				close(done)
+			}()
+		})
+		<-done
+		return manager, errs
+	}
+
+	// The unknown files are reported without invalidating the CAs
+	manager, errs := explore(true)
+	assert.Len(t, manager.Validator.ValidROA, 2)
+	if assert.Len(t, errs, 2) {
+		assert.Contains(t, errs[0].Error(), "Unknown file type")
+	}
+
+	manager, errs = explore(false)
+	assert.Empty(t, manager.Validator.ValidROA)
+	var revoked bool
+	for _, err := range errs {
+		if certErr, ok := err.(*CertificateError); ok && certErr.EType == ERROR_CERTIFICATE_MANIFEST {
+			assert.Contains(t, certErr.Error(), "ca.gbr has an unknown object type")
+			revoked = true
+		}
+	}
+	assert.True(t, revoked)
+}
+
+func TestCMSDecodeFailures(t *testing.T) {
+	fs, talPath := createTestTree(t, 1, 1, nil)
+
+	// The test objects lack the NULL signature parameters required by the
+	// strict decoding
+	lenient := exploreTree(fs, talPath, EXPLORE_BFS, func(sm *SimpleManager) {
+		sm.Validator.DecoderConfig = &librpki.DecoderConfig{ValidateStrict: false}
+	}).Validator
+	assert.NotEmpty(t, lenient.ValidROA)
+	assert.Equal(t, len(lenient.ROA)+len(lenient.Manifest), lenient.CMSStrictFailures)
+	assert.Equal(t, 0, lenient.CMSLenientFailures)
+
+	strict := exploreTree(fs, talPath, EXPLORE_BFS, func(sm *SimpleManager) {
+		sm.Validator.DecoderConfig = &librpki.DecoderConfig{ValidateStrict: true}
+	}).Validator
+	assert.Empty(t, strict.ValidROA)
+	assert.Greater(t, strict.CMSStrictFailures, 0)
+	assert.Equal(t, 0, strict.CMSLenientFailures)
+
+	// Undecodable objects fail in both modes
+	v := NewValidator()
+	v.DecoderConfig = &librpki.DecoderConfig{ValidateStrict: false}
+	_, _, _, err := v.AddResource(&PKIFile{Path: "broken.roa", Type: TYPE_ROA}, []byte("not a ROA"))
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, v.CMSStrictFailures)
+	assert.Equal(t, 1, v.CMSLenientFailures)
+}
+
+func TestManifestHashAlgorithm(t *testing.T) {
+	sha1OID := asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	fs, talPath := createTestTree(t, 1, 1, nil, testTreeOptions{
+		manifest: func(content *librpki.ManifestContent) {
+			content.FileHashAlg = sha1OID
+		},
+	})
+
+	explore := func(check bool) *Validator {
+		return exploreTree(fs, talPath, EXPLORE_BFS, func(sm *SimpleManager) {
+			sm.Validator.CheckManifestHashAlgorithm = check
+		}).Validator
+	}
+
+	v := explore(false)
+	assert.Len(t, v.ValidROA, 2)
+	assert.Equal(t, 0, v.ManifestHashAlgorithmErrors)
+
+	// The root manifest is rejected, its files are not explored
+	v = explore(true)
+	assert.Empty(t, v.ValidROA)
+	assert.Empty(t, v.ValidManifest)
+	assert.Equal(t, 1, v.ManifestHashAlgorithmErrors)
+}
+
+func TestEEKeyUsage(t *testing.T) {
+	fs, talPath := createTestTree(t, 1, 1, nil, testTreeOptions{
+		eeCert: func(object string, template *x509.Certificate) {
+			if object == "ca.roa" {
+				template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+			}
+		},
+	})
+
+	explore := func(strict bool) *Validator {
+		return exploreTree(fs, talPath, EXPLORE_BFS, func(sm *SimpleManager) {
+			sm.Validator.StrictEKU = strict
+		}).Validator
+	}
+
+	// Reported only
+	v := explore(false)
+	assert.Equal(t, 2, v.EKUMismatches)
+	assert.Len(t, v.ValidROA, 2)
+	assert.Len(t, v.ValidManifest, 2)
+
+	v = explore(true)
+	assert.Equal(t, 2, v.EKUMismatches)
+	assert.Empty(t, v.ValidROA)
+	assert.Len(t, v.ROA, 2)
+	assert.Len(t, v.ValidManifest, 2)
+}
+
+// Lists the files of a repository, letting the manager choose between the
+// manifests of a CA.
+type listingFileSeeker struct {
+	*TestingFileSeeker
+}
+
+func (fs *listingFileSeeker) ListRepository(repo string) ([]string, error) {
+	names := make([]string, 0)
+	for path := range fs.Files {
+		name := strings.TrimPrefix(path, repo)
+		if name != path && !strings.Contains(name, "/") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func TestMultipleManifests(t *testing.T) {
+	explore := func(fs FileSeeker, talPath string) (*Validator, []error) {
+		errs := make([]error, 0)
+		done := make(chan struct{})
+		v := exploreTree(fs, talPath, EXPLORE_BFS, func(sm *SimpleManager) {
+			sm.ReportErrors = true
+			go func() {
+				for err := range sm.Errors {
+					errs = append(errs, err)
+				}
+				close(done)
+			}()
+		}).Validator
+		<-done
+		return v, errs
+	}
+	manifestFiles := func(v *Validator) []string {
+		files := make([]string, 0)
+		for _, res := range v.ValidManifest {
+			files = append(files, path.Base(res.File.Path))
+		}
+		return files
+	}
+
+	fs, talPath := createTestTree(t, 1, 1, nil, testTreeOptions{
+		manifests: map[string]func(*librpki.ManifestContent){
+			"next.mft": func(content *librpki.ManifestContent) {
+				content.ManifestNumber = big.NewInt(5)
+			},
+		},
+	})
+
+	// The manifest of the CA certificates is used without listing the repositories
+	v, errs := explore(fs, talPath)
+	assert.Len(t, v.ValidROA, 2)
+	assert.Equal(t, []string{"ca.mft", "ca.mft"}, manifestFiles(v))
+	assert.Equal(t, 0, v.MultipleManifests)
+	assert.Empty(t, errs)
+
+	// The highest manifestNumber is selected and both CAs are reported
+	v, errs = explore(&listingFileSeeker{fs}, talPath)
+	assert.Len(t, v.ValidROA, 2)
+	assert.Equal(t, []string{"next.mft", "next.mft"}, manifestFiles(v))
+	assert.Equal(t, 2, v.MultipleManifests)
+	if assert.Len(t, errs, 2) {
+		certErr, ok := errs[0].(*CertificateError)
+		if assert.True(t, ok) {
+			assert.Equal(t, ERROR_CERTIFICATE_MANIFESTS, certErr.EType)
+			assert.Contains(t, certErr.Error(), "using rsync://tree.example.com/root/next.mft")
+		}
+	}
+
+	// An expired manifest is not selected, whatever its number
+	fs, talPath = createTestTree(t, 1, 1, nil, testTreeOptions{
+		manifests: map[string]func(*librpki.ManifestContent){
+			"next.mft": func(content *librpki.ManifestContent) {
+				content.ManifestNumber = big.NewInt(5)
+				content.NextUpdate = content.ThisUpdate.Add(time.Minute)
+			},
+		},
+	})
+	v, _ = explore(&listingFileSeeker{fs}, talPath)
+	assert.Len(t, v.ValidROA, 2)
+	assert.Equal(t, []string{"ca.mft", "ca.mft"}, manifestFiles(v))
+	assert.Equal(t, 2, v.MultipleManifests)
+}